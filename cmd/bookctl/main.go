@@ -0,0 +1,185 @@
+// Command bookctl runs offline maintenance tasks against the book store.
+//
+// Usage:
+//
+//	bookctl import-calibre [-dry-run] [-user name] path/to/metadata.db
+//	bookctl backup [-o file]
+//	bookctl restore [-replace] file
+package main
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/backup"
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/db"
+	"book-tracker/internal/importer"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "import-calibre":
+		importCalibre(ctx, os.Args[2:])
+	case "backup":
+		backupData(ctx, os.Args[2:])
+	case "restore":
+		restoreData(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: bookctl import-calibre [-dry-run] [-user name] path/to/metadata.db
+       bookctl backup [-o file]
+       bookctl restore [-replace] file`)
+	os.Exit(2)
+}
+
+// importCalibre merges a Calibre library into a user's books and prints the
+// import report as JSON.
+func importCalibre(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("import-calibre", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	username := fs.String("user", "", "import into this user's books; required once there are users")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	records, err := importer.ParseCalibre(ctx, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read Calibre library: %v", err)
+	}
+	database := connect()
+	defer database.Close()
+	ctx = asUser(ctx, repository.NewUserRepository(database), *username)
+
+	report, err := importer.Import(ctx, repository.NewStore(database), records, importer.Options{DryRun: *dryRun, Merge: true})
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	log.Printf("%d created, %d updated, %d merged, %d skipped, %d failed",
+		report.Created, report.Updated, report.Merged, report.Skipped, report.Failed)
+}
+
+// backupData writes a backup archive to a file, or to standard output.
+func backupData(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "", "write the archive to this file instead of standard output")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+	}
+
+	database := connect()
+	defer database.Close()
+	blobs, err := blob.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create backup file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := backup.Write(ctx, repository.NewStore(database), blobs, w); err != nil {
+		if *out != "" {
+			os.Remove(*out)
+		}
+		log.Fatalf("Backup failed: %v", err)
+	}
+}
+
+// restoreData restores a backup archive and prints what was restored.
+func restoreData(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	replace := fs.Bool("replace", false, "delete all existing data before restoring")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+	defer f.Close()
+	database := connect()
+	defer database.Close()
+	blobs, err := blob.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+
+	summary, err := backup.Restore(ctx, repository.NewStore(database), f, backup.Options{Replace: *replace})
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	moved, err := covers.NewService(repository.NewCoverRepository(database), blobs).MoveRestored(ctx)
+	if err != nil {
+		log.Printf("Moving restored covers to blob storage failed, the server retries on start: %v", err)
+	}
+	log.Printf("Restored %d books, %d highlights and %d audit entries from a backup taken %s",
+		summary.Counts[backup.TypeBook], summary.Counts[backup.TypeHighlight], summary.Counts[backup.TypeAudit],
+		summary.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	if moved > 0 {
+		log.Printf("Moved %d restored cover(s) to blob storage", moved)
+	}
+	if len(summary.UsernameCollisions) > 0 {
+		log.Printf("Merged users into existing users of the same name: %s", strings.Join(summary.UsernameCollisions, ", "))
+	}
+}
+
+// asUser returns a context working on the named user's books. Without a
+// name it works on all books, which is only allowed before there are users;
+// the first user then takes the books over.
+func asUser(ctx context.Context, users repository.UserRepositoryInterface, username string) context.Context {
+	if username == "" {
+		n, err := users.CountUsers(ctx)
+		if err != nil {
+			log.Fatalf("Failed to count users: %v", err)
+		}
+		if n > 0 {
+			log.Fatal("The store has users; name the one to import for with -user")
+		}
+		return ctx
+	}
+	user, err := users.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Fatalf("Failed to find user %q: %v", username, err)
+	}
+	return audit.WithActor(repository.WithOwner(ctx, user.ID), user.Username)
+}
+
+func connect() *sqlx.DB {
+	database, err := db.NewDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	return database
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"book-tracker/internal/auth"
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
 	"book-tracker/internal/db"
+	"book-tracker/internal/enrich"
 	"book-tracker/internal/handlers"
+	"book-tracker/internal/jobs"
+	"book-tracker/internal/repository"
+	"context"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -17,11 +26,73 @@ func main() {
 	}
 	defer database.Close()
 
+	// Open blob storage for cover images
+	blobs, err := blob.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+
+	// Purge trashed books once they are older than the retention period
+	retention := durationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	interval := durationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	coverService := covers.NewService(repository.NewCoverRepository(database), blobs)
+	go jobs.RunTrashPurge(ctx, repository.NewBookRepository(database), coverService, retention, interval)
+
+	// Move cover images restored into the database to blob storage, such
+	// as those of books taken out of the trash since the last restore
+	go func() {
+		if n, err := coverService.MoveRestored(ctx); err != nil {
+			log.Printf("Moving restored covers failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Moved %d restored cover(s) to blob storage", n)
+		}
+	}()
+
+	// Fill in missing book metadata from Open Library dumps, if any are
+	// configured
+	var provider enrich.MetadataProvider
+	if paths := listEnv("ENRICH_OPENLIBRARY_DUMPS"); len(paths) > 0 {
+		dump, err := enrich.LoadOpenLibraryDump(paths...)
+		if err != nil {
+			log.Fatalf("Failed to load Open Library dumps: %v", err)
+		}
+		if n := dump.Skipped(); n > 0 {
+			log.Printf("Skipped %d malformed lines in Open Library dumps", n)
+		}
+		provider = dump
+		enricher := enrich.NewEnricher(provider, repository.NewStore(database))
+		go jobs.RunEnrichment(ctx, repository.NewEnrichmentRepository(database), enricher,
+			durationEnv("ENRICH_INTERVAL", time.Hour), durationEnv("ENRICH_RETRY_AFTER", 30*24*time.Hour))
+	}
+
+	// Accept ID tokens from an OpenID Connect provider, if one is
+	// configured
+	var oidc *auth.OIDC
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		audience := os.Getenv("OIDC_AUDIENCE")
+		if audience == "" {
+			log.Fatalf("OIDC_AUDIENCE must be set with OIDC_ISSUER")
+		}
+		oidc = auth.NewOIDC(auth.OIDCConfig{
+			Issuer:        issuer,
+			Audience:      audience,
+			UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+			KeyCacheTTL:   durationEnv("OIDC_KEY_CACHE_TTL", auth.DefaultKeyCacheTTL),
+		})
+	}
+
 	// Initialize router
 	router := mux.NewRouter()
 
 	// Register handlers
-	handlers.RegisterBookHandlers(router, database)
+	handlers.RegisterBookHandlers(router, database, handlers.Config{
+		Blobs:      blobs,
+		Provider:   provider,
+		SessionTTL: durationEnv("SESSION_TTL", auth.DefaultSessionTTL),
+		OIDC:       oidc,
+	})
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -33,3 +104,30 @@ func main() {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// durationEnv reads a duration such as "720h" from the environment, falling
+// back to def when the variable is unset or invalid.
+func durationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Ignoring invalid %s %q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// listEnv reads a comma-separated list from the environment, dropping empty
+// entries.
+func listEnv(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
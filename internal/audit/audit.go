@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+// DefaultActor is recorded when a change has no identified actor.
+const DefaultActor = "anonymous"
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+)
+
+// WithActor returns a context recording who performs changes.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns the actor stored in ctx, or DefaultActor.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return DefaultActor
+}
+
+// WithRequestID returns a context carrying the id of the current request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Middleware tags each request with a request id, taken from the
+// X-Request-ID header or generated, and an actor from the X-Actor header.
+// Requests that sign in are recorded as made by the user instead.
+// The request id is echoed back in the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := WithRequestID(r.Context(), id)
+		if actor := r.Header.Get("X-Actor"); actor != "" {
+			ctx = WithActor(ctx, actor)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Change is the before and after value of one changed field.
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff compares two book states field by field using their JSON names.
+// Either side may be nil for creations and permanent deletions.
+func Diff(before, after *models.Book) map[string]Change {
+	from, to := fields(before), fields(after)
+	diff := make(map[string]Change)
+	for k, v := range to {
+		if !reflect.DeepEqual(from[k], v) {
+			diff[k] = Change{From: from[k], To: v}
+		}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			diff[k] = Change{From: v}
+		}
+	}
+	return diff
+}
+
+// Reverted returns the book state recorded as JSON in an audit entry, as
+// the book current now is. Fields missing from the state, which omits
+// empty ones, are empty; only the book's ID and owner come from current.
+func Reverted(current *models.Book, state json.RawMessage) (models.Book, error) {
+	var book models.Book
+	if err := json.Unmarshal(state, &book); err != nil {
+		return models.Book{}, err
+	}
+	book.ID, book.OwnerID = current.ID, current.OwnerID
+	return book, nil
+}
+
+func fields(book *models.Book) map[string]any {
+	m := make(map[string]any)
+	if book == nil {
+		return m
+	}
+	b, _ := json.Marshal(book)
+	json.Unmarshal(b, &m)
+	return m
+}
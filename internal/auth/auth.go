@@ -0,0 +1,138 @@
+// Package auth registers users, signs them in with session tokens, API
+// tokens or an OpenID Connect provider's ID tokens, and authenticates
+// requests.
+package auth
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// DefaultSessionTTL is how long a session lasts unless configured.
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
+// ErrInvalidLogin is returned for an unknown username, a wrong password or
+// an unknown or expired session, without telling which.
+var ErrInvalidLogin = errors.New("invalid username, password or session")
+
+// dummyHash is checked against when a username is unknown, so a failed
+// login takes as long whether or not the user exists.
+var dummyHash, _ = HashPassword("not a password")
+
+// Service manages users, their sessions and tokens, and who they share
+// their libraries with.
+type Service struct {
+	store     repository.StoreInterface
+	users     repository.UserRepositoryInterface
+	libraries repository.LibraryRepositoryInterface
+	ttl       time.Duration
+	oidc      *OIDC
+}
+
+func NewService(store repository.StoreInterface, users repository.UserRepositoryInterface, libraries repository.LibraryRepositoryInterface, sessionTTL time.Duration) *Service {
+	if sessionTTL <= 0 {
+		sessionTTL = DefaultSessionTTL
+	}
+	return &Service{store: store, users: users, libraries: libraries, ttl: sessionTTL}
+}
+
+// Register creates a user. Returns models.ErrInvalidCredentials for an
+// unusable username or password and repository.ErrUsernameTaken if the
+// name is in use.
+func (s *Service) Register(ctx context.Context, creds models.Credentials) (*models.User, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(creds.Password)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	err = s.store.RunInTx(ctx, func(repos repository.Repos) error {
+		user = models.User{Username: creds.Username, PasswordHash: hash}
+		return repos.Users.CreateUser(ctx, &user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login checks a user's password and starts a session.
+func (s *Service) Login(ctx context.Context, creds models.Credentials) (*models.Session, *models.User, error) {
+	user, err := s.CheckPassword(ctx, creds.Username, creds.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	session := &models.Session{
+		Token:     base64.RawURLEncoding.EncodeToString(b),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(s.ttl).UTC(),
+	}
+	if err := s.users.CreateSession(ctx, hashToken(session.Token), session); err != nil {
+		return nil, nil, err
+	}
+	return session, user, nil
+}
+
+// CheckPassword returns the user with the given name and password.
+func (s *Service) CheckPassword(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := s.users.GetUserByUsername(ctx, username)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		CheckPassword(dummyHash, password)
+		return nil, ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidLogin
+	}
+	return user, nil
+}
+
+// Authenticate returns the user signed in with a session token.
+func (s *Service) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	user, err := s.users.GetSessionUser(ctx, hashToken(token))
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, ErrInvalidLogin
+	}
+	return user, err
+}
+
+// Logout ends the session with the given token.
+func (s *Service) Logout(ctx context.Context, token string) error {
+	return s.users.DeleteSession(ctx, hashToken(token))
+}
+
+// hashToken is what the store keeps of a session token, so a leaked
+// database cannot be used to sign in. Tokens are random, so an unsalted
+// hash suffices.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the signed-in user.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// User returns the signed-in user stored in ctx, or nil.
+func User(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey{}).(*models.User)
+	return user
+}
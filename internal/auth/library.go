@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNotLibraryOwner is returned when someone other than a library's
+	// owners changes its members.
+	ErrNotLibraryOwner = errors.New("only the library's owners can manage its members")
+	// ErrOwnLibrary is returned when changing a library's own user, who is
+	// always its owner.
+	ErrOwnLibrary = errors.New("a library's own user is always its owner")
+)
+
+// LibraryRole returns what user may do in the library with the given ID.
+// Users own their own library. Returns repository.ErrLibraryNotFound if
+// they are not a member.
+func (s *Service) LibraryRole(ctx context.Context, user *models.User, libraryID int) (models.Role, error) {
+	if libraryID == user.ID {
+		return models.RoleOwner, nil
+	}
+	return s.libraries.GetRole(ctx, libraryID, user.ID)
+}
+
+// Libraries returns the libraries user can open.
+func (s *Service) Libraries(ctx context.Context, user *models.User) ([]models.Library, error) {
+	return s.libraries.ListLibraries(ctx, user.ID)
+}
+
+// Members returns a library's members to any of them.
+func (s *Service) Members(ctx context.Context, user *models.User, libraryID int) ([]models.Membership, error) {
+	if _, err := s.LibraryRole(ctx, user, libraryID); err != nil {
+		return nil, err
+	}
+	return s.libraries.ListMembers(ctx, libraryID)
+}
+
+// SetMember gives the user with the given name a role in a library, which
+// only its owners may do. Returns repository.ErrUserNotFound for an
+// unknown name.
+func (s *Service) SetMember(ctx context.Context, user *models.User, libraryID int, username string, role models.Role) (*models.Membership, error) {
+	if err := role.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.requireOwner(ctx, user, libraryID); err != nil {
+		return nil, err
+	}
+	member, err := s.users.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if member.ID == libraryID {
+		return nil, ErrOwnLibrary
+	}
+	if err := s.libraries.SetMember(ctx, libraryID, member.ID, role); err != nil {
+		return nil, err
+	}
+	return &models.Membership{LibraryID: libraryID, UserID: member.ID, Username: member.Username, Role: role}, nil
+}
+
+// RemoveMember takes the user with the given name out of a library. Owners
+// remove anyone, and members remove themselves.
+func (s *Service) RemoveMember(ctx context.Context, user *models.User, libraryID int, username string) error {
+	if username != user.Username {
+		if err := s.requireOwner(ctx, user, libraryID); err != nil {
+			return err
+		}
+	}
+	member, err := s.users.GetUserByUsername(ctx, username)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return repository.ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if member.ID == libraryID {
+		return ErrOwnLibrary
+	}
+	return s.libraries.RemoveMember(ctx, libraryID, member.ID)
+}
+
+func (s *Service) requireOwner(ctx context.Context, user *models.User, libraryID int) error {
+	role, err := s.LibraryRole(ctx, user, libraryID)
+	if err != nil {
+		return err
+	}
+	if !role.Allows(models.RoleOwner) {
+		return ErrNotLibraryOwner
+	}
+	return nil
+}
+
+type roleKey struct{}
+
+// WithRole returns a context carrying the signed-in user's role in the
+// library the request is for.
+func WithRole(ctx context.Context, role models.Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// Role returns the role stored in ctx. Contexts without one act as the
+// owner, as users do in their own library.
+func Role(ctx context.Context) models.Role {
+	if role, ok := ctx.Value(roleKey{}).(models.Role); ok {
+		return role
+	}
+	return models.RoleOwner
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Middleware authenticates requests by a session or API token, or an
+// OpenID Connect ID token, in an "Authorization: Bearer" header or, for
+// e-reader and feed reader apps that only know passwords, HTTP basic
+// authentication. Other requests are answered 401 Unauthorized, and
+// requests by API tokens without the scope their method needs 403
+// Forbidden. Authenticated requests only reach the books of the user's own
+// library or, given its ID in an "X-Library" header, a library they are a
+// member of. Their changes are recorded as made by the user.
+func Middleware(s *Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			header := r.Header.Get("Authorization")
+			var user *models.User
+			var token *models.APIToken
+			var err error
+			if bearer, ok := strings.CutPrefix(header, "Bearer "); ok {
+				bearer = strings.TrimSpace(bearer)
+				if strings.HasPrefix(bearer, TokenPrefix) {
+					user, token, err = s.AuthenticateToken(ctx, bearer)
+				} else if looksLikeJWT(bearer) {
+					user, err = s.AuthenticateJWT(ctx, bearer)
+				} else {
+					user, err = s.Authenticate(ctx, bearer)
+				}
+			} else if username, password, ok := r.BasicAuth(); ok {
+				user, err = s.CheckPassword(ctx, username, password)
+			} else {
+				err = ErrInvalidLogin
+			}
+			if errors.Is(err, ErrInvalidLogin) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="book-tracker", charset="UTF-8"`)
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if errors.Is(err, ErrKeysUnavailable) {
+				log.Printf("Failed to authenticate request: %v", err)
+				http.Error(w, "Identity provider unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if errors.Is(err, repository.ErrUsernameTaken) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to authenticate request: %v", err)
+				http.Error(w, "Authentication failed", http.StatusInternalServerError)
+				return
+			}
+			if token != nil {
+				if scope := methodScope(r.Method); !token.HasScope(scope) {
+					http.Error(w, "API token lacks the "+scope+" scope", http.StatusForbidden)
+					return
+				}
+				ctx = WithToken(ctx, token)
+			}
+			libraryID := user.ID
+			if v := r.Header.Get("X-Library"); v != "" {
+				if libraryID, err = strconv.Atoi(v); err != nil {
+					http.Error(w, "Invalid library ID", http.StatusBadRequest)
+					return
+				}
+			}
+			role, err := s.LibraryRole(ctx, user, libraryID)
+			if errors.Is(err, repository.ErrLibraryNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to look up library role: %v", err)
+				http.Error(w, "Authentication failed", http.StatusInternalServerError)
+				return
+			}
+			ctx = WithUser(ctx, user)
+			ctx = WithRole(ctx, role)
+			ctx = repository.WithOwner(ctx, libraryID)
+			ctx = audit.WithActor(ctx, user.Username)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// methodScope is the scope an API token needs to make a request with the
+// given method.
+func methodScope(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return models.ScopeBooksRead
+	}
+	return models.ScopeBooksWrite
+}
+
+// RequireAdmin answers 403 Forbidden to users who are not admins, and to
+// API tokens without the admin scope. It runs after Middleware.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := User(r.Context()); user == nil || !user.Admin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		if !HasScope(r.Context(), models.ScopeAdmin) {
+			http.Error(w, "API token lacks the "+models.ScopeAdmin+" scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSession answers 403 Forbidden to requests made with API tokens,
+// so a leaked token cannot be used to mint more or to let others into its
+// library. It runs after Middleware.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Token(r.Context()) != nil {
+			http.Error(w, "API tokens cannot manage API tokens or library members; sign in with a password", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyCacheTTL is how long an identity provider's signing keys are
+// used before they are fetched again, unless configured.
+const DefaultKeyCacheTTL = time.Hour
+
+// DefaultKeyRefreshInterval is the least time between fetches of an
+// identity provider's signing keys, unless configured.
+const DefaultKeyRefreshInterval = time.Minute
+
+// ErrKeysUnavailable wraps errors fetching a provider's discovery document
+// or signing keys, as opposed to tokens that fail verification.
+var ErrKeysUnavailable = errors.New("identity provider keys unavailable")
+
+// signingMethods are the JWT algorithms accepted from providers. Symmetric
+// and unsigned tokens are never accepted.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// OIDCConfig configures signing in with an OpenID Connect provider's ID
+// tokens.
+type OIDCConfig struct {
+	// Issuer is the provider's issuer URL. Its discovery document, at
+	// /.well-known/openid-configuration, names where the keys are.
+	Issuer string
+	// Audience is the client ID tokens must be issued to.
+	Audience string
+	// UsernameClaim names the claim local usernames are taken from,
+	// "preferred_username" unless set.
+	UsernameClaim string
+	// KeyCacheTTL is how long signing keys are cached, DefaultKeyCacheTTL
+	// unless set.
+	KeyCacheTTL time.Duration
+	// KeyRefreshInterval is the least time between fetches, so tokens
+	// naming made-up keys cannot flood the provider with requests.
+	// DefaultKeyRefreshInterval unless set.
+	KeyRefreshInterval time.Duration
+	// Client fetches the discovery document and keys. A client with a ten
+	// second timeout is used unless set.
+	Client *http.Client
+}
+
+// Identity is who a verified ID token says its bearer is.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+}
+
+// OIDC verifies ID tokens against an OpenID Connect provider's published
+// keys. Keys are cached and fetched again once they are older than the
+// cache TTL or a token names a key not seen yet, which is how providers
+// rotate keys. Should a fetch fail, the cached keys are used until it
+// succeeds.
+type OIDC struct {
+	cfg OIDCConfig
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]any
+	fetchedAt time.Time
+	triedAt   time.Time
+	// fetching is closed when the fetch in progress, if any, is done.
+	fetching chan struct{}
+}
+
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.KeyCacheTTL <= 0 {
+		cfg.KeyCacheTTL = DefaultKeyCacheTTL
+	}
+	if cfg.KeyRefreshInterval <= 0 {
+		cfg.KeyRefreshInterval = DefaultKeyRefreshInterval
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDC{cfg: cfg}
+}
+
+// Verify checks an ID token's signature, issuer, audience and expiry and
+// returns the identity it asserts. Returns an error wrapping
+// ErrInvalidLogin for tokens that fail verification and one wrapping
+// ErrKeysUnavailable when the provider's keys could not be fetched.
+func (o *OIDC) Verify(ctx context.Context, raw string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.key(ctx, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(o.cfg.Issuer),
+		jwt.WithAudience(o.cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if errors.Is(err, ErrKeysUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogin, err)
+	}
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[o.cfg.UsernameClaim].(string)
+	if subject == "" || !models.ValidUsername(username) {
+		return nil, fmt.Errorf("%w: token has no subject or no usable %s claim", ErrInvalidLogin, o.cfg.UsernameClaim)
+	}
+	return &Identity{Issuer: o.cfg.Issuer, Subject: subject, Username: username}, nil
+}
+
+// key returns the public key with the given ID, fetching the keys again if
+// they are stale or the ID is unknown, at most once per refresh interval.
+// Tokens without a key ID are accepted when the provider has a single key.
+// The fetch runs without the lock, so verifying tokens with known keys never
+// waits on the provider; tokens naming an unknown key wait for the fetch in
+// progress.
+func (o *OIDC) key(ctx context.Context, kid string) (any, error) {
+	o.mu.Lock()
+	_, ok := o.lookup(kid)
+	stale := time.Since(o.fetchedAt) > o.cfg.KeyCacheTTL
+	var done chan struct{}
+	if (stale || !ok) && o.fetching == nil && time.Since(o.triedAt) > o.cfg.KeyRefreshInterval {
+		o.triedAt = time.Now()
+		done = make(chan struct{})
+		o.fetching = done
+	}
+	wait, jwksURL := o.fetching, o.jwksURL
+	o.mu.Unlock()
+
+	var fetchErr error
+	switch {
+	case done != nil:
+		// Other requests may be waiting on the fetch, so it outlives this
+		// one; the client's timeout bounds it
+		keys, url, err := o.fetch(context.WithoutCancel(ctx), jwksURL)
+		o.mu.Lock()
+		if url != "" {
+			o.jwksURL = url
+		}
+		if err == nil {
+			o.keys, o.fetchedAt = keys, time.Now()
+		}
+		o.fetching = nil
+		close(done)
+		o.mu.Unlock()
+		fetchErr = err
+	case wait != nil && !ok:
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key, ok := o.lookup(kid)
+	if fetchErr != nil && !ok {
+		return nil, fetchErr
+	}
+	if len(o.keys) == 0 {
+		return nil, fmt.Errorf("%w: fetching them again shortly", ErrKeysUnavailable)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (o *OIDC) lookup(kid string) (any, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// fetch fetches the provider's keys from jwksURL, discovering it first if
+// it is not known yet, and returns them with the URL.
+func (o *OIDC) fetch(ctx context.Context, jwksURL string) (map[string]any, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.get(ctx, o.cfg.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", err
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != o.cfg.Issuer || discovery.JWKSURI == "" {
+			return nil, "", fmt.Errorf("%w: discovery document is for issuer %q without keys", ErrKeysUnavailable, discovery.Issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.get(ctx, jwksURL, &set); err != nil {
+		return nil, jwksURL, err
+	}
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, jwksURL, fmt.Errorf("%w: no usable signing keys at %s", ErrKeysUnavailable, jwksURL)
+	}
+	return keys, jwksURL, nil
+}
+
+func (o *OIDC) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	resp, err := o.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", ErrKeysUnavailable, url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrKeysUnavailable, url, err)
+	}
+	return nil
+}
+
+// jwk is a JSON Web Key (RFC 7517) holding an RSA or elliptic curve public
+// key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func base64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// UseOIDC makes the service accept ID tokens from an OpenID Connect
+// provider as bearer tokens.
+func (s *Service) UseOIDC(o *OIDC) {
+	s.oidc = o
+}
+
+// AuthenticateJWT returns the user an ID token identifies. The first time
+// an identity signs in, a user without a password is created for it, named
+// after the token's username claim. Returns an error wrapping
+// repository.ErrUsernameTaken if that name belongs to another account.
+func (s *Service) AuthenticateJWT(ctx context.Context, raw string) (*models.User, error) {
+	if s.oidc == nil {
+		return nil, ErrInvalidLogin
+	}
+	identity, err := s.oidc.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return user, err
+	}
+	err = s.store.RunInTx(ctx, func(repos repository.Repos) error {
+		user = &models.User{Username: identity.Username, OIDCIssuer: identity.Issuer, OIDCSubject: identity.Subject}
+		return repos.Users.CreateUser(ctx, user)
+	})
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		// A concurrent request may have created the user first
+		if user, err := s.users.GetUserByIdentity(ctx, identity.Issuer, identity.Subject); err == nil {
+			return user, nil
+		}
+		return nil, fmt.Errorf("%w: cannot sign %s in from %s", repository.ErrUsernameTaken, identity.Username, identity.Issuer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// looksLikeJWT reports whether a bearer token has a JWT's three
+// dot-separated parts, which session and API tokens never do.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// TokenPrefix starts every API token, so the middleware tells them from
+// session tokens and secret scanners can spot leaked ones.
+const TokenPrefix = "bt_"
+
+// CreateToken issues an API token for a user. The returned token carries
+// its secret, which is not stored and cannot be shown again. Returns
+// models.ErrInvalidToken for an unusable request.
+func (s *Service) CreateToken(ctx context.Context, user *models.User, req models.APITokenRequest) (*models.APIToken, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	token := &models.APIToken{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Token:     secret,
+		Prefix:    secret[:len(TokenPrefix)+6],
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.users.CreateAPIToken(ctx, hashToken(secret), token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens returns a user's API tokens without their secrets.
+func (s *Service) ListTokens(ctx context.Context, user *models.User) ([]models.APIToken, error) {
+	return s.users.ListAPITokens(ctx, user.ID)
+}
+
+// RevokeToken deletes one of a user's API tokens. Returns
+// repository.ErrTokenNotFound if the user has no such token.
+func (s *Service) RevokeToken(ctx context.Context, user *models.User, id int) error {
+	return s.users.DeleteAPIToken(ctx, user.ID, id)
+}
+
+// AuthenticateToken returns the user an API token belongs to, and the
+// token, noting that it was used.
+func (s *Service) AuthenticateToken(ctx context.Context, secret string) (*models.User, *models.APIToken, error) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return nil, nil, ErrInvalidLogin
+	}
+	token, err := s.users.UseAPIToken(ctx, hashToken(secret))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return nil, nil, ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.users.GetUser(ctx, token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, token, nil
+}
+
+type tokenKey struct{}
+
+// WithToken returns a context carrying the API token a request was
+// authenticated with.
+func WithToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// Token returns the API token stored in ctx, or nil when the request was
+// authenticated some other way.
+func Token(ctx context.Context) *models.APIToken {
+	token, _ := ctx.Value(tokenKey{}).(*models.APIToken)
+	return token
+}
+
+// HasScope reports whether the request in ctx may do what scope allows.
+// Sessions and passwords allow everything; API tokens only what they were
+// granted.
+func HasScope(ctx context.Context, scope string) bool {
+	token := Token(ctx)
+	return token == nil || token.HasScope(scope)
+}
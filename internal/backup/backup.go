@@ -0,0 +1,487 @@
+// Package backup writes and restores a complete copy of the book store as
+// a versioned, checksummed NDJSON archive.
+//
+// An archive is one JSON object per line: a header naming the format and
+// schema version, one line per row, and a trailer with the row counts and
+// the SHA-256 of every line before it.
+//
+//	{"format":"book-tracker-backup","schema_version":1,"created_at":"..."}
+//	{"type":"user","data":{...}}
+//	{"type":"member","data":{...}}
+//	{"type":"book","data":{...}}
+//	{"type":"highlight","data":{...}}
+//	{"type":"cover","data":{...}}
+//	{"type":"audit","data":{...}}
+//	{"type":"end","counts":{"audit":1,"book":1,"cover":1,"highlight":1,"member":1,"user":1},"sha256":"..."}
+package backup
+
+import (
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+)
+
+// Format identifies a backup archive.
+const Format = "book-tracker-backup"
+
+// SchemaVersion is the version of the row shapes written by Write. Bump it
+// when a field is renamed or changes meaning; new fields alone do not need
+// a bump, as restoring an older archive leaves them at their zero value.
+const SchemaVersion = 1
+
+// Row types in an archive.
+const (
+	TypeUser      = "user"
+	TypeMember    = "member"
+	TypeBook      = "book"
+	TypeHighlight = "highlight"
+	TypeCover     = "cover"
+	TypeAudit     = "audit"
+	typeEnd       = "end"
+)
+
+var (
+	// ErrNotEmpty is returned when restoring into a store that holds books
+	// without asking to replace them.
+	ErrNotEmpty = errors.New("store already holds books; restore with replace to overwrite them")
+	// ErrInvalidArchive is returned for archives that are truncated,
+	// corrupted or not backups at all.
+	ErrInvalidArchive = errors.New("invalid backup archive")
+)
+
+// Header is the first line of an archive.
+type Header struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type line struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Counts map[string]int  `json:"counts,omitempty"`
+	SHA256 string          `json:"sha256,omitempty"`
+}
+
+// userRow adds the password hash and identity the API hides to a user.
+type userRow struct {
+	models.User
+	PasswordHash string `json:"password_hash"`
+	OIDCIssuer   string `json:"oidc_issuer,omitempty"`
+	OIDCSubject  string `json:"oidc_subject,omitempty"`
+}
+
+// highlightRow adds the fingerprint the API hides to a highlight.
+type highlightRow struct {
+	models.Highlight
+	Fingerprint string `json:"fingerprint"`
+}
+
+// coverRow adds a cover's image, which lives in blob storage, to the cover.
+type coverRow struct {
+	models.Cover
+	Data []byte `json:"data"`
+}
+
+// Write writes every user, library member, book, highlight, cover and audit entry in the store to w,
+// reading them all in one transaction so the archive is consistent. Cover
+// images are read from blobs.
+func Write(ctx context.Context, store repository.StoreInterface, blobs blob.Store, w io.Writer) error {
+	attempts := 0
+	return store.RunInTx(ctx, func(repos repository.Repos) error {
+		// Rows already sent cannot be taken back, so a retried
+		// transaction must fail instead of writing them twice
+		if attempts++; attempts > 1 {
+			return errors.New("backup was interrupted by a concurrent change; try again")
+		}
+		aw := newArchiveWriter(w)
+		err := aw.write(Header{Format: Format, SchemaVersion: SchemaVersion, CreatedAt: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportUsers(ctx, func(u models.User) error {
+			return aw.row(TypeUser, userRow{User: u, PasswordHash: u.PasswordHash, OIDCIssuer: u.OIDCIssuer, OIDCSubject: u.OIDCSubject})
+		})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportMembers(ctx, func(m models.Membership) error {
+			return aw.row(TypeMember, m)
+		})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportBooks(ctx, func(b models.Book) error {
+			return aw.row(TypeBook, b)
+		})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportHighlights(ctx, func(h models.Highlight) error {
+			return aw.row(TypeHighlight, highlightRow{Highlight: h, Fingerprint: h.Fingerprint})
+		})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportCovers(ctx, func(c models.Cover) error {
+			data := c.Data
+			if data == nil {
+				var err error
+				data, err = blobs.Get(ctx, covers.OriginalKey(&c))
+				if errors.Is(err, blob.ErrNotFound) {
+					// Nothing could restore the cover, so the backup goes on
+					log.Printf("Skipping cover of book %d: its image is missing from blob storage", c.BookID)
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return aw.row(TypeCover, coverRow{Cover: c, Data: data})
+		})
+		if err != nil {
+			return err
+		}
+		err = repos.Backup.ExportAudit(ctx, func(e models.AuditEntry) error {
+			return aw.row(TypeAudit, e)
+		})
+		if err != nil {
+			return err
+		}
+		return aw.close()
+	})
+}
+
+type archiveWriter struct {
+	w      *bufio.Writer
+	sum    hash.Hash
+	counts map[string]int
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{w: bufio.NewWriter(w), sum: sha256.New(), counts: make(map[string]int)}
+}
+
+func (a *archiveWriter) write(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	a.sum.Write(b)
+	_, err = a.w.Write(b)
+	return err
+}
+
+func (a *archiveWriter) row(typ string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	a.counts[typ]++
+	return a.write(line{Type: typ, Data: data})
+}
+
+func (a *archiveWriter) close() error {
+	end := line{Type: typeEnd, Counts: a.counts, SHA256: hex.EncodeToString(a.sum.Sum(nil))}
+	b, err := json.Marshal(end)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return a.w.Flush()
+}
+
+// Options control how Restore writes an archive.
+type Options struct {
+	// Replace deletes every book, highlight, cover and audit entry in the store
+	// before restoring. Without it, restoring into a store that holds
+	// books fails with ErrNotEmpty.
+	Replace bool
+}
+
+// Summary reports what a restore wrote. UsernameCollisions names the
+// archive's users merged into an existing user of the same name with a
+// different password or OIDC identity.
+type Summary struct {
+	SchemaVersion      int            `json:"schema_version"`
+	CreatedAt          time.Time      `json:"created_at"`
+	Counts             map[string]int `json:"counts"`
+	UsernameCollisions []string       `json:"username_collisions,omitempty"`
+}
+
+// Restore verifies an archive and writes its rows into the store in one
+// unit of work. Rows get new ids; highlights and audit entries are pointed
+// at the new ids of their books, and audit history of purged books gets
+// fresh ids of its own. Users are merged into the existing user with their
+// OIDC identity or, failing that, their name, and name collisions are
+// reported. Books and history without an owner, as in archives from before
+// there were accounts, go to the owner in ctx, if any. Nothing is written
+// unless the whole archive is valid.
+func Restore(ctx context.Context, store repository.StoreInterface, r io.Reader, opts Options) (*Summary, error) {
+	hdr, rows, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{SchemaVersion: hdr.SchemaVersion, CreatedAt: hdr.CreatedAt, Counts: make(map[string]int)}
+	err = store.RunInTx(ctx, func(repos repository.Repos) error {
+		clear(summary.Counts)
+		summary.UsernameCollisions = nil
+		if opts.Replace {
+			if err := repos.Backup.DeleteAll(ctx); err != nil {
+				return err
+			}
+		} else if n, err := repos.Backup.CountBooks(ctx); err != nil {
+			return err
+		} else if n > 0 {
+			return ErrNotEmpty
+		}
+
+		bookIDs, userIDs := make(map[int]int), make(map[int]int)
+		owner := func(id *int) (*int, error) {
+			if id == nil {
+				if restorer, ok := repository.Owner(ctx); ok {
+					return &restorer, nil
+				}
+				return nil, nil
+			}
+			newID, ok := userIDs[*id]
+			if !ok {
+				return nil, fmt.Errorf("%w: row belongs to missing user %d", ErrInvalidArchive, *id)
+			}
+			return &newID, nil
+		}
+		// historyBookID is the new id of the book an audit entry names. The
+		// history of purged books is kept under ids no restored book uses.
+		historyBookID := func(id int) (int, error) {
+			if newID, ok := bookIDs[id]; ok {
+				return newID, nil
+			}
+			reserved, err := repos.Backup.ReserveBookID(ctx)
+			if err != nil {
+				return 0, err
+			}
+			bookIDs[id] = reserved
+			return reserved, nil
+		}
+		for _, row := range rows {
+			switch row.Type {
+			case TypeUser:
+				var u userRow
+				if err := json.Unmarshal(row.Data, &u); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				oldID := u.ID
+				u.User.PasswordHash, u.User.OIDCIssuer, u.User.OIDCSubject = u.PasswordHash, u.OIDCIssuer, u.OIDCSubject
+				collided, err := repos.Backup.InsertUser(ctx, &u.User)
+				if err != nil {
+					return err
+				}
+				if collided {
+					summary.UsernameCollisions = append(summary.UsernameCollisions, u.Username)
+				}
+				userIDs[oldID] = u.User.ID
+			case TypeMember:
+				var m models.Membership
+				if err := json.Unmarshal(row.Data, &m); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				library, ok := userIDs[m.LibraryID]
+				user, ok2 := userIDs[m.UserID]
+				if !ok || !ok2 {
+					return fmt.Errorf("%w: membership of missing user %d or library %d", ErrInvalidArchive, m.UserID, m.LibraryID)
+				}
+				m.LibraryID, m.UserID = library, user
+				if library != user {
+					if err := repos.Backup.InsertMember(ctx, &m); err != nil {
+						return err
+					}
+				}
+			case TypeBook:
+				var b models.Book
+				if err := json.Unmarshal(row.Data, &b); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				var err error
+				if b.OwnerID, err = owner(b.OwnerID); err != nil {
+					return err
+				}
+				oldID := b.ID
+				if err := repos.Backup.InsertBook(ctx, &b); err != nil {
+					return err
+				}
+				bookIDs[oldID] = b.ID
+			case TypeHighlight:
+				var h highlightRow
+				if err := json.Unmarshal(row.Data, &h); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				id, ok := bookIDs[h.BookID]
+				if !ok {
+					return fmt.Errorf("%w: highlight %d belongs to missing book %d", ErrInvalidArchive, h.ID, h.BookID)
+				}
+				h.Highlight.BookID, h.Highlight.Fingerprint = id, h.Fingerprint
+				if err := repos.Backup.InsertHighlight(ctx, &h.Highlight); err != nil {
+					return err
+				}
+			case TypeCover:
+				var c coverRow
+				if err := json.Unmarshal(row.Data, &c); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				id, ok := bookIDs[c.BookID]
+				if !ok {
+					return fmt.Errorf("%w: cover belongs to missing book %d", ErrInvalidArchive, c.BookID)
+				}
+				// The image stays in the database until the cover is first
+				// read, which moves it to blob storage
+				c.Cover.BookID, c.Cover.Data = id, c.Data
+				if err := repos.Backup.InsertCover(ctx, &c.Cover); err != nil {
+					return err
+				}
+			case TypeAudit:
+				var e models.AuditEntry
+				if err := json.Unmarshal(row.Data, &e); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+				}
+				id, err := historyBookID(e.BookID)
+				if err != nil {
+					return err
+				}
+				// States copied from a merged book's history are that
+				// book's
+				stateID := id
+				if e.MergedFrom != nil {
+					if stateID, err = historyBookID(*e.MergedFrom); err != nil {
+						return err
+					}
+					e.MergedFrom = &stateID
+				}
+				if e.OwnerID, err = owner(e.OwnerID); err != nil {
+					return err
+				}
+				e.BookID = id
+				e.Before, e.After = withIDs(e.Before, stateID, e.OwnerID), withIDs(e.After, stateID, e.OwnerID)
+				if err := repos.Backup.InsertAuditEntry(ctx, &e); err != nil {
+					return err
+				}
+			default:
+				// Unknown row types come from newer versions with the
+				// same schema version and carry nothing this one stores
+				continue
+			}
+			summary.Counts[row.Type]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Row is one data line of an archive.
+type Row struct {
+	Type string
+	Data json.RawMessage
+}
+
+// Read parses and verifies an archive: the header must name a supported
+// schema version, and the trailer's counts and checksum must match the
+// rows.
+func Read(r io.Reader) (Header, []Row, error) {
+	var hdr Header
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	sum := sha256.New()
+	counts := make(map[string]int)
+	var rows []Row
+	first, ended := true, false
+	for sc.Scan() {
+		b := sc.Bytes()
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		if ended {
+			return hdr, nil, fmt.Errorf("%w: data after the trailer", ErrInvalidArchive)
+		}
+		if first {
+			first = false
+			if err := json.Unmarshal(b, &hdr); err != nil || hdr.Format != Format {
+				return hdr, nil, fmt.Errorf("%w: missing header", ErrInvalidArchive)
+			}
+			if hdr.SchemaVersion < 1 || hdr.SchemaVersion > SchemaVersion {
+				return hdr, nil, fmt.Errorf("%w: schema version %d is not supported, expected at most %d",
+					ErrInvalidArchive, hdr.SchemaVersion, SchemaVersion)
+			}
+			sum.Write(b)
+			sum.Write([]byte{'\n'})
+			continue
+		}
+		var l line
+		if err := json.Unmarshal(b, &l); err != nil {
+			return hdr, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if l.Type == typeEnd {
+			if hex.EncodeToString(sum.Sum(nil)) != l.SHA256 {
+				return hdr, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidArchive)
+			}
+			for typ, n := range l.Counts {
+				if counts[typ] != n {
+					return hdr, nil, fmt.Errorf("%w: expected %d %s rows, found %d", ErrInvalidArchive, n, typ, counts[typ])
+				}
+			}
+			ended = true
+			continue
+		}
+		sum.Write(b)
+		sum.Write([]byte{'\n'})
+		counts[l.Type]++
+		rows = append(rows, Row{Type: l.Type, Data: l.Data})
+	}
+	if err := sc.Err(); err != nil {
+		return hdr, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if !ended {
+		return hdr, nil, fmt.Errorf("%w: archive is truncated", ErrInvalidArchive)
+	}
+	return hdr, rows, nil
+}
+
+// withIDs sets the "id" and owner of a book state recorded in an audit
+// entry.
+func withIDs(state json.RawMessage, id int, owner *int) json.RawMessage {
+	if len(state) == 0 || string(state) == "null" {
+		return state
+	}
+	var m map[string]any
+	if err := json.Unmarshal(state, &m); err != nil {
+		return state
+	}
+	m["id"] = id
+	if owner != nil {
+		m["owner_id"] = *owner
+	} else {
+		delete(m, "owner_id")
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return state
+	}
+	return b
+}
@@ -0,0 +1,356 @@
+// Package covers validates book cover images, keeps them in blob storage
+// and makes thumbnails of them.
+package covers
+
+import (
+	"book-tracker/internal/blob"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// MaxBytes caps the size of a cover image.
+const MaxBytes = 10 << 20
+
+// maxPixels caps the dimensions of a cover image, so small files cannot
+// decode to huge images.
+const maxPixels = 40_000_000
+
+// Thumbnail sizes, each the longest side in pixels.
+var Sizes = map[string]int{
+	"small":  128,
+	"medium": 320,
+	"large":  640,
+}
+
+// SizeOriginal names the image as uploaded.
+const SizeOriginal = "original"
+
+// ThumbnailType is the content type of every thumbnail.
+const ThumbnailType = "image/jpeg"
+
+// Content types a cover may have.
+var contentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+var (
+	ErrTooLarge        = fmt.Errorf("cover image is larger than %d MB", MaxBytes>>20)
+	ErrUnsupportedType = errors.New("cover image must be JPEG, PNG or WebP")
+	ErrInvalidImage    = errors.New("invalid cover image")
+	ErrUnknownSize     = errors.New("unknown cover size, expected original, small, medium or large")
+)
+
+// Service stores covers: their description in the repository and their
+// images, as uploaded and as thumbnails, in blob storage.
+type Service struct {
+	repo  repository.CoverRepositoryInterface
+	blobs blob.Store
+}
+
+func NewService(repo repository.CoverRepositoryInterface, blobs blob.Store) *Service {
+	return &Service{repo: repo, blobs: blobs}
+}
+
+// OriginalKey is the blob key of a cover's image as uploaded. Keys contain
+// the image's checksum, so a replaced cover never serves stale blobs.
+func OriginalKey(c *models.Cover) string {
+	return fmt.Sprintf("covers/%d/%s", c.BookID, c.SHA256)
+}
+
+func thumbnailKey(c *models.Cover, size string) string {
+	return OriginalKey(c) + "-" + size
+}
+
+// Set validates an image and makes it a book's cover, replacing any cover
+// it had. contentType is the type the client declared; it may be empty or
+// generic, but must not contradict the image. Returns
+// repository.ErrNotFound if the book does not exist or is in the trash.
+func (s *Service) Set(ctx context.Context, bookID int, data []byte, contentType string) (*models.Cover, error) {
+	if len(data) > MaxBytes {
+		return nil, ErrTooLarge
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !contentTypes[detected] {
+		return nil, ErrUnsupportedType
+	}
+	if declared, _, err := mime.ParseMediaType(contentType); err == nil && contentTypes[declared] && declared != detected {
+		return nil, fmt.Errorf("%w: declared as %s but is %s", ErrInvalidImage, declared, detected)
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	cover := &models.Cover{
+		BookID:      bookID,
+		ContentType: detected,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Size:        len(data),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	previous, err := s.repo.GetCover(ctx, bookID)
+	if errors.Is(err, repository.ErrCoverNotFound) {
+		previous = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Images are stored before the description points at them, so a cover
+	// is never served without its images
+	if err := s.blobs.Put(ctx, OriginalKey(cover), data, detected); err != nil {
+		return nil, err
+	}
+	for size, longest := range Sizes {
+		thumb, err := thumbnail(img, longest)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.blobs.Put(ctx, thumbnailKey(cover, size), thumb, ThumbnailType); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.SetCover(ctx, cover); err != nil {
+		// The images just stored are unused, unless they are the current
+		// cover's too
+		if previous == nil || previous.SHA256 != cover.SHA256 || previous.Data != nil {
+			s.deleteBlobs(ctx, cover)
+		}
+		return nil, err
+	}
+	if previous != nil && previous.SHA256 != cover.SHA256 {
+		s.deleteBlobs(ctx, previous)
+	}
+	cover.Data = data
+	return cover, nil
+}
+
+// Get returns a book's cover with the image of the given size in Data:
+// SizeOriginal (or "") for the image as uploaded, or one of Sizes.
+// Returns repository.ErrCoverNotFound if the book has no cover.
+func (s *Service) Get(ctx context.Context, bookID int, size string) (*models.Cover, error) {
+	longest, ok := Sizes[size]
+	if !ok && size != "" && size != SizeOriginal {
+		return nil, ErrUnknownSize
+	}
+	cover, err := s.repo.GetCover(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cover.Data) > 0 {
+		return heldCover(cover, size, longest), nil
+	}
+	if !ok {
+		if cover.Data, err = s.blobs.Get(ctx, OriginalKey(cover)); err != nil {
+			return nil, err
+		}
+		return cover, nil
+	}
+
+	thumb, err := s.blobs.Get(ctx, thumbnailKey(cover, size))
+	if errors.Is(err, blob.ErrNotFound) {
+		// Thumbnails lost from blob storage are made again from the original
+		if thumb, err = s.makeThumbnail(ctx, cover, size, longest); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	cover.ContentType, cover.Size, cover.Data = ThumbnailType, len(thumb), thumb
+	return cover, nil
+}
+
+// heldCover serves a cover whose image is still held in the database until
+// MoveRestored moves it. Thumbnails are made without being stored; images
+// stored before covers were validated, such as GIFs taken from EPUBs, are
+// served as they are at every size.
+func heldCover(cover *models.Cover, size string, longest int) *models.Cover {
+	sum := sha256.Sum256(cover.Data)
+	cover.SHA256, cover.Size = hex.EncodeToString(sum[:]), len(cover.Data)
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(cover.Data))
+	if longest == 0 || !contentTypes[detected] {
+		return cover
+	}
+	img, err := decode(cover.Data)
+	if err != nil {
+		return cover
+	}
+	thumb, err := thumbnail(img, longest)
+	if err != nil {
+		return cover
+	}
+	cover.ContentType, cover.Size, cover.Data = ThumbnailType, len(thumb), thumb
+	return cover
+}
+
+// MoveRestored moves the images of covers held in the database, as
+// restored from backups, to blob storage and returns how many moved.
+// Images that are not valid covers stay in the database. Covers of books
+// in the trash are moved by a later run, once their book is restored, so
+// run it after restores and on start, with a context without an owner to
+// cover every library.
+func (s *Service) MoveRestored(ctx context.Context) (int, error) {
+	ids, err := s.repo.ListHeldCovers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, id := range ids {
+		cover, err := s.repo.GetCover(ctx, id)
+		if errors.Is(err, repository.ErrCoverNotFound) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		if len(cover.Data) == 0 {
+			continue
+		}
+		_, err = s.Set(ctx, id, cover.Data, cover.ContentType)
+		switch {
+		case err == nil:
+			moved++
+		case errors.Is(err, ErrTooLarge), errors.Is(err, ErrUnsupportedType), errors.Is(err, ErrInvalidImage),
+			errors.Is(err, repository.ErrNotFound):
+		default:
+			return moved, fmt.Errorf("moving cover of book %d to blob storage: %w", id, err)
+		}
+	}
+	return moved, nil
+}
+
+func (s *Service) makeThumbnail(ctx context.Context, cover *models.Cover, size string, longest int) ([]byte, error) {
+	original := cover.Data
+	if original == nil {
+		var err error
+		if original, err = s.blobs.Get(ctx, OriginalKey(cover)); err != nil {
+			return nil, err
+		}
+	}
+	img, err := decode(original)
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := thumbnail(img, longest)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(ctx, thumbnailKey(cover, size), thumb, ThumbnailType); err != nil {
+		return nil, err
+	}
+	return thumb, nil
+}
+
+// Merge runs merge, which merges books into a target in one transaction
+// and moves the first source's cover to the target if it has none. Blob
+// keys name the book, so the images of the cover that moves are copied to
+// the target's keys beforehand. Afterwards whichever copy is unused is
+// deleted: the source's if the cover moved, the target's otherwise.
+// Thumbnails are made again from the original when first read.
+func (s *Service) Merge(ctx context.Context, targetID int, sourceIDs []int, merge func() error) error {
+	cover, err := s.forMerge(ctx, targetID, sourceIDs)
+	if err != nil {
+		return err
+	}
+	if cover == nil {
+		return merge()
+	}
+	moved := *cover
+	moved.BookID = targetID
+	// Images still held in the database move with the cover's row, and
+	// lost images cannot be copied
+	if len(cover.Data) == 0 {
+		original, err := s.blobs.Get(ctx, OriginalKey(cover))
+		if err == nil {
+			err = s.blobs.Put(ctx, OriginalKey(&moved), original, cover.ContentType)
+		}
+		if err != nil && !errors.Is(err, blob.ErrNotFound) {
+			return err
+		}
+	}
+	if err := merge(); err != nil {
+		s.deleteBlobs(ctx, &moved)
+		return err
+	}
+	if current, err := s.repo.GetCover(ctx, targetID); err == nil && current.SHA256 == cover.SHA256 {
+		s.deleteBlobs(ctx, cover)
+	} else {
+		s.deleteBlobs(ctx, &moved)
+	}
+	return nil
+}
+
+// forMerge returns the cover the target takes over when the sources are
+// merged into it: the first source's cover, or nil if the target has a
+// cover of its own or no source has one.
+func (s *Service) forMerge(ctx context.Context, targetID int, sourceIDs []int) (*models.Cover, error) {
+	if _, err := s.repo.GetCover(ctx, targetID); !errors.Is(err, repository.ErrCoverNotFound) {
+		return nil, err
+	}
+	for _, id := range sourceIDs {
+		cover, err := s.repo.GetCover(ctx, id)
+		if errors.Is(err, repository.ErrCoverNotFound) {
+			continue
+		}
+		return cover, err
+	}
+	return nil, nil
+}
+
+// Delete removes a book's cover and its images. Returns
+// repository.ErrCoverNotFound if the book has no cover.
+func (s *Service) Delete(ctx context.Context, bookID int) error {
+	cover, err := s.repo.GetCover(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteCover(ctx, bookID); err != nil {
+		return err
+	}
+	s.deleteBlobs(ctx, cover)
+	return nil
+}
+
+// DeleteImages removes the images of covers whose rows are already gone,
+// such as those of books purged from the trash.
+func (s *Service) DeleteImages(ctx context.Context, covers []models.Cover) {
+	for i := range covers {
+		s.deleteBlobs(ctx, &covers[i])
+	}
+}
+
+// deleteBlobs removes a cover's images. Nothing points at them any more,
+// so failures only leave garbage behind and are logged.
+func (s *Service) deleteBlobs(ctx context.Context, cover *models.Cover) {
+	if cover.SHA256 == "" {
+		return
+	}
+	keys := []string{OriginalKey(cover)}
+	for size := range Sizes {
+		keys = append(keys, thumbnailKey(cover, size))
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// ETag identifies one size of a cover's image for HTTP caching.
+func ETag(c *models.Cover, size string) string {
+	if size == "" {
+		size = SizeOriginal
+	}
+	return strconv.Quote(c.SHA256 + "-" + size)
+}
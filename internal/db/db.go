@@ -51,5 +51,191 @@ func NewDB() (*sqlx.DB, error) {
 		return nil, fmt.Errorf("failed to create books table: %w", err)
 	}
 
+	// Apply additive schema changes to databases created by older versions
+	migrations := []string{
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+		`CREATE TABLE IF NOT EXISTS book_audit (
+			id BIGSERIAL PRIMARY KEY,
+			book_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			request_id TEXT NOT NULL,
+			before JSONB,
+			after JSONB,
+			diff JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (book_id, version)
+		)`,
+		`CREATE INDEX IF NOT EXISTS book_audit_created_at_idx ON book_audit (created_at)`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS year INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS shelf TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS source_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS books_source_idx ON books (source, source_id)
+			WHERE source <> '' AND deleted_at IS NULL`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS series TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS series_index DOUBLE PRECISION NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS highlights (
+			id SERIAL PRIMARY KEY,
+			book_id INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			text TEXT NOT NULL DEFAULT '',
+			page TEXT NOT NULL DEFAULT '',
+			location TEXT NOT NULL DEFAULT '',
+			added_at TIMESTAMPTZ,
+			fingerprint TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS highlights_fingerprint_idx ON highlights (book_id, fingerprint)
+			WHERE fingerprint <> ''`,
+		`ALTER TABLE highlights ADD COLUMN IF NOT EXISTS comment TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE highlights ADD COLUMN IF NOT EXISTS color TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE highlights ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`ALTER TABLE highlights ADD COLUMN IF NOT EXISTS search TSVECTOR
+			GENERATED ALWAYS AS (to_tsvector('english', text || ' ' || comment)) STORED`,
+		`CREATE INDEX IF NOT EXISTS highlights_search_idx ON highlights USING GIN (search)`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS book_covers (
+			book_id INTEGER PRIMARY KEY REFERENCES books (id) ON DELETE CASCADE,
+			content_type TEXT NOT NULL,
+			data BYTEA NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		// Cover images moved to blob storage; data only holds images
+		// restored from a backup until they are first read
+		`ALTER TABLE book_covers
+			ALTER COLUMN data DROP NOT NULL,
+			ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS size INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS pages INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS book_enrichment (
+			book_id INTEGER PRIMARY KEY REFERENCES books (id) ON DELETE CASCADE,
+			provider TEXT NOT NULL DEFAULT '',
+			fields TEXT[] NOT NULL DEFAULT '{}',
+			error TEXT NOT NULL DEFAULT '',
+			attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			admin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)`,
+		// Books and their history belong to users; books created before
+		// accounts existed have no owner until the first user claims them
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS owner_id INTEGER REFERENCES users (id) ON DELETE CASCADE`,
+		`CREATE INDEX IF NOT EXISTS books_owner_id_idx ON books (owner_id)`,
+		`ALTER TABLE book_audit ADD COLUMN IF NOT EXISTS owner_id INTEGER`,
+		`CREATE INDEX IF NOT EXISTS book_audit_owner_id_idx ON book_audit (owner_id)`,
+		// Each owner imports from a source independently
+		`DROP INDEX IF EXISTS books_source_idx`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS books_owner_source_idx ON books (COALESCE(owner_id, 0), source, source_id)
+			WHERE source <> '' AND deleted_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens (user_id)`,
+		// Users signed in by an OpenID Connect provider
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject TEXT NOT NULL DEFAULT ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_idx ON users (oidc_issuer, oidc_subject) WHERE oidc_subject <> ''`,
+		// Users share their library, identified by their user id, with
+		// members; a library's own user is always an owner and not listed
+		`CREATE TABLE IF NOT EXISTS library_members (
+			library_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (library_id, user_id),
+			CHECK (library_id <> user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS library_members_user_id_idx ON library_members (user_id)`,
+		// Books' owner_id is the library they belong to. The book repository
+		// runs a library's queries as book_tracker_tenant with its id in
+		// app.library_id, and row-level security keeps that role to the
+		// library's books and their highlights, covers and history. The
+		// tables' owner, connecting, is not restricted
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'book_tracker_tenant') THEN
+				CREATE ROLE book_tracker_tenant NOLOGIN;
+			END IF;
+			IF NOT pg_has_role(current_user, 'book_tracker_tenant', 'MEMBER') THEN
+				EXECUTE format('GRANT book_tracker_tenant TO %I', current_user);
+			END IF;
+			EXECUTE format('GRANT USAGE ON SCHEMA %I TO book_tracker_tenant', current_schema());
+			EXECUTE format('GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA %I TO book_tracker_tenant', current_schema());
+			EXECUTE format('GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA %I TO book_tracker_tenant', current_schema());
+			EXECUTE format('ALTER DEFAULT PRIVILEGES IN SCHEMA %I GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO book_tracker_tenant', current_schema());
+			EXECUTE format('ALTER DEFAULT PRIVILEGES IN SCHEMA %I GRANT USAGE, SELECT ON SEQUENCES TO book_tracker_tenant', current_schema());
+		END
+		$$`,
+		`ALTER TABLE books ENABLE ROW LEVEL SECURITY`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT FROM pg_policies WHERE schemaname = current_schema() AND tablename = 'books' AND policyname = 'books_library') THEN
+				CREATE POLICY books_library ON books TO book_tracker_tenant
+					USING (owner_id = NULLIF(current_setting('app.library_id', true), '')::INTEGER);
+			END IF;
+		END
+		$$`,
+		// Highlights and covers belong to the library of their book, audit
+		// entries to the library recorded with them, which outlives a
+		// purged book
+		`ALTER TABLE highlights ENABLE ROW LEVEL SECURITY`,
+		`ALTER TABLE book_covers ENABLE ROW LEVEL SECURITY`,
+		`ALTER TABLE book_audit ENABLE ROW LEVEL SECURITY`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT FROM pg_policies WHERE schemaname = current_schema() AND tablename = 'highlights' AND policyname = 'highlights_library') THEN
+				CREATE POLICY highlights_library ON highlights TO book_tracker_tenant
+					USING (book_id IN (SELECT id FROM books WHERE owner_id = NULLIF(current_setting('app.library_id', true), '')::INTEGER));
+			END IF;
+			IF NOT EXISTS (SELECT FROM pg_policies WHERE schemaname = current_schema() AND tablename = 'book_covers' AND policyname = 'book_covers_library') THEN
+				CREATE POLICY book_covers_library ON book_covers TO book_tracker_tenant
+					USING (book_id IN (SELECT id FROM books WHERE owner_id = NULLIF(current_setting('app.library_id', true), '')::INTEGER));
+			END IF;
+			IF NOT EXISTS (SELECT FROM pg_policies WHERE schemaname = current_schema() AND tablename = 'book_audit' AND policyname = 'book_audit_library') THEN
+				CREATE POLICY book_audit_library ON book_audit TO book_tracker_tenant
+					USING (owner_id = NULLIF(current_setting('app.library_id', true), '')::INTEGER);
+			END IF;
+		END
+		$$`,
+		// Merged books' history is copied to the book they were merged
+		// into, marked with the book it came from
+		`ALTER TABLE book_audit ADD COLUMN IF NOT EXISTS merged_from INTEGER`,
+	}
+	for _, m := range migrations {
+		if _, err = db.Exec(m); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate schema: %w", err)
+		}
+	}
+
 	return db, nil
 }
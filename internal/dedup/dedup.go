@@ -0,0 +1,297 @@
+package dedup
+
+import (
+	"book-tracker/internal/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Similarity thresholds used when comparing normalized titles and authors.
+const (
+	titleThreshold  = 0.85
+	authorThreshold = 0.75
+)
+
+// Reasons reported for a duplicate group.
+const (
+	ReasonISBN        = "isbn"
+	ReasonTitleAuthor = "title_author"
+)
+
+// Group is a cluster of books that are likely the same work.
+type Group struct {
+	Reason string        `json:"reason"`
+	Books  []models.Book `json:"books"`
+}
+
+// FindDuplicates clusters books that share an ISBN or have a similar
+// normalized title and author. Books without a likely duplicate are omitted.
+func FindDuplicates(books []models.Book) []Group {
+	n := len(books)
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	isbns := make([]string, n)
+	titles := make([]string, n)
+	authors := make([][]string, n)
+	for i, b := range books {
+		isbns[i] = NormalizeISBN(b.ISBN)
+		titles[i] = NormalizeTitle(b.Title)
+		authors[i] = authorTokens(b.Author)
+	}
+
+	byISBN := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			sameISBN := isbns[i] != "" && isbns[i] == isbns[j]
+			if !sameISBN && !similarWork(titles[i], titles[j], authors[i], authors[j]) {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri != rj {
+				parent[rj] = ri
+			}
+			if sameISBN {
+				byISBN[i], byISBN[j] = true, true
+			}
+		}
+	}
+
+	clusters := make(map[int][]int)
+	for i := 0; i < n; i++ {
+		root := find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+
+	var groups []Group
+	for _, members := range clusters {
+		if len(members) < 2 {
+			continue
+		}
+		g := Group{Reason: ReasonTitleAuthor}
+		for _, i := range members {
+			if byISBN[i] {
+				g.Reason = ReasonISBN
+			}
+			g.Books = append(g.Books, books[i])
+		}
+		sort.Slice(g.Books, func(a, b int) bool { return g.Books[a].ID < g.Books[b].ID })
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a].Books[0].ID < groups[b].Books[0].ID })
+	return groups
+}
+
+// Merge combines sources into target, keeping the target's identity, the
+// best progress and rating, and the notes and tags of every book. Fields
+// the target lacks are filled in from the sources.
+func Merge(target models.Book, sources []models.Book) models.Book {
+	merged := target
+	tags := append([]string{}, target.Tags...)
+	notes := []string{}
+	seen := make(map[string]bool)
+	addNote := func(n string) {
+		n = strings.TrimSpace(n)
+		if n != "" && !seen[n] {
+			seen[n] = true
+			notes = append(notes, n)
+		}
+	}
+	addNote(target.Notes)
+	for _, s := range sources {
+		if s.Progress > merged.Progress {
+			merged.Progress = s.Progress
+		}
+		merged.Finished = merged.Finished || s.Finished
+		if merged.Rating == 0 {
+			merged.Rating = s.Rating
+		}
+		if merged.ISBN == "" {
+			merged.ISBN = s.ISBN
+		}
+		if merged.Publisher == "" {
+			merged.Publisher = s.Publisher
+		}
+		if merged.Year == 0 {
+			merged.Year = s.Year
+		}
+		if merged.Pages == 0 {
+			merged.Pages = s.Pages
+		}
+		if merged.Shelf == "" {
+			merged.Shelf = s.Shelf
+		}
+		if merged.Language == "" {
+			merged.Language = s.Language
+		}
+		if merged.Series == "" {
+			merged.Series, merged.SeriesIndex = s.Series, s.SeriesIndex
+		}
+		if s.FinishedAt != nil && (merged.FinishedAt == nil || s.FinishedAt.After(*merged.FinishedAt)) {
+			merged.FinishedAt = s.FinishedAt
+		}
+		tags = append(tags, s.Tags...)
+		addNote(s.Notes)
+	}
+	merged.Tags = models.NewTags(tags...)
+	merged.Notes = strings.Join(notes, "\n\n")
+	return merged
+}
+
+// NormalizeISBN strips separators and converts ISBN-10 to ISBN-13 so both
+// forms of the same edition compare equal. Invalid input yields "".
+func NormalizeISBN(isbn string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(isbn) {
+		if unicode.IsDigit(r) || r == 'X' {
+			b.WriteRune(r)
+		}
+	}
+	s := b.String()
+	switch len(s) {
+	case 13:
+		if strings.ContainsRune(s, 'X') {
+			return ""
+		}
+		return s
+	case 10:
+		if strings.ContainsRune(s[:9], 'X') {
+			return ""
+		}
+		s = "978" + s[:9]
+		sum := 0
+		for i, r := range s {
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return s + string(rune('0'+(10-sum%10)%10))
+	}
+	return ""
+}
+
+// NormalizeTitle lowercases a title and drops punctuation, a leading or
+// library-style trailing article ("Hobbit, The") and any subtitle.
+func NormalizeTitle(title string) string {
+	if i := strings.IndexAny(title, ":("); i > 0 {
+		title = title[:i]
+	}
+	words := tokens(title)
+	if len(words) > 1 {
+		if isArticle(words[0]) {
+			words = words[1:]
+		} else if isArticle(words[len(words)-1]) && strings.Contains(title, ",") {
+			words = words[:len(words)-1]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func isArticle(word string) bool {
+	return word == "the" || word == "a" || word == "an"
+}
+
+// NormalizeAuthor lowercases an author name, drops punctuation and rewrites
+// "Last, First" as "first last".
+func NormalizeAuthor(author string) string {
+	if last, first, ok := strings.Cut(author, ","); ok {
+		author = first + " " + last
+	}
+	return strings.Join(tokens(author), " ")
+}
+
+func authorTokens(author string) []string {
+	return strings.Fields(NormalizeAuthor(author))
+}
+
+func tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SameWork reports whether two books have a similar normalized title and
+// author, the test FindDuplicates uses for books without a shared ISBN.
+func SameWork(a, b models.Book) bool {
+	return similarWork(NormalizeTitle(a.Title), NormalizeTitle(b.Title), authorTokens(a.Author), authorTokens(b.Author))
+}
+
+func similarWork(titleA, titleB string, authorA, authorB []string) bool {
+	if titleA == "" || titleB == "" {
+		return false
+	}
+	if titleA != titleB && similarity(titleA, titleB) < titleThreshold {
+		return false
+	}
+	return authorSimilarity(authorA, authorB) >= authorThreshold
+}
+
+// similarity returns 1 minus the normalized Levenshtein distance of a and b.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// authorSimilarity compares author names token by token, treating a single
+// letter as a match for any token with the same initial ("J. R. R." vs "John").
+func authorSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	used := make([]bool, len(b))
+	matched := 0
+	for _, ta := range a {
+		for j, tb := range b {
+			if used[j] {
+				continue
+			}
+			if ta == tb || tokenInitialMatch(ta, tb) {
+				used[j] = true
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(a))
+}
+
+func tokenInitialMatch(a, b string) bool {
+	if len([]rune(a)) != 1 && len([]rune(b)) != 1 {
+		return false
+	}
+	return []rune(a)[0] == []rune(b)[0]
+}
@@ -0,0 +1,153 @@
+// Package enrich fills in missing book metadata, such as ISBNs, page counts
+// and publishers, from metadata providers.
+package enrich
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoMatch is returned by providers that know nothing about a book.
+	ErrNoMatch = errors.New("no matching metadata found")
+	// ErrLookupFailed wraps the errors of providers that could not be
+	// asked, as opposed to errors saving what they returned.
+	ErrLookupFailed = errors.New("metadata lookup failed")
+)
+
+// Metadata is what a provider knows about a book.
+type Metadata struct {
+	ISBN      string
+	Title     string
+	Authors   []string
+	Publisher string
+	Year      int
+	Pages     int
+}
+
+// MetadataProvider looks books up in a source of bibliographic data. Lookup
+// returns ErrNoMatch when the source has no record of the book.
+type MetadataProvider interface {
+	Name() string
+	Lookup(ctx context.Context, book models.Book) (*Metadata, error)
+}
+
+// Chain tries providers in order and returns the first match.
+type Chain []MetadataProvider
+
+// Ensure Chain implements MetadataProvider
+var _ MetadataProvider = Chain{}
+
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, p := range c {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c Chain) Lookup(ctx context.Context, book models.Book) (*Metadata, error) {
+	for _, p := range c {
+		m, err := p.Lookup(ctx, book)
+		if !errors.Is(err, ErrNoMatch) {
+			return m, err
+		}
+	}
+	return nil, ErrNoMatch
+}
+
+// Fill copies metadata into the fields of book that are empty and returns
+// the JSON names of the fields it filled. Fields already set are never
+// overwritten.
+func Fill(book *models.Book, m *Metadata) []string {
+	filled := []string{}
+	if book.ISBN == "" && m.ISBN != "" {
+		book.ISBN = m.ISBN
+		filled = append(filled, "isbn")
+	}
+	if book.Publisher == "" && m.Publisher != "" {
+		book.Publisher = m.Publisher
+		filled = append(filled, "publisher")
+	}
+	if book.Year == 0 && m.Year > 0 {
+		book.Year = m.Year
+		filled = append(filled, "year")
+	}
+	if book.Pages == 0 && m.Pages > 0 {
+		book.Pages = m.Pages
+		filled = append(filled, "pages")
+	}
+	return filled
+}
+
+// NeedsEnrichment reports whether a book is missing any field Fill sets,
+// the test repository.EnrichmentRepository.ListBooksToEnrich makes in SQL.
+func NeedsEnrichment(book models.Book) bool {
+	return book.ISBN == "" || book.Publisher == "" || book.Year == 0 || book.Pages == 0
+}
+
+// Result reports one enrichment of a book.
+type Result struct {
+	BookID   int          `json:"book_id"`
+	Provider string       `json:"provider"`
+	Matched  bool         `json:"matched"`
+	Fields   []string     `json:"fields"`
+	Book     *models.Book `json:"book,omitempty"`
+}
+
+// Enricher looks books up with a provider and saves what it finds.
+type Enricher struct {
+	provider MetadataProvider
+	store    repository.StoreInterface
+}
+
+func NewEnricher(provider MetadataProvider, store repository.StoreInterface) *Enricher {
+	return &Enricher{provider: provider, store: store}
+}
+
+// Enrich looks a book up and fills its empty fields from the match, as an
+// audited update. The lookup runs outside the transaction; the fields are
+// applied to the book as stored then, so concurrent edits are kept. Every
+// attempt is recorded, so the background job does not retry it too soon.
+// Returns repository.ErrNotFound if the book is gone and ErrLookupFailed if
+// the provider failed.
+func (e *Enricher) Enrich(ctx context.Context, book models.Book) (*Result, error) {
+	result := &Result{BookID: book.ID, Provider: e.provider.Name(), Fields: []string{}}
+	m, lookupErr := e.provider.Lookup(ctx, book)
+	if lookupErr != nil && !errors.Is(lookupErr, ErrNoMatch) {
+		e.record(ctx, result, lookupErr)
+		return nil, fmt.Errorf("%w: %w", ErrLookupFailed, lookupErr)
+	}
+	err := e.store.RunInTx(ctx, func(repos repository.Repos) error {
+		books, err := repos.Books.GetBooksByIDs(ctx, []int{book.ID})
+		if err != nil {
+			return err
+		}
+		current := books[0]
+		result.Matched, result.Fields, result.Book = m != nil, []string{}, &current
+		if m != nil {
+			if result.Fields = Fill(&current, m); len(result.Fields) > 0 {
+				if err := repos.Books.UpdateBook(ctx, &current); err != nil {
+					return err
+				}
+			}
+		}
+		return repos.Enrichment.RecordAttempt(ctx, book.ID, result.Provider, result.Fields, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// record notes a failed lookup. The failure is already being returned, so
+// an error recording it is dropped.
+func (e *Enricher) record(ctx context.Context, result *Result, lookupErr error) {
+	e.store.RunInTx(ctx, func(repos repository.Repos) error {
+		return repos.Enrichment.RecordAttempt(ctx, result.BookID, result.Provider, nil, lookupErr.Error())
+	})
+}
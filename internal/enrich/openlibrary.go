@@ -0,0 +1,251 @@
+package enrich
+
+import (
+	"book-tracker/internal/dedup"
+	"book-tracker/internal/models"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// OpenLibraryDump looks books up in Open Library data dumps
+// (https://openlibrary.org/developers/dumps) read from local files and
+// indexed in memory, so it suits dumps filtered down to the editions of
+// interest. Books are found by ISBN, or by title and author when the
+// authors dump is loaded too. Malformed lines are skipped.
+type OpenLibraryDump struct {
+	byISBN  map[string]*olEdition
+	byTitle map[string][]*olEdition
+	authors map[string]string
+	works   map[string][]string
+	skipped int
+}
+
+// Ensure OpenLibraryDump implements MetadataProvider
+var _ MetadataProvider = &OpenLibraryDump{}
+
+type olRef struct {
+	Key string `json:"key"`
+}
+
+type olEdition struct {
+	Title         string   `json:"title"`
+	ISBN10        []string `json:"isbn_10"`
+	ISBN13        []string `json:"isbn_13"`
+	Publishers    []string `json:"publishers"`
+	NumberOfPages int      `json:"number_of_pages"`
+	PublishDate   string   `json:"publish_date"`
+	Authors       []olRef  `json:"authors"`
+	Works         []olRef  `json:"works"`
+}
+
+type olWork struct {
+	Authors []struct {
+		Author olRef `json:"author"`
+	} `json:"authors"`
+}
+
+type olAuthor struct {
+	Name string `json:"name"`
+}
+
+// LoadOpenLibraryDump reads dump files, gzipped when their name ends in
+// ".gz". Editions, works and authors may be in one file or several.
+func LoadOpenLibraryDump(paths ...string) (*OpenLibraryDump, error) {
+	d := newOpenLibraryDump()
+	for _, path := range paths {
+		if err := d.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// ReadOpenLibraryDump reads uncompressed dumps.
+func ReadOpenLibraryDump(readers ...io.Reader) (*OpenLibraryDump, error) {
+	d := newOpenLibraryDump()
+	for i, r := range readers {
+		if err := d.read(r); err != nil {
+			return nil, fmt.Errorf("dump %d: %w", i+1, err)
+		}
+	}
+	return d, nil
+}
+
+func newOpenLibraryDump() *OpenLibraryDump {
+	return &OpenLibraryDump{
+		byISBN:  make(map[string]*olEdition),
+		byTitle: make(map[string][]*olEdition),
+		authors: make(map[string]string),
+		works:   make(map[string][]string),
+	}
+}
+
+func (d *OpenLibraryDump) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := d.read(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// read indexes one dump. Each line holds the record type, key, revision,
+// last modified time and the record as JSON, separated by tabs. Lines that
+// are not, or whose record does not decode, such as an edition with its
+// page count as a string, are counted and skipped.
+func (d *OpenLibraryDump) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 5)
+		if len(fields) != 5 {
+			d.skipped++
+			continue
+		}
+		typ, key, data := fields[0], fields[1], []byte(fields[4])
+		var err error
+		switch typ {
+		case "/type/edition":
+			var e olEdition
+			if err = json.Unmarshal(data, &e); err == nil {
+				d.addEdition(&e)
+			}
+		case "/type/work":
+			var w olWork
+			if err = json.Unmarshal(data, &w); err == nil {
+				for _, a := range w.Authors {
+					d.works[key] = append(d.works[key], a.Author.Key)
+				}
+			}
+		case "/type/author":
+			var a olAuthor
+			if err = json.Unmarshal(data, &a); err == nil && a.Name != "" {
+				d.authors[key] = a.Name
+			}
+		}
+		if err != nil {
+			d.skipped++
+		}
+	}
+	return scanner.Err()
+}
+
+// Skipped returns the number of malformed lines skipped while loading.
+func (d *OpenLibraryDump) Skipped() int {
+	return d.skipped
+}
+
+func (d *OpenLibraryDump) addEdition(e *olEdition) {
+	for _, isbn := range append(append([]string{}, e.ISBN13...), e.ISBN10...) {
+		if n := dedup.NormalizeISBN(isbn); n != "" {
+			if _, ok := d.byISBN[n]; !ok {
+				d.byISBN[n] = e
+			}
+		}
+	}
+	if title := dedup.NormalizeTitle(e.Title); title != "" {
+		d.byTitle[title] = append(d.byTitle[title], e)
+	}
+}
+
+func (d *OpenLibraryDump) Name() string {
+	return "openlibrary-dump"
+}
+
+// Lookup finds the edition with the book's ISBN or, failing that, the most
+// complete edition with a similar title by a similar author.
+func (d *OpenLibraryDump) Lookup(ctx context.Context, book models.Book) (*Metadata, error) {
+	if e, ok := d.byISBN[dedup.NormalizeISBN(book.ISBN)]; ok {
+		return d.metadata(e), nil
+	}
+	var best *olEdition
+	for _, e := range d.byTitle[dedup.NormalizeTitle(book.Title)] {
+		if !d.byAuthor(e, book) {
+			continue
+		}
+		if best == nil || completeness(e) > completeness(best) {
+			best = e
+		}
+	}
+	if best == nil {
+		return nil, ErrNoMatch
+	}
+	return d.metadata(best), nil
+}
+
+func (d *OpenLibraryDump) byAuthor(e *olEdition, book models.Book) bool {
+	for _, name := range d.authorNames(e) {
+		if dedup.SameWork(models.Book{Title: e.Title, Author: name}, book) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorNames resolves an edition's authors, or its work's when the
+// edition names none.
+func (d *OpenLibraryDump) authorNames(e *olEdition) []string {
+	var keys []string
+	for _, a := range e.Authors {
+		keys = append(keys, a.Key)
+	}
+	if len(keys) == 0 {
+		for _, w := range e.Works {
+			keys = append(keys, d.works[w.Key]...)
+		}
+	}
+	var names []string
+	for _, key := range keys {
+		if name, ok := d.authors[key]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func completeness(e *olEdition) int {
+	n := 0
+	for _, ok := range []bool{len(e.ISBN13)+len(e.ISBN10) > 0, len(e.Publishers) > 0, e.NumberOfPages > 0, e.PublishDate != ""} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+var yearPattern = regexp.MustCompile(`\b(1[0-9]|20)[0-9]{2}\b`)
+
+func (d *OpenLibraryDump) metadata(e *olEdition) *Metadata {
+	m := &Metadata{Title: e.Title, Authors: d.authorNames(e), Pages: e.NumberOfPages}
+	for _, isbn := range append(append([]string{}, e.ISBN13...), e.ISBN10...) {
+		if m.ISBN = dedup.NormalizeISBN(isbn); m.ISBN != "" {
+			break
+		}
+	}
+	if len(e.Publishers) > 0 {
+		m.Publisher = strings.TrimSpace(e.Publishers[0])
+	}
+	if y := yearPattern.FindString(e.PublishDate); y != "" {
+		fmt.Sscan(y, &m.Year)
+	}
+	return m
+}
@@ -0,0 +1,325 @@
+// Package epub reads the metadata and cover image of EPUB 2 and 3 files.
+package epub
+
+import (
+	"archive/zip"
+	"book-tracker/internal/models"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxCoverBytes caps the cover image read from an EPUB.
+const maxCoverBytes = 10 << 20
+
+// ErrInvalidEPUB is returned for files that are not EPUBs or lack the
+// package document.
+var ErrInvalidEPUB = errors.New("invalid EPUB")
+
+// Metadata is what an EPUB's package document says about the book.
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Identifiers []string
+	ISBN        string
+	Language    string
+	Publisher   string
+	Year        int
+	Subjects    []string
+	Series      string
+	SeriesIndex float64
+	Cover       []byte
+	CoverType   string
+}
+
+// Book is a new book record built from the metadata.
+func (m *Metadata) Book() models.Book {
+	return models.Book{
+		Title:       m.Title,
+		Author:      strings.Join(m.Authors, ", "),
+		ISBN:        m.ISBN,
+		Publisher:   m.Publisher,
+		Year:        m.Year,
+		Language:    m.Language,
+		Tags:        models.NewTags(m.Subjects...),
+		Series:      m.Series,
+		SeriesIndex: m.SeriesIndex,
+	}
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Metadata struct {
+		Titles []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"title"`
+		Creators []struct {
+			ID    string `xml:"id,attr"`
+			Role  string `xml:"role,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"creator"`
+		Identifiers []struct {
+			ID     string `xml:"id,attr"`
+			Scheme string `xml:"scheme,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Languages  []string `xml:"language"`
+		Publishers []string `xml:"publisher"`
+		Dates      []string `xml:"date"`
+		Subjects   []string `xml:"subject"`
+		Metas      []struct {
+			ID       string `xml:"id,attr"`
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			Refines  string `xml:"refines,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+// Parse reads an EPUB's container.xml, the package document it points to
+// and the cover image the package document names.
+func Parse(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEPUB, err)
+	}
+	var c container
+	if err := decodeFile(zr, "META-INF/container.xml", &c); err != nil {
+		return nil, err
+	}
+	opfPath := ""
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opfPath = rf.FullPath
+			break
+		}
+	}
+	if opfPath == "" {
+		return nil, fmt.Errorf("%w: container.xml names no package document", ErrInvalidEPUB)
+	}
+	var pkg opfPackage
+	if err := decodeFile(zr, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{}
+	md := pkg.Metadata
+	// EPUB 3 refines metadata by id: title types, creator roles and
+	// collection positions
+	refines := make(map[string]map[string]string)
+	for _, meta := range md.Metas {
+		if id, ok := strings.CutPrefix(meta.Refines, "#"); ok && meta.Property != "" {
+			if refines[id] == nil {
+				refines[id] = make(map[string]string)
+			}
+			refines[id][meta.Property] = strings.TrimSpace(meta.Value)
+		}
+	}
+
+	for _, t := range md.Titles {
+		title := clean(t.Value)
+		if title == "" {
+			continue
+		}
+		if m.Title == "" || refines[t.ID]["title-type"] == "main" {
+			m.Title = title
+		}
+	}
+	for _, c := range md.Creators {
+		role := c.Role
+		if r := refines[c.ID]["role"]; r != "" {
+			role = r
+		}
+		if name := clean(c.Value); name != "" && (role == "" || role == "aut") {
+			m.Authors = append(m.Authors, name)
+		}
+	}
+	for _, id := range md.Identifiers {
+		value := clean(id.Value)
+		if value == "" {
+			continue
+		}
+		m.Identifiers = append(m.Identifiers, value)
+		if m.ISBN == "" {
+			m.ISBN = isbn(value, id.Scheme)
+		}
+	}
+	if len(md.Languages) > 0 {
+		m.Language = clean(md.Languages[0])
+	}
+	if len(md.Publishers) > 0 {
+		m.Publisher = clean(md.Publishers[0])
+	}
+	for _, d := range md.Dates {
+		if d = clean(d); len(d) >= 4 {
+			if year, err := strconv.Atoi(d[:4]); err == nil && year > 0 {
+				m.Year = year
+				break
+			}
+		}
+	}
+	for _, s := range md.Subjects {
+		if s = clean(s); s != "" {
+			m.Subjects = append(m.Subjects, s)
+		}
+	}
+
+	coverID := ""
+	for _, meta := range md.Metas {
+		switch {
+		case meta.Name == "cover":
+			coverID = meta.Content
+		case meta.Name == "calibre:series":
+			m.Series = clean(meta.Content)
+		case meta.Name == "calibre:series_index":
+			m.SeriesIndex, _ = strconv.ParseFloat(meta.Content, 64)
+		}
+	}
+	// EPUB 3 series are collections, refined with their type and position
+	for _, meta := range md.Metas {
+		if m.Series != "" || meta.Property != "belongs-to-collection" || meta.Refines != "" {
+			continue
+		}
+		props := refines[meta.ID]
+		if t := props["collection-type"]; t != "" && t != "series" {
+			continue
+		}
+		m.Series = clean(meta.Value)
+		m.SeriesIndex, _ = strconv.ParseFloat(props["group-position"], 64)
+	}
+
+	// The cover is the manifest item EPUB 3 marks as cover-image, or the
+	// one EPUB 2's <meta name="cover"> points to
+	coverHref, coverType := "", ""
+	for _, item := range pkg.Manifest {
+		if hasProperty(item.Properties, "cover-image") || (coverHref == "" && coverID != "" && item.ID == coverID) {
+			coverHref, coverType = item.Href, item.MediaType
+		}
+	}
+	if coverHref != "" && strings.HasPrefix(coverType, "image/") {
+		href, err := url.PathUnescape(coverHref)
+		if err != nil {
+			href = coverHref
+		}
+		data, err := readFile(zr, path.Join(path.Dir(opfPath), href), maxCoverBytes)
+		if err == nil {
+			m.Cover, m.CoverType = data, coverType
+		}
+	}
+	if m.Title == "" {
+		return nil, fmt.Errorf("%w: the package document has no title", ErrInvalidEPUB)
+	}
+	return m, nil
+}
+
+// isbn returns the ISBN in an identifier, or "" when it is none. EPUB 2
+// marks ISBNs with opf:scheme and EPUB 3 writes them as urn:isbn: URNs;
+// many files just hold a bare ISBN-13.
+func isbn(value, scheme string) string {
+	marked := strings.EqualFold(scheme, "isbn")
+	for _, prefix := range []string{"urn:isbn:", "isbn:"} {
+		if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			value, marked = value[len(prefix):], true
+			break
+		}
+	}
+	s := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value))
+	switch {
+	case len(s) == 13 && validISBN13(s) && (strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")):
+		return s
+	case marked && len(s) == 10 && validISBN10(s):
+		return s
+	}
+	return ""
+}
+
+func validISBN13(s string) bool {
+	sum := 0
+	for i, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+		d := int(r - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func validISBN10(s string) bool {
+	sum := 0
+	for i, r := range s {
+		d := int(r - '0')
+		switch {
+		case r == 'X' && i == 9:
+			d = 10
+		case r < '0' || r > '9':
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+func hasProperty(properties, name string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// clean collapses whitespace, as package documents are often indented.
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func decodeFile(zr *zip.Reader, name string, v any) error {
+	data, err := readFile(zr, name, 8<<20)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidEPUB, name, err)
+	}
+	return nil
+}
+
+func readFile(zr *zip.Reader, name string, limit int64) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is missing", ErrInvalidEPUB, name)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidEPUB, name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidEPUB, name)
+	}
+	return data, nil
+}
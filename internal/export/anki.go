@@ -0,0 +1,121 @@
+package export
+
+import (
+	"book-tracker/internal/models"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Default card templates: the quote on the front, where it is from on the
+// back.
+const (
+	DefaultAnkiFront = `{{.Text}}`
+	DefaultAnkiBack  = `<b>{{.Title}}</b><br>{{.Author}}{{if .Page}}, p. {{.Page}}{{end}}` +
+		`{{if .Comment}}<br><br><i>{{.Comment}}</i>{{end}}`
+)
+
+// AnkiCardData is what card templates can refer to.
+type AnkiCardData struct {
+	Text     string
+	Comment  string
+	Page     string
+	Location string
+	Color    string
+	Title    string
+	Author   string
+}
+
+// AnkiCard is one note to import into Anki. GUID is derived from the
+// highlight id, so importing a later export updates cards instead of
+// duplicating them.
+type AnkiCard struct {
+	GUID  string
+	Front string
+	Back  string
+	Tags  []string
+}
+
+// AnkiTemplates render the front and back of a card from AnkiCardData.
+// Templates use html/template syntax, so highlight text is escaped.
+type AnkiTemplates struct {
+	front, back *template.Template
+}
+
+func ParseAnkiTemplates(front, back string) (*AnkiTemplates, error) {
+	f, err := template.New("front").Parse(front)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid front template: %w", err)
+	}
+	b, err := template.New("back").Parse(back)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid back template: %w", err)
+	}
+	return &AnkiTemplates{front: f, back: b}, nil
+}
+
+// Cards renders a card for every highlighted passage. Notes, bookmarks and
+// highlights whose book is not in books are skipped.
+func (t *AnkiTemplates) Cards(highlights []models.Highlight, books map[int]models.Book) ([]AnkiCard, error) {
+	var cards []AnkiCard
+	for _, h := range highlights {
+		book, ok := books[h.BookID]
+		if !ok || h.Kind != models.HighlightKindHighlight || strings.TrimSpace(h.Text) == "" {
+			continue
+		}
+		data := AnkiCardData{
+			Text:     h.Text,
+			Comment:  h.Comment,
+			Page:     h.Page,
+			Location: h.Location,
+			Color:    h.Color,
+			Title:    book.Title,
+			Author:   book.Author,
+		}
+		card := AnkiCard{GUID: fmt.Sprintf("book-tracker-h%d", h.ID)}
+		var err error
+		if card.Front, err = render(t.front, data); err != nil {
+			return nil, err
+		}
+		if card.Back, err = render(t.back, data); err != nil {
+			return nil, err
+		}
+		for _, tag := range book.Tags {
+			card.Tags = append(card.Tags, strings.Join(strings.Fields(tag), "_"))
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func render(t *template.Template, data AnkiCardData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// WriteAnkiTSV writes cards as a tab-separated Anki import file. Header
+// lines tell Anki the separator, note type, deck and which columns hold the
+// GUID and tags.
+func WriteAnkiTSV(w io.Writer, deck string, cards []AnkiCard) error {
+	var b strings.Builder
+	b.WriteString("#separator:tab\n#html:true\n#notetype:Basic\n")
+	fmt.Fprintf(&b, "#deck:%s\n", tsvField(deck))
+	b.WriteString("#guid column:1\n#tags column:4\n")
+	for _, c := range cards {
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\n", tsvField(c.GUID), tsvField(c.Front), tsvField(c.Back), tsvField(strings.Join(c.Tags, " ")))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// tsvField keeps a value on one line in one column. Line breaks become
+// <br>, which Anki renders since the file is marked as HTML.
+func tsvField(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\n", "<br>")
+	return strings.ReplaceAll(s, "\t", " ")
+}
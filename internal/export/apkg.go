@@ -0,0 +1,216 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Fixed ids keep packages deterministic and let Anki recognize the note
+// type and deck across imports.
+const (
+	ankiModelID     = 1718100000000
+	ankiCreated     = 1718100000
+	ankiNoteIDStart = 1718100000000
+)
+
+// ankiSchema is the schema of an Anki 2.1 collection.anki2 file.
+var ankiSchema = []string{
+	`CREATE TABLE col (id integer primary key, crt integer not null, mod integer not null,
+		scm integer not null, ver integer not null, dty integer not null, usn integer not null,
+		ls integer not null, conf text not null, models text not null, decks text not null,
+		dconf text not null, tags text not null)`,
+	`CREATE TABLE notes (id integer primary key, guid text not null, mid integer not null,
+		mod integer not null, usn integer not null, tags text not null, flds text not null,
+		sfld integer not null, csum integer not null, flags integer not null, data text not null)`,
+	`CREATE TABLE cards (id integer primary key, nid integer not null, did integer not null,
+		ord integer not null, mod integer not null, usn integer not null, type integer not null,
+		queue integer not null, due integer not null, ivl integer not null, factor integer not null,
+		reps integer not null, lapses integer not null, left integer not null, odue integer not null,
+		odid integer not null, flags integer not null, data text not null)`,
+	`CREATE TABLE revlog (id integer primary key, cid integer not null, usn integer not null,
+		ease integer not null, ivl integer not null, lastIvl integer not null, factor integer not null,
+		time integer not null, type integer not null)`,
+	`CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null)`,
+	`CREATE INDEX ix_notes_usn on notes (usn)`,
+	`CREATE INDEX ix_cards_usn on cards (usn)`,
+	`CREATE INDEX ix_revlog_usn on revlog (usn)`,
+	`CREATE INDEX ix_cards_nid on cards (nid)`,
+	`CREATE INDEX ix_cards_sched on cards (did, queue, due)`,
+	`CREATE INDEX ix_revlog_cid on revlog (cid)`,
+	`CREATE INDEX ix_notes_csum on notes (csum)`,
+}
+
+// WriteAnkiPackage writes cards as an Anki deck package (.apkg): a zip of a
+// collection.anki2 SQLite database and an empty media map.
+func WriteAnkiPackage(ctx context.Context, w io.Writer, deck string, cards []AnkiCard) error {
+	dir, err := os.MkdirTemp("", "anki-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki2")
+	if err := writeAnkiCollection(ctx, path, deck, cards); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("collection.anki2")
+	if err != nil {
+		return err
+	}
+	db, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := io.Copy(f, db); err != nil {
+		return err
+	}
+	media, err := zw.Create("media")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(media, "{}"); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeAnkiCollection(ctx context.Context, path, deck string, cards []AnkiCard) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range ankiSchema {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	deckID := ankiDeckID(deck)
+	models, decks, dconf, conf := ankiCollectionConfig(deck, deckID, len(cards))
+	_, err = tx.ExecContext(ctx, `INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		ankiCreated, ankiCreated*1000, ankiCreated*1000, conf, models, decks, dconf)
+	if err != nil {
+		return err
+	}
+	for i, c := range cards {
+		id := ankiNoteIDStart + int64(i)
+		tags := ""
+		if len(c.Tags) > 0 {
+			tags = " " + strings.Join(c.Tags, " ") + " "
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+			id, c.GUID, ankiModelID, ankiCreated, tags, c.Front+"\x1f"+c.Back, stripHTML(c.Front), ankiChecksum(c.Front))
+		if err != nil {
+			return err
+		}
+		// New cards, due in note order
+		_, err = tx.ExecContext(ctx, `INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+			id, id, deckID, ankiCreated, i+1)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ankiDeckID derives a stable deck id from the deck name.
+func ankiDeckID(deck string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(deck))
+	return int64(h.Sum32()) + 1<<32
+}
+
+// ankiCollectionConfig returns the JSON columns of the col table: the Basic
+// note type, the default and export decks, deck options and collection
+// settings.
+func ankiCollectionConfig(deck string, deckID int64, cardCount int) (models, decks, dconf, conf string) {
+	model := map[string]any{
+		"id": ankiModelID, "name": "Basic (book-tracker)", "type": 0, "mod": ankiCreated, "usn": -1,
+		"sortf": 0, "did": deckID, "tags": []string{}, "vers": []any{},
+		"flds": []map[string]any{ankiField("Front", 0), ankiField("Back", 1)},
+		"tmpls": []map[string]any{{
+			"name": "Card 1", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+			"qfmt": "{{Front}}", "afmt": "{{FrontSide}}\n\n<hr id=answer>\n\n{{Back}}",
+		}},
+		"css":       ".card {\n font-family: arial;\n font-size: 20px;\n text-align: center;\n color: black;\n background-color: white;\n}\n",
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"req":       []any{[]any{0, "any", []int{0}}},
+	}
+	deckJSON := func(id int64, name string) map[string]any {
+		return map[string]any{
+			"id": id, "name": name, "mod": ankiCreated, "usn": -1, "desc": "", "dyn": 0, "conf": 1,
+			"collapsed": false, "extendNew": 10, "extendRev": 50,
+			"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+		}
+	}
+	options := map[string]any{
+		"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60, "autoplay": true, "timer": 0,
+		"replayq": true, "dyn": false,
+		"new": map[string]any{
+			"bury": true, "delays": []int{1, 10}, "initialFactor": 2500, "ints": []int{1, 4, 7},
+			"order": 1, "perDay": 20, "separate": true,
+		},
+		"rev": map[string]any{
+			"bury": true, "ease4": 1.3, "fuzz": 0.05, "ivlFct": 1, "maxIvl": 36500, "minSpace": 1, "perDay": 100,
+		},
+		"lapse": map[string]any{
+			"delays": []int{10}, "leechAction": 0, "leechFails": 8, "minInt": 1, "mult": 0,
+		},
+	}
+	settings := map[string]any{
+		"activeDecks": []int64{1}, "curDeck": 1, "newSpread": 0, "collapseTime": 1200, "timeLim": 0,
+		"estTimes": true, "dueCounts": true, "curModel": nil, "nextPos": cardCount + 1,
+		"sortType": "noteFld", "sortBackwards": false, "addToCur": true,
+	}
+	return mustJSON(map[string]any{strconv.FormatInt(ankiModelID, 10): model}),
+		mustJSON(map[string]any{"1": deckJSON(1, "Default"), strconv.FormatInt(deckID, 10): deckJSON(deckID, deck)}),
+		mustJSON(map[string]any{"1": options}),
+		mustJSON(settings)
+}
+
+func ankiField(name string, ord int) map[string]any {
+	return map[string]any{"name": name, "ord": ord, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []any{}}
+}
+
+func mustJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+var ankiTag = regexp.MustCompile(`<[^>]*>`)
+
+func stripHTML(s string) string {
+	return ankiTag.ReplaceAllString(s, "")
+}
+
+// ankiChecksum is Anki's duplicate check: the first 32 bits of the SHA-1 of
+// the sort field with HTML removed.
+func ankiChecksum(field string) int64 {
+	sum := sha1.Sum([]byte(stripHTML(field)))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
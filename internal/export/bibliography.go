@@ -0,0 +1,145 @@
+package export
+
+import (
+	"book-tracker/internal/models"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BibTeXWriter writes books as BibTeX @book entries. Citation keys are
+// "familyYEARword", made unique within one export.
+type BibTeXWriter struct {
+	w    io.Writer
+	keys map[string]bool
+}
+
+func NewBibTeXWriter(w io.Writer) *BibTeXWriter {
+	return &BibTeXWriter{w: w, keys: make(map[string]bool)}
+}
+
+// Write writes one book's entry.
+func (b *BibTeXWriter) Write(book models.Book) error {
+	names := ParseAuthors(book.Author)
+	key := b.key(book, names)
+	var s strings.Builder
+	fmt.Fprintf(&s, "@book{%s,\n", key)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&s, "  %s = {%s},\n", name, bibtexEscape(value))
+		}
+	}
+	authors := make([]string, len(names))
+	for i, n := range names {
+		authors[i] = n.sorted()
+	}
+	field("author", strings.Join(authors, " and "))
+	field("title", book.Title)
+	field("publisher", book.Publisher)
+	if book.Year != 0 {
+		field("year", strconv.Itoa(book.Year))
+	}
+	field("isbn", book.ISBN)
+	if book.Series != "" {
+		field("series", book.Series)
+		if book.SeriesIndex != 0 {
+			field("number", strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64))
+		}
+	}
+	field("keywords", strings.Join(book.Tags, ", "))
+	s.WriteString("}\n\n")
+	_, err := io.WriteString(b.w, s.String())
+	return err
+}
+
+func (b *BibTeXWriter) key(book models.Book, names []Name) string {
+	var base strings.Builder
+	if len(names) > 0 {
+		base.WriteString(keyWord(names[0].Family))
+	}
+	if book.Year != 0 {
+		base.WriteString(strconv.Itoa(book.Year))
+	}
+	for _, w := range strings.Fields(book.Title) {
+		if w = keyWord(w); w != "" && w != "a" && w != "an" && w != "the" {
+			base.WriteString(w)
+			break
+		}
+	}
+	key := base.String()
+	if key == "" {
+		key = "book" + strconv.Itoa(book.ID)
+	}
+	unique := key
+	for i := 0; b.keys[unique]; i++ {
+		if i < 26 {
+			unique = key + string(rune('a'+i))
+		} else {
+			unique = key + strconv.Itoa(i)
+		}
+	}
+	b.keys[unique] = true
+	return unique
+}
+
+// keyWord keeps the ASCII letters and digits of s, lowercased, as classic
+// BibTeX only accepts ASCII keys.
+func keyWord(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, s)
+}
+
+var bibtexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+	"\n", " ",
+)
+
+func bibtexEscape(s string) string {
+	return bibtexReplacer.Replace(strings.TrimSpace(s))
+}
+
+// WriteRIS writes a book as an RIS record, the format reference managers
+// like Zotero, EndNote and Mendeley import.
+func WriteRIS(w io.Writer, book models.Book) error {
+	var s strings.Builder
+	tag := func(name, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			fmt.Fprintf(&s, "%s  - %s\r\n", name, value)
+		}
+	}
+	tag("TY", "BOOK")
+	for _, n := range ParseAuthors(book.Author) {
+		tag("AU", n.sorted())
+	}
+	tag("TI", book.Title)
+	tag("PB", book.Publisher)
+	if book.Year != 0 {
+		tag("PY", strconv.Itoa(book.Year))
+	}
+	tag("SN", book.ISBN)
+	tag("T2", book.Series)
+	for _, t := range book.Tags {
+		tag("KW", t)
+	}
+	s.WriteString("ER  - \r\n\r\n")
+	_, err := io.WriteString(w, s.String())
+	return err
+}
@@ -0,0 +1,318 @@
+package export
+
+import (
+	"book-tracker/internal/models"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Citation styles.
+const (
+	StyleAPA     = "apa"
+	StyleMLA     = "mla"
+	StyleChicago = "chicago"
+)
+
+// ErrUnknownStyle is returned by Cite for styles other than APA, MLA and
+// Chicago.
+var ErrUnknownStyle = errors.New("Invalid style parameter, expected apa, mla or chicago")
+
+// Citation is a formatted reference to a book, as plain text and as HTML
+// with the title in italics.
+type Citation struct {
+	Style string `json:"style"`
+	Text  string `json:"text"`
+	HTML  string `json:"html"`
+}
+
+// Name is one author of a book.
+type Name struct {
+	Given  string
+	Family string
+	Suffix string
+}
+
+// nameParticles are lowercase words that belong to the family name that
+// follows them, as in "Ludwig van Beethoven".
+var nameParticles = map[string]bool{
+	"da": true, "de": true, "del": true, "della": true, "der": true, "di": true, "du": true,
+	"la": true, "le": true, "ten": true, "ter": true, "van": true, "von": true,
+}
+
+var nameSuffixes = map[string]bool{"jr.": true, "jr": true, "sr.": true, "sr": true, "ii": true, "iii": true, "iv": true}
+
+// ParseAuthors splits a book's author field into names. Authors are
+// separated by ";", "&" or "and"; "Family, Given" is one author, like in
+// dedup.NormalizeAuthor, unless both sides are full names without
+// initials, as Calibre imports join authors with ", ".
+func ParseAuthors(author string) []Name {
+	var names []Name
+	for _, part := range splitAuthors(author) {
+		pieces := strings.Split(part, ",")
+		for i := range pieces {
+			pieces[i] = strings.TrimSpace(pieces[i])
+		}
+		switch {
+		case len(pieces) == 1:
+			names = append(names, parseName(part))
+		case len(pieces) == 2 && nameSuffixes[strings.ToLower(pieces[1])]:
+			n := parseName(pieces[0])
+			n.Suffix = pieces[1]
+			names = append(names, n)
+		case len(pieces) == 2 && !isNameList(pieces):
+			names = append(names, Name{Given: pieces[1], Family: pieces[0]})
+		case len(pieces) == 3 && nameSuffixes[strings.ToLower(pieces[2])] && !isNameList(pieces[:2]):
+			names = append(names, Name{Given: pieces[1], Family: pieces[0], Suffix: pieces[2]})
+		default:
+			for _, p := range pieces {
+				if p != "" {
+					names = append(names, parseName(p))
+				}
+			}
+		}
+	}
+	return names
+}
+
+func splitAuthors(author string) []string {
+	var parts []string
+	for _, p := range strings.FieldsFunc(author, func(r rune) bool { return r == ';' || r == '&' }) {
+		words := strings.Fields(p)
+		start := 0
+		for i, w := range words {
+			if strings.EqualFold(w, "and") {
+				parts = append(parts, strings.Join(words[start:i], " "))
+				start = i + 1
+			}
+		}
+		parts = append(parts, strings.Join(words[start:], " "))
+	}
+	var out []string
+	for _, p := range parts {
+		if p = strings.Trim(p, " ,"); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// isNameList reports whether comma-separated pieces are separate full
+// names rather than "Family, Given".
+func isNameList(pieces []string) bool {
+	for _, p := range pieces {
+		words := strings.Fields(p)
+		if len(words) < 2 {
+			return false
+		}
+		for _, w := range words {
+			if strings.HasSuffix(w, ".") || len([]rune(w)) == 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseName splits "Given Family" at the last word, keeping lowercase
+// particles and a trailing suffix with the family name.
+func parseName(s string) Name {
+	words := strings.Fields(s)
+	var n Name
+	if len(words) > 1 && nameSuffixes[strings.ToLower(words[len(words)-1])] {
+		n.Suffix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return n
+	}
+	i := len(words) - 1
+	for i > 1 && nameParticles[words[i-1]] {
+		i--
+	}
+	n.Given = strings.Join(words[:i], " ")
+	n.Family = strings.Join(words[i:], " ")
+	return n
+}
+
+// sorted is "Family, Given, Suffix", the inverted form bibliographies
+// list first authors in.
+func (n Name) sorted() string {
+	s := n.Family
+	if n.Given != "" {
+		s += ", " + n.Given
+	}
+	if n.Suffix != "" {
+		s += ", " + n.Suffix
+	}
+	return s
+}
+
+// natural is "Given Family Suffix".
+func (n Name) natural() string {
+	s := strings.TrimSpace(n.Given + " " + n.Family)
+	if n.Suffix != "" {
+		s += " " + n.Suffix
+	}
+	return s
+}
+
+// initials is "Family, G. G." for APA.
+func (n Name) initials() string {
+	var parts []string
+	for _, w := range strings.Fields(strings.ReplaceAll(n.Given, ".", ". ")) {
+		var hyphenated []string
+		for _, h := range strings.Split(w, "-") {
+			if r := []rune(strings.TrimSuffix(h, ".")); len(r) > 0 {
+				hyphenated = append(hyphenated, string(unicode.ToUpper(r[0]))+".")
+			}
+		}
+		parts = append(parts, strings.Join(hyphenated, "-"))
+	}
+	s := n.Family
+	if len(parts) > 0 {
+		s += ", " + strings.Join(parts, " ")
+	}
+	if n.Suffix != "" {
+		s += ", " + n.Suffix
+	}
+	return s
+}
+
+// citationWriter builds the text and HTML forms of a citation together.
+type citationWriter struct {
+	text, html strings.Builder
+}
+
+func (c *citationWriter) plain(s string) {
+	c.text.WriteString(s)
+	c.html.WriteString(html.EscapeString(s))
+}
+
+func (c *citationWriter) italic(s string) {
+	c.text.WriteString(s)
+	c.html.WriteString("<i>" + html.EscapeString(s) + "</i>")
+}
+
+// sentence ends the citation so far with a period, unless it already ends
+// in punctuation that closes a sentence.
+func (c *citationWriter) sentence() {
+	if !endsSentence(c.text.String()) {
+		c.plain(".")
+	}
+}
+
+// italicSentence writes an italic title followed by a period, unless the
+// title ends in its own punctuation.
+func (c *citationWriter) italicSentence(s string) {
+	c.italic(s)
+	c.sentence()
+}
+
+func endsSentence(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasSuffix(s, ".") || strings.HasSuffix(s, "?") || strings.HasSuffix(s, "!")
+}
+
+// Cite formats a book in APA (7th edition), MLA (9th edition) or Chicago
+// (17th edition, bibliography) style from its author, title, publisher and
+// year.
+func Cite(book models.Book, style string) (Citation, error) {
+	names := ParseAuthors(book.Author)
+	title := strings.TrimSpace(book.Title)
+	publisher := strings.TrimSpace(book.Publisher)
+	var c citationWriter
+	switch style {
+	case StyleAPA:
+		year := "n.d."
+		if book.Year != 0 {
+			year = fmt.Sprint(book.Year)
+		}
+		if len(names) > 0 {
+			c.plain(apaAuthors(names))
+			c.plain(" (" + year + ").")
+			c.plain(" ")
+			c.italicSentence(title)
+		} else {
+			c.italicSentence(title)
+			c.plain(" (" + year + ").")
+		}
+		if publisher != "" {
+			c.plain(" " + publisher)
+			c.sentence()
+		}
+	case StyleMLA, StyleChicago:
+		if len(names) > 0 {
+			if style == StyleMLA {
+				c.plain(mlaAuthors(names))
+			} else {
+				c.plain(chicagoAuthors(names))
+			}
+			c.sentence()
+			c.plain(" ")
+		}
+		c.italicSentence(title)
+		switch {
+		case publisher != "" && book.Year != 0:
+			c.plain(fmt.Sprintf(" %s, %d.", publisher, book.Year))
+		case publisher != "":
+			c.plain(" " + publisher)
+			c.sentence()
+		case book.Year != 0:
+			c.plain(fmt.Sprintf(" %d.", book.Year))
+		}
+	default:
+		return Citation{}, ErrUnknownStyle
+	}
+	return Citation{Style: style, Text: c.text.String(), HTML: c.html.String()}, nil
+}
+
+// apaAuthors lists up to 20 authors; longer lists give the first 19, an
+// ellipsis and the last.
+func apaAuthors(names []Name) string {
+	list := make([]string, len(names))
+	for i, n := range names {
+		list[i] = n.initials()
+	}
+	switch {
+	case len(list) == 1:
+		return list[0]
+	case len(list) == 2:
+		return list[0] + ", & " + list[1]
+	case len(list) > 20:
+		return strings.Join(list[:19], ", ") + ", . . . " + list[len(list)-1]
+	}
+	return strings.Join(list[:len(list)-1], ", ") + ", & " + list[len(list)-1]
+}
+
+// mlaAuthors names one or two authors and shortens three or more to the
+// first and "et al."
+func mlaAuthors(names []Name) string {
+	switch len(names) {
+	case 1:
+		return names[0].sorted()
+	case 2:
+		return names[0].sorted() + ", and " + names[1].natural()
+	}
+	return names[0].sorted() + ", et al."
+}
+
+// chicagoAuthors lists up to ten authors; longer lists give the first
+// seven and "et al."
+func chicagoAuthors(names []Name) string {
+	list := []string{names[0].sorted()}
+	for _, n := range names[1:] {
+		list = append(list, n.natural())
+	}
+	switch {
+	case len(list) == 1:
+		return list[0]
+	case len(list) == 2:
+		return list[0] + ", and " + list[1]
+	case len(list) > 10:
+		return strings.Join(list[:7], ", ") + ", et al."
+	}
+	return strings.Join(list[:len(list)-1], ", ") + ", and " + list[len(list)-1]
+}
@@ -0,0 +1,76 @@
+package export
+
+import (
+	"book-tracker/internal/models"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVHeader is the column order written by CSVWriter and understood by
+// the CSV importer without any header mapping. Tags are separated by
+// commas and finished_at is in RFC 3339 format.
+var CSVHeader = []string{"id", "title", "author", "isbn", "progress", "notes", "finished", "rating",
+	"shelf", "tags", "publisher", "year", "pages", "language", "series", "series_index", "finished_at", "source", "source_id"}
+
+// CSVWriter streams books as CSV rows, writing the header before the first
+// book.
+type CSVWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// Write appends one book. Rows are buffered; call Flush to force them out.
+func (c *CSVWriter) Write(book models.Book) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	var finishedAt string
+	if book.FinishedAt != nil {
+		finishedAt = book.FinishedAt.UTC().Format(time.RFC3339)
+	}
+	return c.w.Write([]string{
+		strconv.Itoa(book.ID),
+		book.Title,
+		book.Author,
+		book.ISBN,
+		strconv.Itoa(book.Progress),
+		book.Notes,
+		strconv.FormatBool(book.Finished),
+		strconv.Itoa(book.Rating),
+		book.Shelf,
+		strings.Join(book.Tags, ", "),
+		book.Publisher,
+		strconv.Itoa(book.Year),
+		strconv.Itoa(book.Pages),
+		book.Language,
+		book.Series,
+		strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64),
+		finishedAt,
+		book.Source,
+		book.SourceID,
+	})
+}
+
+// Flush writes any buffered rows, and the header if no book was written.
+func (c *CSVWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *CSVWriter) writeHeader() error {
+	if c.wroteHeader {
+		return nil
+	}
+	c.wroteHeader = true
+	return c.w.Write(CSVHeader)
+}
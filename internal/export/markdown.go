@@ -0,0 +1,170 @@
+package export
+
+import (
+	"archive/zip"
+	"book-tracker/internal/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFilenameRunes caps the title and author part of a Markdown filename.
+const maxFilenameRunes = 100
+
+// MarkdownVault writes one Markdown file per book into a zip archive laid
+// out as an Obsidian vault. The archive is deterministic: the same books
+// and highlights always produce the same bytes.
+type MarkdownVault struct {
+	zw *zip.Writer
+}
+
+func NewMarkdownVault(w io.Writer) *MarkdownVault {
+	return &MarkdownVault{zw: zip.NewWriter(w)}
+}
+
+// Add writes a book's note file with its highlights.
+func (v *MarkdownVault) Add(book models.Book, highlights []models.Highlight) error {
+	f, err := v.zw.CreateHeader(&zip.FileHeader{
+		Name:     MarkdownFilename(book),
+		Method:   zip.Deflate,
+		Modified: book.UpdatedAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return WriteMarkdown(f, book, highlights)
+}
+
+// Close finishes the archive.
+func (v *MarkdownVault) Close() error {
+	return v.zw.Close()
+}
+
+// MarkdownFilename names a book's note "Title - Author (id).md". The id
+// keeps names unique and stable across exports; characters that are not
+// allowed in file names or that Obsidian treats as link syntax are dropped.
+func MarkdownFilename(book models.Book) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', '#', '^', '[', ']':
+			return -1
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, book.Title+" - "+book.Author)
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > maxFilenameRunes {
+		name = strings.TrimSpace(string(runes[:maxFilenameRunes]))
+	}
+	return fmt.Sprintf("%s (%d).md", name, book.ID)
+}
+
+// WriteMarkdown writes a book as Markdown: YAML front matter, then its
+// notes, highlights and notes on passages. Bookmarks are omitted.
+func WriteMarkdown(w io.Writer, book models.Book, highlights []models.Highlight) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	frontMatter(&b, "id", strconv.Itoa(book.ID))
+	frontMatter(&b, "title", yamlString(book.Title))
+	frontMatter(&b, "author", yamlString(book.Author))
+	if book.ISBN != "" {
+		frontMatter(&b, "isbn", yamlString(book.ISBN))
+	}
+	if book.Publisher != "" {
+		frontMatter(&b, "publisher", yamlString(book.Publisher))
+	}
+	if book.Year != 0 {
+		frontMatter(&b, "year", strconv.Itoa(book.Year))
+	}
+	if book.Series != "" {
+		frontMatter(&b, "series", yamlString(book.Series))
+		frontMatter(&b, "series_index", strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64))
+	}
+	if book.Shelf != "" {
+		frontMatter(&b, "shelf", yamlString(book.Shelf))
+	}
+	frontMatter(&b, "progress", strconv.Itoa(book.Progress))
+	frontMatter(&b, "finished", strconv.FormatBool(book.Finished))
+	if book.Rating != 0 {
+		frontMatter(&b, "rating", strconv.Itoa(book.Rating))
+	}
+	tags := make([]string, len(book.Tags))
+	for i, t := range book.Tags {
+		tags[i] = yamlString(t)
+	}
+	frontMatter(&b, "tags", "["+strings.Join(tags, ", ")+"]")
+	frontMatter(&b, "created", yamlDate(book.CreatedAt))
+	if book.FinishedAt != nil {
+		frontMatter(&b, "finished_at", yamlDate(*book.FinishedAt))
+	}
+	frontMatter(&b, "updated", yamlDate(book.UpdatedAt))
+	b.WriteString("---\n\n")
+
+	fmt.Fprintf(&b, "# %s\n\nby %s\n", book.Title, book.Author)
+	if notes := strings.TrimSpace(book.Notes); notes != "" {
+		fmt.Fprintf(&b, "\n## Notes\n\n%s\n", notes)
+	}
+	wroteHeading := false
+	for _, h := range highlights {
+		if h.Kind == models.HighlightKindBookmark {
+			continue
+		}
+		if !wroteHeading {
+			b.WriteString("\n## Highlights\n")
+			wroteHeading = true
+		}
+		b.WriteString("\n")
+		if h.Kind == models.HighlightKindNote {
+			fmt.Fprintf(&b, "%s\n", strings.TrimSpace(h.Text))
+		} else {
+			fmt.Fprintf(&b, "%s\n", quote(h.Text))
+		}
+		if pos := position(h); pos != "" {
+			fmt.Fprintf(&b, "\n— %s\n", pos)
+		}
+		if comment := strings.TrimSpace(h.Comment); comment != "" {
+			fmt.Fprintf(&b, "\n%s\n", comment)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func frontMatter(b *strings.Builder, key, value string) {
+	fmt.Fprintf(b, "%s: %s\n", key, value)
+}
+
+// yamlString quotes s as a YAML double-quoted scalar, which JSON string
+// syntax is a subset of.
+func yamlString(s string) string {
+	q, _ := json.Marshal(s)
+	return string(q)
+}
+
+func yamlDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func quote(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight("> "+l, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func position(h models.Highlight) string {
+	var parts []string
+	if h.Page != "" {
+		parts = append(parts, "page "+h.Page)
+	}
+	if h.Location != "" {
+		parts = append(parts, "location "+h.Location)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,200 @@
+// Package feed renders Atom and RSS feeds of finished books, so others can
+// follow what has been read.
+package feed
+
+import (
+	"book-tracker/internal/models"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// Finished describes the feed of finished books. BaseURL is the absolute
+// URL of the server, without a trailing slash.
+type Finished struct {
+	BaseURL string
+	Title   string
+	Books   []models.Book
+}
+
+// Updated is when the feed last changed: the latest update of any of its
+// books, or the Unix epoch for an empty feed, so the feed and its
+// timestamps only change when a book does.
+func (f *Finished) Updated() time.Time {
+	updated := time.Unix(0, 0)
+	for _, b := range f.Books {
+		if b.UpdatedAt.After(updated) {
+			updated = b.UpdatedAt
+		}
+	}
+	return updated.UTC()
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+// WriteAtom writes the feed as Atom.
+func (f *Finished) WriteAtom(w io.Writer) error {
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      "urn:book-tracker:feeds:finished",
+		Title:   f.Title,
+		Updated: atomTime(f.Updated()),
+		Author:  atomAuthor{Name: "Book Tracker"},
+		Links: []atomLink{
+			{Rel: "self", Href: f.BaseURL + "/feeds/finished.atom", Type: "application/atom+xml"},
+			{Rel: "alternate", Href: f.BaseURL + "/feeds/finished.rss", Type: "application/rss+xml"},
+		},
+	}
+	for _, b := range f.Books {
+		e := atomEntry{
+			ID:      fmt.Sprintf("urn:book-tracker:book:%d", b.ID),
+			Title:   entryTitle(b),
+			Updated: atomTime(b.UpdatedAt),
+			Author:  atomAuthor{Name: b.Author},
+			Content: atomContent{Type: "html", Body: Content(b)},
+		}
+		if b.FinishedAt != nil {
+			e.Published = atomTime(*b.FinishedAt)
+		}
+		for _, t := range b.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: t})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return writeXML(w, feed)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	XmlnsAtom string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+// WriteRSS writes the feed as RSS 2.0.
+func (f *Finished) WriteRSS(w io.Writer) error {
+	feed := rssFeed{
+		Version:   "2.0",
+		XmlnsAtom: "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.BaseURL + "/feeds/finished.rss",
+			Description:   "Books finished, with ratings and notes",
+			LastBuildDate: f.Updated().Format(time.RFC1123Z),
+			AtomLink:      atomLink{Rel: "self", Href: f.BaseURL + "/feeds/finished.rss", Type: "application/rss+xml"},
+		},
+	}
+	for _, b := range f.Books {
+		published := b.UpdatedAt
+		if b.FinishedAt != nil {
+			published = *b.FinishedAt
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       entryTitle(b),
+			GUID:        rssGUID{Value: fmt.Sprintf("urn:book-tracker:book:%d", b.ID)},
+			PubDate:     published.UTC().Format(time.RFC1123Z),
+			Categories:  b.Tags,
+			Description: Content(b),
+		})
+	}
+	return writeXML(w, feed)
+}
+
+func entryTitle(b models.Book) string {
+	return b.Title + " by " + b.Author
+}
+
+// Content is the HTML body of a book's entry: its rating as stars and its
+// notes, one paragraph per blank-line separated block.
+func Content(b models.Book) string {
+	var s strings.Builder
+	if b.Rating > 0 {
+		fmt.Fprintf(&s, "<p>Rating: %s%s (%d/5)</p>", strings.Repeat("★", min(b.Rating, 5)), strings.Repeat("☆", max(5-b.Rating, 0)), b.Rating)
+	}
+	for _, p := range strings.Split(strings.ReplaceAll(strings.TrimSpace(b.Notes), "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			s.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(p), "\n", "<br>") + "</p>")
+		}
+	}
+	return s.String()
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func GetBookHistory(repo repository.AuditRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		entries, err := repo.GetBookHistory(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			http.Error(w, repository.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+func ListAudit(repo repository.AuditRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := repo.ListAudit(r.Context(), filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []models.AuditEntry{}
+		}
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// parseAuditFilter reads book_id, actor, action, since, until (RFC 3339),
+// limit and offset from the query string. Limit defaults to 100.
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Limit:  100,
+	}
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"book_id", &filter.BookID},
+		{"limit", &filter.Limit},
+		{"offset", &filter.Offset},
+	}
+	for _, p := range ints {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return filter, &queryError{p.name}
+			}
+			*p.dst = n
+		}
+	}
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, p := range times {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, &queryError{p.name}
+			}
+			*p.dst = t
+		}
+	}
+	return filter, nil
+}
+
+type queryError struct {
+	param string
+}
+
+func (e *queryError) Error() string {
+	return "Invalid " + e.param + " parameter"
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"book-tracker/internal/auth"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// loginResponse is a new session with the user it signs in.
+type loginResponse struct {
+	models.Session
+	User *models.User `json:"user"`
+}
+
+// Register creates a user account.
+func Register(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds models.Credentials
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		user, err := authService.Register(r.Context(), creds)
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repository.ErrUsernameTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(user)
+		}
+	}
+}
+
+// Login checks a username and password and returns a session token to send
+// as "Authorization: Bearer <token>".
+func Login(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds models.Credentials
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		session, user, err := authService.Login(r.Context(), creds)
+		if errors.Is(err, auth.ErrInvalidLogin) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(loginResponse{Session: *session, User: user})
+	}
+}
+
+// Logout ends the session whose token authenticated the request.
+func Logout(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, "Sign out needs a session token", http.StatusBadRequest)
+			return
+		}
+		if err := authService.Logout(r.Context(), strings.TrimSpace(token)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetMe returns the signed-in user.
+func GetMe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.User(r.Context()))
+	}
+}
+
+// CreateAPIToken issues an API token for the signed-in user. The response
+// is the only time the token's secret is shown.
+func CreateAPIToken(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.APITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		token, err := authService.CreateToken(r.Context(), auth.User(r.Context()), req)
+		if errors.Is(err, models.ErrInvalidToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(token)
+	}
+}
+
+// ListAPITokens returns the signed-in user's API tokens.
+func ListAPITokens(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := authService.ListTokens(r.Context(), auth.User(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// RevokeAPIToken deletes one of the signed-in user's API tokens.
+func RevokeAPIToken(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		err = authService.RevokeToken(r.Context(), auth.User(r.Context()), id)
+		if errors.Is(err, repository.ErrTokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
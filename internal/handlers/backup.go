@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"book-tracker/internal/backup"
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRestoreBytes caps the size of an uploaded backup archive.
+const maxRestoreBytes = 1 << 30
+
+// BackupData streams a backup archive of every book, highlight, cover and audit
+// entry.
+func BackupData(store repository.StoreInterface, blobs blob.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="book-tracker-%s.ndjson"`, time.Now().UTC().Format("20060102-150405")))
+		if err := backup.Write(r.Context(), store, blobs, w); err != nil {
+			// The status line is already sent, so the missing trailer is
+			// the only signal the client gets; restores reject such archives
+			log.Printf("Backup failed: %v", err)
+		}
+	}
+}
+
+// RestoreData restores an uploaded backup archive. The store must be empty
+// unless replace=true, which deletes everything in it first. Restored cover
+// images are then moved to blob storage.
+func RestoreData(store repository.StoreInterface, coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		replace, err := strconv.ParseBool(q.Get("replace"))
+		if q.Get("replace") != "" && err != nil {
+			http.Error(w, "Invalid replace parameter", http.StatusBadRequest)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, maxRestoreBytes)
+		summary, err := backup.Restore(r.Context(), store, body, backup.Options{Replace: replace})
+		switch {
+		case errors.Is(err, backup.ErrInvalidArchive):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, backup.ErrNotEmpty):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The archive may hold every library's covers
+		if _, err := coverService.MoveRestored(repository.WithoutOwner(r.Context())); err != nil {
+			log.Printf("Moving restored covers failed: %v", err)
+		}
+		json.NewEncoder(w).Encode(summary)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchOperations caps the operations accepted by a single batch request.
+const maxBatchOperations = 10000
+
+// Batch operation kinds.
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+type batchOperation struct {
+	Op   string       `json:"op"`
+	ID   int          `json:"id,omitempty"`
+	Book *models.Book `json:"book,omitempty"`
+}
+
+type batchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	Status int          `json:"status"`
+	ID     int          `json:"id,omitempty"`
+	Book   *models.Book `json:"book,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Atomic    bool          `json:"atomic"`
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// BatchBooks applies a list of create, update and delete operations and
+// responds 207 Multi-Status with one result per operation. Without "atomic"
+// each operation stands alone; with it, all operations run in a single
+// transaction and either all of them commit or none do.
+func BatchBooks(repo repository.BookRepositoryInterface, store repository.StoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		var req batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+			http.Error(w, fmt.Sprintf("Between 1 and %d operations are required", maxBatchOperations), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]batchResult, len(req.Operations))
+		valid := true
+		for i, op := range req.Operations {
+			results[i] = batchResult{Index: i, Op: op.Op}
+			if err := validateOperation(op); err != nil {
+				results[i].Status = http.StatusBadRequest
+				results[i].Error = err.Error()
+				valid = false
+			}
+		}
+
+		resp := batchResponse{Atomic: req.Atomic, Results: results}
+		switch {
+		case !req.Atomic:
+			for i, op := range req.Operations {
+				if results[i].Status == 0 {
+					applyOperation(r.Context(), repo, op, &results[i])
+				}
+			}
+			resp.Committed = true
+		case valid:
+			resp.Committed = runAtomicBatch(r.Context(), store, req.Operations, results)
+		default:
+			failDependents(results)
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func validateOperation(op batchOperation) error {
+	switch op.Op {
+	case opCreate, opUpdate:
+		if op.Op == opUpdate && op.ID <= 0 {
+			return errors.New("Update requires a positive id")
+		}
+		if op.Book == nil {
+			return errors.New("Operation requires a book")
+		}
+		return op.Book.Validate()
+	case opDelete:
+		if op.ID <= 0 {
+			return errors.New("Delete requires a positive id")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown operation %q", op.Op)
+}
+
+// runAtomicBatch applies every operation in one transaction, sending runs
+// of consecutive creates through the bulk insert path. It reports whether
+// the transaction committed.
+func runAtomicBatch(ctx context.Context, store repository.StoreInterface, ops []batchOperation, results []batchResult) bool {
+	err := store.RunInTx(ctx, func(repos repository.Repos) error {
+		// RunInTx may retry, so every attempt starts from clean results
+		for i := range results {
+			results[i] = batchResult{Index: i, Op: ops[i].Op}
+		}
+		for i := 0; i < len(ops); {
+			if ops[i].Op != opCreate {
+				if err := applyOperation(ctx, repos.Books, ops[i], &results[i]); err != nil {
+					return err
+				}
+				i++
+				continue
+			}
+			end := i
+			var books []*models.Book
+			for end < len(ops) && ops[end].Op == opCreate {
+				book := *ops[end].Book
+				books = append(books, &book)
+				end++
+			}
+			if err := repos.Books.CreateBooks(ctx, books); err != nil {
+				for j := i; j < end; j++ {
+					results[j].Status, results[j].Error = statusForError(err), err.Error()
+				}
+				return err
+			}
+			for j, book := range books {
+				results[i+j].Status, results[i+j].ID, results[i+j].Book = http.StatusCreated, book.ID, book
+			}
+			i = end
+		}
+		return nil
+	})
+	if err != nil {
+		failDependents(results)
+		return false
+	}
+	return true
+}
+
+// applyOperation runs one operation against repo and records its outcome.
+func applyOperation(ctx context.Context, repo repository.BookRepositoryInterface, op batchOperation, result *batchResult) error {
+	var err error
+	switch op.Op {
+	case opCreate:
+		book := *op.Book
+		if err = repo.CreateBook(ctx, &book); err == nil {
+			result.Status, result.ID, result.Book = http.StatusCreated, book.ID, &book
+		}
+	case opUpdate:
+		book := *op.Book
+		book.ID = op.ID
+		if err = repo.UpdateBook(ctx, &book); err == nil {
+			result.Status, result.ID, result.Book = http.StatusOK, book.ID, &book
+		}
+	case opDelete:
+		if err = repo.DeleteBook(ctx, op.ID); err == nil {
+			result.Status, result.ID = http.StatusNoContent, op.ID
+		}
+	}
+	if err != nil {
+		result.Status, result.Error = statusForError(err), err.Error()
+	}
+	return err
+}
+
+// failDependents marks every operation without an error of its own as
+// failed because the batch it belonged to did not commit.
+func failDependents(results []batchResult) {
+	for i := range results {
+		if results[i].Error == "" {
+			results[i] = batchResult{
+				Index:  i,
+				Op:     results[i].Op,
+				Status: http.StatusFailedDependency,
+				Error:  "Batch was not committed",
+			}
+		}
+	}
+}
+
+func statusForError(err error) int {
+	if errors.Is(err, repository.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"book-tracker/internal/covers"
+	"book-tracker/internal/repository"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// PutCover makes the uploaded JPEG, PNG or WebP image a book's cover and
+// generates its thumbnails.
+func PutCover(coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, covers.MaxBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, covers.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		cover, err := coverService.Set(r.Context(), id, data, r.Header.Get("Content-Type"))
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, covers.ErrTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, covers.ErrUnsupportedType):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.Is(err, covers.ErrInvalidImage):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(cover)
+		}
+	}
+}
+
+// GetCover serves a book's cover image as uploaded, or the thumbnail named
+// by the size path variable. Responses carry an ETag and Last-Modified, so
+// clients revalidate instead of downloading the image again.
+func GetCover(coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		size := mux.Vars(r)["size"]
+		cover, err := coverService.Get(r.Context(), id, size)
+		if errors.Is(err, repository.ErrCoverNotFound) || errors.Is(err, covers.ErrUnknownSize) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", cover.ContentType)
+		w.Header().Set("ETag", covers.ETag(cover, size))
+		w.Header().Set("Cache-Control", "private, no-cache")
+		http.ServeContent(w, r, "", cover.UpdatedAt, bytes.NewReader(cover.Data))
+	}
+}
+
+// DeleteCover removes a book's cover and its thumbnails.
+func DeleteCover(coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		err = coverService.Delete(r.Context(), id)
+		if errors.Is(err, repository.ErrCoverNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
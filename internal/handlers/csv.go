@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"book-tracker/internal/export"
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes caps the size of an uploaded import file.
+const maxImportBytes = 32 << 20
+
+func ExportCSV(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="books.csv"`)
+		cw := export.NewCSVWriter(w)
+		err := repo.StreamBooks(r.Context(), func(book models.Book) error {
+			return cw.Write(book)
+		})
+		if err == nil {
+			err = cw.Flush()
+		}
+		if err != nil {
+			// The status line is already sent, so the truncated body is
+			// the only signal the client gets
+			log.Printf("CSV export failed: %v", err)
+		}
+	}
+}
+
+// ImportCSV creates and updates books from an uploaded CSV file. The format
+// parameter selects a Goodreads or StoryGraph export; the default generic
+// format matches columns by header name, and repeated map=field:Header
+// query parameters map other headers. Exports from other services are merged
+// into matching books, so progress, notes and tags kept here survive them.
+// With dry_run=true nothing is written and the report shows what would
+// happen.
+func ImportCSV(store repository.StoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		dryRun, err := strconv.ParseBool(q.Get("dry_run"))
+		if q.Get("dry_run") != "" && err != nil {
+			http.Error(w, "Invalid dry_run parameter", http.StatusBadRequest)
+			return
+		}
+		mapping := make(map[string]string)
+		for _, m := range q["map"] {
+			field, header, ok := strings.Cut(m, ":")
+			if !ok {
+				http.Error(w, "Invalid map parameter, expected field:Header", http.StatusBadRequest)
+				return
+			}
+			mapping[strings.ToLower(field)] = header
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+		var records []importer.Record
+		opts := importer.Options{DryRun: dryRun}
+		switch q.Get("format") {
+		case "", "csv":
+			records, err = importer.ParseCSV(body, mapping)
+		case importer.SourceGoodreads:
+			records, err = importer.ParseGoodreads(body)
+			opts.Merge = true
+		case importer.SourceStoryGraph:
+			records, err = importer.ParseStoryGraph(body)
+			opts.Merge = true
+		default:
+			http.Error(w, "Invalid format parameter, expected csv, goodreads or storygraph", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeImportReport(w, r, store, records, opts)
+	}
+}
+
+func writeImportReport(w http.ResponseWriter, r *http.Request, store repository.StoreInterface, records []importer.Record, opts importer.Options) {
+	report, err := importer.Import(r.Context(), store, records, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"book-tracker/internal/covers"
+	"book-tracker/internal/dedup"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type mergeRequest struct {
+	TargetID  int   `json:"target_id"`
+	SourceIDs []int `json:"source_ids"`
+}
+
+func GetDuplicates(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		books, err := repo.GetBooks(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		groups := dedup.FindDuplicates(books)
+		if groups == nil {
+			groups = []dedup.Group{}
+		}
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// MergeBooks folds books into a target. A target without a cover takes the
+// first source's, and the target's history takes in the sources'.
+func MergeBooks(repo repository.BookRepositoryInterface, coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		var req mergeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		// Validate merge input
+		seen := map[int]bool{req.TargetID: true}
+		for _, id := range req.SourceIDs {
+			if seen[id] {
+				http.Error(w, "Source IDs must be distinct and differ from the target ID", http.StatusBadRequest)
+				return
+			}
+			seen[id] = true
+		}
+		if req.TargetID <= 0 || len(req.SourceIDs) == 0 {
+			http.Error(w, "Target ID and at least one source ID are required", http.StatusBadRequest)
+			return
+		}
+		var book *models.Book
+		err := coverService.Merge(r.Context(), req.TargetID, req.SourceIDs, func() error {
+			var err error
+			book, err = repo.MergeBooks(r.Context(), req.TargetID, req.SourceIDs)
+			return err
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(book)
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"book-tracker/internal/enrich"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// EnrichBook looks a book up with the configured metadata providers and
+// fills in its missing ISBN, publisher, year and page count. enricher is
+// nil when no provider is configured.
+func EnrichBook(repo repository.BookRepositoryInterface, enricher *enrich.Enricher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		if enricher == nil {
+			http.Error(w, "No metadata provider is configured", http.StatusServiceUnavailable)
+			return
+		}
+		books, err := repo.GetBooksByIDs(r.Context(), []int{id})
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result, err := enricher.Enrich(r.Context(), books[0])
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, enrich.ErrLookupFailed) {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"book-tracker/internal/covers"
+	"book-tracker/internal/epub"
+	"book-tracker/internal/repository"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxEPUBBytes caps the size of an uploaded EPUB file.
+const maxEPUBBytes = 100 << 20
+
+// CreateBookFromEPUB creates a book from the metadata of an uploaded EPUB
+// file, making its cover image the book's cover when it has a usable one.
+func CreateBookFromEPUB(repo repository.BookRepositoryInterface, coverService *covers.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEPUBBytes))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		meta, err := epub.Parse(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		book := meta.Book()
+		if err := book.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := repo.CreateBook(r.Context(), &book); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(meta.Cover) > 0 {
+			// The cover is a bonus: the book exists either way, and one can
+			// still be uploaded with PUT /books/{id}/cover
+			if _, err := coverService.Set(r.Context(), book.ID, meta.Cover, meta.CoverType); err != nil {
+				log.Printf("Skipping cover of EPUB for book %d: %v", book.ID, err)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(book)
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"book-tracker/internal/export"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ExportMarkdown streams a zip of one Markdown file per book, with its
+// notes and highlights, for use as an Obsidian vault.
+func ExportMarkdown(repo repository.BookRepositoryInterface, highlightRepo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		highlights, err := highlightRepo.GetAllHighlights(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		byBook := make(map[int][]models.Highlight)
+		for _, h := range highlights {
+			byBook[h.BookID] = append(byBook[h.BookID], h)
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="books-markdown.zip"`)
+		vault := export.NewMarkdownVault(w)
+		err = repo.StreamBooks(r.Context(), func(book models.Book) error {
+			return vault.Add(book, byBook[book.ID])
+		})
+		if err == nil {
+			err = vault.Close()
+		}
+		if err != nil {
+			// The status line is already sent, so the truncated archive
+			// is the only signal the client gets
+			log.Printf("Markdown export failed: %v", err)
+		}
+	}
+}
+
+// ExportAnki writes a card per highlight for import into Anki, as a
+// tab-separated file or, with format=apkg, a deck package. deck names the
+// deck (default "Books"); front and back override the card templates.
+func ExportAnki(repo repository.BookRepositoryInterface, highlightRepo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format != "" && format != "tsv" && format != "apkg" {
+			http.Error(w, "Invalid format parameter, expected tsv or apkg", http.StatusBadRequest)
+			return
+		}
+		deck := q.Get("deck")
+		if deck == "" {
+			deck = "Books"
+		}
+		front, back := q.Get("front"), q.Get("back")
+		if front == "" {
+			front = export.DefaultAnkiFront
+		}
+		if back == "" {
+			back = export.DefaultAnkiBack
+		}
+		templates, err := export.ParseAnkiTemplates(front, back)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		books, err := repo.GetBooks(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		byID := make(map[int]models.Book, len(books))
+		for _, b := range books {
+			byID[b.ID] = b
+		}
+		highlights, err := highlightRepo.GetAllHighlights(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cards, err := templates.Cards(highlights, byID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if format == "apkg" {
+			var buf bytes.Buffer
+			if err := export.WriteAnkiPackage(r.Context(), &buf, deck, cards); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="highlights.apkg"`)
+			w.Write(buf.Bytes())
+			return
+		}
+		w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="highlights.txt"`)
+		if err := export.WriteAnkiTSV(w, deck, cards); err != nil {
+			log.Printf("Anki export failed: %v", err)
+		}
+	}
+}
+
+// ExportBibTeX writes books as a BibTeX file: those listed in ids
+// (comma-separated), or every book.
+func ExportBibTeX(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var bw *export.BibTeXWriter
+		exportSelected(w, r, repo, "BibTeX", func() {
+			w.Header().Set("Content-Type", "application/x-bibtex; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="books.bib"`)
+			bw = export.NewBibTeXWriter(w)
+		}, func(book models.Book) error {
+			return bw.Write(book)
+		})
+	}
+}
+
+// ExportRIS writes books as an RIS file: those listed in ids
+// (comma-separated), or every book.
+func ExportRIS(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		exportSelected(w, r, repo, "RIS", func() {
+			w.Header().Set("Content-Type", "application/x-research-info-systems; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="books.ris"`)
+		}, func(book models.Book) error {
+			return export.WriteRIS(w, book)
+		})
+	}
+}
+
+// exportSelected calls start, then write for each book selected by the ids
+// parameter, or for every book when it is absent. Unknown ids fail before
+// anything is written.
+func exportSelected(w http.ResponseWriter, r *http.Request, repo repository.BookRepositoryInterface,
+	name string, start func(), write func(book models.Book) error) {
+	ids, err := bookIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		start()
+		if err := repo.StreamBooks(r.Context(), write); err != nil {
+			// The status line is already sent, so the truncated file is
+			// the only signal the client gets
+			log.Printf("%s export failed: %v", name, err)
+		}
+		return
+	}
+
+	books, err := repo.GetBooksByIDs(r.Context(), ids)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	start()
+	for _, book := range books {
+		if err := write(book); err != nil {
+			log.Printf("%s export failed: %v", name, err)
+			return
+		}
+	}
+}
+
+func bookIDs(param string) ([]int, error) {
+	var ids []int
+	for _, s := range strings.Split(param, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, &queryError{"ids"}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetCitation formats a book as a citation in the style given by the style
+// parameter: apa (the default), mla or chicago.
+func GetCitation(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		style := r.URL.Query().Get("style")
+		if style == "" {
+			style = export.StyleAPA
+		}
+		books, err := repo.GetBooksByIDs(r.Context(), []int{id})
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		citation, err := export.Cite(books[0], style)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(citation)
+	}
+}
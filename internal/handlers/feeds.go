@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"book-tracker/internal/feed"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// feedSize is the number of most recently finished books in a feed.
+const feedSize = 50
+
+// Feed formats.
+const (
+	FeedAtom = "atom"
+	FeedRSS  = "rss"
+)
+
+// FinishedFeed serves the most recently finished books as an Atom or RSS
+// feed. Responses carry an ETag, so feed readers polling with If-None-Match
+// get 304 Not Modified until the feed changes. There is no Last-Modified:
+// no book's timestamp changes when another book leaves the feed.
+func FinishedFeed(repo repository.CatalogRepositoryInterface, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		books, _, err := repo.ListBooks(r.Context(), models.CatalogFilter{
+			Finished: true,
+			Order:    models.CatalogOrderFinished,
+			Limit:    feedSize,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f := &feed.Finished{BaseURL: baseURL(r), Title: "Finished books", Books: books}
+		var buf bytes.Buffer
+		contentType := "application/atom+xml; charset=utf-8"
+		if format == FeedRSS {
+			contentType = "application/rss+xml; charset=utf-8"
+			err = f.WriteRSS(&buf)
+		} else {
+			err = f.WriteAtom(&buf)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+	}
+}
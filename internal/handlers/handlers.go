@@ -1,34 +1,139 @@
 package handlers
 
 import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/auth"
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/enrich"
 	"book-tracker/internal/models"
 	"book-tracker/internal/repository"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 )
 
-func RegisterBookHandlers(router *mux.Router, db *sqlx.DB) {
+// Config holds what the handlers need besides the database.
+type Config struct {
+	// Blobs stores cover images.
+	Blobs blob.Store
+	// Provider looks up missing book metadata; enrichment is disabled when
+	// it is nil.
+	Provider enrich.MetadataProvider
+	// SessionTTL is how long sign-ins last, auth.DefaultSessionTTL if zero.
+	SessionTTL time.Duration
+	// OIDC verifies ID tokens from an OpenID Connect provider, which are
+	// not accepted when it is nil.
+	OIDC *auth.OIDC
+}
+
+// RegisterBookHandlers registers every route. Only registration and sign-in
+// are open; every other route needs an authenticated user and works on
+// their library's books, changing them only as an editor or owner. API
+// tokens need the scope for the request's method, /admin routes need an
+// admin, and API tokens are managed by signed-in users only.
+func RegisterBookHandlers(router *mux.Router, db *sqlx.DB, cfg Config) {
 	repo := repository.NewBookRepository(db)
-	router.HandleFunc("/books", CreateBook(repo)).Methods("POST")
-	router.HandleFunc("/books", GetBooks(repo)).Methods("GET")
-	router.HandleFunc("/books/{id}", UpdateBook(repo)).Methods("PUT")
-	router.HandleFunc("/books/{id}", DeleteBook(repo)).Methods("DELETE")
+	auditRepo := repository.NewAuditRepository(db)
+	highlightRepo := repository.NewHighlightRepository(db)
+	catalogRepo := repository.NewCatalogRepository(db)
+	coverService := covers.NewService(repository.NewCoverRepository(db), cfg.Blobs)
+	store := repository.NewStore(db)
+	authService := auth.NewService(store, repository.NewUserRepository(db), repository.NewLibraryRepository(db), cfg.SessionTTL)
+	if cfg.OIDC != nil {
+		authService.UseOIDC(cfg.OIDC)
+	}
+	var enricher *enrich.Enricher
+	if cfg.Provider != nil {
+		enricher = enrich.NewEnricher(cfg.Provider, store)
+	}
+	router.Use(audit.Middleware)
+	router.HandleFunc("/auth/register", Register(authService)).Methods("POST")
+	router.HandleFunc("/auth/login", Login(authService)).Methods("POST")
+
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(authService))
+	api.HandleFunc("/auth/logout", Logout(authService)).Methods("POST")
+	api.HandleFunc("/auth/me", GetMe()).Methods("GET")
+	tokens := api.PathPrefix("/auth/tokens").Subrouter()
+	tokens.Use(auth.RequireSession)
+	tokens.HandleFunc("", CreateAPIToken(authService)).Methods("POST")
+	tokens.HandleFunc("", ListAPITokens(authService)).Methods("GET")
+	tokens.HandleFunc("/{id}", RevokeAPIToken(authService)).Methods("DELETE")
+	api.HandleFunc("/libraries", ListLibraries(authService)).Methods("GET")
+	api.HandleFunc("/libraries/{id}/members", ListMembers(authService)).Methods("GET")
+	members := api.PathPrefix("/libraries/{id}/members/{username}").Subrouter()
+	members.Use(auth.RequireSession)
+	members.HandleFunc("", SetMember(authService)).Methods("PUT")
+	members.HandleFunc("", RemoveMember(authService)).Methods("DELETE")
+	api.HandleFunc("/books", CreateBook(repo)).Methods("POST")
+	api.HandleFunc("/books", GetBooks(repo)).Methods("GET")
+	api.HandleFunc("/books/batch", BatchBooks(repo, store)).Methods("POST")
+	api.HandleFunc("/books/export.csv", ExportCSV(repo)).Methods("GET")
+	api.HandleFunc("/books/import", ImportCSV(store)).Methods("POST")
+	api.HandleFunc("/books/from-epub", CreateBookFromEPUB(repo, coverService)).Methods("POST")
+	api.HandleFunc("/books/duplicates", GetDuplicates(repo)).Methods("GET")
+	api.HandleFunc("/books/merge", MergeBooks(repo, coverService)).Methods("POST")
+	api.HandleFunc("/books/{id}", UpdateBook(repo)).Methods("PUT")
+	api.HandleFunc("/books/{id}", DeleteBook(repo)).Methods("DELETE")
+	api.HandleFunc("/books/{id}/restore", RestoreBook(repo)).Methods("POST")
+	api.HandleFunc("/books/{id}/history", GetBookHistory(auditRepo)).Methods("GET")
+	api.HandleFunc("/books/{id}/revert", RevertBook(repo)).Methods("POST")
+	api.HandleFunc("/books/{id}/citation", GetCitation(repo)).Methods("GET")
+	api.HandleFunc("/books/{id}/cover", PutCover(coverService)).Methods("PUT")
+	api.HandleFunc("/books/{id}/cover", GetCover(coverService)).Methods("GET")
+	api.HandleFunc("/books/{id}/cover", DeleteCover(coverService)).Methods("DELETE")
+	api.HandleFunc("/books/{id}/cover/{size}", GetCover(coverService)).Methods("GET")
+	api.HandleFunc("/books/{id}/enrich", EnrichBook(repo, enricher)).Methods("POST")
+	api.HandleFunc("/books/{id}/highlights", CreateHighlight(highlightRepo)).Methods("POST")
+	api.HandleFunc("/books/{id}/highlights", GetHighlights(highlightRepo)).Methods("GET")
+	api.HandleFunc("/books/{id}/highlights/{highlight_id}", GetHighlight(highlightRepo)).Methods("GET")
+	api.HandleFunc("/books/{id}/highlights/{highlight_id}", UpdateHighlight(highlightRepo)).Methods("PUT")
+	api.HandleFunc("/books/{id}/highlights/{highlight_id}", DeleteHighlight(highlightRepo)).Methods("DELETE")
+	api.HandleFunc("/highlights/search", SearchHighlights(highlightRepo)).Methods("GET")
+	api.HandleFunc("/highlights/import", ImportClippings(store)).Methods("POST")
+	api.HandleFunc("/export/anki", ExportAnki(repo, highlightRepo)).Methods("GET")
+	api.HandleFunc("/export/markdown", ExportMarkdown(repo, highlightRepo)).Methods("GET")
+	api.HandleFunc("/export/bibtex", ExportBibTeX(repo)).Methods("GET")
+	api.HandleFunc("/export/ris", ExportRIS(repo)).Methods("GET")
+	api.HandleFunc("/opds", OPDSRoot()).Methods("GET")
+	api.HandleFunc("/opds/recent", OPDSRecent(catalogRepo)).Methods("GET")
+	api.HandleFunc("/opds/books", OPDSBooks(catalogRepo)).Methods("GET")
+	api.HandleFunc("/opds/books/{id}", OPDSBook(repo)).Methods("GET")
+	api.HandleFunc("/opds/authors", OPDSFacets(catalogRepo, repository.FacetAuthor)).Methods("GET")
+	api.HandleFunc("/opds/shelves", OPDSFacets(catalogRepo, repository.FacetShelf)).Methods("GET")
+	api.HandleFunc("/opds/tags", OPDSFacets(catalogRepo, repository.FacetTag)).Methods("GET")
+	api.HandleFunc("/opds/search", OPDSSearch(catalogRepo)).Methods("GET")
+	api.HandleFunc("/opds/opensearch.xml", OPDSOpenSearch()).Methods("GET")
+	api.HandleFunc("/feeds/finished.atom", FinishedFeed(catalogRepo, FeedAtom)).Methods("GET")
+	api.HandleFunc("/feeds/finished.rss", FinishedFeed(catalogRepo, FeedRSS)).Methods("GET")
+	api.HandleFunc("/trash", GetTrash(repo)).Methods("GET")
+	api.HandleFunc("/audit", ListAudit(auditRepo)).Methods("GET")
+
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequireAdmin)
+	admin.HandleFunc("/backup", BackupData(store, cfg.Blobs)).Methods("GET")
+	admin.HandleFunc("/restore", RestoreData(store, coverService)).Methods("POST")
 }
 
 func CreateBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
 		var book models.Book
 		if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 		// Validate book input
-		if book.Title == "" || book.Author == "" || book.Progress < 0 {
-			http.Error(w, "Title, Author, and non-negative Progress are required", http.StatusBadRequest)
+		if err := book.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		if err := repo.CreateBook(r.Context(), &book); err != nil {
@@ -53,6 +158,9 @@ func GetBooks(repo repository.BookRepositoryInterface) http.HandlerFunc {
 
 func UpdateBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
 		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
@@ -64,12 +172,17 @@ func UpdateBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
 			return
 		}
 		// Validate book input
-		if book.Title == "" || book.Author == "" || book.Progress < 0 {
-			http.Error(w, "Title, Author, and non-negative Progress are required", http.StatusBadRequest)
+		if err := book.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		book.ID = id
-		if err := repo.UpdateBook(r.Context(), &book); err != nil {
+		err = repo.UpdateBook(r.Context(), &book)
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -79,12 +192,20 @@ func UpdateBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
 
 func DeleteBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
 		id, err := strconv.Atoi(mux.Vars(r)["id"])
 		if err != nil {
 			http.Error(w, "Invalid ID", http.StatusBadRequest)
 			return
 		}
-		if err := repo.DeleteBook(r.Context(), id); err != nil {
+		err = repo.DeleteBook(r.Context(), id)
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func CreateHighlight(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		var highlight models.Highlight
+		if err := json.NewDecoder(r.Body).Decode(&highlight); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := highlight.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		highlight.ID, highlight.BookID, highlight.Fingerprint = 0, bookID, ""
+		err = repo.CreateHighlight(r.Context(), &highlight)
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(highlight)
+	}
+}
+
+func GetHighlights(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		highlights, err := repo.GetHighlights(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if highlights == nil {
+			highlights = []models.Highlight{}
+		}
+		json.NewEncoder(w).Encode(highlights)
+	}
+}
+
+func GetHighlight(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bookID, id, err := highlightIDs(r)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		highlight, err := repo.GetHighlight(r.Context(), bookID, id)
+		if errors.Is(err, repository.ErrHighlightNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(highlight)
+	}
+}
+
+func UpdateHighlight(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		bookID, id, err := highlightIDs(r)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		var highlight models.Highlight
+		if err := json.NewDecoder(r.Body).Decode(&highlight); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := highlight.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		highlight.ID, highlight.BookID = id, bookID
+		err = repo.UpdateHighlight(r.Context(), &highlight)
+		if errors.Is(err, repository.ErrHighlightNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(highlight)
+	}
+}
+
+func DeleteHighlight(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		bookID, id, err := highlightIDs(r)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		err = repo.DeleteHighlight(r.Context(), bookID, id)
+		if errors.Is(err, repository.ErrHighlightNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SearchHighlights runs a full-text search over every highlight's text and
+// comment. q is required; limit defaults to 50.
+func SearchHighlights(repo repository.HighlightRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("q") == "" {
+			http.Error(w, "Missing q parameter", http.StatusBadRequest)
+			return
+		}
+		limit, offset := 50, 0
+		for _, p := range []struct {
+			name string
+			dst  *int
+		}{{"limit", &limit}, {"offset", &offset}} {
+			if v := q.Get(p.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					http.Error(w, (&queryError{p.name}).Error(), http.StatusBadRequest)
+					return
+				}
+				*p.dst = n
+			}
+		}
+		matches, err := repo.SearchHighlights(r.Context(), q.Get("q"), limit, offset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if matches == nil {
+			matches = []models.HighlightMatch{}
+		}
+		json.NewEncoder(w).Encode(matches)
+	}
+}
+
+func highlightIDs(r *http.Request) (bookID, id int, err error) {
+	vars := mux.Vars(r)
+	if bookID, err = strconv.Atoi(vars["id"]); err != nil {
+		return 0, 0, err
+	}
+	id, err = strconv.Atoi(vars["highlight_id"])
+	return bookID, id, err
+}
+
+// ImportClippings attaches the highlights, notes and bookmarks in an
+// uploaded Kindle "My Clippings.txt" file to their books.
+func ImportClippings(store repository.StoreInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if r.URL.Query().Get("dry_run") != "" && err != nil {
+			http.Error(w, "Invalid dry_run parameter", http.StatusBadRequest)
+			return
+		}
+		clippings, err := importer.ParseClippings(http.MaxBytesReader(w, r.Body, maxImportBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := importer.ImportClippings(r.Context(), store, clippings, dryRun)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
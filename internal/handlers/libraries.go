@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"book-tracker/internal/auth"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// canEdit answers 403 Forbidden, and returns false, unless the signed-in
+// user may change the books of the library the request is for.
+func canEdit(w http.ResponseWriter, r *http.Request) bool {
+	if !auth.Role(r.Context()).Allows(models.RoleEditor) {
+		http.Error(w, "Viewers cannot change this library's books", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ListLibraries returns the libraries the signed-in user can open, with
+// their role in each. Requests pick one by its ID in an X-Library header.
+func ListLibraries(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		libraries, err := authService.Libraries(r.Context(), auth.User(r.Context()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(libraries)
+	}
+}
+
+// ListMembers returns a library's members and their roles.
+func ListMembers(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		members, err := authService.Members(r.Context(), auth.User(r.Context()), id)
+		if err != nil {
+			writeMemberError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+// SetMember adds a user to a library, or changes their role, given as
+// {"role": "editor"}.
+func SetMember(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Role models.Role `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		member, err := authService.SetMember(r.Context(), auth.User(r.Context()), id, mux.Vars(r)["username"], req.Role)
+		if err != nil {
+			writeMemberError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(member)
+	}
+}
+
+// RemoveMember takes a user out of a library. Members may remove
+// themselves to leave it.
+func RemoveMember(authService *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		if err := authService.RemoveMember(r.Context(), auth.User(r.Context()), id, mux.Vars(r)["username"]); err != nil {
+			writeMemberError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeMemberError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidRole), errors.Is(err, auth.ErrOwnLibrary):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrNotLibraryOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, repository.ErrLibraryNotFound), errors.Is(err, repository.ErrMemberNotFound),
+		errors.Is(err, repository.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
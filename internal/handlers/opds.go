@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/opds"
+	"book-tracker/internal/repository"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// opdsTitle names the catalog in e-reader apps.
+const opdsTitle = "Book Tracker"
+
+// baseURL is the absolute URL of this server as the client reached it,
+// honoring the scheme a reverse proxy reports in X-Forwarded-Proto.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// catalog builds feeds with absolute links to this server.
+func catalog(r *http.Request) *opds.Catalog {
+	base := baseURL(r)
+	return &opds.Catalog{BaseURL: base + "/opds", APIURL: base, Title: opdsTitle}
+}
+
+func writeOPDS(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType+";charset=utf-8")
+	if err := opds.Write(w, v); err != nil {
+		log.Printf("OPDS feed failed: %v", err)
+	}
+}
+
+// OPDSRoot serves the OPDS start feed.
+func OPDSRoot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeOPDS(w, opds.NavigationType, catalog(r).Root(time.Now()))
+	}
+}
+
+// OPDSFacets serves a navigation feed of the authors, shelves or tags of
+// the library, each linking to its books.
+func OPDSFacets(repo repository.CatalogRepositoryInterface, field string) http.HandlerFunc {
+	feeds := map[string]struct{ id, title, path string }{
+		repository.FacetAuthor: {"authors", "By author", "/authors"},
+		repository.FacetShelf:  {"shelves", "By shelf", "/shelves"},
+		repository.FacetTag:    {"tags", "By tag", "/tags"},
+	}
+	feed := feeds[field]
+	return func(w http.ResponseWriter, r *http.Request) {
+		facets, err := repo.Facets(r.Context(), field)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeOPDS(w, opds.NavigationType, catalog(r).Facets(feed.id, feed.title, feed.path, field, facets, time.Now()))
+	}
+}
+
+// OPDSBooks serves an acquisition feed of books by title, narrowed by the
+// author, shelf and tag parameters, one page at a time.
+func OPDSBooks(repo repository.CatalogRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := models.CatalogFilter{
+			Author: q.Get(repository.FacetAuthor),
+			Shelf:  q.Get(repository.FacetShelf),
+			Tag:    q.Get(repository.FacetTag),
+			Order:  models.CatalogOrderTitle,
+		}
+		title := "All books"
+		switch {
+		case filter.Author != "":
+			title = "Books by " + filter.Author
+		case filter.Shelf != "":
+			title = "Shelf: " + filter.Shelf
+		case filter.Tag != "":
+			title = "Tag: " + filter.Tag
+		}
+		serveOPDSBooks(w, r, repo, "books", title, "/books", filter)
+	}
+}
+
+// OPDSRecent serves an acquisition feed of books, newest first.
+func OPDSRecent(repo repository.CatalogRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := models.CatalogFilter{Order: models.CatalogOrderRecent}
+		serveOPDSBooks(w, r, repo, "recent", "Recently added", "/recent", filter)
+	}
+}
+
+// OPDSSearch serves an acquisition feed of the books whose title, author
+// or ISBN contains q.
+func OPDSSearch(repo repository.CatalogRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			http.Error(w, "Missing q parameter", http.StatusBadRequest)
+			return
+		}
+		filter := models.CatalogFilter{Query: query, Order: models.CatalogOrderTitle}
+		serveOPDSBooks(w, r, repo, "search", "Search: "+query, "/search", filter)
+	}
+}
+
+// OPDSBook serves the complete catalog entry of one book.
+func OPDSBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		books, err := repo.GetBooksByIDs(r.Context(), []int{id})
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeOPDS(w, opds.EntryType, catalog(r).Book(books[0]))
+	}
+}
+
+func serveOPDSBooks(w http.ResponseWriter, r *http.Request, repo repository.CatalogRepositoryInterface,
+	id, title, path string, filter models.CatalogFilter) {
+	q := r.URL.Query()
+	number := 1
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, (&queryError{"page"}).Error(), http.StatusBadRequest)
+			return
+		}
+		number = n
+	}
+	filter.Limit, filter.Offset = opds.PageSize, (number-1)*opds.PageSize
+	books, total, err := repo.ListBooks(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Page links keep the parameters that select the books
+	kept := url.Values{}
+	for _, p := range []string{repository.FacetAuthor, repository.FacetShelf, repository.FacetTag, "q"} {
+		if v := q.Get(p); v != "" {
+			kept.Set(p, v)
+		}
+	}
+	page := opds.Page{Path: path, Query: kept, Number: number, Total: total}
+	writeOPDS(w, opds.AcquisitionType, catalog(r).Books(id, title, page, books))
+}
+
+// OPDSOpenSearch serves the OpenSearch description of the catalog search.
+func OPDSOpenSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeOPDS(w, opds.OpenSearchType, catalog(r).OpenSearch())
+	}
+}
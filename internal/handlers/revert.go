@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RevertBook restores a book to an earlier state. The "to" query parameter
+// is either a version number from the book's history or an RFC 3339 time.
+func RevertBook(repo repository.BookRepositoryInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !canEdit(w, r) {
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		to, err := parseRevertPoint(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, "The to parameter must be a version number or an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		book, err := repo.RevertBook(r.Context(), id, to)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrNoSuchVersion) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(book)
+	}
+}
+
+func parseRevertPoint(v string) (models.RevertPoint, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		if n <= 0 {
+			return models.RevertPoint{}, errors.New("version must be positive")
+		}
+		return models.RevertPoint{Version: n}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return models.RevertPoint{}, err
+	}
+	return models.RevertPoint{At: t}, nil
+}
@@ -0,0 +1,107 @@
+package importer
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// calibreQuery reads one row per book from a Calibre metadata.db, with
+// many-to-many fields joined by the unit separator.
+const calibreQuery = `
+	SELECT b.id, COALESCE(b.uuid, ''), b.title, COALESCE(b.series_index, 0),
+	       COALESCE(b.pubdate, ''), COALESCE(b.timestamp, ''),
+	       COALESCE((SELECT group_concat(name, char(31)) FROM (
+	           SELECT a.name FROM books_authors_link l JOIN authors a ON a.id = l.author
+	           WHERE l.book = b.id ORDER BY l.id)), ''),
+	       COALESCE((SELECT group_concat(t.name, char(31)) FROM books_tags_link l
+	           JOIN tags t ON t.id = l.tag WHERE l.book = b.id), ''),
+	       COALESCE((SELECT s.name FROM books_series_link l
+	           JOIN series s ON s.id = l.series WHERE l.book = b.id), ''),
+	       COALESCE((SELECT p.name FROM books_publishers_link l
+	           JOIN publishers p ON p.id = l.publisher WHERE l.book = b.id), ''),
+	       COALESCE((SELECT r.rating FROM books_ratings_link l
+	           JOIN ratings r ON r.id = l.rating WHERE l.book = b.id), 0),
+	       COALESCE((SELECT i.val FROM identifiers i WHERE i.book = b.id AND i.type = 'isbn'), ''),
+	       COALESCE((SELECT c.text FROM comments c WHERE c.book = b.id), '')
+	FROM books b
+	ORDER BY b.id`
+
+// calibreUndefinedYear is the year Calibre stores for unknown dates.
+const calibreUndefinedYear = 101
+
+// Timestamp layouts used in Calibre's metadata.db.
+var calibreDateLayouts = []string{
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02T15:04:05.999999-07:00",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02",
+}
+
+// ParseCalibre reads the books in a Calibre library's metadata.db. The file
+// is opened read-only, so a library Calibre has open can be imported. Books
+// are keyed by their Calibre uuid; Line is the Calibre book id.
+func ParseCalibre(ctx context.Context, path string) ([]Record, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=ro&_pragma=query_only(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, calibreQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Not a Calibre library: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		var uuid, pubdate, added, authors, tags, isbn, comments string
+		var rating int
+		b := &rec.Book
+		err := rows.Scan(&rec.Line, &uuid, &b.Title, &b.SeriesIndex, &pubdate, &added,
+			&authors, &tags, &b.Series, &b.Publisher, &rating, &isbn, &comments)
+		if err != nil {
+			return nil, err
+		}
+		b.Source, b.SourceID = SourceCalibre, uuid
+		if uuid == "" {
+			b.SourceID = fmt.Sprint(rec.Line)
+		}
+		b.Author = strings.Join(strings.Split(authors, "\x1f"), ", ")
+		b.Tags = models.NewTags(strings.Split(tags, "\x1f")...)
+		b.ISBN = isbn
+		// Calibre stores ratings as half stars out of 10
+		b.Rating = int(math.Round(float64(rating) / 2))
+		b.Notes = reviewText(comments)
+		if b.Series == "" {
+			b.SeriesIndex = 0
+		}
+		if t, ok := calibreDate(pubdate); ok && t.Year() > calibreUndefinedYear {
+			b.Year = t.Year()
+		}
+		if t, ok := calibreDate(added); ok {
+			b.CreatedAt = t.UTC()
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func calibreDate(v string) (time.Time, bool) {
+	for _, layout := range calibreDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,205 @@
+package importer
+
+import (
+	"book-tracker/internal/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvAliases lists the header names recognized for each book field,
+// compared case-insensitively.
+var csvAliases = map[string][]string{
+	"id":           {"id", "book id"},
+	"title":        {"title", "book title", "name"},
+	"author":       {"author", "authors", "writer"},
+	"isbn":         {"isbn", "isbn13", "isbn-13", "isbn10", "isbn-10"},
+	"progress":     {"progress", "percent", "progress (%)"},
+	"notes":        {"notes", "note", "comments"},
+	"finished":     {"finished", "read", "done"},
+	"rating":       {"rating", "stars", "my rating"},
+	"shelf":        {"shelf", "exclusive shelf"},
+	"tags":         {"tags", "labels"},
+	"publisher":    {"publisher"},
+	"year":         {"year", "year published"},
+	"pages":        {"pages", "number of pages"},
+	"language":     {"language"},
+	"series":       {"series"},
+	"series_index": {"series_index", "series index"},
+	"finished_at":  {"finished_at", "finished at"},
+	"source":       {"source"},
+	"source_id":    {"source_id", "source id"},
+}
+
+// ParseCSV reads books from CSV with a header row. Columns are matched to
+// book fields by csvAliases; mapping overrides that with explicit field to
+// header name pairs such as {"title": "Book Name"}. Unmapped columns are
+// ignored. Rows with unparseable values are returned with Errors set.
+func ParseCSV(r io.Reader, mapping map[string]string) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns, err := mapColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if isBlank(row) {
+			continue
+		}
+		rec := Record{Line: line}
+		for field, col := range columns {
+			if col >= len(row) {
+				continue
+			}
+			if err := setField(&rec.Book, field, strings.TrimSpace(row[col])); err != nil {
+				rec.Errors = append(rec.Errors, err.Error())
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// mapColumns resolves each book field to a column index.
+func mapColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		index[strings.ToLower(cleanHeader(h))] = i
+	}
+	columns := make(map[string]int)
+	for field, aliases := range csvAliases {
+		for _, a := range aliases {
+			if i, ok := index[a]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	for field, name := range mapping {
+		if _, ok := csvAliases[field]; !ok {
+			return nil, fmt.Errorf("Unknown field %q in header mapping", field)
+		}
+		i, ok := index[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("Mapped column %q not found in header", name)
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("CSV header has no title column")
+	}
+	if _, ok := columns["author"]; !ok {
+		return nil, errors.New("CSV header has no author column")
+	}
+	return columns, nil
+}
+
+func setField(book *models.Book, field, value string) error {
+	var err error
+	switch field {
+	case "id":
+		if value != "" {
+			book.ID, err = strconv.Atoi(value)
+		}
+	case "title":
+		book.Title = value
+	case "author":
+		book.Author = value
+	case "isbn":
+		book.ISBN = value
+	case "progress":
+		if value != "" {
+			book.Progress, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		}
+	case "notes":
+		book.Notes = value
+	case "finished":
+		book.Finished, err = parseBool(value)
+	case "rating":
+		if value != "" {
+			book.Rating, err = strconv.Atoi(value)
+		}
+	case "shelf":
+		book.Shelf = strings.ToLower(value)
+	case "tags":
+		book.Tags = models.NewTags(strings.Split(value, ",")...)
+	case "publisher":
+		book.Publisher = value
+	case "year":
+		if value != "" {
+			book.Year, err = strconv.Atoi(value)
+		}
+	case "pages":
+		if value != "" {
+			book.Pages, err = strconv.Atoi(value)
+		}
+	case "language":
+		book.Language = value
+	case "series":
+		book.Series = value
+	case "series_index":
+		if value != "" {
+			book.SeriesIndex, err = strconv.ParseFloat(value, 64)
+		}
+	case "finished_at":
+		if value != "" {
+			var at time.Time
+			if at, err = time.Parse(time.RFC3339, value); err == nil {
+				book.FinishedAt = &at
+			}
+		}
+	case "source":
+		book.Source = value
+	case "source_id":
+		book.SourceID = value
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid %s %q", field, value)
+	}
+	return nil
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "", "0", "false", "no", "n":
+		return false, nil
+	case "1", "true", "yes", "y":
+		return true, nil
+	}
+	return false, errors.New("invalid boolean")
+}
+
+func isBlank(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanHeader trims a header name and the byte order mark spreadsheet
+// programs put before the first one.
+func cleanHeader(h string) string {
+	return strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF"))
+}
@@ -0,0 +1,183 @@
+package importer
+
+import (
+	"book-tracker/internal/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportRow gives access to one row of a service export by column name.
+type exportRow struct {
+	columns map[string]int
+	values  []string
+}
+
+func (r exportRow) get(name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(r.values) {
+		return ""
+	}
+	return strings.TrimSpace(r.values[i])
+}
+
+func (r exportRow) intValue(rec *Record, name string) int {
+	v := r.get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		rec.Errors = append(rec.Errors, fmt.Sprintf("Invalid %s %q", name, v))
+	}
+	return n
+}
+
+// Date layouts used by Goodreads and StoryGraph exports.
+var exportDateLayouts = []string{"2006/01/02", "2006-01-02", "2006/01", "2006"}
+
+func parseExportDate(v string) (*time.Time, bool) {
+	for _, layout := range exportDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, true
+		}
+	}
+	return nil, false
+}
+
+func (r exportRow) date(rec *Record, name string) *time.Time {
+	v := r.get(name)
+	if v == "" {
+		return nil
+	}
+	t, ok := parseExportDate(v)
+	if !ok {
+		rec.Errors = append(rec.Errors, fmt.Sprintf("Invalid %s %q", name, v))
+	}
+	return t
+}
+
+// dateRanges reads a comma-separated list of dates or "start-end" ranges
+// and returns the date each ended on.
+func (r exportRow) dateRanges(rec *Record, name string) []*time.Time {
+	var dates []*time.Time
+	for _, entry := range strings.Split(r.get(name), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		t, ok := parseExportDate(entry)
+		if _, end, found := strings.Cut(entry, "-"); !ok && found {
+			t, ok = parseExportDate(strings.TrimSpace(end))
+		}
+		if !ok {
+			rec.Errors = append(rec.Errors, fmt.Sprintf("Invalid %s %q", name, entry))
+			continue
+		}
+		dates = append(dates, t)
+	}
+	return dates
+}
+
+// reads is the reading history of a row: a read finishing on each of
+// dates, preceded by undated reads for any more the Read Count column
+// counts, since exports only keep the dates of recent reads.
+func (r exportRow) reads(rec *Record, dates []*time.Time) []models.Read {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(*dates[j]) })
+	var reads []models.Read
+	for n := r.intValue(rec, "Read Count"); n > len(dates); n-- {
+		reads = append(reads, models.Read{})
+	}
+	for _, t := range dates {
+		reads = append(reads, models.Read{FinishedAt: t})
+	}
+	return reads
+}
+
+// parseExport reads a CSV export whose header must contain required, and
+// converts each non-blank row with convert.
+func parseExport(r io.Reader, required []string, convert func(row exportRow) Record) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, h := range header {
+		columns[cleanHeader(h)] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header has no %q column", name)
+		}
+	}
+
+	var records []Record
+	for {
+		values, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if isBlank(values) {
+			continue
+		}
+		rec := convert(exportRow{columns: columns, values: values})
+		rec.Line = line
+		records = append(records, rec)
+	}
+}
+
+// setShelf places a book on a shelf, marking books on the read shelf as
+// finished.
+func setShelf(b *models.Book, shelf string) {
+	b.Shelf = strings.ToLower(strings.TrimSpace(shelf))
+	if b.Shelf == models.ShelfRead {
+		b.Finished = true
+		b.Progress = 100
+	}
+}
+
+var breakTag = regexp.MustCompile(`(?i)<br\s*/?>`)
+var htmlTag = regexp.MustCompile(`<[^>]+>`)
+
+// reviewText turns review HTML into plain text.
+func reviewText(v string) string {
+	v = breakTag.ReplaceAllString(v, "\n")
+	v = htmlTag.ReplaceAllString(v, "")
+	return strings.TrimSpace(html.UnescapeString(v))
+}
+
+func joinNotes(notes ...string) string {
+	var parts []string
+	for _, n := range notes {
+		if n = strings.TrimSpace(n); n != "" {
+			parts = append(parts, n)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
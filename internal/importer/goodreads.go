@@ -0,0 +1,56 @@
+package importer
+
+import (
+	"book-tracker/internal/models"
+	"io"
+	"strings"
+	"time"
+)
+
+// ParseGoodreads reads a Goodreads library export. Books are keyed by
+// Goodreads book id, shelved on their exclusive shelf and tagged with their
+// other shelves. The review and private notes become the book's notes, and
+// the read date and count its reading history.
+func ParseGoodreads(r io.Reader) ([]Record, error) {
+	return parseExport(r, []string{"Book Id", "Title", "Author", "Exclusive Shelf"}, func(row exportRow) Record {
+		var rec Record
+		b := &rec.Book
+		b.Source = SourceGoodreads
+		b.SourceID = row.get("Book Id")
+		b.Title = row.get("Title")
+		b.Author = row.get("Author")
+		b.ISBN = firstNonEmpty(unquoteExcel(row.get("ISBN13")), unquoteExcel(row.get("ISBN")))
+		b.Publisher = row.get("Publisher")
+		b.Year = row.intValue(&rec, "Original Publication Year")
+		if b.Year == 0 {
+			b.Year = row.intValue(&rec, "Year Published")
+		}
+		b.Rating = row.intValue(&rec, "My Rating")
+		setShelf(b, row.get("Exclusive Shelf"))
+		var tags []string
+		for _, shelf := range strings.Split(row.get("Bookshelves"), ",") {
+			if strings.TrimSpace(shelf) != b.Shelf {
+				tags = append(tags, shelf)
+			}
+		}
+		b.Tags = models.NewTags(tags...)
+		b.Notes = joinNotes(reviewText(row.get("My Review")), row.get("Private Notes"))
+		b.FinishedAt = row.date(&rec, "Date Read")
+		var dates []*time.Time
+		if b.FinishedAt != nil {
+			dates = append(dates, b.FinishedAt)
+		}
+		rec.Reads = row.reads(&rec, dates)
+		if added := row.date(&rec, "Date Added"); added != nil {
+			b.CreatedAt = *added
+		}
+		return rec
+	})
+}
+
+// unquoteExcel strips the ="..." wrapper Goodreads puts around ISBNs to
+// stop spreadsheets from treating them as numbers.
+func unquoteExcel(v string) string {
+	v = strings.TrimPrefix(v, "=")
+	return strings.Trim(v, `"`)
+}
@@ -0,0 +1,284 @@
+package importer
+
+import (
+	"book-tracker/internal/dedup"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"reflect"
+)
+
+// Actions reported for an imported record.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionMerge  = "merge"
+	ActionSkip   = "skip"
+	ActionError  = "error"
+)
+
+// Sources recorded on imported books, used to find them on re-import.
+const (
+	SourceGoodreads  = "goodreads"
+	SourceStoryGraph = "storygraph"
+	SourceCalibre    = "calibre"
+)
+
+// Record is one book read from an import source. Line is the 1-based line
+// or entry number in the source, for error reporting. Reads is the book's
+// reading history, oldest first, for sources that have one.
+type Record struct {
+	Line   int
+	Book   models.Book
+	Reads  []models.Read
+	Errors []string
+}
+
+// Result reports what happened, or would happen in a dry run, to a record.
+type Result struct {
+	Line   int      `json:"line"`
+	Action string   `json:"action"`
+	ID     int      `json:"id,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+
+	// sameAs is the index of an earlier record creating the book this
+	// record updates, or -1.
+	sameAs int
+}
+
+// Options control how Import applies records.
+type Options struct {
+	// DryRun reports what would happen without writing anything.
+	DryRun bool
+	// Merge folds records into the books they match instead of replacing
+	// them, so progress and notes kept here survive the import. Records also
+	// match stored books with the same normalized title and author. Records
+	// that would not change their book are skipped.
+	Merge bool
+}
+
+// Report summarizes an import.
+type Report struct {
+	DryRun  bool     `json:"dry_run"`
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Merged  int      `json:"merged"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Results []Result `json:"results"`
+}
+
+// Plan validates records and decides whether each creates a new book or
+// updates an existing one. A record updates the book with its ID, else the
+// book imported from the same source entry, else the book with the same
+// ISBN; a record naming an unknown ID is an error.
+// A record repeating the source entry or ISBN of an earlier new record
+// updates the book that record creates.
+func Plan(records []Record, existing []models.Book) []Result {
+	byID := make(map[int]bool, len(existing))
+	byISBN := make(map[string]int)
+	bySource := make(map[sourceKey]int)
+	for _, b := range existing {
+		byID[b.ID] = true
+		if b.Source != "" {
+			bySource[sourceKey{b.Source, b.SourceID}] = b.ID
+		}
+		if isbn := dedup.NormalizeISBN(b.ISBN); isbn != "" {
+			byISBN[isbn] = b.ID
+		}
+	}
+	// createdBy maps the ISBN and source key of records planned for
+	// creation to their index
+	createdBy := make(map[any]int)
+
+	results := make([]Result, len(records))
+	for i, rec := range records {
+		res := Result{Line: rec.Line, Title: rec.Book.Title, Errors: rec.Errors, ID: rec.Book.ID, sameAs: -1}
+		if err := rec.Book.Validate(); err != nil {
+			res.Errors = append(res.Errors, err.Error())
+		}
+		isbn := dedup.NormalizeISBN(rec.Book.ISBN)
+		if res.ID != 0 && !byID[res.ID] {
+			res.Errors = append(res.Errors, repository.ErrNotFound.Error())
+		}
+		key := sourceKey{rec.Book.Source, rec.Book.SourceID}
+		if id, ok := bySource[key]; ok && res.ID == 0 && rec.Book.Source != "" {
+			res.ID = id
+		}
+		if res.ID == 0 && isbn != "" {
+			if id, ok := byISBN[isbn]; ok {
+				res.ID = id
+			}
+		}
+		if res.ID == 0 {
+			if j, ok := createdBy[key]; ok && rec.Book.Source != "" {
+				res.sameAs = j
+			} else if j, ok := createdBy[isbn]; ok && isbn != "" {
+				res.sameAs = j
+			}
+		}
+		switch {
+		case len(res.Errors) > 0:
+			res.Action, res.ID = ActionError, 0
+		case res.ID != 0 || res.sameAs >= 0:
+			res.Action = ActionUpdate
+		default:
+			res.Action = ActionCreate
+			if isbn != "" {
+				createdBy[isbn] = i
+			}
+			if rec.Book.Source != "" {
+				createdBy[key] = i
+			}
+		}
+		results[i] = res
+	}
+	return results
+}
+
+// Import plans records against the stored books and, unless opts.DryRun is
+// set, applies every valid record in a single unit of work and adds their
+// reads to the books' history. Invalid records are reported and skipped.
+func Import(ctx context.Context, store repository.StoreInterface, records []Record, opts Options) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun}
+	err := store.RunInTx(ctx, func(repos repository.Repos) error {
+		existing, err := repos.Books.GetBooks(ctx)
+		if err != nil {
+			return err
+		}
+		results := Plan(records, existing)
+		books := make([]models.Book, len(records))
+		for i, rec := range records {
+			books[i] = rec.Book
+		}
+		if opts.Merge {
+			planMerge(books, results, existing)
+		}
+		if !opts.DryRun {
+			if err := apply(ctx, repos.Books, records, books, results); err != nil {
+				return err
+			}
+		}
+		report.Results = results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range report.Results {
+		switch r.Action {
+		case ActionCreate:
+			report.Created++
+		case ActionUpdate:
+			report.Updated++
+		case ActionMerge:
+			report.Merged++
+		case ActionSkip:
+			report.Skipped++
+		case ActionError:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+type sourceKey struct {
+	source, id string
+}
+
+// planMerge turns the updates Plan chose into merges, folding each record
+// into the stored book it matches, or into the earlier record it repeats.
+// Records that match no book by identity but share a normalized title and
+// author with one are merged into it too. books holds the state to write
+// for each record and is updated in place.
+func planMerge(books []models.Book, results []Result, existing []models.Book) {
+	current := make(map[int]models.Book, len(existing))
+	byWork := make(map[string]int)
+	for _, b := range existing {
+		current[b.ID] = b
+		byWork[workKey(b)] = b.ID
+	}
+	for i := range results {
+		res := &results[i]
+		if res.Action == ActionCreate {
+			if id, ok := byWork[workKey(books[i])]; ok {
+				res.Action, res.ID = ActionUpdate, id
+			}
+		}
+		if res.Action != ActionUpdate {
+			continue
+		}
+		if res.sameAs >= 0 {
+			books[res.sameAs] = dedup.Merge(books[res.sameAs], []models.Book{books[i]})
+			res.Action = ActionMerge
+			continue
+		}
+		target := current[res.ID]
+		merged := dedup.Merge(target, []models.Book{books[i]})
+		if merged.Source == "" {
+			merged.Source, merged.SourceID = books[i].Source, books[i].SourceID
+		}
+		if reflect.DeepEqual(merged, dedup.Merge(target, nil)) {
+			res.Action = ActionSkip
+			continue
+		}
+		res.Action = ActionMerge
+		current[res.ID] = merged
+		books[i] = merged
+	}
+}
+
+func workKey(b models.Book) string {
+	return dedup.NormalizeTitle(b.Title) + "\x00" + dedup.NormalizeAuthor(b.Author)
+}
+
+func apply(ctx context.Context, repo repository.BookRepositoryInterface, records []Record, books []models.Book, results []Result) error {
+	var creates []*models.Book
+	var createIdx []int
+	for i := range books {
+		if results[i].Action != ActionCreate {
+			continue
+		}
+		book := books[i]
+		creates = append(creates, &book)
+		createIdx = append(createIdx, i)
+	}
+	if len(creates) > 0 {
+		if err := repo.CreateBooks(ctx, creates); err != nil {
+			return err
+		}
+	}
+	for j, book := range creates {
+		results[createIdx[j]].ID = book.ID
+	}
+
+	for i := range books {
+		if results[i].Action != ActionUpdate && results[i].Action != ActionMerge {
+			continue
+		}
+		if results[i].sameAs >= 0 {
+			results[i].ID = results[results[i].sameAs].ID
+			if results[i].Action == ActionMerge {
+				continue
+			}
+		}
+		book := books[i]
+		book.ID = results[i].ID
+		if err := repo.UpdateBook(ctx, &book); err != nil {
+			return err
+		}
+	}
+
+	// Reads are added even to skipped books, whose history may be newer
+	// than their last import
+	for i, rec := range records {
+		if len(rec.Reads) == 0 || results[i].ID == 0 {
+			continue
+		}
+		if err := repo.RecordReads(ctx, results[i].ID, rec.Reads); err != nil {
+			return err
+		}
+	}
+	return nil
+}
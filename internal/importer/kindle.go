@@ -0,0 +1,480 @@
+package importer
+
+import (
+	"book-tracker/internal/dedup"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceKindle is recorded on books created for unmatched clippings.
+const SourceKindle = "kindle"
+
+// Clipping is one entry of a Kindle "My Clippings.txt" file. Line is the
+// line of its title, for error reporting.
+type Clipping struct {
+	Line      int
+	Title     string
+	Author    string
+	Highlight models.Highlight
+	Errors    []string
+}
+
+const clippingSeparator = "=========="
+
+// Words identifying the kind of clipping in the header line, in the
+// languages Kindle writes. Bookmarks are checked first because some
+// languages share a stem between bookmark and highlight.
+var clippingKinds = []struct {
+	kind  string
+	words []string
+}{
+	{models.HighlightKindBookmark, []string{"bookmark", "lesezeichen", "signet", "marcador", "segnalibro", "bladwijzer"}},
+	{models.HighlightKindHighlight, []string{"highlight", "markierung", "surlignement", "subrayado", "evidenziazione", "destaque", "markering"}},
+	{models.HighlightKindNote, []string{"note", "notiz", "nota", "notitie"}},
+}
+
+var (
+	locationWords = []string{"location", "loc.", "position", "posición", "posizione", "posição", "emplacement", "locatie"}
+	pageWords     = []string{"page", "seite", "página", "pagina"}
+)
+
+var (
+	rangePattern = regexp.MustCompile(`^\s*([0-9]+(?:-[0-9]+)?)`)
+	pagePattern  = regexp.MustCompile(`(?i)^\s*([0-9]+(?:-[0-9]+)?|[ivxlcdm]+\b)`)
+	clockPattern = regexp.MustCompile(`(\d{1,2}):(\d{2})(?::(\d{2}))?`)
+)
+
+// Month names in the languages Kindle writes dates in.
+var monthNames = map[string]time.Month{
+	"january": 1, "february": 2, "march": 3, "april": 4, "may": 5, "june": 6,
+	"july": 7, "august": 8, "september": 9, "october": 10, "november": 11, "december": 12,
+	"januar": 1, "februar": 2, "märz": 3, "mai": 5, "juni": 6, "juli": 7, "oktober": 10, "dezember": 12,
+	"janvier": 1, "février": 2, "mars": 3, "avril": 4, "juin": 6, "juillet": 7, "août": 8,
+	"septembre": 9, "octobre": 10, "novembre": 11, "décembre": 12,
+	"enero": 1, "febrero": 2, "marzo": 3, "abril": 4, "mayo": 5, "junio": 6, "julio": 7,
+	"agosto": 8, "septiembre": 9, "setiembre": 9, "octubre": 10, "noviembre": 11, "diciembre": 12,
+	"gennaio": 1, "febbraio": 2, "aprile": 4, "maggio": 5, "giugno": 6, "luglio": 7,
+	"settembre": 9, "ottobre": 10, "dicembre": 12,
+	"janeiro": 1, "fevereiro": 2, "março": 3, "maio": 5, "junho": 6, "julho": 7,
+	"setembro": 9, "outubro": 10, "dezembro": 12,
+	"januari": 1, "februari": 2, "maart": 3, "mei": 5, "augustus": 8,
+}
+
+// ParseClippings reads a Kindle "My Clippings.txt" file. Header lines are
+// understood in English, German, French, Spanish, Italian, Portuguese and
+// Dutch. When a highlight was extended, Kindle keeps both versions; only
+// the longer one is returned.
+func ParseClippings(r io.Reader) ([]Clipping, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var clippings []Clipping
+	var entry []string
+	start, line := 1, 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(text) != clippingSeparator {
+			entry = append(entry, text)
+			continue
+		}
+		if c, ok := parseClipping(entry); ok {
+			c.Line = start
+			clippings = append(clippings, c)
+		}
+		entry, start = nil, line+1
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if c, ok := parseClipping(entry); ok {
+		c.Line = start
+		clippings = append(clippings, c)
+	}
+	if len(clippings) == 0 {
+		return nil, errors.New("No clippings found")
+	}
+	return dropSuperseded(clippings), nil
+}
+
+// parseClipping reads one entry: the title line, the header line, a blank
+// line and the clipped text. It reports false for blank entries.
+func parseClipping(lines []string) (Clipping, bool) {
+	for len(lines) > 0 && strings.TrimSpace(strings.ReplaceAll(lines[0], "\uFEFF", "")) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return Clipping{}, false
+	}
+	var c Clipping
+	c.Title, c.Author = splitTitleLine(strings.ReplaceAll(lines[0], "\uFEFF", ""))
+	if len(lines) < 2 {
+		c.Errors = append(c.Errors, "Clipping has no header line")
+		return c, true
+	}
+	parseClippingHeader(&c, lines[1])
+	h := &c.Highlight
+	h.Text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	if h.Kind == models.HighlightKindBookmark {
+		h.Text = ""
+	} else if h.Text == "" && h.Kind != "" {
+		c.Errors = append(c.Errors, "Clipping has no text")
+	}
+	h.Fingerprint = fingerprint(h)
+	return c, true
+}
+
+// splitTitleLine splits "Title (Author)" at the last parenthesized group.
+func splitTitleLine(s string) (title, author string) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, ")") {
+		if i := strings.LastIndex(s, "("); i > 0 {
+			return strings.TrimSpace(s[:i]), kindleAuthor(s[i+1 : len(s)-1])
+		}
+	}
+	return s, ""
+}
+
+// kindleAuthor rewrites Kindle's "Last, First" names, which separate
+// several authors with semicolons, as "First Last, First Last".
+func kindleAuthor(s string) string {
+	var names []string
+	for _, name := range strings.Split(s, ";") {
+		name = strings.TrimSpace(name)
+		if last, first, ok := strings.Cut(name, ","); ok && !strings.Contains(first, ",") {
+			name = strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseClippingHeader reads a line such as "- Your Highlight on page 12 |
+// Location 180-182 | Added on Sunday, March 5, 2023 10:15:32 PM".
+func parseClippingHeader(c *Clipping, header string) {
+	h := &c.Highlight
+	segments := strings.Split(strings.TrimLeft(strings.TrimSpace(header), "- "), "|")
+	first := strings.ToLower(segments[0])
+	for _, k := range clippingKinds {
+		if containsAny(first, k.words) {
+			h.Kind = k.kind
+			break
+		}
+	}
+	if h.Kind == "" {
+		c.Errors = append(c.Errors, fmt.Sprintf("Unrecognized clipping header %q", header))
+		return
+	}
+	for _, seg := range segments {
+		lower := strings.ToLower(seg)
+		if rest, ok := afterAny(lower, locationWords); ok {
+			if m := rangePattern.FindStringSubmatch(rest); m != nil {
+				h.Location = m[1]
+			}
+		} else if rest, ok := afterAny(lower, pageWords); ok {
+			if m := pagePattern.FindStringSubmatch(rest); m != nil {
+				h.Page = m[1]
+			}
+		} else if t, ok := parseClippingDate(lower); ok {
+			h.AddedAt = &t
+		}
+	}
+}
+
+// parseClippingDate reads the date from a header segment in any supported
+// language by picking out the year, month name, day and clock time. Kindle
+// does not record a time zone, so the time is taken as UTC.
+func parseClippingDate(s string) (time.Time, bool) {
+	clock := clockPattern.FindStringSubmatchIndex(s)
+	if clock == nil {
+		return time.Time{}, false
+	}
+	hour, _ := strconv.Atoi(s[clock[2]:clock[3]])
+	minute, _ := strconv.Atoi(s[clock[4]:clock[5]])
+	second := 0
+	if clock[6] >= 0 {
+		second, _ = strconv.Atoi(s[clock[6]:clock[7]])
+	}
+	rest := s[clock[1]:]
+	if strings.Contains(rest, "pm") && hour < 12 {
+		hour += 12
+	} else if strings.Contains(rest, "am") && hour == 12 {
+		hour = 0
+	}
+
+	var year, day int
+	var month time.Month
+	words := strings.FieldsFunc(s[:clock[0]], func(r rune) bool {
+		return r == ' ' || r == ',' || r == '.' || r == '/'
+	})
+	for _, w := range words {
+		if n, err := strconv.Atoi(w); err == nil {
+			if n > 31 {
+				year = n
+			} else if day == 0 {
+				day = n
+			}
+		} else if m, ok := monthNames[w]; ok {
+			month = m
+		}
+	}
+	if year == 0 || month == 0 || day == 0 {
+		return time.Time{}, false
+	}
+	return time.Date(year, month, day, hour, minute, second, 0, time.UTC), true
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// afterAny returns the text following the first of words found in s.
+func afterAny(s string, words []string) (string, bool) {
+	for _, w := range words {
+		if i := strings.Index(s, w); i >= 0 {
+			return s[i+len(w):], true
+		}
+	}
+	return "", false
+}
+
+// fingerprint identifies a clipping by its kind, position and text, so the
+// same clipping is recognized when a clippings file is imported again.
+func fingerprint(h *models.Highlight) string {
+	text := strings.Join(strings.Fields(h.Text), " ")
+	sum := sha1.Sum([]byte(h.Kind + "\x00" + h.Page + "\x00" + h.Location + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// dropSuperseded removes highlights that a later highlight of the same
+// book extended: one starting at the same location whose text contains
+// the earlier text.
+func dropSuperseded(clippings []Clipping) []Clipping {
+	var out []Clipping
+	last := make(map[string]int)
+	for _, c := range clippings {
+		h := c.Highlight
+		if h.Kind != models.HighlightKindHighlight || len(c.Errors) > 0 {
+			out = append(out, c)
+			continue
+		}
+		key := c.Title + "\x00" + c.Author + "\x00" + locationStart(h.Location)
+		if i, ok := last[key]; ok && h.Location != "" {
+			prev := out[i].Highlight.Text
+			if strings.Contains(h.Text, prev) {
+				out[i] = c
+				continue
+			}
+			if strings.Contains(prev, h.Text) {
+				continue
+			}
+		}
+		last[key] = len(out)
+		out = append(out, c)
+	}
+	return out
+}
+
+func locationStart(location string) string {
+	start, _, _ := strings.Cut(location, "-")
+	return start
+}
+
+// ActionMatch reports clippings attached to a book already stored.
+const ActionMatch = "match"
+
+// ClippingsReport summarizes a clippings import.
+type ClippingsReport struct {
+	DryRun       bool              `json:"dry_run"`
+	BooksMatched int               `json:"books_matched"`
+	BooksCreated int               `json:"books_created"`
+	Added        int               `json:"added"`
+	Duplicates   int               `json:"duplicates"`
+	Failed       int               `json:"failed"`
+	Books        []ClippingsResult `json:"books"`
+	Errors       []Result          `json:"errors,omitempty"`
+}
+
+// ClippingsResult reports what happened to the clippings of one book.
+type ClippingsResult struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	BookID     int    `json:"book_id,omitempty"`
+	Action     string `json:"action"`
+	Added      int    `json:"added"`
+	Duplicates int    `json:"duplicates"`
+}
+
+// ImportClippings attaches clippings to the books they belong to in a
+// single unit of work. Clippings match a book created by an earlier
+// clippings import, else a book with a similar title and author; a book is
+// created for clippings that match none. Clippings already stored with the
+// book are counted as duplicates and skipped. Unless dryRun is set, books
+// and highlights are written.
+func ImportClippings(ctx context.Context, store repository.StoreInterface, clippings []Clipping, dryRun bool) (*ClippingsReport, error) {
+	var report *ClippingsReport
+	err := store.RunInTx(ctx, func(repos repository.Repos) error {
+		report = &ClippingsReport{DryRun: dryRun}
+		existing, err := repos.Books.GetBooks(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Group clippings by book, in the order books first appear
+		var groups [][]Clipping
+		index := make(map[string]int)
+		for _, c := range clippings {
+			if len(c.Errors) > 0 {
+				report.Failed++
+				report.Errors = append(report.Errors, Result{Line: c.Line, Action: ActionError, Title: c.Title, Errors: c.Errors})
+				continue
+			}
+			key := clippingsKey(c)
+			i, ok := index[key]
+			if !ok {
+				i = len(groups)
+				index[key] = i
+				groups = append(groups, nil)
+			}
+			groups[i] = append(groups[i], c)
+		}
+
+		// Groups naming the same work differently, such as "Frank
+		// Herbert" and "Herbert, Frank", share a book: owner holds the
+		// index of the first group with each group's book
+		var creates []*models.Book
+		var createIdx []int
+		owner := make([]int, len(groups))
+		matchedBy := make(map[int]int)
+		report.Books = make([]ClippingsResult, len(groups))
+		for i, group := range groups {
+			book := clippingsBook(group[0])
+			res := ClippingsResult{Title: book.Title, Author: book.Author, Action: ActionCreate}
+			owner[i] = i
+			if match := matchClippingsBook(book, existing); match != nil {
+				res.BookID, res.Action = match.ID, ActionMatch
+				if j, ok := matchedBy[match.ID]; ok {
+					owner[i] = j
+				} else {
+					matchedBy[match.ID] = i
+					report.BooksMatched++
+				}
+			} else if j := matchCreated(book, creates); j >= 0 {
+				owner[i] = createIdx[j]
+			} else {
+				creates = append(creates, &book)
+				createIdx = append(createIdx, i)
+				report.BooksCreated++
+			}
+			report.Books[i] = res
+		}
+		if !dryRun && len(creates) > 0 {
+			if err := repos.Books.CreateBooks(ctx, creates); err != nil {
+				return err
+			}
+			for j, b := range creates {
+				report.Books[createIdx[j]].BookID = b.ID
+			}
+		}
+
+		// Clippings already stored with a book, or added to it from an
+		// earlier group, are duplicates
+		var added []*models.Highlight
+		seen := make(map[int]map[string]bool)
+		for i, group := range groups {
+			res := &report.Books[i]
+			res.BookID = report.Books[owner[i]].BookID
+			if seen[owner[i]] == nil {
+				seen[owner[i]] = make(map[string]bool)
+				if res.Action == ActionMatch {
+					stored, err := repos.Highlights.GetHighlights(ctx, res.BookID)
+					if err != nil {
+						return err
+					}
+					for _, h := range stored {
+						seen[owner[i]][h.Fingerprint] = true
+					}
+				}
+			}
+			for _, c := range group {
+				h := c.Highlight
+				if seen[owner[i]][h.Fingerprint] {
+					res.Duplicates++
+					continue
+				}
+				seen[owner[i]][h.Fingerprint] = true
+				h.BookID = res.BookID
+				added = append(added, &h)
+				res.Added++
+			}
+			report.Added += res.Added
+			report.Duplicates += res.Duplicates
+		}
+		if !dryRun && len(added) > 0 {
+			return repos.Highlights.CreateHighlights(ctx, added)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// clippingsKey identifies the book a clipping names. Kindles vary the case
+// of titles between devices, so it ignores case.
+func clippingsKey(c Clipping) string {
+	return strings.ToLower(c.Title + "\x00" + c.Author)
+}
+
+// clippingsBook is the book created for clippings that match no stored
+// book. Its source id is derived from the clippings' key so later imports
+// find it again.
+func clippingsBook(c Clipping) models.Book {
+	b := models.Book{Title: c.Title, Author: firstNonEmpty(c.Author, "Unknown"), Source: SourceKindle}
+	sum := sha1.Sum([]byte(clippingsKey(c)))
+	b.SourceID = hex.EncodeToString(sum[:8])
+	return b
+}
+
+// matchCreated returns the index of the book to be created that book is
+// the same work as, or -1.
+func matchCreated(book models.Book, creates []*models.Book) int {
+	for j, b := range creates {
+		if dedup.SameWork(book, *b) {
+			return j
+		}
+	}
+	return -1
+}
+
+func matchClippingsBook(book models.Book, existing []models.Book) *models.Book {
+	for i := range existing {
+		if existing[i].Source == book.Source && existing[i].SourceID == book.SourceID {
+			return &existing[i]
+		}
+	}
+	for i := range existing {
+		if dedup.SameWork(book, existing[i]) {
+			return &existing[i]
+		}
+	}
+	return nil
+}
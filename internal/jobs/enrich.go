@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/enrich"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// enrichBatchSize is how many books each query for books to enrich returns.
+const enrichBatchSize = 100
+
+// EnrichmentQueue lists books missing metadata that were not looked up
+// recently.
+type EnrichmentQueue interface {
+	ListBooksToEnrich(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error)
+}
+
+// BookEnricher looks a book up and fills its missing metadata, recording the
+// attempt.
+type BookEnricher interface {
+	Enrich(ctx context.Context, book models.Book) (*enrich.Result, error)
+}
+
+// RunEnrichment enriches books missing metadata every interval until ctx is
+// cancelled, skipping books looked up less than retryAfter ago. A round runs
+// immediately on start.
+func RunEnrichment(ctx context.Context, queue EnrichmentQueue, enricher BookEnricher, interval, retryAfter time.Duration) {
+	ctx = audit.WithActor(ctx, "enrichment")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := EnrichPending(ctx, queue, enricher, time.Now().Add(-retryAfter))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Enrichment failed: %v", err)
+		}
+		if n > 0 {
+			log.Printf("Enriched %d book(s)", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnrichPending looks up every book the queue lists, batch by batch, and
+// returns how many got new metadata. Each attempt is recorded, so a book is
+// listed once per round; the round stops at the first error rather than
+// listing the same books again.
+func EnrichPending(ctx context.Context, queue EnrichmentQueue, enricher BookEnricher, attemptedBefore time.Time) (int, error) {
+	enriched := 0
+	for {
+		books, err := queue.ListBooksToEnrich(ctx, attemptedBefore, enrichBatchSize)
+		if err != nil {
+			return enriched, err
+		}
+		for _, book := range books {
+			result, err := enricher.Enrich(ctx, book)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return enriched, err
+			}
+			if len(result.Fields) > 0 {
+				enriched++
+			}
+		}
+		if len(books) < enrichBatchSize {
+			return enriched, nil
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"log"
+	"time"
+)
+
+// TrashPurger permanently removes books that were deleted before a cutoff
+// and returns the covers they had.
+type TrashPurger interface {
+	PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, []models.Cover, error)
+}
+
+// CoverImageDeleter removes the stored images of covers.
+type CoverImageDeleter interface {
+	DeleteImages(ctx context.Context, covers []models.Cover)
+}
+
+// RunTrashPurge removes trashed books older than retention, and their cover
+// images, every interval until ctx is cancelled. A purge runs immediately
+// on start.
+func RunTrashPurge(ctx context.Context, purger TrashPurger, images CoverImageDeleter, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, covers, err := purger.PurgeTrash(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if n > 0 {
+			images.DeleteImages(ctx, covers)
+			log.Printf("Purged %d book(s) from the trash", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
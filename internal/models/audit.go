@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit actions recorded for book changes.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditMerge   = "merge"
+	AuditPurge   = "purge"
+	AuditRevert  = "revert"
+	// AuditRead records a finished read of a book, such as one from an
+	// import's reading history, dated when the read finished.
+	AuditRead = "read"
+)
+
+// AuditEntry is one recorded change to a book. Before and After hold the
+// full book state as JSON; Diff holds only the fields that changed. OwnerID
+// is the owner of the book, kept so its history stays theirs once the book
+// is purged. MergedFrom is set on entries copied from the history of a
+// book merged into this one, and names that book.
+type AuditEntry struct {
+	ID         int64           `json:"id" db:"id"`
+	BookID     int             `json:"book_id" db:"book_id"`
+	Version    int             `json:"version" db:"version"`
+	Action     string          `json:"action" db:"action"`
+	Actor      string          `json:"actor" db:"actor"`
+	RequestID  string          `json:"request_id" db:"request_id"`
+	Before     json.RawMessage `json:"before" db:"before"`
+	After      json.RawMessage `json:"after" db:"after"`
+	Diff       json.RawMessage `json:"diff" db:"diff"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	OwnerID    *int            `json:"owner_id,omitempty" db:"owner_id"`
+	MergedFrom *int            `json:"merged_from,omitempty" db:"merged_from"`
+}
+
+// Read is one finished read of a book. FinishedAt is nil when only the
+// number of reads is known, as for earlier rereads in Goodreads exports.
+type Read struct {
+	FinishedAt *time.Time
+}
+
+// AuditFilter narrows an audit log query. Zero values are ignored.
+type AuditFilter struct {
+	BookID int
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// RevertPoint selects an earlier book state: the given version, or else
+// the latest state recorded at or before At.
+type RevertPoint struct {
+	Version int
+	At      time.Time
+}
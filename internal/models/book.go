@@ -1,11 +1,51 @@
 package models
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidBook is returned by Validate for books missing required fields.
+var ErrInvalidBook = errors.New("Title, Author, and non-negative Progress are required")
+
+// Shelves a book can sit on.
+const (
+	ShelfToRead           = "to-read"
+	ShelfCurrentlyReading = "currently-reading"
+	ShelfRead             = "read"
+	ShelfDidNotFinish     = "did-not-finish"
+)
+
 type Book struct {
-	ID       int    `json:"id" db:"id"`
-	Title    string `json:"title" db:"title"`
-	Author   string `json:"author" db:"author"`
-	Progress int    `json:"progress" db:"progress"`
-	Notes    string `json:"notes" db:"notes"`
-	Finished bool   `json:"finished" db:"finished"`
-	Rating   int    `json:"rating" db:"rating"`
+	ID          int        `json:"id" db:"id"`
+	Title       string     `json:"title" db:"title"`
+	Author      string     `json:"author" db:"author"`
+	ISBN        string     `json:"isbn" db:"isbn"`
+	Publisher   string     `json:"publisher" db:"publisher"`
+	Year        int        `json:"year" db:"year"`
+	Pages       int        `json:"pages,omitempty" db:"pages"`
+	Progress    int        `json:"progress" db:"progress"`
+	Notes       string     `json:"notes" db:"notes"`
+	Finished    bool       `json:"finished" db:"finished"`
+	Rating      int        `json:"rating" db:"rating"`
+	Shelf       string     `json:"shelf" db:"shelf"`
+	Tags        Tags       `json:"tags" db:"tags"`
+	Language    string     `json:"language,omitempty" db:"language"`
+	Series      string     `json:"series,omitempty" db:"series"`
+	SeriesIndex float64    `json:"series_index,omitempty" db:"series_index"`
+	Source      string     `json:"source,omitempty" db:"source"`
+	SourceID    string     `json:"source_id,omitempty" db:"source_id"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	OwnerID     *int       `json:"owner_id,omitempty" db:"owner_id"`
+}
+
+// Validate checks the fields every stored book must have.
+func (b *Book) Validate() error {
+	if b.Title == "" || b.Author == "" || b.Progress < 0 {
+		return ErrInvalidBook
+	}
+	return nil
 }
@@ -0,0 +1,28 @@
+package models
+
+// Catalog orders.
+const (
+	CatalogOrderTitle    = "title"
+	CatalogOrderRecent   = "recent"
+	CatalogOrderFinished = "finished"
+)
+
+// CatalogFilter selects a page of live books for a catalog. Zero values are
+// ignored; Query matches title, author or ISBN.
+type CatalogFilter struct {
+	Finished bool
+	Author   string
+	Shelf    string
+	Tag      string
+	Query    string
+	Order    string
+	Limit    int
+	Offset   int
+}
+
+// Facet is a value books can be grouped by, such as an author or tag, with
+// the number of live books that have it.
+type Facet struct {
+	Value string `json:"value" db:"value"`
+	Count int    `json:"count" db:"count"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Cover describes a book's cover image. The image itself lives in blob
+// storage; Data holds it only while it is being moved around, such as in
+// backups.
+type Cover struct {
+	BookID      int       `json:"book_id" db:"book_id"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Width       int       `json:"width" db:"width"`
+	Height      int       `json:"height" db:"height"`
+	Size        int       `json:"size" db:"size"`
+	SHA256      string    `json:"sha256" db:"sha256"`
+	Data        []byte    `json:"-" db:"data"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Kinds of highlight.
+const (
+	HighlightKindHighlight = "highlight"
+	HighlightKindNote      = "note"
+	HighlightKindBookmark  = "bookmark"
+)
+
+// HighlightColors are the colors a highlight may be marked with. An empty
+// color means the reader's default.
+var HighlightColors = []string{"yellow", "blue", "pink", "orange", "green", "purple"}
+
+// ErrInvalidHighlight is returned by Validate for malformed highlights.
+var ErrInvalidHighlight = errors.New("Kind must be highlight, note or bookmark, text is required except for bookmarks, and color must be one of yellow, blue, pink, orange, green or purple")
+
+// Highlight is a passage marked in a book, a note on it, or a bookmark.
+// Page and Location are kept as text because e-readers report ranges such
+// as "180-182" and roman page numbers. Fingerprint identifies an imported
+// clipping so re-imports do not add it twice; it is empty for highlights
+// entered by hand.
+type Highlight struct {
+	ID          int        `json:"id" db:"id"`
+	BookID      int        `json:"book_id" db:"book_id"`
+	Kind        string     `json:"kind" db:"kind"`
+	Text        string     `json:"text" db:"text"`
+	Comment     string     `json:"comment" db:"comment"`
+	Color       string     `json:"color,omitempty" db:"color"`
+	Page        string     `json:"page,omitempty" db:"page"`
+	Location    string     `json:"location,omitempty" db:"location"`
+	AddedAt     *time.Time `json:"added_at,omitempty" db:"added_at"`
+	Fingerprint string     `json:"-" db:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Validate checks a highlight's kind, text and color. A missing kind
+// defaults to a highlight.
+func (h *Highlight) Validate() error {
+	if h.Kind == "" {
+		h.Kind = HighlightKindHighlight
+	}
+	switch h.Kind {
+	case HighlightKindHighlight, HighlightKindNote:
+		if h.Text == "" {
+			return ErrInvalidHighlight
+		}
+	case HighlightKindBookmark:
+	default:
+		return ErrInvalidHighlight
+	}
+	if h.Color == "" {
+		return nil
+	}
+	for _, c := range HighlightColors {
+		if h.Color == c {
+			return nil
+		}
+	}
+	return ErrInvalidHighlight
+}
+
+// HighlightMatch is a highlight found by a full-text search, with the book
+// it belongs to and the matching text marked in Snippet.
+type HighlightMatch struct {
+	Highlight
+	BookTitle  string  `json:"book_title" db:"book_title"`
+	BookAuthor string  `json:"book_author" db:"book_author"`
+	Snippet    string  `json:"snippet" db:"snippet"`
+	Rank       float64 `json:"rank" db:"rank"`
+}
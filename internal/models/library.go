@@ -0,0 +1,52 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Role is what a member may do in a library.
+type Role string
+
+// Roles, from most to least trusted. Owners manage a library's members and
+// do everything editors do; editors add, change and delete books, their
+// highlights and covers; viewers only read.
+const (
+	RoleOwner  Role = "owner"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+// ErrInvalidRole is returned by Validate for unknown roles.
+var ErrInvalidRole = errors.New("Role must be owner, editor or viewer")
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+func (r Role) Validate() error {
+	if roleRanks[r] == 0 {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// Allows reports whether the role may do what required may.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Library is a user's books, shared with the members they invite. A
+// library's ID is its user's ID, and its user is always an owner of it.
+type Library struct {
+	ID    int    `json:"id" db:"id"`
+	Owner string `json:"owner" db:"owner"`
+	Role  Role   `json:"role" db:"role"`
+}
+
+// Membership is a user's role in a library.
+type Membership struct {
+	LibraryID int       `json:"library_id" db:"library_id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	Role      Role      `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
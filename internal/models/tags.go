@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql/driver"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Tags is a set of lowercase labels stored as a Postgres text array.
+type Tags []string
+
+// NewTags trims, lowercases, de-duplicates and sorts tags, dropping blanks.
+func NewTags(tags ...string) Tags {
+	seen := make(map[string]bool)
+	out := Tags{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Value stores nil as an empty array so the column never holds NULL.
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return "{}", nil
+	}
+	return pq.StringArray(t).Value()
+}
+
+func (t *Tags) Scan(src any) error {
+	return (*pq.StringArray)(t).Scan(src)
+}
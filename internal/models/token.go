@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes an API token can be granted. Reads (GET and HEAD requests) need
+// books:read and everything else books:write; backups and restores need
+// admin as well, and only work for admins' tokens.
+const (
+	ScopeBooksRead  = "books:read"
+	ScopeBooksWrite = "books:write"
+	ScopeAdmin      = "admin"
+)
+
+// Scopes lists every scope in the order they are documented.
+var Scopes = []string{ScopeBooksRead, ScopeBooksWrite, ScopeAdmin}
+
+// ErrInvalidToken is returned by Validate for unusable token requests.
+var ErrInvalidToken = errors.New("Token needs a name of up to 100 characters, one or more of the scopes " + strings.Join(Scopes, ", ") + " and an expiry in the future")
+
+// APIToken is a personal token for scripts, sent as
+// "Authorization: Bearer <token>". Token is only known when the token is
+// created; the store keeps its hash and Prefix, which tells tokens apart
+// in listings.
+type APIToken struct {
+	ID         int            `json:"id" db:"id"`
+	UserID     int            `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	Token      string         `json:"token,omitempty" db:"-"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time     `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// APITokenRequest is what a user asks a new token for. Tokens without
+// ExpiresAt never expire.
+type APITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Validate trims the name, checks it, the scopes and the expiry, and
+// sorts the scopes into their documented order without repeats.
+func (t *APITokenRequest) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || len(t.Name) > 100 || len(t.Scopes) == 0 {
+		return ErrInvalidToken
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return ErrInvalidToken
+	}
+	for _, scope := range t.Scopes {
+		if !slices.Contains(Scopes, scope) {
+			return ErrInvalidToken
+		}
+	}
+	var scopes []string
+	for _, scope := range Scopes {
+		if slices.Contains(t.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	t.Scopes = scopes
+	return nil
+}
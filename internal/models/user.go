@@ -0,0 +1,57 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+// ErrInvalidCredentials is returned by Validate for unusable usernames and
+// passwords.
+var ErrInvalidCredentials = errors.New("Username must be 3 to 64 letters, digits, dots, dashes or underscores, and password 8 to 256 characters")
+
+// User is an account. Admin users may back up and restore the whole store;
+// the first account created is an admin. Accounts created by signing in
+// with an OpenID Connect provider have no password and are linked to the
+// provider's issuer and subject.
+type User struct {
+	ID           int       `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	Admin        bool      `json:"admin" db:"admin"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	OIDCIssuer   string    `json:"-" db:"oidc_issuer"`
+	OIDCSubject  string    `json:"-" db:"oidc_subject"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Credentials are what a user registers and signs in with.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+// ValidUsername reports whether a username has 3 to 64 letters, digits,
+// dots, dashes or underscores.
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// Validate checks a username's characters and length and a password's
+// length. Passwords are capped so hashing them stays cheap.
+func (c *Credentials) Validate() error {
+	if !ValidUsername(c.Username) || len(c.Password) < 8 || len(c.Password) > 256 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Session is a signed-in user's bearer token. Token is only known when the
+// session is created; the store keeps its hash.
+type Session struct {
+	Token     string    `json:"token"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
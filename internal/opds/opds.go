@@ -0,0 +1,329 @@
+// Package opds builds OPDS 1.2 catalog feeds, the Atom documents e-reader
+// apps browse and search libraries with.
+package opds
+
+import (
+	"book-tracker/internal/covers"
+	"book-tracker/internal/models"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Media types of catalog documents.
+const (
+	NavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	EntryType       = "application/atom+xml;type=entry;profile=opds-catalog"
+	OpenSearchType  = "application/opensearchdescription+xml"
+)
+
+// Link relations defined by OPDS.
+const (
+	RelSortNew    = "http://opds-spec.org/sort/new"
+	RelSubsection = "subsection"
+	RelImage      = "http://opds-spec.org/image"
+	RelThumbnail  = "http://opds-spec.org/image/thumbnail"
+)
+
+// ThumbnailSize is the cover size thumbnail links ask for.
+const ThumbnailSize = "medium"
+
+// PageSize is the number of books in one page of an acquisition feed.
+const PageSize = 50
+
+// Feed is an Atom feed. Navigation feeds list other feeds; acquisition
+// feeds list books.
+type Feed struct {
+	XMLName      xml.Name `xml:"feed"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	XmlnsDC      string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS    string   `xml:"xmlns:opds,attr"`
+	XmlnsSearch  string   `xml:"xmlns:opensearch,attr"`
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       *Author  `xml:"author,omitempty"`
+	Links        []Link   `xml:"link"`
+	TotalResults *int     `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage *int     `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   *int     `xml:"opensearch:startIndex,omitempty"`
+	Entries      []Entry  `xml:"entry"`
+}
+
+type Author struct {
+	Name string `xml:"name"`
+}
+
+type Link struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type Content struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Entry is a navigation entry pointing at another feed, or a book.
+type Entry struct {
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Updated    string     `xml:"updated"`
+	Authors    []Author   `xml:"author,omitempty"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Identifier string     `xml:"dc:identifier,omitempty"`
+	Categories []Category `xml:"category,omitempty"`
+	Content    *Content   `xml:"content,omitempty"`
+	Links      []Link     `xml:"link,omitempty"`
+}
+
+// EntryDocument is a complete catalog entry for one book, served on its
+// own.
+type EntryDocument struct {
+	XMLName xml.Name `xml:"entry"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	XmlnsDC string   `xml:"xmlns:dc,attr"`
+	Entry
+}
+
+// Catalog builds the feeds of one library. BaseURL is the absolute URL
+// feeds are served under, without a trailing slash, e.g.
+// "https://books.example.com/opds". APIURL is the absolute URL book covers
+// are served under, e.g. "https://books.example.com".
+type Catalog struct {
+	BaseURL string
+	APIURL  string
+	Title   string
+}
+
+func (c *Catalog) newFeed(id, title string, updated time.Time, links ...Link) *Feed {
+	return &Feed{
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsDC:     "http://purl.org/dc/terms/",
+		XmlnsOPDS:   "http://opds-spec.org/2010/catalog",
+		XmlnsSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		ID:          "urn:book-tracker:opds:" + id,
+		Title:       title,
+		Updated:     atomTime(updated),
+		Author:      &Author{Name: c.Title},
+		Links: append([]Link{
+			{Rel: "start", Href: c.BaseURL, Type: NavigationType},
+			{Rel: "search", Href: c.BaseURL + "/opensearch.xml", Type: OpenSearchType},
+		}, links...),
+	}
+}
+
+// Root is the start feed: recently added books, and books by author, shelf
+// and tag.
+func (c *Catalog) Root(updated time.Time) *Feed {
+	f := c.newFeed("root", c.Title, updated, Link{Rel: "self", Href: c.BaseURL, Type: NavigationType})
+	nav := []struct {
+		id, title, path, rel, typ, text string
+	}{
+		{"recent", "Recently added", "/recent", RelSortNew, AcquisitionType, "The newest books in the library"},
+		{"authors", "By author", "/authors", RelSubsection, NavigationType, "Browse books by author"},
+		{"shelves", "By shelf", "/shelves", RelSubsection, NavigationType, "Browse books by shelf"},
+		{"tags", "By tag", "/tags", RelSubsection, NavigationType, "Browse books by tag"},
+		{"all", "All books", "/books", RelSubsection, AcquisitionType, "Every book, by title"},
+	}
+	for _, n := range nav {
+		f.Entries = append(f.Entries, Entry{
+			Title:   n.title,
+			ID:      "urn:book-tracker:opds:" + n.id,
+			Updated: f.Updated,
+			Content: &Content{Type: "text", Text: n.text},
+			Links:   []Link{{Rel: n.rel, Href: c.BaseURL + n.path, Type: n.typ}},
+		})
+	}
+	return f
+}
+
+// Facets is a navigation feed with an entry per author, shelf or tag,
+// each linking to the acquisition feed of its books. param is the query
+// parameter of the books feed that selects the facet value.
+func (c *Catalog) Facets(id, title, path, param string, facets []models.Facet, updated time.Time) *Feed {
+	f := c.newFeed(id, title, updated, Link{Rel: "self", Href: c.BaseURL + path, Type: NavigationType},
+		Link{Rel: "up", Href: c.BaseURL, Type: NavigationType})
+	for _, facet := range facets {
+		books := "books"
+		if facet.Count == 1 {
+			books = "book"
+		}
+		f.Entries = append(f.Entries, Entry{
+			Title:   facet.Value,
+			ID:      "urn:book-tracker:opds:" + id + ":" + url.QueryEscape(facet.Value),
+			Updated: f.Updated,
+			Content: &Content{Type: "text", Text: fmt.Sprintf("%d %s", facet.Count, books)},
+			Links: []Link{{
+				Rel:  RelSubsection,
+				Href: c.BaseURL + "/books?" + url.Values{param: {facet.Value}}.Encode(),
+				Type: AcquisitionType,
+			}},
+		})
+	}
+	return f
+}
+
+// Page locates one page of an acquisition feed: the feed's path and query
+// without the page parameter, the 1-based page number and the total number
+// of books.
+type Page struct {
+	Path   string
+	Query  url.Values
+	Number int
+	Total  int
+}
+
+func (p Page) href(base string, number int) string {
+	q := url.Values{}
+	for k, v := range p.Query {
+		q[k] = v
+	}
+	if number > 1 {
+		q.Set("page", strconv.Itoa(number))
+	}
+	if len(q) == 0 {
+		return base + p.Path
+	}
+	return base + p.Path + "?" + q.Encode()
+}
+
+// Books is an acquisition feed of one page of books, with links to the
+// first, previous, next and last pages.
+func (c *Catalog) Books(id, title string, page Page, books []models.Book) *Feed {
+	updated := time.Time{}
+	for _, b := range books {
+		if b.UpdatedAt.After(updated) {
+			updated = b.UpdatedAt
+		}
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	f := c.newFeed(id, title, updated,
+		Link{Rel: "self", Href: page.href(c.BaseURL, page.Number), Type: AcquisitionType},
+		Link{Rel: "up", Href: c.BaseURL, Type: NavigationType})
+
+	last := (page.Total + PageSize - 1) / PageSize
+	if last < 1 {
+		last = 1
+	}
+	f.Links = append(f.Links, Link{Rel: "first", Href: page.href(c.BaseURL, 1), Type: AcquisitionType})
+	if page.Number > 1 {
+		f.Links = append(f.Links, Link{Rel: "previous", Href: page.href(c.BaseURL, page.Number-1), Type: AcquisitionType})
+	}
+	if page.Number < last {
+		f.Links = append(f.Links, Link{Rel: "next", Href: page.href(c.BaseURL, page.Number+1), Type: AcquisitionType})
+	}
+	f.Links = append(f.Links, Link{Rel: "last", Href: page.href(c.BaseURL, last), Type: AcquisitionType})
+
+	total, perPage, start := page.Total, PageSize, (page.Number-1)*PageSize+1
+	f.TotalResults, f.ItemsPerPage, f.StartIndex = &total, &perPage, &start
+	for _, b := range books {
+		f.Entries = append(f.Entries, c.bookEntry(b))
+	}
+	return f
+}
+
+// Book is the complete entry of one book.
+func (c *Catalog) Book(b models.Book) *EntryDocument {
+	return &EntryDocument{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		XmlnsDC: "http://purl.org/dc/terms/",
+		Entry:   c.bookEntry(b),
+	}
+}
+
+// bookEntry describes a book, linking to its complete entry and its cover.
+// Books without a cover answer the cover links with 404 Not Found.
+func (c *Catalog) bookEntry(b models.Book) Entry {
+	e := Entry{
+		Title:     b.Title,
+		ID:        fmt.Sprintf("urn:book-tracker:book:%d", b.ID),
+		Updated:   atomTime(b.UpdatedAt),
+		Authors:   []Author{{Name: b.Author}},
+		Publisher: b.Publisher,
+	}
+	if b.Year != 0 {
+		e.Issued = strconv.Itoa(b.Year)
+	}
+	if b.ISBN != "" {
+		e.Identifier = "urn:isbn:" + b.ISBN
+	}
+	for _, t := range b.Tags {
+		e.Categories = append(e.Categories, Category{Term: t, Label: t})
+	}
+	if b.Series != "" {
+		text := b.Series
+		if b.SeriesIndex != 0 {
+			text += " #" + strconv.FormatFloat(b.SeriesIndex, 'f', -1, 64)
+		}
+		e.Content = &Content{Type: "text", Text: text}
+	}
+	cover := fmt.Sprintf("%s/books/%d/cover", c.APIURL, b.ID)
+	e.Links = []Link{
+		{Rel: "alternate", Href: fmt.Sprintf("%s/books/%d", c.BaseURL, b.ID), Type: EntryType, Title: "Full entry"},
+		{Rel: RelImage, Href: cover},
+		{Rel: RelThumbnail, Href: cover + "/" + ThumbnailSize, Type: covers.ThumbnailType},
+	}
+	return e
+}
+
+// OpenSearchDescription tells clients how to search the catalog.
+type OpenSearchDescription struct {
+	XMLName        xml.Name `xml:"OpenSearchDescription"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	ShortName      string   `xml:"ShortName"`
+	Description    string   `xml:"Description"`
+	InputEncoding  string   `xml:"InputEncoding"`
+	OutputEncoding string   `xml:"OutputEncoding"`
+	URL            struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"Url"`
+}
+
+// OpenSearch describes the search feed, which matches titles, authors and
+// ISBNs.
+func (c *Catalog) OpenSearch() *OpenSearchDescription {
+	d := &OpenSearchDescription{
+		Xmlns:          "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:      c.Title,
+		Description:    "Search " + c.Title + " by title, author or ISBN",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+	}
+	d.URL.Type = AcquisitionType
+	d.URL.Template = c.BaseURL + "/search?q={searchTerms}"
+	return d
+}
+
+// Write writes a feed or OpenSearch description as an XML document.
+func Write(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditRepositoryInterface defines the methods for reading the audit log.
+type AuditRepositoryInterface interface {
+	GetBookHistory(ctx context.Context, bookID int) ([]models.AuditEntry, error)
+	ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+}
+
+type AuditRepository struct {
+	db DBTX
+}
+
+func NewAuditRepository(db *sqlx.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// Ensure AuditRepository implements AuditRepositoryInterface
+var _ AuditRepositoryInterface = &AuditRepository{}
+
+const auditColumns = `id, book_id, version, action, actor, request_id, before, after, diff, created_at, owner_id, merged_from`
+
+// GetBookHistory lists every recorded change to a book of the owner in
+// ctx, oldest first.
+func (r *AuditRepository) GetBookHistory(ctx context.Context, bookID int) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	query := `SELECT ` + auditColumns + ` FROM book_audit WHERE book_id = $1 AND ` + ownedBy(ctx, "owner_id") + ` ORDER BY version`
+	err := r.db.SelectContext(ctx, &entries, query, bookID)
+	return entries, err
+}
+
+// ListAudit lists audit entries of the owner in ctx matching filter, newest
+// first.
+func (r *AuditRepository) ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	conds := []string{ownedBy(ctx, "owner_id")}
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.BookID != 0 {
+		add("book_id = $%d", filter.BookID)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM book_audit WHERE ` + strings.Join(conds, " AND ")
+	query += ` ORDER BY created_at DESC, id DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+
+	var entries []models.AuditEntry
+	err := r.db.SelectContext(ctx, &entries, query, args...)
+	return entries, err
+}
+
+// recordAudit writes one audit entry for a book change using the caller's
+// transaction, so the entry commits or rolls back with the change itself.
+func recordAudit(ctx context.Context, tx *sqlx.Tx, action string, bookID int, before, after *models.Book) error {
+	beforeJSON, err := marshalState(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalState(after)
+	if err != nil {
+		return err
+	}
+	diff, err := json.Marshal(audit.Diff(before, after))
+	if err != nil {
+		return err
+	}
+	var owner *int
+	if after != nil {
+		owner = after.OwnerID
+	} else if before != nil {
+		owner = before.OwnerID
+	}
+	query := `
+		INSERT INTO book_audit (book_id, version, action, actor, request_id, before, after, diff, owner_id)
+		SELECT $1::integer, COALESCE(MAX(version), 0) + 1, $2::text, $3::text, $4::text,
+		       $5::jsonb, $6::jsonb, $7::jsonb, $8::integer
+		FROM book_audit WHERE book_id = $1::integer`
+	_, err = tx.ExecContext(ctx, query, bookID, action, audit.Actor(ctx), audit.RequestID(ctx),
+		beforeJSON, afterJSON, string(diff), owner)
+	return err
+}
+
+// recordRead adds a read of book to its history, dated when the read
+// finished or, when that is unknown, now. Reads hold no book state, so
+// reverts pass over them.
+func recordRead(ctx context.Context, tx *sqlx.Tx, book *models.Book, read models.Read) error {
+	diff, err := json.Marshal(map[string]audit.Change{"finished_at": {To: read.FinishedAt}})
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO book_audit (book_id, version, action, actor, request_id, before, after, diff, owner_id, created_at)
+		SELECT $1::integer, COALESCE(MAX(version), 0) + 1, $2::text, $3::text, $4::text,
+		       NULL, NULL, $5::jsonb, $6::integer, COALESCE($7::timestamptz, NOW())
+		FROM book_audit WHERE book_id = $1::integer`
+	_, err = tx.ExecContext(ctx, query, book.ID, models.AuditRead, audit.Actor(ctx), audit.RequestID(ctx),
+		string(diff), book.OwnerID, read.FinishedAt)
+	return err
+}
+
+// marshalState encodes a book state for a JSONB column; nil stays NULL.
+// The JSON is passed as a string because lib/pq sends []byte as bytea.
+func marshalState(book *models.Book) (any, error) {
+	if book == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// BackupRepositoryInterface reads and writes every stored row, including
+// trashed books, for backups and restores.
+type BackupRepositoryInterface interface {
+	ExportUsers(ctx context.Context, fn func(user models.User) error) error
+	ExportMembers(ctx context.Context, fn func(member models.Membership) error) error
+	ExportBooks(ctx context.Context, fn func(book models.Book) error) error
+	ExportHighlights(ctx context.Context, fn func(highlight models.Highlight) error) error
+	ExportAudit(ctx context.Context, fn func(entry models.AuditEntry) error) error
+	ExportCovers(ctx context.Context, fn func(cover models.Cover) error) error
+	CountBooks(ctx context.Context) (int, error)
+	DeleteAll(ctx context.Context) error
+	InsertUser(ctx context.Context, user *models.User) (collided bool, err error)
+	InsertMember(ctx context.Context, member *models.Membership) error
+	InsertBook(ctx context.Context, book *models.Book) error
+	InsertHighlight(ctx context.Context, highlight *models.Highlight) error
+	InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+	InsertCover(ctx context.Context, cover *models.Cover) error
+	ReserveBookID(ctx context.Context) (int, error)
+}
+
+type BackupRepository struct {
+	db DBTX
+}
+
+func NewBackupRepository(db *sqlx.DB) *BackupRepository {
+	return &BackupRepository{db: db}
+}
+
+// Ensure BackupRepository implements BackupRepositoryInterface
+var _ BackupRepositoryInterface = &BackupRepository{}
+
+// ExportUsers calls fn for every user, ordered by id.
+func (r *BackupRepository) ExportUsers(ctx context.Context, fn func(user models.User) error) error {
+	return exportRows(ctx, r.db, `SELECT `+userColumns+` FROM users ORDER BY id`, fn)
+}
+
+// ExportMembers calls fn for every library membership, ordered by library
+// and user id. Libraries' own users are not members.
+func (r *BackupRepository) ExportMembers(ctx context.Context, fn func(member models.Membership) error) error {
+	query := `
+		SELECT m.library_id, m.user_id, u.username, m.role, m.created_at
+		FROM library_members m JOIN users u ON u.id = m.user_id
+		ORDER BY m.library_id, m.user_id`
+	return exportRows(ctx, r.db, query, fn)
+}
+
+// ExportBooks calls fn for every book, trashed or not, ordered by id.
+func (r *BackupRepository) ExportBooks(ctx context.Context, fn func(book models.Book) error) error {
+	return exportRows(ctx, r.db, `SELECT `+bookColumns+` FROM books ORDER BY id`, fn)
+}
+
+// ExportHighlights calls fn for every highlight, ordered by id.
+func (r *BackupRepository) ExportHighlights(ctx context.Context, fn func(highlight models.Highlight) error) error {
+	return exportRows(ctx, r.db, `SELECT `+highlightColumns+` FROM highlights ORDER BY id`, fn)
+}
+
+// ExportAudit calls fn for every audit entry, ordered by id.
+func (r *BackupRepository) ExportAudit(ctx context.Context, fn func(entry models.AuditEntry) error) error {
+	return exportRows(ctx, r.db, `SELECT `+auditColumns+` FROM book_audit ORDER BY id`, fn)
+}
+
+// ExportCovers calls fn for every cover, ordered by book id. Data is only
+// set for covers whose image is still held in the database.
+func (r *BackupRepository) ExportCovers(ctx context.Context, fn func(cover models.Cover) error) error {
+	return exportRows(ctx, r.db, `SELECT book_id, content_type, width, height, size, sha256, data, updated_at FROM book_covers ORDER BY book_id`, fn)
+}
+
+func exportRows[T any](ctx context.Context, db DBTX, query string, fn func(T) error) error {
+	rows, err := db.QueryxContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v T
+		if err := rows.StructScan(&v); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountBooks counts every book, including those in the trash.
+func (r *BackupRepository) CountBooks(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM books`)
+	return n, err
+}
+
+// DeleteAll removes every book, highlight, cover and audit entry. Users
+// are kept.
+func (r *BackupRepository) DeleteAll(ctx context.Context) error {
+	for _, table := range []string{"book_audit", "book_covers", "highlights", "books"} {
+		if _, err := r.db.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertUser stores a user and sets its new ID. A user with the same OIDC
+// identity, or else the same name, is kept as it is, and its ID is set
+// instead. It reports a collision when the user of the same name has a
+// different password or identity, so is likely someone else.
+func (r *BackupRepository) InsertUser(ctx context.Context, user *models.User) (bool, error) {
+	if user.OIDCSubject != "" {
+		err := r.db.GetContext(ctx, &user.ID, `SELECT id FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2`,
+			user.OIDCIssuer, user.OIDCSubject)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+	}
+	var existing models.User
+	err := r.db.GetContext(ctx, &existing, `SELECT `+userColumns+` FROM users WHERE username = $1`, user.Username)
+	if err == nil {
+		user.ID = existing.ID
+		same := existing.PasswordHash == user.PasswordHash &&
+			existing.OIDCIssuer == user.OIDCIssuer && existing.OIDCSubject == user.OIDCSubject
+		return !same, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	query := `
+		INSERT INTO users (username, password_hash, oidc_issuer, oidc_subject, admin, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+	return false, r.db.GetContext(ctx, &user.ID, query, user.Username, user.PasswordHash, user.OIDCIssuer, user.OIDCSubject, user.Admin, user.CreatedAt)
+}
+
+// InsertMember stores a library membership. A user already a member keeps
+// their role.
+func (r *BackupRepository) InsertMember(ctx context.Context, member *models.Membership) error {
+	query := `
+		INSERT INTO library_members (library_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (library_id, user_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, member.LibraryID, member.UserID, member.Role, member.CreatedAt)
+	return err
+}
+
+// InsertBook stores a book exactly as given, timestamps and trash state
+// included, and sets its new ID. No audit entry is recorded.
+func (r *BackupRepository) InsertBook(ctx context.Context, book *models.Book) error {
+	columns := append(append([]string{}, insertColumns...), "deleted_at")
+	values := append(insertValues(book), book.DeletedAt)
+	query := `INSERT INTO books (` + strings.Join(columns, ", ") + `) VALUES (` + placeholders(len(values)) + `) RETURNING id`
+	return r.db.GetContext(ctx, &book.ID, query, values...)
+}
+
+// InsertHighlight stores a highlight exactly as given and sets its new ID.
+func (r *BackupRepository) InsertHighlight(ctx context.Context, highlight *models.Highlight) error {
+	values := highlightValues(highlight)
+	query := `INSERT INTO highlights (` + highlightInsertColumns + `) VALUES (` + placeholders(len(values)) + `) RETURNING id`
+	return r.db.GetContext(ctx, &highlight.ID, query, values...)
+}
+
+// InsertAuditEntry stores an audit entry exactly as given and sets its new ID.
+func (r *BackupRepository) InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	query := `
+		INSERT INTO book_audit (book_id, version, action, actor, request_id, before, after, diff, created_at, owner_id, merged_from)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7::jsonb, $8::jsonb, $9, $10, $11)
+		RETURNING id`
+	return r.db.GetContext(ctx, &entry.ID, query, entry.BookID, entry.Version, entry.Action, entry.Actor,
+		entry.RequestID, jsonParam(entry.Before), jsonParam(entry.After), jsonParam(entry.Diff), entry.CreatedAt, entry.OwnerID,
+		entry.MergedFrom)
+}
+
+// InsertCover stores a cover exactly as given, keeping its image in the
+// database until it is first read.
+func (r *BackupRepository) InsertCover(ctx context.Context, cover *models.Cover) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO book_covers (book_id, content_type, width, height, size, sha256, data, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		cover.BookID, cover.ContentType, cover.Width, cover.Height, cover.Size, cover.SHA256, cover.Data, cover.UpdatedAt)
+	return err
+}
+
+// ReserveBookID allocates a book id without creating a book, for audit
+// history of books that were purged.
+func (r *BackupRepository) ReserveBookID(ctx context.Context) (int, error) {
+	var id int
+	err := r.db.GetContext(ctx, &id, `SELECT nextval(pg_get_serial_sequence('books', 'id'))`)
+	return id, err
+}
+
+// jsonParam passes JSON to a JSONB parameter as a string, since lib/pq
+// sends []byte as bytea. Missing JSON and JSON null are stored as NULL.
+func jsonParam(raw json.RawMessage) any {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return string(raw)
+}
+
+func placeholders(n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return strings.Join(p, ", ")
+}
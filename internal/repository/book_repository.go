@@ -1,22 +1,52 @@
 package repository
 
 import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/dedup"
 	"book-tracker/internal/models"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is returned when a referenced book does not exist.
+	ErrNotFound = errors.New("book not found")
+	// ErrNoSuchVersion is returned when a revert point matches no recorded state.
+	ErrNoSuchVersion = errors.New("no recorded state at the requested version or time")
 )
 
 // BookRepositoryInterface defines the methods for book repository operations.
 type BookRepositoryInterface interface {
 	CreateBook(ctx context.Context, book *models.Book) error
+	CreateBooks(ctx context.Context, books []*models.Book) error
 	GetBooks(ctx context.Context) ([]models.Book, error)
+	GetBooksByIDs(ctx context.Context, ids []int) ([]models.Book, error)
+	StreamBooks(ctx context.Context, fn func(book models.Book) error) error
 	UpdateBook(ctx context.Context, book *models.Book) error
 	DeleteBook(ctx context.Context, id int) error
+	MergeBooks(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error)
+	GetTrash(ctx context.Context) ([]models.Book, error)
+	RestoreBook(ctx context.Context, id int) (*models.Book, error)
+	RevertBook(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error)
+	RecordReads(ctx context.Context, id int, reads []models.Read) error
 }
 
+// BookRepository stores books in Postgres. Every change is written to the
+// audit log in the same transaction as the change itself. A repository
+// obtained from Store.RunInTx joins the store's transaction instead of
+// starting its own. Queries for a library run as it, so Postgres' row-level
+// security keeps them to its books even if a condition is missed.
 type BookRepository struct {
 	db *sqlx.DB
+	tx *sqlx.Tx
 }
 
 func NewBookRepository(db *sqlx.DB) *BookRepository {
@@ -26,41 +56,479 @@ func NewBookRepository(db *sqlx.DB) *BookRepository {
 // Ensure BookRepository implements BookRepositoryInterface
 var _ BookRepositoryInterface = &BookRepository{}
 
-func (r *BookRepository) CreateBook(ctx context.Context, book *models.Book) error {
-	query := `
-		INSERT INTO books (title, author, progress, notes, finished, rating)
-		VALUES (:title, :author, :progress, :notes, :finished, :rating)
-		RETURNING id`
-	rows, err := r.db.NamedQueryContext(ctx, query, book)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-	if rows.Next() {
-		return rows.Scan(&book.ID)
+const bookColumns = `id, title, author, isbn, publisher, year, pages, progress, notes, finished, rating, shelf, tags,
+	language, series, series_index, source, source_id, finished_at, created_at, updated_at, deleted_at, owner_id`
+
+// insertColumns are the columns CreateBooks writes, in insertValues order.
+var insertColumns = []string{
+	"title", "author", "isbn", "publisher", "year", "pages", "progress", "notes", "finished", "rating", "shelf", "tags",
+	"language", "series", "series_index", "source", "source_id", "finished_at", "created_at", "updated_at", "owner_id",
+}
+
+func insertValues(b *models.Book) []any {
+	return []any{
+		b.Title, b.Author, b.ISBN, b.Publisher, b.Year, b.Pages, b.Progress, b.Notes, b.Finished, b.Rating, b.Shelf, b.Tags,
+		b.Language, b.Series, b.SeriesIndex, b.Source, b.SourceID, b.FinishedAt, b.CreatedAt, b.UpdatedAt, b.OwnerID,
 	}
-	return nil
+}
+
+// updateBookQuery replaces a book's editable fields. A book without a
+// source is claimed by the first import that matches it, so later imports
+// from that source find it by source id.
+const updateBookQuery = `
+	UPDATE books
+	SET title = :title, author = :author, isbn = :isbn, publisher = :publisher, year = :year, pages = :pages,
+	    progress = :progress, notes = :notes, finished = :finished, rating = :rating, shelf = :shelf,
+	    tags = :tags, language = :language, series = :series, series_index = :series_index, finished_at = :finished_at, updated_at = :updated_at,
+	    source = CASE WHEN source = '' THEN :source ELSE source END,
+	    source_id = CASE WHEN source = '' THEN :source_id ELSE source_id END
+	WHERE id = :id
+	RETURNING ` + bookColumns
+
+func (r *BookRepository) CreateBook(ctx context.Context, book *models.Book) error {
+	return r.CreateBooks(ctx, []*models.Book{book})
+}
+
+// createBatchSize caps the rows per multi-row INSERT, keeping the
+// statement well under Postgres' limit of 65535 parameters.
+const createBatchSize = 500
+
+// CreateBooks inserts many books using multi-row INSERTs and sets their IDs.
+// All books are created, or none are. CreatedAt defaults to now, and the
+// books belong to the owner in ctx, if any.
+func (r *BookRepository) CreateBooks(ctx context.Context, books []*models.Book) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now().UTC()
+		owner, owned := Owner(ctx)
+		for start := 0; start < len(books); start += createBatchSize {
+			chunk := books[start:min(start+createBatchSize, len(books))]
+			// Rows returned by a multi-row INSERT carry no promise of
+			// order, so ids are drawn first and inserted explicitly.
+			var ids []int
+			if err := tx.SelectContext(ctx, &ids, `SELECT nextval(pg_get_serial_sequence('books', 'id')) FROM generate_series(1, $1)`, len(chunk)); err != nil {
+				return err
+			}
+			columns := append([]string{"id"}, insertColumns...)
+			values := make([]string, len(chunk))
+			args := make([]any, 0, len(chunk)*len(columns))
+			for i, b := range chunk {
+				if b.CreatedAt.IsZero() {
+					b.CreatedAt = now
+				}
+				if owned {
+					b.OwnerID = &owner
+				}
+				b.UpdatedAt = now
+				if b.Tags == nil {
+					b.Tags = models.Tags{}
+				}
+				placeholders := make([]string, len(columns))
+				for j := range placeholders {
+					placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+				}
+				values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+				args = append(args, ids[i])
+				args = append(args, insertValues(b)...)
+			}
+			query := `INSERT INTO books (` + strings.Join(columns, ", ") + `) VALUES ` + strings.Join(values, ", ")
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+			for i, b := range chunk {
+				b.ID = ids[i]
+				if err := recordAudit(ctx, tx, models.AuditCreate, b.ID, nil, b); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 }
 
 func (r *BookRepository) GetBooks(ctx context.Context) ([]models.Book, error) {
 	var books []models.Book
-	query := `SELECT id, title, author, progress, notes, finished, rating FROM books`
-	err := r.db.SelectContext(ctx, &books, query)
+	query := `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id")
+	err := r.read(ctx, func(q DBTX) error {
+		return q.SelectContext(ctx, &books, query)
+	})
 	return books, err
 }
 
+// GetBooksByIDs returns the live books with the given ids, ordered by id.
+// It returns ErrNotFound unless every id names a live book.
+func (r *BookRepository) GetBooksByIDs(ctx context.Context, ids []int) ([]models.Book, error) {
+	var books []models.Book
+	query := `SELECT ` + bookColumns + ` FROM books WHERE id = ANY($1) AND deleted_at IS NULL AND ` +
+		ownedBy(ctx, "owner_id") + ` ORDER BY id`
+	err := r.read(ctx, func(q DBTX) error {
+		return q.SelectContext(ctx, &books, query, pq.Array(ids))
+	})
+	if err != nil {
+		return nil, err
+	}
+	unique := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(books) != len(unique) {
+		return nil, ErrNotFound
+	}
+	return books, nil
+}
+
+// StreamBooks calls fn for each live book, ordered by id, without loading
+// them all into memory. It stops at the first error fn returns.
+func (r *BookRepository) StreamBooks(ctx context.Context, fn func(book models.Book) error) error {
+	query := `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id") + ` ORDER BY id`
+	return r.read(ctx, func(q DBTX) error {
+		rows, err := q.QueryxContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var book models.Book
+			if err := rows.StructScan(&book); err != nil {
+				return err
+			}
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
 func (r *BookRepository) UpdateBook(ctx context.Context, book *models.Book) error {
-	query := `
-		UPDATE books
-		SET title = :title, author = :author, progress = :progress, notes = :notes,
-		    finished = :finished, rating = :rating
-		WHERE id = :id`
-	_, err := r.db.NamedExecContext(ctx, query, book)
-	return err
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := lockBook(ctx, tx, book.ID, false)
+		if err != nil {
+			return err
+		}
+		if err := updateBook(ctx, tx, book); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditUpdate, book.ID, before, book)
+	})
 }
 
+// DeleteBook moves a book to the trash. It stays restorable until the
+// retention purge removes it.
 func (r *BookRepository) DeleteBook(ctx context.Context, id int) error {
-	query := `DELETE FROM books WHERE id = $1`
-	_, err := r.db.ExecContext(ctx, query, id)
-	return err
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		var after models.Book
+		query := `UPDATE books SET deleted_at = NOW() WHERE id = $1 RETURNING ` + bookColumns
+		if err := tx.GetContext(ctx, &after, query, id); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditDelete, id, before, &after)
+	})
+}
+
+// GetTrash lists deleted books, most recently deleted first.
+func (r *BookRepository) GetTrash(ctx context.Context) ([]models.Book, error) {
+	var books []models.Book
+	query := `SELECT ` + bookColumns + ` FROM books WHERE deleted_at IS NOT NULL AND ` + ownedBy(ctx, "owner_id") +
+		` ORDER BY deleted_at DESC`
+	err := r.read(ctx, func(q DBTX) error {
+		return q.SelectContext(ctx, &books, query)
+	})
+	return books, err
+}
+
+// RestoreBook takes a book out of the trash.
+func (r *BookRepository) RestoreBook(ctx context.Context, id int) (*models.Book, error) {
+	var book models.Book
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := lockBook(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		query := `UPDATE books SET deleted_at = NULL WHERE id = $1 RETURNING ` + bookColumns
+		if err := tx.GetContext(ctx, &book, query, id); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditRestore, id, before, &book)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// PurgeTrash permanently removes books deleted before the given time. It
+// reports how many were removed and the covers they had, whose images the
+// caller should delete from blob storage.
+func (r *BookRepository) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, []models.Cover, error) {
+	var purged []models.Book
+	var covers []models.Cover
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Lock the books first so none is restored between reading its
+		// cover and deleting it
+		query := `
+			SELECT c.book_id, c.content_type, c.width, c.height, c.size, c.sha256, c.updated_at
+			FROM book_covers c JOIN books b ON b.id = c.book_id
+			WHERE b.deleted_at IS NOT NULL AND b.deleted_at < $1 AND ` + ownedBy(ctx, "b.owner_id") + `
+			FOR UPDATE OF b`
+		if err := tx.SelectContext(ctx, &covers, query, deletedBefore); err != nil {
+			return err
+		}
+		query = `DELETE FROM books WHERE deleted_at IS NOT NULL AND deleted_at < $1 AND ` + ownedBy(ctx, "owner_id") +
+			` RETURNING ` + bookColumns
+		if err := tx.SelectContext(ctx, &purged, query, deletedBefore); err != nil {
+			return err
+		}
+		for i := range purged {
+			if err := recordAudit(ctx, tx, models.AuditPurge, purged[i].ID, &purged[i], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(len(purged)), covers, nil
+}
+
+// MergeBooks folds the source books into the target inside a single
+// transaction, moves their highlights to it, and the first source's cover
+// if the target has none, copies their history into its history and moves
+// the sources to the trash. It returns the merged target.
+func (r *BookRepository) MergeBooks(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+	var merged models.Book
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		ids := append([]int{targetID}, sourceIDs...)
+		var books []models.Book
+		query := `SELECT ` + bookColumns + ` FROM books WHERE id = ANY($1) AND deleted_at IS NULL AND ` +
+			ownedBy(ctx, "owner_id") + ` ORDER BY id FOR UPDATE`
+		if err := tx.SelectContext(ctx, &books, query, pq.Array(ids)); err != nil {
+			return err
+		}
+		if len(books) != len(ids) {
+			return ErrNotFound
+		}
+
+		var target models.Book
+		var sources []models.Book
+		for _, b := range books {
+			if b.ID == targetID {
+				target = b
+			} else {
+				sources = append(sources, b)
+			}
+		}
+		merged = dedup.Merge(target, sources)
+		if err := updateBook(ctx, tx, &merged); err != nil {
+			return err
+		}
+		// Clippings imported into several of the books stay behind once,
+		// so the target keeps one highlight per fingerprint
+		query = `
+			UPDATE highlights h SET book_id = $1, updated_at = NOW()
+			WHERE h.book_id = ANY($2) AND (h.fingerprint = '' OR (
+				h.id = (SELECT MIN(d.id) FROM highlights d WHERE d.book_id = ANY($2) AND d.fingerprint = h.fingerprint)
+				AND NOT EXISTS (SELECT 1 FROM highlights t WHERE t.book_id = $1 AND t.fingerprint = h.fingerprint)))`
+		if _, err := tx.ExecContext(ctx, query, targetID, pq.Array(sourceIDs)); err != nil {
+			return err
+		}
+		// The target takes the first source's cover if it has none. Its
+		// images must already be stored under the target's blob keys
+		query = `
+			UPDATE book_covers SET book_id = $1
+			WHERE book_id = (SELECT c.book_id FROM book_covers c WHERE c.book_id = ANY($2::integer[])
+				ORDER BY array_position($2::integer[], c.book_id) LIMIT 1)
+			AND NOT EXISTS (SELECT 1 FROM book_covers t WHERE t.book_id = $1)`
+		if _, err := tx.ExecContext(ctx, query, targetID, pq.Array(sourceIDs)); err != nil {
+			return err
+		}
+		// The sources' history is copied into the target's, oldest first,
+		// marked with the book it came from. Reverts pass over it, since
+		// its states are the sources'
+		query = `
+			INSERT INTO book_audit (book_id, version, action, actor, request_id, before, after, diff, created_at, owner_id, merged_from)
+			SELECT $1, (SELECT COALESCE(MAX(version), 0) FROM book_audit WHERE book_id = $1) + ROW_NUMBER() OVER (ORDER BY a.created_at, a.id),
+			       a.action, a.actor, a.request_id, a.before, a.after, a.diff, a.created_at, a.owner_id, COALESCE(a.merged_from, a.book_id)
+			FROM book_audit a WHERE a.book_id = ANY($2)`
+		if _, err := tx.ExecContext(ctx, query, targetID, pq.Array(sourceIDs)); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, tx, models.AuditMerge, targetID, &target, &merged); err != nil {
+			return err
+		}
+		for i := range sources {
+			var after models.Book
+			query := `UPDATE books SET deleted_at = NOW() WHERE id = $1 RETURNING ` + bookColumns
+			if err := tx.GetContext(ctx, &after, query, sources[i].ID); err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, tx, models.AuditMerge, sources[i].ID, &sources[i], &after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// RevertBook restores a book's fields to the state recorded at an earlier
+// point in its history. The revert is itself recorded as a new version.
+func (r *BookRepository) RevertBook(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+	var book models.Book
+	err := r.inTx(ctx, func(tx *sqlx.Tx) error {
+		before, err := lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+
+		var state json.RawMessage
+		query := `SELECT after FROM book_audit WHERE book_id = $1 AND version <= $2 AND after IS NOT NULL AND merged_from IS NULL
+			ORDER BY version DESC LIMIT 1`
+		arg := any(to.Version)
+		if to.Version == 0 {
+			query = `SELECT after FROM book_audit WHERE book_id = $1 AND created_at <= $2 AND after IS NOT NULL AND merged_from IS NULL
+				ORDER BY version DESC LIMIT 1`
+			arg = to.At
+		}
+		err = tx.GetContext(ctx, &state, query, id, arg)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoSuchVersion
+		}
+		if err != nil {
+			return err
+		}
+		if book, err = audit.Reverted(before, state); err != nil {
+			return err
+		}
+		if err := updateBook(ctx, tx, &book); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditRevert, id, before, &book)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// RecordReads adds the reads of a live book its history does not hold yet.
+// Dated reads are matched by date and undated ones by count, so importing
+// the same reading history again records nothing new.
+func (r *BookRepository) RecordReads(ctx context.Context, id int, reads []models.Read) error {
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		book, err := lockBook(ctx, tx, id, false)
+		if err != nil {
+			return err
+		}
+		var recorded []json.RawMessage
+		query := `SELECT diff FROM book_audit WHERE book_id = $1 AND action = $2`
+		if err := tx.SelectContext(ctx, &recorded, query, id, models.AuditRead); err != nil {
+			return err
+		}
+		dated := make(map[time.Time]bool)
+		undated := 0
+		for _, diff := range recorded {
+			var changes map[string]struct {
+				To *time.Time `json:"to"`
+			}
+			if err := json.Unmarshal(diff, &changes); err != nil {
+				return err
+			}
+			if to := changes["finished_at"].To; to != nil {
+				dated[to.UTC()] = true
+			} else {
+				undated++
+			}
+		}
+		for _, read := range reads {
+			if read.FinishedAt != nil {
+				if dated[read.FinishedAt.UTC()] {
+					continue
+				}
+				dated[read.FinishedAt.UTC()] = true
+			} else if undated > 0 {
+				undated--
+				continue
+			}
+			if err := recordRead(ctx, tx, book, read); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// read runs fn with the handle reads should use: the joined transaction,
+// if any, a transaction as the library in ctx, or else the database.
+func (r *BookRepository) read(ctx context.Context, fn func(q DBTX) error) error {
+	if _, ok := Owner(ctx); !ok && r.tx == nil {
+		return fn(r.db)
+	}
+	return r.inTx(ctx, func(tx *sqlx.Tx) error {
+		return fn(tx)
+	})
+}
+
+// inTx runs fn in a transaction, committing if it returns nil. Inside a
+// joined transaction fn runs directly and the caller owns the commit.
+// Either way fn runs as the library in ctx, if any.
+func (r *BookRepository) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	if r.tx != nil {
+		return asLibrary(ctx, r.tx, fn)
+	}
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := asLibrary(ctx, tx, fn); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateBook writes a book's editable fields and reloads it, so book
+// reflects the stored row afterwards.
+func updateBook(ctx context.Context, tx *sqlx.Tx, book *models.Book) error {
+	book.UpdatedAt = time.Now().UTC()
+	if book.Tags == nil {
+		book.Tags = models.Tags{}
+	}
+	rows, err := sqlx.NamedQueryContext(ctx, tx, updateBookQuery, book)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrNotFound
+	}
+	return rows.StructScan(book)
+}
+
+// lockBook loads a book for update, returning ErrNotFound unless it exists,
+// belongs to the owner in ctx and is in the trash (trashed) or out of it
+// (!trashed). Changes to a book go through lockBook first, which keeps them
+// to the owner's books.
+func lockBook(ctx context.Context, tx *sqlx.Tx, id int, trashed bool) (*models.Book, error) {
+	var book models.Book
+	query := `SELECT ` + bookColumns + ` FROM books WHERE id = $1 AND (deleted_at IS NOT NULL) = $2 AND ` +
+		ownedBy(ctx, "owner_id") + ` FOR UPDATE`
+	err := tx.GetContext(ctx, &book, query, id, trashed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
 }
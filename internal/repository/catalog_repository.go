@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Facet fields books can be grouped by.
+const (
+	FacetAuthor = "author"
+	FacetShelf  = "shelf"
+	FacetTag    = "tag"
+)
+
+// facetValues are the SQL expressions yielding each facet's values.
+var facetValues = map[string]string{
+	FacetAuthor: `author`,
+	FacetShelf:  `shelf`,
+	FacetTag:    `unnest(tags)`,
+}
+
+// CatalogRepositoryInterface browses live books for catalogs like OPDS.
+type CatalogRepositoryInterface interface {
+	ListBooks(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error)
+	Facets(ctx context.Context, field string) ([]models.Facet, error)
+}
+
+type CatalogRepository struct {
+	db DBTX
+}
+
+func NewCatalogRepository(db *sqlx.DB) *CatalogRepository {
+	return &CatalogRepository{db: db}
+}
+
+// Ensure CatalogRepository implements CatalogRepositoryInterface
+var _ CatalogRepositoryInterface = &CatalogRepository{}
+
+// ListBooks returns a page of the live books matching filter, ordered by
+// title, newest first or most recently finished first, and how many match
+// in total.
+func (r *CatalogRepository) ListBooks(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error) {
+	conds := []string{"deleted_at IS NULL", ownedBy(ctx, "owner_id")}
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "$%d", fmt.Sprintf("$%d", len(args))))
+	}
+	if filter.Finished {
+		conds = append(conds, "finished")
+	}
+	if filter.Author != "" {
+		add("author = $%d", filter.Author)
+	}
+	if filter.Shelf != "" {
+		add("shelf = $%d", filter.Shelf)
+	}
+	if filter.Tag != "" {
+		add("$%d = ANY(tags)", filter.Tag)
+	}
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		add(`(title ILIKE $%d OR author ILIKE $%d OR isbn ILIKE $%d)`, "%"+escapeLike(q)+"%")
+	}
+	where := ` WHERE ` + strings.Join(conds, " AND ")
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM books`+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM books` + where
+	switch filter.Order {
+	case models.CatalogOrderRecent:
+		query += ` ORDER BY created_at DESC, id DESC`
+	case models.CatalogOrderFinished:
+		query += ` ORDER BY finished_at DESC NULLS LAST, updated_at DESC, id DESC`
+	default:
+		query += ` ORDER BY lower(title), id`
+	}
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+	var books []models.Book
+	err := r.db.SelectContext(ctx, &books, query, args...)
+	return books, total, err
+}
+
+// Facets counts live books per author, shelf or tag, ordered by value.
+// Books without a value are not counted.
+func (r *CatalogRepository) Facets(ctx context.Context, field string) ([]models.Facet, error) {
+	expr, ok := facetValues[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown facet %q", field)
+	}
+	query := `
+		SELECT value, COUNT(*) AS count
+		FROM (SELECT ` + expr + ` AS value FROM books WHERE deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id") + `) v
+		WHERE value <> ''
+		GROUP BY value
+		ORDER BY lower(value), value`
+	var facets []models.Facet
+	err := r.db.SelectContext(ctx, &facets, query)
+	return facets, err
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrCoverNotFound is returned when a book has no cover.
+var ErrCoverNotFound = errors.New("cover not found")
+
+type CoverRepositoryInterface interface {
+	SetCover(ctx context.Context, cover *models.Cover) error
+	GetCover(ctx context.Context, bookID int) (*models.Cover, error)
+	DeleteCover(ctx context.Context, bookID int) error
+	ListHeldCovers(ctx context.Context) ([]int, error)
+}
+
+type CoverRepository struct {
+	db DBTX
+}
+
+func NewCoverRepository(db *sqlx.DB) *CoverRepository {
+	return &CoverRepository{db: db}
+}
+
+// Ensure CoverRepository implements CoverRepositoryInterface
+var _ CoverRepositoryInterface = &CoverRepository{}
+
+// SetCover stores or replaces a live book's cover description and sets its
+// UpdatedAt. The image is expected in blob storage, so any image held in
+// the row is cleared.
+func (r *CoverRepository) SetCover(ctx context.Context, cover *models.Cover) error {
+	query := `
+		INSERT INTO book_covers (book_id, content_type, width, height, size, sha256, data, updated_at)
+		SELECT id, $2, $3, $4, $5, $6, NULL, NOW() FROM books
+		WHERE id = $1::integer AND deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id") + `
+		ON CONFLICT (book_id) DO UPDATE
+		SET content_type = EXCLUDED.content_type, width = EXCLUDED.width, height = EXCLUDED.height,
+			size = EXCLUDED.size, sha256 = EXCLUDED.sha256, data = NULL, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`
+	err := r.db.GetContext(ctx, &cover.UpdatedAt, query,
+		cover.BookID, cover.ContentType, cover.Width, cover.Height, cover.Size, cover.SHA256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetCover returns a live book's cover. Data is only set for covers whose
+// image is still held in the database.
+func (r *CoverRepository) GetCover(ctx context.Context, bookID int) (*models.Cover, error) {
+	var cover models.Cover
+	query := `
+		SELECT c.book_id, c.content_type, c.width, c.height, c.size, c.sha256, c.data, c.updated_at
+		FROM book_covers c JOIN books b ON b.id = c.book_id
+		WHERE c.book_id = $1 AND b.deleted_at IS NULL AND ` + ownedBy(ctx, "b.owner_id")
+	err := r.db.GetContext(ctx, &cover, query, bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCoverNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cover, nil
+}
+
+// ListHeldCovers returns the ids of live books whose cover image is still
+// held in the database, as restored from a backup, ordered by id.
+func (r *CoverRepository) ListHeldCovers(ctx context.Context) ([]int, error) {
+	ids := []int{}
+	query := `
+		SELECT c.book_id FROM book_covers c JOIN books b ON b.id = c.book_id
+		WHERE c.data IS NOT NULL AND b.deleted_at IS NULL AND ` + ownedBy(ctx, "b.owner_id") + `
+		ORDER BY c.book_id`
+	err := r.db.SelectContext(ctx, &ids, query)
+	return ids, err
+}
+
+// DeleteCover removes a live book's cover.
+func (r *CoverRepository) DeleteCover(ctx context.Context, bookID int) error {
+	query := `DELETE FROM book_covers WHERE book_id = $1 AND ` + onLiveBook(ctx)
+	result, err := r.db.ExecContext(ctx, query, bookID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCoverNotFound
+	}
+	return nil
+}
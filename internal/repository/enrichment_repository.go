@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type EnrichmentRepositoryInterface interface {
+	ListBooksToEnrich(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error)
+	RecordAttempt(ctx context.Context, bookID int, provider string, fields []string, errMsg string) error
+}
+
+type EnrichmentRepository struct {
+	db DBTX
+}
+
+func NewEnrichmentRepository(db *sqlx.DB) *EnrichmentRepository {
+	return &EnrichmentRepository{db: db}
+}
+
+// Ensure EnrichmentRepository implements EnrichmentRepositoryInterface
+var _ EnrichmentRepositoryInterface = &EnrichmentRepository{}
+
+// ListBooksToEnrich returns live books missing an ISBN, publisher, year or
+// page count that were never enriched, or last tried before attemptedBefore,
+// ordered by id. It matches enrich.NeedsEnrichment.
+func (r *EnrichmentRepository) ListBooksToEnrich(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error) {
+	books := []models.Book{}
+	query := `
+		SELECT ` + bookColumns + ` FROM books
+		WHERE deleted_at IS NULL AND (isbn = '' OR publisher = '' OR year = 0 OR pages = 0) AND ` + ownedBy(ctx, "owner_id") + `
+		AND NOT EXISTS (
+			SELECT 1 FROM book_enrichment e WHERE e.book_id = books.id AND e.attempted_at >= $1
+		)
+		ORDER BY id LIMIT $2`
+	err := r.db.SelectContext(ctx, &books, query, attemptedBefore, limit)
+	return books, err
+}
+
+// RecordAttempt notes that a book was looked up, which fields it got and
+// the lookup error, if any, replacing the previous attempt.
+func (r *EnrichmentRepository) RecordAttempt(ctx context.Context, bookID int, provider string, fields []string, errMsg string) error {
+	if fields == nil {
+		fields = []string{}
+	}
+	query := `
+		INSERT INTO book_enrichment (book_id, provider, fields, error, attempted_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (book_id) DO UPDATE
+		SET provider = EXCLUDED.provider, fields = EXCLUDED.fields, error = EXCLUDED.error, attempted_at = EXCLUDED.attempted_at`
+	_, err := r.db.ExecContext(ctx, query, bookID, provider, pq.Array(fields), errMsg)
+	return err
+}
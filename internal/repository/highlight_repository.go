@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrHighlightNotFound is returned when a referenced highlight does not
+// exist on the given book.
+var ErrHighlightNotFound = errors.New("highlight not found")
+
+// HighlightRepositoryInterface defines the methods for highlight repository
+// operations.
+type HighlightRepositoryInterface interface {
+	CreateHighlight(ctx context.Context, highlight *models.Highlight) error
+	CreateHighlights(ctx context.Context, highlights []*models.Highlight) error
+	GetHighlights(ctx context.Context, bookID int) ([]models.Highlight, error)
+	GetAllHighlights(ctx context.Context) ([]models.Highlight, error)
+	GetHighlight(ctx context.Context, bookID, id int) (*models.Highlight, error)
+	UpdateHighlight(ctx context.Context, highlight *models.Highlight) error
+	DeleteHighlight(ctx context.Context, bookID, id int) error
+	SearchHighlights(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error)
+}
+
+type HighlightRepository struct {
+	db DBTX
+}
+
+func NewHighlightRepository(db *sqlx.DB) *HighlightRepository {
+	return &HighlightRepository{db: db}
+}
+
+// Ensure HighlightRepository implements HighlightRepositoryInterface
+var _ HighlightRepositoryInterface = &HighlightRepository{}
+
+const highlightColumns = `id, book_id, kind, text, comment, color, page, location, added_at, fingerprint, created_at, updated_at`
+
+// highlightInsertColumns are the columns CreateHighlights writes, in
+// highlightValues order.
+const highlightInsertColumns = `book_id, kind, text, comment, color, page, location, added_at, fingerprint,
+	created_at, updated_at`
+
+func highlightValues(h *models.Highlight) []any {
+	return []any{h.BookID, h.Kind, h.Text, h.Comment, h.Color, h.Page, h.Location, h.AddedAt, h.Fingerprint,
+		h.CreatedAt, h.UpdatedAt}
+}
+
+// onLiveBook restricts a highlights query to books not in the trash that
+// belong to the owner in ctx.
+func onLiveBook(ctx context.Context) string {
+	return `book_id IN (SELECT id FROM books WHERE deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id") + `)`
+}
+
+// CreateHighlight adds a highlight to a book, returning ErrNotFound if the
+// book does not exist, is in the trash or belongs to another owner.
+func (r *HighlightRepository) CreateHighlight(ctx context.Context, highlight *models.Highlight) error {
+	now := time.Now().UTC()
+	highlight.CreatedAt, highlight.UpdatedAt = now, now
+	query := `INSERT INTO highlights (` + highlightInsertColumns + `)
+		SELECT $1::integer, $2::text, $3::text, $4::text, $5::text, $6::text, $7::text, $8::timestamptz,
+		       $9::text, $10::timestamptz, $11::timestamptz
+		FROM books WHERE id = $1::integer AND deleted_at IS NULL AND ` + ownedBy(ctx, "owner_id") + `
+		RETURNING ` + highlightColumns
+	err := r.db.GetContext(ctx, highlight, query, highlightValues(highlight)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// CreateHighlights inserts highlights using multi-row INSERTs and sets their
+// IDs. CreatedAt defaults to now.
+func (r *HighlightRepository) CreateHighlights(ctx context.Context, highlights []*models.Highlight) error {
+	now := time.Now().UTC()
+	for start := 0; start < len(highlights); start += createBatchSize {
+		chunk := highlights[start:min(start+createBatchSize, len(highlights))]
+		values := make([]string, len(chunk))
+		var args []any
+		for i, h := range chunk {
+			if h.CreatedAt.IsZero() {
+				h.CreatedAt = now
+			}
+			h.UpdatedAt = now
+			row := highlightValues(h)
+			placeholders := make([]string, len(row))
+			for j := range placeholders {
+				placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+			}
+			values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+			args = append(args, row...)
+		}
+		query := `INSERT INTO highlights (` + highlightInsertColumns + `) VALUES ` +
+			strings.Join(values, ", ") + ` RETURNING id`
+		var ids []int
+		if err := r.db.SelectContext(ctx, &ids, query, args...); err != nil {
+			return err
+		}
+		if len(ids) != len(chunk) {
+			return fmt.Errorf("inserted %d of %d highlights", len(ids), len(chunk))
+		}
+		sort.Ints(ids)
+		for i, h := range chunk {
+			h.ID = ids[i]
+		}
+	}
+	return nil
+}
+
+// GetHighlights lists a book's highlights, oldest first.
+func (r *HighlightRepository) GetHighlights(ctx context.Context, bookID int) ([]models.Highlight, error) {
+	var highlights []models.Highlight
+	query := `SELECT ` + highlightColumns + ` FROM highlights WHERE book_id = $1 AND ` + onLiveBook(ctx) + `
+		ORDER BY COALESCE(added_at, created_at), id`
+	err := r.db.SelectContext(ctx, &highlights, query, bookID)
+	return highlights, err
+}
+
+// GetAllHighlights lists the highlights of every live book, grouped by book
+// id and oldest first within a book.
+func (r *HighlightRepository) GetAllHighlights(ctx context.Context) ([]models.Highlight, error) {
+	var highlights []models.Highlight
+	query := `SELECT ` + highlightColumns + ` FROM highlights WHERE ` + onLiveBook(ctx) + `
+		ORDER BY book_id, COALESCE(added_at, created_at), id`
+	err := r.db.SelectContext(ctx, &highlights, query)
+	return highlights, err
+}
+
+func (r *HighlightRepository) GetHighlight(ctx context.Context, bookID, id int) (*models.Highlight, error) {
+	var highlight models.Highlight
+	query := `SELECT ` + highlightColumns + ` FROM highlights WHERE id = $1 AND book_id = $2 AND ` + onLiveBook(ctx)
+	err := r.db.GetContext(ctx, &highlight, query, id, bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrHighlightNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &highlight, nil
+}
+
+// UpdateHighlight replaces a highlight's editable fields.
+func (r *HighlightRepository) UpdateHighlight(ctx context.Context, highlight *models.Highlight) error {
+	query := `UPDATE highlights
+		SET kind = :kind, text = :text, comment = :comment, color = :color, page = :page,
+		    location = :location, updated_at = NOW()
+		WHERE id = :id AND book_id = :book_id AND ` + onLiveBook(ctx) + `
+		RETURNING ` + highlightColumns
+	rows, err := sqlx.NamedQueryContext(ctx, r.db, query, highlight)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrHighlightNotFound
+	}
+	return rows.StructScan(highlight)
+}
+
+func (r *HighlightRepository) DeleteHighlight(ctx context.Context, bookID, id int) error {
+	query := `DELETE FROM highlights WHERE id = $1 AND book_id = $2 AND ` + onLiveBook(ctx)
+	result, err := r.db.ExecContext(ctx, query, id, bookID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrHighlightNotFound
+	}
+	return nil
+}
+
+// SearchHighlights finds highlights whose text or comment matches query,
+// written in web search syntax ("quoted phrases", -excluded, or). Results
+// are ordered by relevance.
+func (r *HighlightRepository) SearchHighlights(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error) {
+	columns := "h." + strings.ReplaceAll(highlightColumns, ", ", ", h.")
+	q := `SELECT ` + columns + `, b.title AS book_title, b.author AS book_author,
+		    ts_headline('english',
+		        CASE WHEN to_tsvector('english', h.text) @@ q THEN h.text ELSE h.comment END,
+		        q, 'StartSel=**, StopSel=**') AS snippet,
+		    ts_rank(h.search, q) AS rank
+		FROM highlights h
+		JOIN books b ON b.id = h.book_id, websearch_to_tsquery('english', $1) q
+		WHERE h.search @@ q AND b.deleted_at IS NULL AND ` + ownedBy(ctx, "b.owner_id") + `
+		ORDER BY rank DESC, h.id
+		LIMIT $2 OFFSET $3`
+	var matches []models.HighlightMatch
+	err := r.db.SelectContext(ctx, &matches, q, query, limit, offset)
+	return matches, err
+}
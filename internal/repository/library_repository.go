@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrLibraryNotFound is returned for libraries the user is not a member
+	// of, whether or not they exist.
+	ErrLibraryNotFound = errors.New("library not found")
+	// ErrMemberNotFound is returned when removing a user who is not a
+	// member.
+	ErrMemberNotFound = errors.New("member not found")
+)
+
+type LibraryRepositoryInterface interface {
+	GetRole(ctx context.Context, libraryID, userID int) (models.Role, error)
+	ListLibraries(ctx context.Context, userID int) ([]models.Library, error)
+	ListMembers(ctx context.Context, libraryID int) ([]models.Membership, error)
+	SetMember(ctx context.Context, libraryID, userID int, role models.Role) error
+	RemoveMember(ctx context.Context, libraryID, userID int) error
+}
+
+type LibraryRepository struct {
+	db DBTX
+}
+
+func NewLibraryRepository(db *sqlx.DB) *LibraryRepository {
+	return &LibraryRepository{db: db}
+}
+
+// Ensure LibraryRepository implements LibraryRepositoryInterface
+var _ LibraryRepositoryInterface = &LibraryRepository{}
+
+// GetRole returns a member's role in another user's library.
+func (r *LibraryRepository) GetRole(ctx context.Context, libraryID, userID int) (models.Role, error) {
+	var role models.Role
+	err := r.db.GetContext(ctx, &role, `SELECT role FROM library_members WHERE library_id = $1 AND user_id = $2`, libraryID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrLibraryNotFound
+	}
+	return role, err
+}
+
+// ListLibraries returns the user's own library, then the libraries they
+// are a member of by their users' names.
+func (r *LibraryRepository) ListLibraries(ctx context.Context, userID int) ([]models.Library, error) {
+	libraries := []models.Library{}
+	query := `
+		SELECT * FROM (
+			SELECT id, username AS owner, 'owner' AS role FROM users WHERE id = $1
+			UNION ALL
+			SELECT u.id, u.username, m.role
+			FROM library_members m JOIN users u ON u.id = m.library_id
+			WHERE m.user_id = $1
+		) l ORDER BY id <> $1, owner`
+	if err := r.db.SelectContext(ctx, &libraries, query, userID); err != nil {
+		return nil, err
+	}
+	return libraries, nil
+}
+
+// ListMembers returns a library's user, then its members by name.
+func (r *LibraryRepository) ListMembers(ctx context.Context, libraryID int) ([]models.Membership, error) {
+	members := []models.Membership{}
+	query := `
+		SELECT * FROM (
+			SELECT id AS library_id, id AS user_id, username, 'owner' AS role, created_at FROM users WHERE id = $1
+			UNION ALL
+			SELECT m.library_id, m.user_id, u.username, m.role, m.created_at
+			FROM library_members m JOIN users u ON u.id = m.user_id
+			WHERE m.library_id = $1
+		) m ORDER BY user_id <> $1, username`
+	if err := r.db.SelectContext(ctx, &members, query, libraryID); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetMember adds a user to a library, or changes their role if they are
+// already a member.
+func (r *LibraryRepository) SetMember(ctx context.Context, libraryID, userID int, role models.Role) error {
+	query := `
+		INSERT INTO library_members (library_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (library_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+	_, err := r.db.ExecContext(ctx, query, libraryID, userID, role)
+	return err
+}
+
+func (r *LibraryRepository) RemoveMember(ctx context.Context, libraryID, userID int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM library_members WHERE library_id = $1 AND user_id = $2`, libraryID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
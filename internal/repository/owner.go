@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ownerKey struct{}
+
+// WithOwner returns a context whose queries only see and change the books
+// of the given user, and whose new books belong to them. Without an owner,
+// as in background jobs and the command line, queries see every book.
+func WithOwner(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, ownerKey{}, userID)
+}
+
+// WithoutOwner returns ctx with queries no longer restricted to a user, for
+// work on every library such as finishing a restore.
+func WithoutOwner(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ownerKey{}, nil)
+}
+
+// Owner returns the id of the user queries in ctx are restricted to.
+func Owner(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(ownerKey{}).(int)
+	return id, ok
+}
+
+// ownedBy is a condition restricting column, a books.owner_id reference,
+// to the owner in ctx. The id is an integer written into the query rather
+// than passed as an argument, so the condition fits any query's parameters.
+func ownedBy(ctx context.Context, column string) string {
+	if id, ok := Owner(ctx); ok {
+		return fmt.Sprintf("%s = %d", column, id)
+	}
+	return "TRUE"
+}
+
+// tenantRole is the Postgres role whose queries row-level security limits
+// to the books of the library in the app.library_id setting.
+const tenantRole = "book_tracker_tenant"
+
+// asLibrary runs fn in tx as the library of the owner in ctx, if any: as
+// tenantRole with app.library_id set to it, so Postgres only lets fn see
+// and change that library's books. The role is reset when fn returns, so
+// other queries in a joined transaction are not restricted.
+func asLibrary(ctx context.Context, tx *sqlx.Tx, fn func(tx *sqlx.Tx) error) error {
+	id, ok := Owner(ctx)
+	if !ok {
+		return fn(tx)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.library_id', $1, true)`, strconv.Itoa(id)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL ROLE `+tenantRole); err != nil {
+		return err
+	}
+	err := fn(tx)
+	// A failed statement aborts tx, which then rolls back and cannot reset
+	if _, resetErr := tx.ExecContext(ctx, `RESET ROLE`); err == nil {
+		err = resetErr
+	}
+	return err
+}
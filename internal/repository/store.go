@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DBTX is the query interface shared by *sqlx.DB and *sqlx.Tx, so
+// repositories can run either standalone or inside a transaction.
+type DBTX interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// Repos is the set of repositories bound to one unit of work.
+type Repos struct {
+	Books      BookRepositoryInterface
+	Audit      AuditRepositoryInterface
+	Highlights HighlightRepositoryInterface
+	Backup     BackupRepositoryInterface
+	Enrichment EnrichmentRepositoryInterface
+	Users      UserRepositoryInterface
+}
+
+// StoreInterface runs multi-step operations atomically. Backends other than
+// Postgres implement it to hand out repositories bound to their own
+// transactions.
+type StoreInterface interface {
+	RunInTx(ctx context.Context, fn func(repos Repos) error) error
+}
+
+// maxTxRetries bounds how often RunInTx retries a transaction that lost a
+// serialization conflict.
+const maxTxRetries = 5
+
+// Store is the Postgres StoreInterface.
+type Store struct {
+	db *sqlx.DB
+}
+
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{db: db}
+}
+
+// Ensure Store implements StoreInterface
+var _ StoreInterface = &Store{}
+
+// RunInTx runs fn in a serializable transaction with repositories that all
+// share it. The transaction commits if fn returns nil and rolls back
+// otherwise. Serialization failures and deadlocks are retried with backoff,
+// so fn may run more than once and must not have effects outside the
+// database.
+func (s *Store) RunInTx(ctx context.Context, fn func(repos Repos) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = s.runOnce(ctx, fn)
+		if err == nil || !IsSerializationFailure(err) || attempt == maxTxRetries {
+			return err
+		}
+		backoff := time.Duration(10<<attempt)*time.Millisecond + time.Duration(rand.IntN(10))*time.Millisecond
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (s *Store) runOnce(ctx context.Context, fn func(repos Repos) error) error {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	repos := Repos{
+		Books:      &BookRepository{db: s.db, tx: tx},
+		Audit:      &AuditRepository{db: tx},
+		Highlights: &HighlightRepository{db: tx},
+		Backup:     &BackupRepository{db: tx},
+		Enrichment: &EnrichmentRepository{db: tx},
+		Users:      &UserRepository{db: tx},
+	}
+	if err := fn(repos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsSerializationFailure reports whether err is a Postgres serialization
+// failure or deadlock, both of which succeed when the transaction is retried.
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"book-tracker/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUserNotFound is returned when no user has the given name.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUsernameTaken is returned when registering a name already in use.
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrSessionNotFound is returned for unknown and expired sessions.
+	ErrSessionNotFound = errors.New("session not found or expired")
+	// ErrTokenNotFound is returned for unknown and expired API tokens.
+	ErrTokenNotFound = errors.New("API token not found or expired")
+)
+
+type UserRepositoryInterface interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUser(ctx context.Context, id int) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	CreateSession(ctx context.Context, tokenHash string, session *models.Session) error
+	GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	CreateAPIToken(ctx context.Context, tokenHash string, token *models.APIToken) error
+	ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error)
+	UseAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, userID, id int) error
+}
+
+type UserRepository struct {
+	db DBTX
+}
+
+func NewUserRepository(db *sqlx.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// Ensure UserRepository implements UserRepositoryInterface
+var _ UserRepositoryInterface = &UserRepository{}
+
+const userColumns = `id, username, admin, password_hash, oidc_issuer, oidc_subject, created_at`
+
+// CreateUser adds a user and sets its ID, Admin and CreatedAt. Returns
+// ErrUsernameTaken if the name, or the OIDC identity, is in use. The first
+// user becomes an admin and takes ownership of the books, and their
+// history, created before there were accounts. Run it in a serializable
+// transaction so two first users cannot both become admins.
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	query := `
+		INSERT INTO users (username, password_hash, oidc_issuer, oidc_subject, admin)
+		SELECT $1, $2, $3, $4, NOT EXISTS (SELECT 1 FROM users)
+		RETURNING ` + userColumns
+	err := r.db.GetContext(ctx, user, query, user.Username, user.PasswordHash, user.OIDCIssuer, user.OIDCSubject)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrUsernameTaken
+	}
+	if err != nil || !user.Admin {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, `UPDATE books SET owner_id = $1 WHERE owner_id IS NULL`, user.ID); err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `UPDATE book_audit SET owner_id = $1 WHERE owner_id IS NULL`, user.ID)
+	return err
+}
+
+func (r *UserRepository) GetUser(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+	err := r.db.GetContext(ctx, &user, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByIdentity returns the user linked to an OpenID Connect
+// provider's subject.
+func (r *UserRepository) GetUserByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	var user models.User
+	query := `SELECT ` + userColumns + ` FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2 AND oidc_subject <> ''`
+	err := r.db.GetContext(ctx, &user, query, issuer, subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := r.db.GetContext(ctx, &user, `SELECT `+userColumns+` FROM users WHERE username = $1`, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.GetContext(ctx, &n, `SELECT COUNT(*) FROM users`)
+	return n, err
+}
+
+// CreateSession stores a session under the hash of its token and removes
+// the user's expired sessions.
+func (r *UserRepository) CreateSession(ctx context.Context, tokenHash string, session *models.Session) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND expires_at <= NOW()`, session.UserID)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)
+		RETURNING created_at`
+	return r.db.GetContext(ctx, &session.CreatedAt, query, tokenHash, session.UserID, session.ExpiresAt)
+}
+
+// GetSessionUser returns the user signed in with the session whose token
+// has the given hash, unless the session expired.
+func (r *UserRepository) GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	var user models.User
+	query := `
+		SELECT u.id, u.username, u.admin, u.password_hash, u.oidc_issuer, u.oidc_subject, u.created_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.expires_at > NOW()`
+	err := r.db.GetContext(ctx, &user, query, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteSession signs a session out. Unknown sessions are ignored.
+func (r *UserRepository) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at`
+
+// CreateAPIToken stores a token under the hash of its secret and sets its
+// ID and CreatedAt. The secret itself is left in token.Token.
+func (r *UserRepository) CreateAPIToken(ctx context.Context, tokenHash string, token *models.APIToken) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiTokenColumns
+	return r.db.GetContext(ctx, token, query, token.UserID, token.Name, tokenHash, token.Prefix, token.Scopes, token.ExpiresAt)
+}
+
+// ListAPITokens returns a user's tokens, expired ones included, newest
+// first.
+func (r *UserRepository) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	if err := r.db.SelectContext(ctx, &tokens, query, userID); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// UseAPIToken returns the token whose secret has the given hash, unless it
+// expired, and records that it was used.
+func (r *UserRepository) UseAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	query := `
+		UPDATE api_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING ` + apiTokenColumns
+	err := r.db.GetContext(ctx, &token, query, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteAPIToken revokes one of a user's tokens.
+func (r *UserRepository) DeleteAPIToken(ctx context.Context, userID, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
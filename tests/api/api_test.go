@@ -1,27 +1,66 @@
 package api
 
 import (
+	"book-tracker/internal/blob"
 	"book-tracker/internal/db"
 	"book-tracker/internal/handlers"
 	"book-tracker/internal/models"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func setupTestServer(t *testing.T) *mux.Router {
+func setupTestServer(t *testing.T) http.Handler {
 	db, err := db.NewDB()
 	if err != nil {
 		t.Fatalf("Failed to connect to database: %v", err)
 	}
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open blob storage: %v", err)
+	}
 	router := mux.NewRouter()
-	handlers.RegisterBookHandlers(router, db)
-	return router
+	handlers.RegisterBookHandlers(router, db, handlers.Config{Blobs: blobs})
+	return signedIn(router, newTestUser(t, router))
+}
+
+// newTestUser registers a user with a unique name and returns their
+// session token.
+func newTestUser(t *testing.T, router http.Handler) string {
+	creds := models.Credentials{
+		Username: fmt.Sprintf("api-test-%d", time.Now().UnixNano()),
+		Password: "correct horse battery staple",
+	}
+	body, _ := json.Marshal(creds)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/register", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to register: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body)))
+	var session models.Session
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil || session.Token == "" {
+		t.Fatalf("Failed to sign in: %d", w.Code)
+	}
+	return session.Token
+}
+
+// signedIn sends requests without credentials with the given session token.
+func signedIn(router http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, r)
+	})
 }
 
 func TestAPICreateAndGet(t *testing.T) {
@@ -147,3 +186,49 @@ func TestAPIDeleteBook(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIAuthentication(t *testing.T) {
+	db, err := db.NewDB()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	router := mux.NewRouter()
+	handlers.RegisterBookHandlers(router, db, handlers.Config{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without credentials, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	alice := signedIn(router, newTestUser(t, router))
+	bob := signedIn(router, newTestUser(t, router))
+	body, _ := json.Marshal(models.Book{Title: "Private Book", Author: "API Author"})
+	w = httptest.NewRecorder()
+	alice.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body)))
+	var book models.Book
+	if err := json.NewDecoder(w.Body).Decode(&book); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Other users neither see nor change the book
+	w = httptest.NewRecorder()
+	bob.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books", nil))
+	var books []models.Book
+	json.NewDecoder(w.Body).Decode(&books)
+	for _, b := range books {
+		if b.ID == book.ID {
+			t.Error("Expected another user's book to be hidden")
+		}
+	}
+	w = httptest.NewRecorder()
+	bob.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/books/"+strconv.Itoa(book.ID), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d deleting another user's book, got %d", http.StatusNotFound, w.Code)
+	}
+	w = httptest.NewRecorder()
+	bob.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a backup by a non-admin, got %d", http.StatusForbidden, w.Code)
+	}
+}
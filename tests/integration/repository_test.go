@@ -1,11 +1,24 @@
 package integration
 
 import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/backup"
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
 	"book-tracker/internal/db"
+	"book-tracker/internal/enrich"
+	"book-tracker/internal/importer"
 	"book-tracker/internal/models"
 	"book-tracker/internal/repository"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -112,3 +125,1226 @@ func TestDeleteBook(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeBooks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	target := models.Book{Title: "Merge Target", Author: "Test Author", Progress: 10, Notes: "target notes"}
+	source := models.Book{Title: "Merge Target", Author: "Test Author", Progress: 60, Notes: "source notes"}
+	for _, b := range []*models.Book{&target, &source} {
+		if err := repo.CreateBook(context.Background(), b); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+	}
+
+	highlights := repository.NewHighlightRepository(db)
+	err := highlights.CreateHighlights(context.Background(), []*models.Highlight{
+		{BookID: target.ID, Kind: "highlight", Text: "Shared clipping", Fingerprint: "shared"},
+		{BookID: source.ID, Kind: "highlight", Text: "Shared clipping", Fingerprint: "shared"},
+		{BookID: source.ID, Kind: "highlight", Text: "Source clipping", Fingerprint: "source"},
+		{BookID: source.ID, Kind: "note", Text: "Source note"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create highlights: %v", err)
+	}
+	coverRepo := repository.NewCoverRepository(db)
+	cover := models.Cover{BookID: source.ID, ContentType: "image/png", Width: 1, Height: 1, Size: 1, SHA256: "merge"}
+	if err := coverRepo.SetCover(context.Background(), &cover); err != nil {
+		t.Fatalf("Failed to set cover: %v", err)
+	}
+
+	// Merge the source into the target
+	merged, err := repo.MergeBooks(context.Background(), target.ID, []int{source.ID})
+	if err != nil {
+		t.Fatalf("Failed to merge books: %v", err)
+	}
+	if merged.Progress != 60 || merged.Notes != "target notes\n\nsource notes" {
+		t.Errorf("Unexpected merged book: %+v", merged)
+	}
+	// The source's highlights move to the target, once per clipping
+	hs, err := highlights.GetHighlights(context.Background(), target.ID)
+	if err != nil || len(hs) != 3 {
+		t.Errorf("Expected the target to have 3 highlights, got %+v, %v", hs, err)
+	}
+	// The cover moves in the same transaction
+	if c, err := coverRepo.GetCover(context.Background(), target.ID); err != nil || c.SHA256 != "merge" {
+		t.Errorf("Expected the target to take the source's cover, got %+v, %v", c, err)
+	}
+	// The source's history is copied into the target's, before the merge
+	history, err := repository.NewAuditRepository(db).GetBookHistory(context.Background(), target.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 3 || history[1].MergedFrom == nil || *history[1].MergedFrom != source.ID ||
+		history[1].Action != models.AuditCreate || history[2].Action != models.AuditMerge || history[2].MergedFrom != nil {
+		t.Errorf("Expected the target's creation, the source's and the merge, got %+v", history)
+	}
+	// Reverts only use the target's own states
+	reverted, err := repo.RevertBook(context.Background(), target.ID, models.RevertPoint{Version: history[1].Version})
+	if err != nil || reverted.Notes != "target notes" {
+		t.Errorf("Expected revert to the target's creation, got %+v, %v", reverted, err)
+	}
+
+	// Verify the source is gone
+	books, err := repo.GetBooks(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get books: %v", err)
+	}
+	for _, b := range books {
+		if b.ID == source.ID {
+			t.Error("Expected merged source book to be removed")
+		}
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	book := models.Book{Title: "Trash Test Book", Author: "Test Author", Progress: 20}
+	if err := repo.CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if err := repo.DeleteBook(context.Background(), book.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+
+	// Verify the book is in the trash
+	trash, err := repo.GetTrash(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get trash: %v", err)
+	}
+	found := false
+	for _, b := range trash {
+		if b.ID == book.ID && b.DeletedAt != nil {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected deleted book in trash")
+	}
+
+	// Restore it
+	restored, err := repo.RestoreBook(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to restore book: %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Error("Expected restored book to have no deleted_at")
+	}
+	if _, err := repo.RestoreBook(context.Background(), book.ID); err != repository.ErrNotFound {
+		t.Errorf("Expected ErrNotFound restoring a live book, got %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	book := models.Book{Title: "Purge Test Book", Author: "Test Author", Progress: 20}
+	if err := repo.CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	cover := models.Cover{BookID: book.ID, ContentType: "image/png", Width: 1, Height: 1, Size: 1, SHA256: "purge"}
+	if err := repository.NewCoverRepository(db).SetCover(context.Background(), &cover); err != nil {
+		t.Fatalf("Failed to set cover: %v", err)
+	}
+	if err := repo.DeleteBook(context.Background(), book.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+
+	// Purge everything deleted before now
+	n, covers, err := repo.PurgeTrash(context.Background(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to purge trash: %v", err)
+	}
+	if n < 1 {
+		t.Errorf("Expected at least 1 purged book, got %d", n)
+	}
+	found := false
+	for _, c := range covers {
+		found = found || c.BookID == book.ID && c.SHA256 == "purge"
+	}
+	if !found {
+		t.Errorf("Expected the purged book's cover to be returned, got %+v", covers)
+	}
+	if _, err := repo.RestoreBook(context.Background(), book.ID); err != repository.ErrNotFound {
+		t.Errorf("Expected purged book to be gone, got %v", err)
+	}
+}
+
+func TestRecordReads(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	ctx := context.Background()
+	book := models.Book{Title: "Reads Test Book", Author: "Test Author"}
+	if err := repo.CreateBook(ctx, &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	finished := time.Date(2023, 3, 14, 0, 0, 0, 0, time.UTC)
+	reads := []models.Read{{}, {FinishedAt: &finished}}
+
+	// Recording the same history twice adds it once
+	for i := 0; i < 2; i++ {
+		if err := repo.RecordReads(ctx, book.ID, reads); err != nil {
+			t.Fatalf("Failed to record reads: %v", err)
+		}
+	}
+	entries, err := repository.NewAuditRepository(db).ListAudit(ctx, models.AuditFilter{BookID: book.ID, Action: models.AuditRead})
+	if err != nil {
+		t.Fatalf("Failed to list history: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 reads in the history, got %d", len(entries))
+	}
+	if !entries[0].CreatedAt.Equal(finished) && !entries[1].CreatedAt.Equal(finished) {
+		t.Errorf("Expected a read dated %s, got %+v", finished, entries)
+	}
+
+	// Reads hold no state, so reverting to the latest version skips them
+	if _, err := repo.RevertBook(ctx, book.ID, models.RevertPoint{Version: entries[0].Version}); err != nil {
+		t.Errorf("Failed to revert past reads: %v", err)
+	}
+}
+
+func TestAuditHistory(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	ctx := audit.WithRequestID(audit.WithActor(context.Background(), "integration"), "req-audit")
+
+	book := models.Book{Title: "Audit Test Book", Author: "Test Author", Progress: 20}
+	if err := repo.CreateBook(ctx, &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	book.Progress = 70
+	if err := repo.UpdateBook(ctx, &book); err != nil {
+		t.Fatalf("Failed to update book: %v", err)
+	}
+	if err := repo.DeleteBook(ctx, book.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+
+	// Verify one entry per change, in order
+	history, err := auditRepo.GetBookHistory(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	actions := []string{models.AuditCreate, models.AuditUpdate, models.AuditDelete}
+	if len(history) != len(actions) {
+		t.Fatalf("Expected %d history entries, got %d", len(actions), len(history))
+	}
+	for i, e := range history {
+		if e.Action != actions[i] || e.Version != i+1 || e.Actor != "integration" || e.RequestID != "req-audit" {
+			t.Errorf("Unexpected history entry %d: %+v", i, e)
+		}
+	}
+
+	// Verify filtering
+	entries, err := auditRepo.ListAudit(context.Background(), models.AuditFilter{BookID: book.ID, Action: models.AuditUpdate})
+	if err != nil {
+		t.Fatalf("Failed to list audit: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 update entry, got %d", len(entries))
+	}
+}
+
+func TestRevertBook(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	book := models.Book{Title: "Revert Test Book", Author: "Test Author", Progress: 20}
+	if err := repo.CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	book.Title = "Bad Bulk Edit"
+	book.Progress = 0
+	if err := repo.UpdateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to update book: %v", err)
+	}
+
+	// Revert to the first version
+	reverted, err := repo.RevertBook(context.Background(), book.ID, models.RevertPoint{Version: 1})
+	if err != nil {
+		t.Fatalf("Failed to revert book: %v", err)
+	}
+	if reverted.Title != "Revert Test Book" || reverted.Progress != 20 {
+		t.Errorf("Unexpected reverted book: %+v", reverted)
+	}
+
+	// The revert is recorded as a new version
+	history, err := repository.NewAuditRepository(db).GetBookHistory(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if last := history[len(history)-1]; last.Action != models.AuditRevert || last.Version != 3 {
+		t.Errorf("Expected revert recorded as version 3, got %+v", last)
+	}
+
+	if _, err := repo.RevertBook(context.Background(), book.ID, models.RevertPoint{At: time.Unix(0, 0)}); err != repository.ErrNoSuchVersion {
+		t.Errorf("Expected ErrNoSuchVersion, got %v", err)
+	}
+}
+
+func TestRunInTx(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := repository.NewStore(db)
+	book := models.Book{Title: "Unit Of Work Book", Author: "Test Author", Progress: 20}
+
+	// A failing unit of work leaves nothing behind
+	err := store.RunInTx(context.Background(), func(repos repository.Repos) error {
+		if err := repos.Books.CreateBook(context.Background(), &book); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if err == nil || err.Error() != "abort" {
+		t.Fatalf("Expected abort error, got %v", err)
+	}
+	history, err := repository.NewAuditRepository(db).GetBookHistory(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 0 {
+		t.Error("Expected rolled back book to have no audit entries")
+	}
+
+	// A successful one commits the book and its audit entry together
+	err = store.RunInTx(context.Background(), func(repos repository.Repos) error {
+		return repos.Books.CreateBook(context.Background(), &book)
+	})
+	if err != nil {
+		t.Fatalf("Failed to run unit of work: %v", err)
+	}
+	history, err = repository.NewAuditRepository(db).GetBookHistory(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 1 {
+		t.Errorf("Expected 1 audit entry, got %d", len(history))
+	}
+}
+
+func TestCreateBooks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	var books []*models.Book
+	for i := 0; i < 1200; i++ {
+		books = append(books, &models.Book{Title: fmt.Sprintf("Bulk Book %d", i), Author: "Bulk Author", Progress: i % 100})
+	}
+
+	// Insert across several multi-row chunks
+	if err := repo.CreateBooks(context.Background(), books); err != nil {
+		t.Fatalf("Failed to create books: %v", err)
+	}
+
+	// Verify every book got the id of its own row
+	stored, err := repo.GetBooks(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get books: %v", err)
+	}
+	byID := make(map[int]models.Book)
+	for _, b := range stored {
+		byID[b.ID] = b
+	}
+	for _, b := range books {
+		if got, ok := byID[b.ID]; !ok || got.Title != b.Title {
+			t.Fatalf("Expected book %d to be %q, got %+v", b.ID, b.Title, got)
+		}
+	}
+}
+
+func TestStreamBooks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := repository.NewBookRepository(db)
+	book := models.Book{Title: "Stream Test Book", Author: "Test Author", Progress: 20}
+	if err := repo.CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+
+	// Verify the book is streamed and ids ascend
+	found, lastID := false, 0
+	err := repo.StreamBooks(context.Background(), func(b models.Book) error {
+		if b.ID <= lastID {
+			t.Errorf("Expected ascending ids, got %d after %d", b.ID, lastID)
+		}
+		lastID = b.ID
+		found = found || b.ID == book.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream books: %v", err)
+	}
+	if !found {
+		t.Error("Expected created book to be streamed")
+	}
+}
+
+func TestImportMerge(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := repository.NewStore(db)
+	sourceID := fmt.Sprint(time.Now().UnixNano())
+	records := []importer.Record{{Line: 1, Book: models.Book{
+		Title: "Import Merge Book " + sourceID, Author: "Test Author", Series: "Test Series", SeriesIndex: 2.5,
+		Tags: models.Tags{"imported"}, Source: importer.SourceCalibre, SourceID: sourceID,
+	}}}
+
+	// The first import creates the book with its series
+	report, err := importer.Import(context.Background(), store, records, importer.Options{Merge: true})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if report.Created != 1 {
+		t.Fatalf("Expected 1 created book, got %+v", report)
+	}
+	id := report.Results[0].ID
+
+	// Re-importing the unchanged library skips it
+	report, err = importer.Import(context.Background(), store, records, importer.Options{Merge: true})
+	if err != nil {
+		t.Fatalf("Failed to re-import: %v", err)
+	}
+	if report.Skipped != 1 || report.Results[0].ID != id {
+		t.Errorf("Expected book %d to be skipped, got %+v", id, report)
+	}
+
+	books, err := repository.NewBookRepository(db).GetBooks(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get books: %v", err)
+	}
+	for _, b := range books {
+		if b.ID == id && (b.Series != "Test Series" || b.SeriesIndex != 2.5) {
+			t.Errorf("Expected series to be stored, got %+v", b)
+		}
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	book := models.Book{Title: "Highlight Test Book", Author: "Test Author"}
+	if err := repository.NewBookRepository(db).CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+
+	// Store a highlight and a bookmark
+	repo := repository.NewHighlightRepository(db)
+	added := time.Date(2023, 3, 5, 22, 15, 0, 0, time.UTC)
+	highlights := []*models.Highlight{
+		{BookID: book.ID, Kind: models.HighlightKindHighlight, Text: "A passage", Location: "10-12", AddedAt: &added, Fingerprint: "a"},
+		{BookID: book.ID, Kind: models.HighlightKindBookmark, Location: "40", Fingerprint: "b"},
+	}
+	if err := repo.CreateHighlights(context.Background(), highlights); err != nil {
+		t.Fatalf("Failed to create highlights: %v", err)
+	}
+
+	// Verify they are listed with the book
+	stored, err := repo.GetHighlights(context.Background(), book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get highlights: %v", err)
+	}
+	if len(stored) != 2 || stored[0].ID != highlights[0].ID || stored[0].Text != "A passage" || stored[1].Fingerprint != "b" {
+		t.Errorf("Unexpected highlights: %+v", stored)
+	}
+
+	// The same clipping cannot be stored twice
+	if err := repo.CreateHighlights(context.Background(), highlights[:1]); err == nil {
+		t.Error("Expected a duplicate fingerprint to be rejected")
+	}
+}
+
+func TestHighlightCRUDAndSearch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	book := models.Book{Title: "Search Test Book", Author: "Test Author"}
+	if err := repository.NewBookRepository(db).CreateBook(context.Background(), &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+
+	// Create a highlight with a unique word to search for
+	repo := repository.NewHighlightRepository(db)
+	word := fmt.Sprintf("zyxquote%d", time.Now().UnixNano())
+	highlight := models.Highlight{BookID: book.ID, Kind: models.HighlightKindHighlight, Text: "The " + word + " passage", Color: "yellow"}
+	if err := repo.CreateHighlight(context.Background(), &highlight); err != nil {
+		t.Fatalf("Failed to create highlight: %v", err)
+	}
+	if err := repo.CreateHighlight(context.Background(), &models.Highlight{BookID: -1, Kind: "highlight", Text: "x"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing book, got %v", err)
+	}
+
+	// Update its comment
+	highlight.Comment = "Worth rereading"
+	if err := repo.UpdateHighlight(context.Background(), &highlight); err != nil {
+		t.Fatalf("Failed to update highlight: %v", err)
+	}
+	got, err := repo.GetHighlight(context.Background(), book.ID, highlight.ID)
+	if err != nil || got.Comment != "Worth rereading" {
+		t.Fatalf("Expected updated comment, got %+v (%v)", got, err)
+	}
+
+	// Search finds it by text and by comment
+	for _, q := range []string{word, "rereading"} {
+		matches, err := repo.SearchHighlights(context.Background(), q, 100, 0)
+		if err != nil {
+			t.Fatalf("Failed to search highlights: %v", err)
+		}
+		found := false
+		for _, m := range matches {
+			found = found || (m.ID == highlight.ID && m.BookTitle == book.Title)
+		}
+		if !found {
+			t.Errorf("Expected search for %q to find highlight %d", q, highlight.ID)
+		}
+	}
+
+	// Delete it
+	if err := repo.DeleteHighlight(context.Background(), book.ID, highlight.ID); err != nil {
+		t.Fatalf("Failed to delete highlight: %v", err)
+	}
+	if _, err := repo.GetHighlight(context.Background(), book.ID, highlight.ID); !errors.Is(err, repository.ErrHighlightNotFound) {
+		t.Errorf("Expected ErrHighlightNotFound after delete, got %v", err)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	book := models.Book{Title: "Backup Test Book", Author: "Test Author", Tags: models.Tags{"backup"}}
+	if err := repository.NewBookRepository(db).CreateBook(ctx, &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	highlight := models.Highlight{BookID: book.ID, Kind: models.HighlightKindHighlight, Text: "A backed up passage"}
+	if err := repository.NewHighlightRepository(db).CreateHighlight(ctx, &highlight); err != nil {
+		t.Fatalf("Failed to create highlight: %v", err)
+	}
+
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open blob storage: %v", err)
+	}
+	coverService := covers.NewService(repository.NewCoverRepository(db), blobs)
+	if _, err := coverService.Set(ctx, book.ID, testPNG(t), "image/png"); err != nil {
+		t.Fatalf("Failed to set cover: %v", err)
+	}
+
+	store := repository.NewStore(db)
+	var archive bytes.Buffer
+	if err := backup.Write(ctx, store, blobs, &archive); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+
+	// Restoring over existing data needs replace
+	if _, err := backup.Restore(ctx, store, bytes.NewReader(archive.Bytes()), backup.Options{}); !errors.Is(err, backup.ErrNotEmpty) {
+		t.Fatalf("Expected ErrNotEmpty, got %v", err)
+	}
+	summary, err := backup.Restore(ctx, store, bytes.NewReader(archive.Bytes()), backup.Options{Replace: true})
+	if err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	if summary.Counts[backup.TypeBook] == 0 || summary.Counts[backup.TypeHighlight] == 0 || summary.Counts[backup.TypeCover] == 0 {
+		t.Errorf("Expected books, highlights and covers to be restored, got %v", summary.Counts)
+	}
+
+	// The book is back under a new id, with its highlight and history
+	var restored models.Book
+	if err := db.GetContext(ctx, &restored.ID, `SELECT id FROM books WHERE title = $1 ORDER BY id DESC LIMIT 1`, book.Title); err != nil {
+		t.Fatalf("Failed to find restored book: %v", err)
+	}
+	if restored.ID == book.ID {
+		t.Errorf("Expected the restored book to get a new id")
+	}
+	highlights, err := repository.NewHighlightRepository(db).GetHighlights(ctx, restored.ID)
+	if err != nil || len(highlights) != 1 || highlights[0].Text != highlight.Text {
+		t.Errorf("Expected the highlight on the restored book, got %+v (%v)", highlights, err)
+	}
+	history, err := repository.NewAuditRepository(db).GetBookHistory(ctx, restored.ID)
+	if err != nil || len(history) == 0 {
+		t.Errorf("Expected the restored book to keep its history, got %+v (%v)", history, err)
+	}
+	// The restored cover is served from the database until it is moved to
+	// blob storage
+	cover, err := coverService.Get(ctx, restored.ID, "small")
+	if err != nil || cover.ContentType != covers.ThumbnailType {
+		t.Errorf("Expected a thumbnail of the restored cover, got %+v (%v)", cover, err)
+	}
+	if held, err := repository.NewCoverRepository(db).ListHeldCovers(ctx); err != nil || len(held) != 1 || held[0] != restored.ID {
+		t.Errorf("Expected the restored cover to be held in the database, got %v (%v)", held, err)
+	}
+	if moved, err := coverService.MoveRestored(ctx); err != nil || moved != 1 {
+		t.Errorf("Expected the restored cover to be moved, got %d (%v)", moved, err)
+	}
+	if stored, err := repository.NewCoverRepository(db).GetCover(ctx, restored.ID); err != nil || stored.Data != nil {
+		t.Errorf("Expected the restored image to leave the database, got %v", err)
+	}
+}
+
+func TestBackupInsertUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	existing := models.User{Username: fmt.Sprintf("sso-%d", suffix), OIDCIssuer: "https://id.example", OIDCSubject: fmt.Sprintf("s-%d", suffix)}
+	if err := repository.NewUserRepository(db).CreateUser(ctx, &existing); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	repo := repository.NewBackupRepository(db)
+
+	// The OIDC identity finds the user under another name
+	renamed := models.User{Username: fmt.Sprintf("renamed-%d", suffix), OIDCIssuer: existing.OIDCIssuer, OIDCSubject: existing.OIDCSubject}
+	if collided, err := repo.InsertUser(ctx, &renamed); err != nil || collided || renamed.ID != existing.ID {
+		t.Errorf("Expected user %d by identity, got %d (collided %v, %v)", existing.ID, renamed.ID, collided, err)
+	}
+
+	// Someone else with the name is merged and reported
+	other := models.User{Username: existing.Username, PasswordHash: "x"}
+	if collided, err := repo.InsertUser(ctx, &other); err != nil || !collided || other.ID != existing.ID {
+		t.Errorf("Expected a collision with user %d, got %d (collided %v, %v)", existing.ID, other.ID, collided, err)
+	}
+
+	fresh := models.User{Username: fmt.Sprintf("fresh-%d", suffix), PasswordHash: "x", CreatedAt: time.Now()}
+	if collided, err := repo.InsertUser(ctx, &fresh); err != nil || collided || fresh.ID == 0 || fresh.ID == existing.ID {
+		t.Errorf("Expected a new user, got %d (collided %v, %v)", fresh.ID, collided, err)
+	}
+}
+
+func TestGetBooksByIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	repo := repository.NewBookRepository(db)
+	a := models.Book{Title: "Selected A", Author: "Test Author"}
+	b := models.Book{Title: "Selected B", Author: "Test Author"}
+	for _, book := range []*models.Book{&a, &b} {
+		if err := repo.CreateBook(ctx, book); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+	}
+
+	books, err := repo.GetBooksByIDs(ctx, []int{b.ID, a.ID, a.ID})
+	if err != nil {
+		t.Fatalf("Failed to get books: %v", err)
+	}
+	if len(books) != 2 || books[0].ID != a.ID || books[1].ID != b.ID {
+		t.Errorf("Expected books %d and %d in id order, got %+v", a.ID, b.ID, books)
+	}
+
+	// Trashed books are not selectable
+	if err := repo.DeleteBook(ctx, b.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+	if _, err := repo.GetBooksByIDs(ctx, []int{a.ID, b.ID}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	books := repository.NewBookRepository(db)
+	author := fmt.Sprintf("Catalog Author %d", time.Now().UnixNano())
+	for _, title := range []string{"b_catalog 100%", "A catalog book"} {
+		book := models.Book{Title: title, Author: author, Shelf: models.ShelfToRead, Tags: models.Tags{"catalog-test"}}
+		if err := books.CreateBook(ctx, &book); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+	}
+
+	repo := repository.NewCatalogRepository(db)
+	list, total, err := repo.ListBooks(ctx, models.CatalogFilter{Author: author, Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed to list books: %v", err)
+	}
+	if total != 2 || len(list) != 1 || list[0].Title != "A catalog book" {
+		t.Errorf("Expected the first of 2 books by title, got %d and %+v", total, list)
+	}
+
+	// LIKE wildcards in searches match literally
+	list, total, err = repo.ListBooks(ctx, models.CatalogFilter{Query: "B_CATALOG 100%", Author: author})
+	if err != nil || total != 1 || list[0].Title != "b_catalog 100%" {
+		t.Errorf("Expected one search match, got %d %+v (%v)", total, list, err)
+	}
+
+	facets, err := repo.Facets(ctx, repository.FacetAuthor)
+	if err != nil {
+		t.Fatalf("Failed to list facets: %v", err)
+	}
+	found := false
+	for _, f := range facets {
+		if f.Value == author {
+			found = f.Count == 2
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s with 2 books among %+v", author, facets)
+	}
+	if _, err := repo.Facets(ctx, "publisher"); err == nil {
+		t.Error("Expected an unknown facet to be rejected")
+	}
+
+	// Only finished books are in the finished feed
+	_, total, err = repo.ListBooks(ctx, models.CatalogFilter{Author: author, Finished: true, Order: models.CatalogOrderFinished})
+	if err != nil || total != 0 {
+		t.Errorf("Expected no finished books, got %d (%v)", total, err)
+	}
+}
+
+// testPNG encodes a small opaque PNG.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 60))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCovers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	books := repository.NewBookRepository(db)
+	repo := repository.NewCoverRepository(db)
+	book := models.Book{Title: "Covered Book", Author: "Test Author", Language: "en"}
+	if err := books.CreateBook(ctx, &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if _, err := repo.GetCover(ctx, book.ID); !errors.Is(err, repository.ErrCoverNotFound) {
+		t.Errorf("Expected ErrCoverNotFound, got %v", err)
+	}
+
+	for _, sum := range []string{"first", "second"} {
+		cover := models.Cover{BookID: book.ID, ContentType: "image/png", Width: 40, Height: 60, Size: 99, SHA256: sum}
+		if err := repo.SetCover(ctx, &cover); err != nil {
+			t.Fatalf("Failed to set cover: %v", err)
+		}
+	}
+	cover, err := repo.GetCover(ctx, book.ID)
+	if err != nil {
+		t.Fatalf("Failed to get cover: %v", err)
+	}
+	if cover.SHA256 != "second" || cover.Width != 40 || cover.Data != nil || cover.UpdatedAt.IsZero() {
+		t.Errorf("Expected the replaced cover without data, got %+v", cover)
+	}
+	if err := repo.DeleteCover(ctx, book.ID); err != nil {
+		t.Fatalf("Failed to delete cover: %v", err)
+	}
+	if err := repo.DeleteCover(ctx, book.ID); !errors.Is(err, repository.ErrCoverNotFound) {
+		t.Errorf("Expected ErrCoverNotFound, got %v", err)
+	}
+
+	// Trashed books have no cover and cannot get one
+	if err := books.DeleteBook(ctx, book.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+	err = repo.SetCover(ctx, &models.Cover{BookID: book.ID, ContentType: "image/png", SHA256: "x"})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEnrichmentQueue(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	books := repository.NewBookRepository(db)
+	repo := repository.NewEnrichmentRepository(db)
+	complete := models.Book{Title: "Complete Book", Author: "Test Author", ISBN: "9780441013593", Publisher: "Ace", Year: 1965, Pages: 412}
+	missing := models.Book{Title: "Bare Book", Author: "Test Author"}
+	undated := models.Book{Title: "Undated Book", Author: "Test Author", ISBN: "9780441172696", Publisher: "Ace", Pages: 336}
+	for _, b := range []*models.Book{&complete, &missing, &undated} {
+		if err := books.CreateBook(ctx, b); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+	}
+	queued := func(attemptedBefore time.Time) map[int]bool {
+		list, err := repo.ListBooksToEnrich(ctx, attemptedBefore, 1000)
+		if err != nil {
+			t.Fatalf("Failed to list books to enrich: %v", err)
+		}
+		ids := map[int]bool{}
+		for _, b := range list {
+			ids[b.ID] = true
+		}
+		return ids
+	}
+	ids := queued(time.Now())
+	if !ids[missing.ID] || !ids[undated.ID] || ids[complete.ID] {
+		t.Errorf("Expected only the books missing metadata to be queued, got %v", ids)
+	}
+	for _, b := range []models.Book{complete, missing, undated} {
+		if enrich.NeedsEnrichment(b) != ids[b.ID] {
+			t.Errorf("Expected NeedsEnrichment to agree with the queue for %q", b.Title)
+		}
+	}
+
+	if err := repo.RecordAttempt(ctx, missing.ID, "fake", nil, "timeout"); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+	if err := repo.RecordAttempt(ctx, missing.ID, "fake", []string{"pages"}, ""); err != nil {
+		t.Fatalf("Failed to record attempt again: %v", err)
+	}
+	if ids := queued(time.Now().Add(-time.Hour)); ids[missing.ID] {
+		t.Error("Expected a recently attempted book not to be queued")
+	}
+	if ids := queued(time.Now().Add(time.Hour)); !ids[missing.ID] {
+		t.Error("Expected a book attempted before the cutoff to be queued")
+	}
+}
+
+func TestOwnerScoping(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	var owners [2]models.User
+	for i := range owners {
+		owners[i] = models.User{Username: fmt.Sprintf("owner-%d-%d", i, time.Now().UnixNano()), PasswordHash: "x"}
+		if err := users.CreateUser(ctx, &owners[i]); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	if err := users.CreateUser(ctx, &models.User{Username: owners[0].Username, PasswordHash: "x"}); !errors.Is(err, repository.ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken, got %v", err)
+	}
+	alice := repository.WithOwner(ctx, owners[0].ID)
+	bob := repository.WithOwner(ctx, owners[1].ID)
+
+	books := repository.NewBookRepository(db)
+	book := models.Book{Title: "Owned Book", Author: "Test Author", OwnerID: &owners[1].ID}
+	if err := books.CreateBook(alice, &book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if book.OwnerID == nil || *book.OwnerID != owners[0].ID {
+		t.Fatalf("Expected the book to belong to its creator, got %v", book.OwnerID)
+	}
+	highlights := repository.NewHighlightRepository(db)
+	if err := highlights.CreateHighlight(alice, &models.Highlight{BookID: book.ID, Kind: "note", Text: "Mine"}); err != nil {
+		t.Fatalf("Failed to create highlight: %v", err)
+	}
+
+	list, err := books.GetBooks(bob)
+	if err != nil {
+		t.Fatalf("Failed to get books: %v", err)
+	}
+	for _, b := range list {
+		if b.ID == book.ID {
+			t.Error("Expected another owner's book to be hidden")
+		}
+	}
+	if _, err := books.GetBooksByIDs(bob, []int{book.ID}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound reading another owner's book, got %v", err)
+	}
+	if err := books.DeleteBook(bob, book.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting another owner's book, got %v", err)
+	}
+	if err := highlights.CreateHighlight(bob, &models.Highlight{BookID: book.ID, Kind: "note", Text: "Theirs"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound adding a highlight to another owner's book, got %v", err)
+	}
+	if hs, _ := highlights.GetHighlights(bob, book.ID); len(hs) != 0 {
+		t.Errorf("Expected another owner's highlights to be hidden, got %d", len(hs))
+	}
+	history, err := repository.NewAuditRepository(db).GetBookHistory(bob, book.ID)
+	if err != nil || len(history) != 0 {
+		t.Errorf("Expected another owner's history to be hidden, got %d entries, %v", len(history), err)
+	}
+	if history, _ := repository.NewAuditRepository(db).GetBookHistory(alice, book.ID); len(history) != 1 {
+		t.Errorf("Expected the owner to see the book's history, got %d entries", len(history))
+	}
+
+	// Without an owner, as in background jobs, every book is visible
+	if _, err := books.GetBooksByIDs(ctx, []int{book.ID}); err != nil {
+		t.Errorf("Expected an unscoped read to find the book, got %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	user := models.User{Username: fmt.Sprintf("session-%d", time.Now().UnixNano()), PasswordHash: "x"}
+	if err := users.CreateUser(ctx, &user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	expired := &models.Session{UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)}
+	live := &models.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := users.CreateSession(ctx, "expired-"+user.Username, expired); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if err := users.CreateSession(ctx, "live-"+user.Username, live); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, err := users.GetSessionUser(ctx, "expired-"+user.Username); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for an expired session, got %v", err)
+	}
+	got, err := users.GetSessionUser(ctx, "live-"+user.Username)
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("Expected the session's user, got %+v, %v", got, err)
+	}
+	if err := users.DeleteSession(ctx, "live-"+user.Username); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if _, err := users.GetSessionUser(ctx, "live-"+user.Username); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound after signing out, got %v", err)
+	}
+}
+
+func TestUserIdentity(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	suffix := time.Now().UnixNano()
+	user := models.User{Username: fmt.Sprintf("oidc-%d", suffix), OIDCIssuer: "https://id.example", OIDCSubject: fmt.Sprintf("sub-%d", suffix)}
+	if err := users.CreateUser(ctx, &user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	got, err := users.GetUserByIdentity(ctx, user.OIDCIssuer, user.OIDCSubject)
+	if err != nil || got.ID != user.ID || got.PasswordHash != "" {
+		t.Fatalf("Expected the linked user, got %+v, %v", got, err)
+	}
+	if _, err := users.GetUserByIdentity(ctx, "https://other.example", user.OIDCSubject); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for another issuer, got %v", err)
+	}
+	if _, err := users.GetUserByIdentity(ctx, "", ""); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected password users not to match an empty identity, got %v", err)
+	}
+	again := models.User{Username: user.Username + "-2", OIDCIssuer: user.OIDCIssuer, OIDCSubject: user.OIDCSubject}
+	if err := users.CreateUser(ctx, &again); !errors.Is(err, repository.ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken linking an identity twice, got %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	user := models.User{Username: fmt.Sprintf("tokens-%d", time.Now().UnixNano()), PasswordHash: "x"}
+	if err := users.CreateUser(ctx, &user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	expired := &models.APIToken{UserID: user.ID, Name: "expired", Prefix: "bt_old", Scopes: []string{models.ScopeBooksRead}, ExpiresAt: &past}
+	live := &models.APIToken{UserID: user.ID, Name: "live", Prefix: "bt_new", Scopes: []string{models.ScopeBooksRead, models.ScopeBooksWrite}}
+	if err := users.CreateAPIToken(ctx, "expired-"+user.Username, expired); err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if err := users.CreateAPIToken(ctx, "live-"+user.Username, live); err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if live.ID == 0 || live.CreatedAt.IsZero() || live.LastUsedAt != nil {
+		t.Errorf("Expected an unused token with an ID, got %+v", live)
+	}
+
+	if _, err := users.UseAPIToken(ctx, "expired-"+user.Username); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound for an expired token, got %v", err)
+	}
+	used, err := users.UseAPIToken(ctx, "live-"+user.Username)
+	if err != nil || used.ID != live.ID || used.LastUsedAt == nil || !used.HasScope(models.ScopeBooksWrite) {
+		t.Fatalf("Expected the live token marked used, got %+v, %v", used, err)
+	}
+
+	tokens, err := users.ListAPITokens(ctx, user.ID)
+	if err != nil || len(tokens) != 2 || tokens[0].ID != live.ID {
+		t.Fatalf("Expected both tokens, newest first, got %+v, %v", tokens, err)
+	}
+	if err := users.DeleteAPIToken(ctx, user.ID+1, live.ID); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound revoking another user's token, got %v", err)
+	}
+	if err := users.DeleteAPIToken(ctx, user.ID, live.ID); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, err := users.UseAPIToken(ctx, "live-"+user.Username); !errors.Is(err, repository.ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound after revoking, got %v", err)
+	}
+}
+
+func TestLibraryMembers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	libraries := repository.NewLibraryRepository(db)
+	suffix := time.Now().UnixNano()
+	owner := models.User{Username: fmt.Sprintf("owner-%d", suffix), PasswordHash: "x"}
+	member := models.User{Username: fmt.Sprintf("member-%d", suffix), PasswordHash: "x"}
+	for _, u := range []*models.User{&owner, &member} {
+		if err := users.CreateUser(ctx, u); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	if _, err := libraries.GetRole(ctx, owner.ID, member.ID); !errors.Is(err, repository.ErrLibraryNotFound) {
+		t.Errorf("Expected ErrLibraryNotFound before joining, got %v", err)
+	}
+	if err := libraries.SetMember(ctx, owner.ID, member.ID, models.RoleViewer); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+	if err := libraries.SetMember(ctx, owner.ID, member.ID, models.RoleEditor); err != nil {
+		t.Fatalf("Failed to change role: %v", err)
+	}
+	if role, err := libraries.GetRole(ctx, owner.ID, member.ID); err != nil || role != models.RoleEditor {
+		t.Errorf("Expected editor, got %s, %v", role, err)
+	}
+	if err := libraries.SetMember(ctx, owner.ID, member.ID, "admin"); err == nil {
+		t.Error("Expected the database to reject an unknown role")
+	}
+
+	members, err := libraries.ListMembers(ctx, owner.ID)
+	if err != nil || len(members) != 2 || members[0].UserID != owner.ID || members[0].Role != models.RoleOwner ||
+		members[1].Username != member.Username || members[1].LibraryID != owner.ID {
+		t.Errorf("Expected the owner then the member, got %+v, %v", members, err)
+	}
+	list, err := libraries.ListLibraries(ctx, member.ID)
+	if err != nil || len(list) != 2 || list[0].ID != member.ID || list[1] != (models.Library{ID: owner.ID, Owner: owner.Username, Role: models.RoleEditor}) {
+		t.Errorf("Expected the member's own library then the owner's, got %+v, %v", list, err)
+	}
+
+	if err := libraries.RemoveMember(ctx, owner.ID, member.ID); err != nil {
+		t.Fatalf("Failed to remove member: %v", err)
+	}
+	if err := libraries.RemoveMember(ctx, owner.ID, member.ID); !errors.Is(err, repository.ErrMemberNotFound) {
+		t.Errorf("Expected ErrMemberNotFound, got %v", err)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	users := repository.NewUserRepository(db)
+	var tenants [2]models.User
+	for i := range tenants {
+		tenants[i] = models.User{Username: fmt.Sprintf("tenant-%d-%d", i, time.Now().UnixNano()), PasswordHash: "x"}
+		if err := users.CreateUser(ctx, &tenants[i]); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	mine := repository.WithOwner(ctx, tenants[0].ID)
+	theirs := repository.WithOwner(ctx, tenants[1].ID)
+
+	books := repository.NewBookRepository(db)
+	own := models.Book{Title: "Own Book", Author: "Test Author"}
+	other := models.Book{Title: "Other Book", Author: "Test Author"}
+	trashed := models.Book{Title: "Other Trashed Book", Author: "Test Author"}
+	if err := books.CreateBook(mine, &own); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if err := books.CreateBooks(theirs, []*models.Book{&other, &trashed}); err != nil {
+		t.Fatalf("Failed to create books: %v", err)
+	}
+	if err := books.DeleteBook(theirs, trashed.ID); err != nil {
+		t.Fatalf("Failed to delete book: %v", err)
+	}
+	hidden := func(list []models.Book) error {
+		for _, b := range list {
+			if b.OwnerID == nil || *b.OwnerID != tenants[0].ID {
+				return fmt.Errorf("got book %d of library %v", b.ID, b.OwnerID)
+			}
+		}
+		return nil
+	}
+	notFound := func(err error) error {
+		if !errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("expected ErrNotFound, got %v", err)
+		}
+		return nil
+	}
+
+	// Every BookRepository method tried on the other library's books
+	cases := map[string]func() error{
+		"CreateBook": func() error {
+			b := models.Book{Title: "Planted Book", Author: "Test Author", OwnerID: &tenants[1].ID}
+			if err := books.CreateBook(mine, &b); err != nil || *b.OwnerID != tenants[0].ID {
+				return fmt.Errorf("expected a book of its creator's library, got %v, %v", b.OwnerID, err)
+			}
+			return nil
+		},
+		"CreateBooks": func() error {
+			b := models.Book{Title: "Planted Book", Author: "Test Author", OwnerID: &tenants[1].ID}
+			if err := books.CreateBooks(mine, []*models.Book{&b}); err != nil || *b.OwnerID != tenants[0].ID {
+				return fmt.Errorf("expected a book of its creator's library, got %v, %v", b.OwnerID, err)
+			}
+			return nil
+		},
+		"GetBooks": func() error {
+			list, err := books.GetBooks(mine)
+			if err != nil {
+				return err
+			}
+			return hidden(list)
+		},
+		"GetBooksByIDs": func() error {
+			_, err := books.GetBooksByIDs(mine, []int{own.ID, other.ID})
+			return notFound(err)
+		},
+		"StreamBooks": func() error {
+			var list []models.Book
+			err := books.StreamBooks(mine, func(b models.Book) error {
+				list = append(list, b)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return hidden(list)
+		},
+		"UpdateBook": func() error {
+			b := other
+			b.Title = "Overwritten"
+			return notFound(books.UpdateBook(mine, &b))
+		},
+		"DeleteBook": func() error {
+			return notFound(books.DeleteBook(mine, other.ID))
+		},
+		"MergeBooks": func() error {
+			_, err := books.MergeBooks(mine, own.ID, []int{other.ID})
+			return notFound(err)
+		},
+		"GetTrash": func() error {
+			list, err := books.GetTrash(mine)
+			if err != nil {
+				return err
+			}
+			return hidden(list)
+		},
+		"RestoreBook": func() error {
+			_, err := books.RestoreBook(mine, trashed.ID)
+			return notFound(err)
+		},
+		"RecordReads": func() error {
+			return notFound(books.RecordReads(mine, other.ID, []models.Read{{}}))
+		},
+		"RevertBook": func() error {
+			_, err := books.RevertBook(mine, other.ID, models.RevertPoint{Version: 1})
+			return notFound(err)
+		},
+		"PurgeTrash": func() error {
+			_, _, err := books.PurgeTrash(mine, time.Now().Add(time.Hour))
+			return err
+		},
+	}
+	repoType := reflect.TypeOf(books)
+	for i := 0; i < repoType.NumMethod(); i++ {
+		if name := repoType.Method(i).Name; cases[name] == nil {
+			t.Errorf("BookRepository.%s is not checked for crossing libraries", name)
+		}
+	}
+	for name, check := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := check(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	got, err := books.GetBooksByIDs(ctx, []int{other.ID})
+	if err != nil || got[0].Title != other.Title || got[0].DeletedAt != nil {
+		t.Errorf("Expected the other library's book unchanged, got %+v, %v", got, err)
+	}
+	if trash, _ := books.GetTrash(theirs); len(trash) != 1 || trash[0].ID != trashed.ID {
+		t.Errorf("Expected the other library's trash unchanged, got %+v", trash)
+	}
+
+	// Postgres itself refuses the tenant role rows of other libraries,
+	// whatever the query's conditions
+	highlight := models.Highlight{BookID: other.ID, Kind: "highlight", Text: "Other highlight"}
+	if err := repository.NewHighlightRepository(db).CreateHighlight(theirs, &highlight); err != nil {
+		t.Fatalf("Failed to create highlight: %v", err)
+	}
+	cover := models.Cover{BookID: other.ID, ContentType: "image/png", Width: 1, Height: 1, Size: 1, SHA256: "other"}
+	if err := repository.NewCoverRepository(db).SetCover(theirs, &cover); err != nil {
+		t.Fatalf("Failed to set cover: %v", err)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT set_config('app.library_id', $1, true)`, fmt.Sprint(tenants[0].ID)); err != nil {
+		t.Fatalf("Failed to set library: %v", err)
+	}
+	if _, err := tx.Exec(`SET LOCAL ROLE book_tracker_tenant`); err != nil {
+		t.Fatalf("Failed to switch role: %v", err)
+	}
+	var visible int
+	if err := tx.Get(&visible, `SELECT COUNT(*) FROM books WHERE owner_id <> $1 OR owner_id IS NULL`, tenants[0].ID); err != nil || visible != 0 {
+		t.Errorf("Expected no other library's books to be visible, got %d, %v", visible, err)
+	}
+	for _, table := range []string{"highlights", "book_covers", "book_audit"} {
+		if err := tx.Get(&visible, `SELECT COUNT(*) FROM `+table+` WHERE book_id = $1`, other.ID); err != nil || visible != 0 {
+			t.Errorf("Expected no other library's %s to be visible, got %d, %v", table, visible, err)
+		}
+	}
+	if result, err := tx.Exec(`UPDATE highlights SET book_id = $1 WHERE id = $2`, own.ID, highlight.ID); err != nil {
+		t.Errorf("Failed to run update: %v", err)
+	} else if n, _ := result.RowsAffected(); n != 0 {
+		t.Errorf("Expected the other library's highlight not to be moved, got %d rows", n)
+	}
+	if result, err := tx.Exec(`UPDATE books SET title = 'Overwritten' WHERE id = $1`, other.ID); err != nil {
+		t.Errorf("Failed to run update: %v", err)
+	} else if n, _ := result.RowsAffected(); n != 0 {
+		t.Errorf("Expected the other library's book not to be updated, got %d rows", n)
+	}
+	if _, err := tx.Exec(`INSERT INTO books (title, author, owner_id) VALUES ('Planted Book', 'Test Author', $1)`, tenants[1].ID); err == nil {
+		t.Error("Expected inserting into another library to violate row-level security")
+	}
+
+	// A joined transaction's other queries are not restricted
+	store := repository.NewStore(db)
+	err = store.RunInTx(mine, func(repos repository.Repos) error {
+		if _, err := repos.Books.GetBooks(mine); err != nil {
+			return err
+		}
+		found := false
+		err := repos.Backup.ExportBooks(mine, func(b models.Book) error {
+			found = found || b.ID == other.ID
+			return nil
+		})
+		if err == nil && !found {
+			err = errors.New("the other library's book is hidden")
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("Expected the role reset after the book query: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package unit
+
+import (
+	"archive/zip"
+	"book-tracker/internal/export"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func ankiRepos() (*mockBookRepository, *mockHighlightRepository) {
+	books := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) {
+			return []models.Book{{ID: 1, Title: "Pride & Prejudice", Author: "Jane Austen", Tags: models.Tags{"classics", "romance novel"}}}, nil
+		},
+	}
+	highlights := &mockHighlightRepository{
+		getAllFunc: func(ctx context.Context) ([]models.Highlight, error) {
+			return []models.Highlight{
+				{ID: 7, BookID: 1, Kind: models.HighlightKindHighlight, Text: "It is a truth\tuniversally <acknowledged>", Page: "1", Comment: "Opening"},
+				{ID: 8, BookID: 1, Kind: models.HighlightKindBookmark, Location: "40"},
+				{ID: 9, BookID: 2, Kind: models.HighlightKindHighlight, Text: "Book in the trash"},
+			}, nil
+		},
+	}
+	return books, highlights
+}
+
+func TestExportAnkiTSV(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Default templates",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedBody: "#separator:tab\n#html:true\n#notetype:Basic\n#deck:Books\n#guid column:1\n#tags column:4\n" +
+				"book-tracker-h7\tIt is a truth universally &lt;acknowledged&gt;\t<b>Pride &amp; Prejudice</b><br>Jane Austen, p. 1<br><br><i>Opening</i>\tclassics romance_novel\n",
+		},
+		{
+			name:           "Custom templates and deck",
+			query:          "?deck=Quotes&front=" + "%7B%7B.Title%7D%7D&back=%7B%7B.Text%7D%7D",
+			expectedStatus: http.StatusOK,
+			expectedBody: "#separator:tab\n#html:true\n#notetype:Basic\n#deck:Quotes\n#guid column:1\n#tags column:4\n" +
+				"book-tracker-h7\tPride &amp; Prejudice\tIt is a truth universally &lt;acknowledged&gt;\tclassics romance_novel\n",
+		},
+		{
+			name:           "Invalid template",
+			query:          "?front=%7B%7B.Title",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown field",
+			query:          "?front=%7B%7B.Publisher%7D%7D",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid format",
+			query:          "?format=csv",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			books, highlights := ankiRepos()
+			req := httptest.NewRequest(http.MethodGet, "/export/anki"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handlers.ExportAnki(books, highlights)(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+				t.Errorf("Unexpected body:\n%q\nwant:\n%q", w.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+}
+
+func TestWriteAnkiPackage(t *testing.T) {
+	cards := []export.AnkiCard{
+		{GUID: "book-tracker-h7", Front: "It is a truth", Back: "<b>Pride &amp; Prejudice</b>", Tags: []string{"classics"}},
+		{GUID: "book-tracker-h9", Front: "Second quote", Back: "Emma"},
+	}
+	var buf bytes.Buffer
+	if err := export.WriteAnkiPackage(context.Background(), &buf, "Quotes", cards); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "collection.anki2" || zr.File[1].Name != "media" {
+		t.Fatalf("Unexpected files in package: %v", zr.File)
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "collection.anki2")
+	data, _ := io.ReadAll(f)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var guid, flds, tags, decks string
+	if err := db.QueryRow(`SELECT guid, flds, tags FROM notes ORDER BY id LIMIT 1`).Scan(&guid, &flds, &tags); err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	if guid != "book-tracker-h7" || flds != "It is a truth\x1f<b>Pride &amp; Prejudice</b>" || tags != " classics " {
+		t.Errorf("Unexpected note: %q %q %q", guid, flds, tags)
+	}
+	var cardCount int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM cards`).Scan(&cardCount); err != nil || cardCount != 2 {
+		t.Errorf("Expected 2 cards, got %d (%v)", cardCount, err)
+	}
+	if err := db.QueryRow(`SELECT decks FROM col`).Scan(&decks); err != nil || !strings.Contains(decks, `"name":"Quotes"`) {
+		t.Errorf("Expected the Quotes deck, got %s (%v)", decks, err)
+	}
+}
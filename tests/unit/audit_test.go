@@ -0,0 +1,232 @@
+package unit
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type mockAuditRepository struct {
+	historyFunc func(ctx context.Context, bookID int) ([]models.AuditEntry, error)
+	listFunc    func(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+}
+
+func (m *mockAuditRepository) GetBookHistory(ctx context.Context, bookID int) ([]models.AuditEntry, error) {
+	return m.historyFunc(ctx, bookID)
+}
+
+func (m *mockAuditRepository) ListAudit(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	return m.listFunc(ctx, filter)
+}
+
+var _ repository.AuditRepositoryInterface = &mockAuditRepository{}
+
+func TestAuditDiff(t *testing.T) {
+	before := &models.Book{ID: 1, Title: "Old Title", Author: "Author", Progress: 10}
+	after := &models.Book{ID: 1, Title: "New Title", Author: "Author", Progress: 40}
+
+	diff := audit.Diff(before, after)
+	if len(diff) != 2 {
+		t.Fatalf("Expected 2 changed fields, got %d: %+v", len(diff), diff)
+	}
+	if diff["title"].From != "Old Title" || diff["title"].To != "New Title" {
+		t.Errorf("Unexpected title change: %+v", diff["title"])
+	}
+	if diff["progress"].From != float64(10) || diff["progress"].To != float64(40) {
+		t.Errorf("Unexpected progress change: %+v", diff["progress"])
+	}
+
+	created := audit.Diff(nil, after)
+	if created["title"].From != nil || created["title"].To != "New Title" {
+		t.Errorf("Unexpected creation diff: %+v", created["title"])
+	}
+}
+
+func TestAuditReverted(t *testing.T) {
+	owner := 7
+	finished := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	unfinished, _ := json.Marshal(models.Book{ID: 1, Title: "Dune", Author: "Frank Herbert", Progress: 40})
+	current := &models.Book{ID: 1, Title: "Dune", Author: "Frank Herbert", Progress: 100, Finished: true,
+		FinishedAt: &finished, Series: "Dune", SeriesIndex: 1, Pages: 412, OwnerID: &owner}
+
+	book, err := audit.Reverted(current, unfinished)
+	if err != nil {
+		t.Fatalf("Reverted failed: %v", err)
+	}
+	if book.FinishedAt != nil || book.Finished || book.Progress != 40 || book.Series != "" || book.SeriesIndex != 0 || book.Pages != 0 {
+		t.Errorf("Expected fields empty at the recorded version to be cleared, got %+v", book)
+	}
+	if book.ID != 1 || book.OwnerID != &owner {
+		t.Errorf("Expected the book's ID and owner to be kept, got %d and %v", book.ID, book.OwnerID)
+	}
+	if _, err := audit.Reverted(current, json.RawMessage(`{`)); err == nil {
+		t.Error("Expected an error for malformed state")
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	var actor, requestID string
+	handler := audit.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = audit.Actor(r.Context())
+		requestID = audit.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("X-Actor", "alice")
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if actor != "alice" || requestID != "req-123" {
+		t.Errorf("Expected alice/req-123, got %s/%s", actor, requestID)
+	}
+	if w.Header().Get("X-Request-ID") != "req-123" {
+		t.Errorf("Expected request id to be echoed, got %q", w.Header().Get("X-Request-ID"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/books", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if actor != audit.DefaultActor || requestID == "" {
+		t.Errorf("Expected default actor and generated request id, got %s/%q", actor, requestID)
+	}
+}
+
+func TestGetBookHistory(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		historyFunc    func(ctx context.Context, bookID int) ([]models.AuditEntry, error)
+		expectedStatus int
+	}{
+		{
+			name: "Successful get",
+			id:   "1",
+			historyFunc: func(ctx context.Context, bookID int) ([]models.AuditEntry, error) {
+				return []models.AuditEntry{{ID: 1, BookID: bookID, Version: 1, Action: models.AuditCreate}}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid ID",
+			id:             "invalid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "No history",
+			id:             "2",
+			historyFunc:    func(ctx context.Context, bookID int) ([]models.AuditEntry, error) { return nil, nil },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Repository error",
+			id:   "1",
+			historyFunc: func(ctx context.Context, bookID int) ([]models.AuditEntry, error) {
+				return nil, errors.New("database error")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/books/"+tt.id+"/history", nil)
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockAuditRepository{
+				historyFunc: tt.historyFunc,
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/history", handlers.GetBookHistory(mockRepo)).Methods("GET")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestListAudit(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedFilter models.AuditFilter
+	}{
+		{
+			name:           "Default filter",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedFilter: models.AuditFilter{Limit: 100},
+		},
+		{
+			name:           "All filters",
+			query:          "?book_id=3&actor=alice&action=update&since=2024-01-01T00:00:00Z&until=2024-02-01T00:00:00Z&limit=10&offset=20",
+			expectedStatus: http.StatusOK,
+			expectedFilter: models.AuditFilter{
+				BookID: 3,
+				Actor:  "alice",
+				Action: "update",
+				Since:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Until:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+				Limit:  10,
+				Offset: 20,
+			},
+		},
+		{
+			name:           "Invalid since",
+			query:          "?since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/audit"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			var got models.AuditFilter
+			mockRepo := &mockAuditRepository{
+				listFunc: func(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+					got = filter
+					return nil, nil
+				},
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/audit", handlers.ListAudit(mockRepo)).Methods("GET")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				if !got.Since.Equal(tt.expectedFilter.Since) || !got.Until.Equal(tt.expectedFilter.Until) {
+					t.Errorf("Expected filter %+v, got %+v", tt.expectedFilter, got)
+				}
+				got.Since, got.Until = tt.expectedFilter.Since, tt.expectedFilter.Until
+				if got != tt.expectedFilter {
+					t.Errorf("Expected filter %+v, got %+v", tt.expectedFilter, got)
+				}
+				var entries []models.AuditEntry
+				if err := json.NewDecoder(w.Body).Decode(&entries); err != nil || entries == nil {
+					t.Errorf("Expected an empty JSON array, got %q", w.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,542 @@
+package unit
+
+import (
+	"book-tracker/internal/audit"
+	"book-tracker/internal/auth"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memUsers is an in-memory UserRepository. The first user is an admin.
+type memUsers struct {
+	users    []models.User
+	sessions map[string]models.Session
+	tokens   map[string]models.APIToken
+}
+
+func (m *memUsers) CreateUser(ctx context.Context, user *models.User) error {
+	for _, u := range m.users {
+		if u.Username == user.Username || (user.OIDCSubject != "" && u.OIDCIssuer == user.OIDCIssuer && u.OIDCSubject == user.OIDCSubject) {
+			return repository.ErrUsernameTaken
+		}
+	}
+	user.ID, user.Admin, user.CreatedAt = len(m.users)+1, len(m.users) == 0, time.Now()
+	m.users = append(m.users, *user)
+	return nil
+}
+
+func (m *memUsers) GetUser(ctx context.Context, id int) (*models.User, error) {
+	if id < 1 || id > len(m.users) {
+		return nil, repository.ErrUserNotFound
+	}
+	u := m.users[id-1]
+	return &u, nil
+}
+
+func (m *memUsers) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memUsers) GetUserByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.OIDCSubject != "" && u.OIDCIssuer == issuer && u.OIDCSubject == subject {
+			return &u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memUsers) CountUsers(ctx context.Context) (int, error) {
+	return len(m.users), nil
+}
+
+func (m *memUsers) CreateSession(ctx context.Context, tokenHash string, session *models.Session) error {
+	if m.sessions == nil {
+		m.sessions = make(map[string]models.Session)
+	}
+	session.CreatedAt = time.Now()
+	m.sessions[tokenHash] = *session
+	return nil
+}
+
+func (m *memUsers) GetSessionUser(ctx context.Context, tokenHash string) (*models.User, error) {
+	s, ok := m.sessions[tokenHash]
+	if !ok || !s.ExpiresAt.After(time.Now()) {
+		return nil, repository.ErrSessionNotFound
+	}
+	u := m.users[s.UserID-1]
+	return &u, nil
+}
+
+func (m *memUsers) DeleteSession(ctx context.Context, tokenHash string) error {
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+func (m *memUsers) CreateAPIToken(ctx context.Context, tokenHash string, token *models.APIToken) error {
+	if m.tokens == nil {
+		m.tokens = make(map[string]models.APIToken)
+	}
+	token.ID, token.CreatedAt = len(m.tokens)+1, time.Now()
+	stored := *token
+	stored.Token = ""
+	m.tokens[tokenHash] = stored
+	return nil
+}
+
+func (m *memUsers) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memUsers) UseAPIToken(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	t, ok := m.tokens[tokenHash]
+	if !ok || (t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())) {
+		return nil, repository.ErrTokenNotFound
+	}
+	now := time.Now()
+	t.LastUsedAt = &now
+	m.tokens[tokenHash] = t
+	return &t, nil
+}
+
+func (m *memUsers) DeleteAPIToken(ctx context.Context, userID, id int) error {
+	for hash, t := range m.tokens {
+		if t.ID == id && t.UserID == userID {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return repository.ErrTokenNotFound
+}
+
+var _ repository.UserRepositoryInterface = &memUsers{}
+
+func newAuthService(ttl time.Duration) (*auth.Service, *memUsers) {
+	users := &memUsers{}
+	return auth.NewService(&mockStore{repos: repository.Repos{Users: users}}, users, &memLibraries{}, ttl), users
+}
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Expected an argon2id PHC string, got %s", hash)
+	}
+	if other, _ := auth.HashPassword("correct horse"); other == hash {
+		t.Error("Expected a fresh salt per hash")
+	}
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		expected bool
+	}{
+		{name: "Argon2id match", hash: hash, password: "correct horse", expected: true},
+		{name: "Argon2id mismatch", hash: hash, password: "correct horsf"},
+		{name: "Bcrypt match", hash: string(legacy), password: "correct horse", expected: true},
+		{name: "Bcrypt mismatch", hash: string(legacy), password: "battery staple"},
+		{name: "Wrong version", hash: strings.Replace(hash, "v=19", "v=16", 1), password: "correct horse"},
+		{name: "Truncated", hash: hash[:strings.LastIndex(hash, "$")], password: "correct horse"},
+		{name: "Empty", hash: "", password: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auth.CheckPassword(tt.hash, tt.password); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCredentialsValidate(t *testing.T) {
+	tests := []struct {
+		creds models.Credentials
+		valid bool
+	}{
+		{models.Credentials{Username: "reader_1.a-b", Password: "12345678"}, true},
+		{models.Credentials{Username: "ab", Password: "12345678"}, false},
+		{models.Credentials{Username: "has space", Password: "12345678"}, false},
+		{models.Credentials{Username: "reader", Password: "1234567"}, false},
+		{models.Credentials{Username: "reader", Password: strings.Repeat("x", 257)}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.creds.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q, %d chars) = %v, expected valid %v", tt.creds.Username, len(tt.creds.Password), err, tt.valid)
+		}
+	}
+}
+
+func TestAuthService(t *testing.T) {
+	svc, users := newAuthService(time.Hour)
+	ctx := context.Background()
+	creds := models.Credentials{Username: "alice", Password: "correct horse"}
+
+	alice, err := svc.Register(ctx, creds)
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if !alice.Admin || users.users[0].PasswordHash == creds.Password {
+		t.Errorf("Expected the first user to be an admin with a hashed password, got %+v", users.users[0])
+	}
+	if _, err := svc.Register(ctx, creds); !errors.Is(err, repository.ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken, got %v", err)
+	}
+	if _, err := svc.Register(ctx, models.Credentials{Username: "bob", Password: "short"}); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	session, user, err := svc.Login(ctx, creds)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if session.Token == "" || user.ID != alice.ID || time.Until(session.ExpiresAt) > time.Hour {
+		t.Errorf("Unexpected session %+v for %+v", session, user)
+	}
+	if _, ok := users.sessions[session.Token]; ok {
+		t.Error("Expected the token to be stored hashed")
+	}
+	if got, err := svc.Authenticate(ctx, session.Token); err != nil || got.Username != "alice" {
+		t.Errorf("Expected the token to authenticate alice, got %+v, %v", got, err)
+	}
+
+	for _, wrong := range []models.Credentials{{Username: "alice", Password: "wrong horse"}, {Username: "nobody", Password: "correct horse"}} {
+		if _, _, err := svc.Login(ctx, wrong); !errors.Is(err, auth.ErrInvalidLogin) {
+			t.Errorf("Expected ErrInvalidLogin for %q, got %v", wrong.Username, err)
+		}
+	}
+
+	if err := svc.Logout(ctx, session.Token); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, session.Token); !errors.Is(err, auth.ErrInvalidLogin) {
+		t.Errorf("Expected a signed out token to be rejected, got %v", err)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	svc, _ := newAuthService(time.Hour)
+	ctx := context.Background()
+	svc.Register(ctx, models.Credentials{Username: "alice", Password: "correct horse"})
+	svc.Register(ctx, models.Credentials{Username: "bob", Password: "battery staple"})
+	aliceSession, _, _ := svc.Login(ctx, models.Credentials{Username: "alice", Password: "correct horse"})
+	bobSession, _, _ := svc.Login(ctx, models.Credentials{Username: "bob", Password: "battery staple"})
+
+	var seen struct {
+		user  string
+		owner int
+		actor string
+	}
+	router := mux.NewRouter()
+	router.Use(audit.Middleware)
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
+		seen.user = auth.User(r.Context()).Username
+		seen.owner, _ = repository.Owner(r.Context())
+		seen.actor = audit.Actor(r.Context())
+	})
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequireAdmin)
+	admin.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name           string
+		path           string
+		setAuth        func(r *http.Request)
+		expectedStatus int
+		expectedUser   string
+	}{
+		{name: "Session token", path: "/books", setAuth: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+aliceSession.Token)
+		}, expectedStatus: http.StatusOK, expectedUser: "alice"},
+		{name: "Basic auth", path: "/books", setAuth: func(r *http.Request) {
+			r.SetBasicAuth("bob", "battery staple")
+		}, expectedStatus: http.StatusOK, expectedUser: "bob"},
+		{name: "Actor header is ignored", path: "/books", setAuth: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+bobSession.Token)
+			r.Header.Set("X-Actor", "alice")
+		}, expectedStatus: http.StatusOK, expectedUser: "bob"},
+		{name: "No credentials", path: "/books", setAuth: func(r *http.Request) {}, expectedStatus: http.StatusUnauthorized},
+		{name: "Unknown token", path: "/books", setAuth: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer forged")
+		}, expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong password", path: "/books", setAuth: func(r *http.Request) {
+			r.SetBasicAuth("bob", "correct horse")
+		}, expectedStatus: http.StatusUnauthorized},
+		{name: "Admin", path: "/admin/backup", setAuth: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+aliceSession.Token)
+		}, expectedStatus: http.StatusOK},
+		{name: "Not an admin", path: "/admin/backup", setAuth: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+bobSession.Token)
+		}, expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen.user = ""
+			req := httptest.NewRequest("GET", tt.path, nil)
+			tt.setAuth(req)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if rr.Code == http.StatusUnauthorized && !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Basic") {
+				t.Errorf("Expected a basic auth challenge, got %q", rr.Header().Get("WWW-Authenticate"))
+			}
+			if tt.expectedUser == "" {
+				return
+			}
+			wantOwner := map[string]int{"alice": 1, "bob": 2}[tt.expectedUser]
+			if seen.user != tt.expectedUser || seen.owner != wantOwner || seen.actor != tt.expectedUser {
+				t.Errorf("Expected %s as user, owner and actor, got %+v", tt.expectedUser, seen)
+			}
+		})
+	}
+}
+
+func TestAuthHandlers(t *testing.T) {
+	svc, _ := newAuthService(time.Hour)
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/register", handlers.Register(svc)).Methods("POST")
+	router.HandleFunc("/auth/login", handlers.Login(svc)).Methods("POST")
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/auth/logout", handlers.Logout(svc)).Methods("POST")
+	api.HandleFunc("/auth/me", handlers.GetMe()).Methods("GET")
+	serve := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	const creds = `{"username":"alice","password":"correct horse"}`
+
+	if rr := serve("POST", "/auth/register", creds, ""); rr.Code != http.StatusCreated || strings.Contains(rr.Body.String(), "argon2") {
+		t.Fatalf("Expected status 201 without the hash, got %d: %s", rr.Code, rr.Body)
+	}
+	if rr := serve("POST", "/auth/register", creds, ""); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a taken name, got %d", rr.Code)
+	}
+	if rr := serve("POST", "/auth/register", `{"username":"bob","password":"x"}`, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a short password, got %d", rr.Code)
+	}
+	if rr := serve("POST", "/auth/login", `{"username":"alice","password":"wrong horse"}`, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong password, got %d", rr.Code)
+	}
+
+	rr := serve("POST", "/auth/login", creds, "")
+	var login struct {
+		Token string      `json:"token"`
+		User  models.User `json:"user"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil || login.Token == "" || login.User.Username != "alice" {
+		t.Fatalf("Expected a session for alice, got %d: %+v, %v", rr.Code, login, err)
+	}
+	rr = serve("GET", "/auth/me", "", login.Token)
+	var me models.User
+	json.NewDecoder(rr.Body).Decode(&me)
+	if me.Username != "alice" || !me.Admin {
+		t.Errorf("Expected alice as admin, got %+v", me)
+	}
+	if rr := serve("POST", "/auth/logout", "", login.Token); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+	if rr := serve("GET", "/auth/me", "", login.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 after signing out, got %d", rr.Code)
+	}
+	if rr := serve("POST", "/auth/login", "{", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed body, got %d", rr.Code)
+	}
+}
+
+func TestAPITokenRequestValidate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name           string
+		req            models.APITokenRequest
+		expectedScopes []string
+	}{
+		{name: "Scopes sorted without repeats", req: models.APITokenRequest{Name: " backup script ", Scopes: []string{"books:write", "books:read", "books:write"}},
+			expectedScopes: []string{"books:read", "books:write"}},
+		{name: "Expiry in the future", req: models.APITokenRequest{Name: "ci", Scopes: []string{"books:read"}, ExpiresAt: &future},
+			expectedScopes: []string{"books:read"}},
+		{name: "No name", req: models.APITokenRequest{Name: "  ", Scopes: []string{"books:read"}}},
+		{name: "Long name", req: models.APITokenRequest{Name: strings.Repeat("x", 101), Scopes: []string{"books:read"}}},
+		{name: "No scopes", req: models.APITokenRequest{Name: "ci"}},
+		{name: "Unknown scope", req: models.APITokenRequest{Name: "ci", Scopes: []string{"books:read", "books:delete"}}},
+		{name: "Expired", req: models.APITokenRequest{Name: "ci", Scopes: []string{"books:read"}, ExpiresAt: &past}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.expectedScopes == nil {
+				if !errors.Is(err, models.ErrInvalidToken) {
+					t.Errorf("Expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if strings.Join(tt.req.Scopes, " ") != strings.Join(tt.expectedScopes, " ") || strings.TrimSpace(tt.req.Name) != tt.req.Name {
+				t.Errorf("Expected scopes %v and a trimmed name, got %+v", tt.expectedScopes, tt.req)
+			}
+		})
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	svc, users := newAuthService(time.Hour)
+	ctx := context.Background()
+	alice, _ := svc.Register(ctx, models.Credentials{Username: "alice", Password: "correct horse"})
+	bob, _ := svc.Register(ctx, models.Credentials{Username: "bob", Password: "battery staple"})
+	session, _, _ := svc.Login(ctx, models.Credentials{Username: "alice", Password: "correct horse"})
+	newToken := func(user *models.User, scopes ...string) string {
+		token, err := svc.CreateToken(ctx, user, models.APITokenRequest{Name: "script", Scopes: scopes})
+		if err != nil {
+			t.Fatalf("CreateToken failed: %v", err)
+		}
+		return token.Token
+	}
+	reader := newToken(alice, models.ScopeBooksRead)
+	writer := newToken(alice, models.ScopeBooksRead, models.ScopeBooksWrite)
+	admin := newToken(alice, models.Scopes...)
+	bobAdmin := newToken(bob, models.Scopes...)
+	for hash, stored := range users.tokens {
+		if stored.Token != "" || strings.HasPrefix(hash, auth.TokenPrefix) || !strings.HasPrefix(stored.Prefix, auth.TokenPrefix) {
+			t.Errorf("Expected tokens to be stored hashed, got %s: %+v", hash, stored)
+		}
+	}
+
+	router := mux.NewRouter()
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
+		if owner, _ := repository.Owner(r.Context()); owner != auth.User(r.Context()).ID {
+			t.Errorf("Expected the token's owner in the context, got %d", owner)
+		}
+	}).Methods("GET", "POST")
+	tokens := api.PathPrefix("/auth/tokens").Subrouter()
+	tokens.Use(auth.RequireSession)
+	tokens.HandleFunc("", handlers.CreateAPIToken(svc)).Methods("POST")
+	tokens.HandleFunc("", handlers.ListAPITokens(svc)).Methods("GET")
+	tokens.HandleFunc("/{id}", handlers.RevokeAPIToken(svc)).Methods("DELETE")
+	admins := api.PathPrefix("/admin").Subrouter()
+	admins.Use(auth.RequireAdmin)
+	admins.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "Read with books:read", method: "GET", path: "/books", token: reader, expectedStatus: http.StatusOK},
+		{name: "Write without books:write", method: "POST", path: "/books", token: reader, expectedStatus: http.StatusForbidden},
+		{name: "Write with books:write", method: "POST", path: "/books", token: writer, expectedStatus: http.StatusOK},
+		{name: "Backup without admin", method: "GET", path: "/admin/backup", token: writer, expectedStatus: http.StatusForbidden},
+		{name: "Backup with admin", method: "GET", path: "/admin/backup", token: admin, expectedStatus: http.StatusOK},
+		{name: "Admin scope of a non-admin", method: "GET", path: "/admin/backup", token: bobAdmin, expectedStatus: http.StatusForbidden},
+		{name: "Tokens cannot list tokens", method: "GET", path: "/auth/tokens", token: admin, expectedStatus: http.StatusForbidden},
+		{name: "Unknown token", method: "GET", path: "/books", token: auth.TokenPrefix + "forged", expectedStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := serve(tt.method, tt.path, "", tt.token); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+
+	rr := serve("POST", "/auth/tokens", `{"name":"nightly export","scopes":["books:read"]}`, session.Token)
+	var created models.APIToken
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || rr.Code != http.StatusCreated || !strings.HasPrefix(created.Token, auth.TokenPrefix) {
+		t.Fatalf("Expected status 201 with the secret, got %d: %+v, %v", rr.Code, created, err)
+	}
+	if rr := serve("POST", "/auth/tokens", `{"name":"bad","scopes":["everything"]}`, session.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown scope, got %d", rr.Code)
+	}
+	if rr := serve("GET", "/books", "", created.Token); rr.Code != http.StatusOK {
+		t.Errorf("Expected the new token to work, got %d", rr.Code)
+	}
+
+	rr = serve("GET", "/auth/tokens", "", session.Token)
+	var listed []models.APIToken
+	json.NewDecoder(rr.Body).Decode(&listed)
+	var used *models.APIToken
+	for i, token := range listed {
+		if token.Token != "" {
+			t.Errorf("Expected listed tokens without their secrets, got %+v", token)
+		}
+		if token.ID == created.ID {
+			used = &listed[i]
+		}
+	}
+	if len(listed) != 4 || used == nil || used.LastUsedAt == nil {
+		t.Fatalf("Expected alice's 4 tokens with the new one marked used, got %+v", listed)
+	}
+
+	path := "/auth/tokens/" + strconv.Itoa(created.ID)
+	if rr := serve("DELETE", "/auth/tokens/"+strconv.Itoa(created.ID+100), "", session.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown token, got %d", rr.Code)
+	}
+	if rr := serve("DELETE", path, "", session.Token); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+	if rr := serve("GET", "/books", "", created.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be rejected, got %d", rr.Code)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	expiring, _ := svc.CreateToken(ctx, alice, models.APITokenRequest{Name: "soon", Scopes: []string{models.ScopeBooksRead}, ExpiresAt: &expiry})
+	for hash, stored := range users.tokens {
+		if stored.ID == expiring.ID {
+			past := time.Now().Add(-time.Minute)
+			stored.ExpiresAt = &past
+			users.tokens[hash] = stored
+		}
+	}
+	if _, _, err := svc.AuthenticateToken(ctx, expiring.Token); !errors.Is(err, auth.ErrInvalidLogin) {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,389 @@
+package unit
+
+import (
+	"book-tracker/internal/backup"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memBackup is an in-memory BackupRepository handing out sequential ids.
+type memBackup struct {
+	users      []models.User
+	members    []models.Membership
+	books      []models.Book
+	highlights []models.Highlight
+	covers     []models.Cover
+	audit      []models.AuditEntry
+	nextID     int
+}
+
+func (m *memBackup) ExportUsers(ctx context.Context, fn func(models.User) error) error {
+	for _, u := range m.users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) ExportMembers(ctx context.Context, fn func(models.Membership) error) error {
+	for _, mm := range m.members {
+		if err := fn(mm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) ExportBooks(ctx context.Context, fn func(models.Book) error) error {
+	for _, b := range m.books {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) ExportHighlights(ctx context.Context, fn func(models.Highlight) error) error {
+	for _, h := range m.highlights {
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) ExportAudit(ctx context.Context, fn func(models.AuditEntry) error) error {
+	for _, e := range m.audit {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) ExportCovers(ctx context.Context, fn func(models.Cover) error) error {
+	for _, c := range m.covers {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memBackup) CountBooks(ctx context.Context) (int, error) { return len(m.books), nil }
+
+func (m *memBackup) DeleteAll(ctx context.Context) error {
+	m.books, m.highlights, m.covers, m.audit = nil, nil, nil, nil
+	return nil
+}
+
+func (m *memBackup) InsertUser(ctx context.Context, user *models.User) (bool, error) {
+	for _, u := range m.users {
+		if user.OIDCSubject != "" && u.OIDCIssuer == user.OIDCIssuer && u.OIDCSubject == user.OIDCSubject {
+			user.ID = u.ID
+			return false, nil
+		}
+	}
+	for _, u := range m.users {
+		if u.Username == user.Username {
+			user.ID = u.ID
+			return u.PasswordHash != user.PasswordHash || u.OIDCIssuer != user.OIDCIssuer || u.OIDCSubject != user.OIDCSubject, nil
+		}
+	}
+	user.ID = m.reserve()
+	m.users = append(m.users, *user)
+	return false, nil
+}
+
+func (m *memBackup) InsertMember(ctx context.Context, member *models.Membership) error {
+	m.members = append(m.members, *member)
+	return nil
+}
+
+func (m *memBackup) InsertBook(ctx context.Context, book *models.Book) error {
+	book.ID = m.reserve()
+	m.books = append(m.books, *book)
+	return nil
+}
+
+func (m *memBackup) InsertHighlight(ctx context.Context, highlight *models.Highlight) error {
+	highlight.ID = m.reserve()
+	m.highlights = append(m.highlights, *highlight)
+	return nil
+}
+
+func (m *memBackup) InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	entry.ID = int64(m.reserve())
+	m.audit = append(m.audit, *entry)
+	return nil
+}
+
+func (m *memBackup) InsertCover(ctx context.Context, cover *models.Cover) error {
+	m.covers = append(m.covers, *cover)
+	return nil
+}
+
+func (m *memBackup) ReserveBookID(ctx context.Context) (int, error) { return m.reserve(), nil }
+
+func (m *memBackup) reserve() int {
+	m.nextID++
+	return m.nextID
+}
+
+var _ repository.BackupRepositoryInterface = &memBackup{}
+
+func seededBackup() *memBackup {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	deleted := created.Add(time.Hour)
+	emma := 5
+	return &memBackup{
+		books: []models.Book{
+			{ID: 3, Title: "Dune", Author: "Frank Herbert", Tags: models.Tags{"sf"}, CreatedAt: created, UpdatedAt: created},
+			{ID: 5, Title: "Emma", Author: "Jane Austen", CreatedAt: created, UpdatedAt: created, DeletedAt: &deleted},
+		},
+		highlights: []models.Highlight{
+			{ID: 1, BookID: 3, Kind: models.HighlightKindHighlight, Text: "Fear is the mind-killer.", Fingerprint: "abc", CreatedAt: created, UpdatedAt: created},
+		},
+		covers: []models.Cover{
+			{BookID: 3, ContentType: "image/png", SHA256: "abc", UpdatedAt: created},
+		},
+		audit: []models.AuditEntry{
+			{ID: 1, BookID: 3, Version: 1, Action: "create", After: json.RawMessage(`{"id":3,"title":"Dune"}`), CreatedAt: created},
+			{ID: 2, BookID: 9, Version: 1, Action: "create", After: json.RawMessage(`{"id":9,"title":"Gone"}`), CreatedAt: created},
+			{ID: 3, BookID: 9, Version: 2, Action: "purge", Before: json.RawMessage(`{"id":9,"title":"Gone"}`), CreatedAt: created},
+			{ID: 4, BookID: 3, Version: 2, Action: "create", After: json.RawMessage(`{"id":5,"title":"Emma"}`), CreatedAt: created,
+				MergedFrom: &emma},
+		},
+	}
+}
+
+func writeBackup(t *testing.T, repo *memBackup) []byte {
+	t.Helper()
+	// Cover images live in blob storage
+	blobs := newTestBlobs(t)
+	for _, c := range repo.covers {
+		if err := blobs.Put(context.Background(), covers.OriginalKey(&c), []byte("\x89PNG"), c.ContentType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := backup.Write(context.Background(), &mockStore{repos: repository.Repos{Backup: repo}}, blobs, &buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	archive := writeBackup(t, seededBackup())
+
+	target := &memBackup{nextID: 100}
+	summary, err := backup.Restore(context.Background(), &mockStore{repos: repository.Repos{Backup: target}},
+		bytes.NewReader(archive), backup.Options{})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if summary.SchemaVersion != backup.SchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", backup.SchemaVersion, summary.SchemaVersion)
+	}
+	want := map[string]int{backup.TypeBook: 2, backup.TypeHighlight: 1, backup.TypeCover: 1, backup.TypeAudit: 4}
+	for typ, n := range want {
+		if summary.Counts[typ] != n {
+			t.Errorf("Expected %d %s rows, got %d", n, typ, summary.Counts[typ])
+		}
+	}
+
+	if len(target.books) != 2 || target.books[0].ID != 101 || target.books[1].ID != 102 {
+		t.Fatalf("Expected books with new ids 101 and 102, got %+v", target.books)
+	}
+	if target.books[1].DeletedAt == nil || target.books[0].Tags[0] != "sf" {
+		t.Errorf("Expected trash state and tags to survive, got %+v", target.books)
+	}
+	h := target.highlights[0]
+	if h.BookID != 101 || h.Fingerprint != "abc" {
+		t.Errorf("Expected highlight on book 101 with its fingerprint, got %+v", h)
+	}
+	if c := target.covers[0]; c.BookID != 101 || string(c.Data) != "\x89PNG" || c.ContentType != "image/png" {
+		t.Errorf("Expected cover image on book 101, got %+v", c)
+	}
+	if target.audit[0].BookID != 101 || string(target.audit[0].After) != `{"id":101,"title":"Dune"}` {
+		t.Errorf("Expected audit entry remapped to book 101, got %+v", target.audit[0])
+	}
+	// Both entries of the purged book share one reserved id
+	purged := target.audit[1].BookID
+	if purged == 101 || purged == 102 || target.audit[2].BookID != purged {
+		t.Errorf("Expected purged book history under one fresh id, got %d and %d", purged, target.audit[2].BookID)
+	}
+	if string(target.audit[2].After) != "null" && len(target.audit[2].After) != 0 {
+		t.Errorf("Expected missing after state to stay empty, got %s", target.audit[2].After)
+	}
+	// History copied from a merged book keeps pointing at it
+	if e := target.audit[3]; e.BookID != 101 || e.MergedFrom == nil || *e.MergedFrom != 102 || string(e.After) != `{"id":102,"title":"Emma"}` {
+		t.Errorf("Expected merged history on book 101 from book 102, got %+v with %s", e, e.After)
+	}
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	archive := string(writeBackup(t, seededBackup()))
+	lines := strings.SplitAfter(archive, "\n")
+
+	tests := []struct {
+		name    string
+		archive string
+	}{
+		{name: "Tampered row", archive: strings.Replace(archive, "Dune", "Dunf", 1)},
+		{name: "Truncated", archive: strings.Join(lines[:len(lines)-2], "")},
+		{name: "Newer schema version", archive: strings.Replace(archive, `"schema_version":1`, `"schema_version":2`, 1)},
+		{name: "Not a backup", archive: "title,author\nDune,Frank Herbert\n"},
+		{name: "Empty", archive: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &memBackup{}
+			_, err := backup.Restore(context.Background(), &mockStore{repos: repository.Repos{Backup: target}},
+				strings.NewReader(tt.archive), backup.Options{})
+			if !errors.Is(err, backup.ErrInvalidArchive) {
+				t.Errorf("Expected ErrInvalidArchive, got %v", err)
+			}
+			if len(target.books) != 0 {
+				t.Errorf("Expected nothing restored, got %d books", len(target.books))
+			}
+		})
+	}
+}
+
+func TestRestoreData(t *testing.T) {
+	archive := writeBackup(t, seededBackup())
+
+	tests := []struct {
+		name           string
+		query          string
+		existing       []models.Book
+		body           string
+		expectedStatus int
+		expectedBooks  int
+	}{
+		{name: "Into empty store", expectedStatus: http.StatusOK, expectedBooks: 2},
+		{name: "Into non-empty store", existing: []models.Book{{ID: 1}}, expectedStatus: http.StatusConflict, expectedBooks: 1},
+		{name: "Replace", query: "?replace=true", existing: []models.Book{{ID: 1}}, expectedStatus: http.StatusOK, expectedBooks: 2},
+		{name: "Invalid replace", query: "?replace=maybe", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid archive", body: "{}\n", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &memBackup{books: tt.existing}
+			body := tt.body
+			if body == "" {
+				body = string(archive)
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/admin/restore", handlers.RestoreData(&mockStore{repos: repository.Repos{Backup: target}}, covers.NewService(newMemCovers(), newTestBlobs(t)))).Methods("POST")
+
+			req := httptest.NewRequest("POST", "/admin/restore"+tt.query, strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if len(target.books) != tt.expectedBooks {
+				t.Errorf("Expected %d books, got %d", tt.expectedBooks, len(target.books))
+			}
+		})
+	}
+}
+
+func TestRestoreOwners(t *testing.T) {
+	alice, bob := 7, 8
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	source := &memBackup{
+		users: []models.User{
+			{ID: alice, Username: "alice", Admin: true, PasswordHash: "$argon2id$alice", OIDCIssuer: "https://id.example", OIDCSubject: "a-1", CreatedAt: created},
+			{ID: bob, Username: "bob", PasswordHash: "$argon2id$bob", CreatedAt: created},
+		},
+		members: []models.Membership{
+			{LibraryID: alice, UserID: bob, Username: "bob", Role: models.RoleEditor, CreatedAt: created},
+		},
+		books: []models.Book{
+			{ID: 1, Title: "Dune", Author: "Frank Herbert", OwnerID: &alice},
+			{ID: 2, Title: "Emma", Author: "Jane Austen", OwnerID: &bob},
+			{ID: 3, Title: "Ulysses", Author: "James Joyce"},
+		},
+		audit: []models.AuditEntry{
+			{ID: 1, BookID: 2, Version: 1, Action: "create", After: json.RawMessage(`{"id":2,"owner_id":8}`), OwnerID: &bob},
+		},
+	}
+	archive := writeBackup(t, source)
+
+	// Bob already exists; the restoring admin gets the books without owner
+	target := &memBackup{users: []models.User{{ID: 50, Username: "bob", PasswordHash: "$argon2id$new"}}, nextID: 100}
+	ctx := repository.WithOwner(context.Background(), 60)
+	summary, err := backup.Restore(ctx, &mockStore{repos: repository.Repos{Backup: target}}, bytes.NewReader(archive), backup.Options{})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if summary.Counts[backup.TypeUser] != 2 || len(target.users) != 2 {
+		t.Fatalf("Expected 2 users restored into 2 users, got %d into %+v", summary.Counts[backup.TypeUser], target.users)
+	}
+	if u := target.users[0]; u.PasswordHash != "$argon2id$new" {
+		t.Errorf("Expected the existing user to be kept, got %+v", u)
+	}
+	if !reflect.DeepEqual(summary.UsernameCollisions, []string{"bob"}) {
+		t.Errorf("Expected bob's name to be reported as taken, got %v", summary.UsernameCollisions)
+	}
+	if u := target.users[1]; u.Username != "alice" || !u.Admin || u.PasswordHash != "$argon2id$alice" || u.OIDCSubject != "a-1" {
+		t.Errorf("Expected alice with her password hash and identity, got %+v", u)
+	}
+	if len(target.members) != 1 || target.members[0] != (models.Membership{LibraryID: target.users[1].ID, UserID: 50, Username: "bob", Role: models.RoleEditor, CreatedAt: created}) {
+		t.Errorf("Expected bob to edit alice's library, got %+v", target.members)
+	}
+	owners := []int{target.users[1].ID, 50, 60}
+	for i, b := range target.books {
+		if b.OwnerID == nil || *b.OwnerID != owners[i] {
+			t.Errorf("Expected %s owned by %d, got %v", b.Title, owners[i], b.OwnerID)
+		}
+	}
+	if e := target.audit[0]; e.OwnerID == nil || *e.OwnerID != 50 || string(e.After) != fmt.Sprintf(`{"id":%d,"owner_id":50}`, e.BookID) {
+		t.Errorf("Expected audit entry owned by 50, got %+v with %s", e, e.After)
+	}
+
+	// Users are found by OIDC identity first, whatever their name, and a
+	// user with the same name and password is no collision
+	target = &memBackup{users: []models.User{
+		{ID: 50, Username: "alice-sso", OIDCIssuer: "https://id.example", OIDCSubject: "a-1"},
+		{ID: 51, Username: "bob", PasswordHash: "$argon2id$bob"},
+	}, nextID: 100}
+	summary, err = backup.Restore(ctx, &mockStore{repos: repository.Repos{Backup: target}}, bytes.NewReader(archive), backup.Options{})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if len(target.users) != 2 || len(summary.UsernameCollisions) != 0 {
+		t.Errorf("Expected both users to be found without collisions, got %+v and %v", target.users, summary.UsernameCollisions)
+	}
+	if b := target.books[0]; b.OwnerID == nil || *b.OwnerID != 50 {
+		t.Errorf("Expected alice's book to go to her OIDC account, got %v", b.OwnerID)
+	}
+
+	// Books of users missing from the archive cannot be restored
+	missing := &memBackup{books: []models.Book{{ID: 1, Title: "Dune", Author: "Frank Herbert", OwnerID: &bob}}}
+	_, err = backup.Restore(context.Background(), &mockStore{repos: repository.Repos{Backup: &memBackup{}}},
+		bytes.NewReader(writeBackup(t, missing)), backup.Options{})
+	if !errors.Is(err, backup.ErrInvalidArchive) {
+		t.Errorf("Expected ErrInvalidArchive, got %v", err)
+	}
+}
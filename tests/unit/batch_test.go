@@ -0,0 +1,193 @@
+package unit
+
+import (
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// mockStore runs units of work directly against the given repositories.
+type mockStore struct {
+	repos repository.Repos
+	runs  int
+}
+
+func (m *mockStore) RunInTx(ctx context.Context, fn func(repos repository.Repos) error) error {
+	m.runs++
+	return fn(m.repos)
+}
+
+var _ repository.StoreInterface = &mockStore{}
+
+type batchResponse struct {
+	Committed bool `json:"committed"`
+	Results   []struct {
+		Index  int    `json:"index"`
+		Status int    `json:"status"`
+		ID     int    `json:"id"`
+		Error  string `json:"error"`
+	} `json:"results"`
+}
+
+func TestBatchBooks(t *testing.T) {
+	const body = `{"atomic":%s,"operations":[
+		{"op":"create","book":{"title":"A","author":"X"}},
+		{"op":"create","book":{"title":"B","author":"Y"}},
+		{"op":"update","id":7,"book":{"title":"C","author":"Z"}},
+		{"op":"delete","id":8}
+	]}`
+
+	tests := []struct {
+		name             string
+		atomic           string
+		updateErr        error
+		expectedCommit   bool
+		expectedStatuses []int
+		expectedBulk     int
+		expectedSingle   int
+	}{
+		{
+			name:             "Independent operations",
+			atomic:           "false",
+			expectedCommit:   true,
+			expectedStatuses: []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusNoContent},
+			expectedSingle:   2,
+		},
+		{
+			name:             "Independent operations with a failure",
+			atomic:           "false",
+			updateErr:        repository.ErrNotFound,
+			expectedCommit:   true,
+			expectedStatuses: []int{http.StatusCreated, http.StatusCreated, http.StatusNotFound, http.StatusNoContent},
+			expectedSingle:   2,
+		},
+		{
+			name:             "Atomic success uses bulk insert",
+			atomic:           "true",
+			expectedCommit:   true,
+			expectedStatuses: []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusNoContent},
+			expectedBulk:     2,
+		},
+		{
+			name:             "Atomic failure rolls back everything",
+			atomic:           "true",
+			updateErr:        errors.New("database error"),
+			expectedCommit:   false,
+			expectedStatuses: []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusInternalServerError, http.StatusFailedDependency},
+			expectedBulk:     2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextID, bulk, single := 100, 0, 0
+			mockRepo := &mockBookRepository{
+				createFunc: func(ctx context.Context, b *models.Book) error { single++; nextID++; b.ID = nextID; return nil },
+				bulkFunc: func(ctx context.Context, books []*models.Book) error {
+					for _, b := range books {
+						bulk++
+						nextID++
+						b.ID = nextID
+					}
+					return nil
+				},
+				updateFunc: func(ctx context.Context, b *models.Book) error { return tt.updateErr },
+				deleteFunc: func(ctx context.Context, id int) error { return nil },
+			}
+			store := &mockStore{repos: repository.Repos{Books: mockRepo}}
+
+			req := httptest.NewRequest(http.MethodPost, "/books/batch", bytes.NewReader([]byte(fmt.Sprintf(body, tt.atomic))))
+			w := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/books/batch", handlers.BatchBooks(mockRepo, store)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusMultiStatus {
+				t.Fatalf("Expected status %d, got %d", http.StatusMultiStatus, w.Code)
+			}
+			var resp batchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Committed != tt.expectedCommit {
+				t.Errorf("Expected committed %v, got %v", tt.expectedCommit, resp.Committed)
+			}
+			if len(resp.Results) != len(tt.expectedStatuses) {
+				t.Fatalf("Expected %d results, got %d", len(tt.expectedStatuses), len(resp.Results))
+			}
+			for i, r := range resp.Results {
+				if r.Index != i || r.Status != tt.expectedStatuses[i] {
+					t.Errorf("Result %d: expected status %d, got %+v", i, tt.expectedStatuses[i], r)
+				}
+			}
+			if bulk != tt.expectedBulk || single != tt.expectedSingle {
+				t.Errorf("Expected %d bulk and %d single creates, got %d and %d", tt.expectedBulk, tt.expectedSingle, bulk, single)
+			}
+		})
+	}
+}
+
+func TestBatchBooksValidation(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectedStatus   int
+		expectedStatuses []int
+	}{
+		{
+			name:           "Invalid request body",
+			body:           `not json`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "No operations",
+			body:           `{"operations":[]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:             "Invalid operation fails atomic batch",
+			body:             `{"atomic":true,"operations":[{"op":"delete","id":1},{"op":"create","book":{"title":""}},{"op":"rename"}]}`,
+			expectedStatus:   http.StatusMultiStatus,
+			expectedStatuses: []int{http.StatusFailedDependency, http.StatusBadRequest, http.StatusBadRequest},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &mockStore{}
+			req := httptest.NewRequest(http.MethodPost, "/books/batch", bytes.NewReader([]byte(tt.body)))
+			w := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/books/batch", handlers.BatchBooks(&mockBookRepository{}, store)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if store.runs != 0 {
+				t.Errorf("Expected no transaction for an invalid batch, got %d", store.runs)
+			}
+			if tt.expectedStatuses != nil {
+				var resp batchResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				for i, r := range resp.Results {
+					if r.Status != tt.expectedStatuses[i] {
+						t.Errorf("Result %d: expected status %d, got %d", i, tt.expectedStatuses[i], r.Status)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// calibreFixture is the subset of Calibre's metadata.db schema the importer
+// reads, with two books.
+var calibreFixture = []string{
+	`CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, series_index REAL DEFAULT 1.0,
+		pubdate TIMESTAMP, timestamp TIMESTAMP, uuid TEXT)`,
+	`CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)`,
+	`CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER)`,
+	`CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT)`,
+	`CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER, tag INTEGER)`,
+	`CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT)`,
+	`CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER, series INTEGER)`,
+	`CREATE TABLE publishers (id INTEGER PRIMARY KEY, name TEXT)`,
+	`CREATE TABLE books_publishers_link (id INTEGER PRIMARY KEY, book INTEGER, publisher INTEGER)`,
+	`CREATE TABLE ratings (id INTEGER PRIMARY KEY, rating INTEGER)`,
+	`CREATE TABLE books_ratings_link (id INTEGER PRIMARY KEY, book INTEGER, rating INTEGER)`,
+	`CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER, type TEXT, val TEXT)`,
+	`CREATE TABLE comments (id INTEGER PRIMARY KEY, book INTEGER, text TEXT)`,
+	`INSERT INTO books VALUES (1, 'Good Omens', 1.0, '1990-05-01 00:00:00+00:00',
+		'2023-01-15 10:20:30.123456+00:00', 'uuid-omens')`,
+	`INSERT INTO books VALUES (2, 'The Colour of Magic', 1.0, '0101-01-01 00:00:00+00:00',
+		'2023-02-01 08:00:00+00:00', 'uuid-colour')`,
+	`INSERT INTO authors VALUES (1, 'Terry Pratchett'), (2, 'Neil Gaiman')`,
+	`INSERT INTO books_authors_link VALUES (1, 1, 1), (2, 1, 2), (3, 2, 1)`,
+	`INSERT INTO tags VALUES (1, 'Fantasy'), (2, 'Humor')`,
+	`INSERT INTO books_tags_link VALUES (1, 1, 2), (2, 2, 1), (3, 2, 2)`,
+	`INSERT INTO series VALUES (1, 'Discworld')`,
+	`INSERT INTO books_series_link VALUES (1, 2, 1)`,
+	`INSERT INTO publishers VALUES (1, 'Gollancz')`,
+	`INSERT INTO books_publishers_link VALUES (1, 1, 1)`,
+	`INSERT INTO ratings VALUES (1, 8), (2, 9)`,
+	`INSERT INTO books_ratings_link VALUES (1, 1, 1), (2, 2, 2)`,
+	`INSERT INTO identifiers VALUES (1, 1, 'isbn', '9780060853983'), (2, 1, 'goodreads', '12067')`,
+	`INSERT INTO comments VALUES (1, 1, '<p>The world ends on <b>Saturday</b>.</p>')`,
+}
+
+func writeCalibreFixture(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer db.Close()
+	for _, stmt := range calibreFixture {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create fixture: %v", err)
+		}
+	}
+	return path
+}
+
+func TestParseCalibre(t *testing.T) {
+	records, err := importer.ParseCalibre(context.Background(), writeCalibreFixture(t))
+	if err != nil {
+		t.Fatalf("Failed to read library: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	omens := records[0].Book
+	if omens.Author != "Terry Pratchett, Neil Gaiman" || omens.ISBN != "9780060853983" || omens.Rating != 4 {
+		t.Errorf("Unexpected authors, ISBN or rating: %+v", omens)
+	}
+	if omens.Publisher != "Gollancz" || omens.Year != 1990 || omens.Notes != "The world ends on Saturday." {
+		t.Errorf("Unexpected publisher, year or notes: %+v", omens)
+	}
+	if omens.Source != importer.SourceCalibre || omens.SourceID != "uuid-omens" || omens.Series != "" || omens.SeriesIndex != 0 {
+		t.Errorf("Unexpected source or series: %+v", omens)
+	}
+
+	colour := records[1].Book
+	if colour.Series != "Discworld" || colour.SeriesIndex != 1 || colour.Year != 0 || colour.Rating != 5 {
+		t.Errorf("Unexpected series, year or rating: %+v", colour)
+	}
+	if !reflect.DeepEqual(colour.Tags, models.Tags{"fantasy", "humor"}) {
+		t.Errorf("Unexpected tags %v", colour.Tags)
+	}
+}
+
+func TestParseCalibreRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`CREATE TABLE notes (id INTEGER)`)
+	db.Close()
+	if _, err := importer.ParseCalibre(context.Background(), path); err == nil {
+		t.Error("Expected an error for a database that is not a Calibre library")
+	}
+}
+
+func TestImportMerge(t *testing.T) {
+	existing := []models.Book{
+		// matched by ISBN: gains metadata, keeps progress and notes
+		{ID: 1, Title: "Good Omens", Author: "Pratchett & Gaiman", ISBN: "978-0060853983", Progress: 40, Notes: "Halfway", Tags: models.Tags{}},
+		// matched by title and author and already up to date
+		{ID: 2, Title: "Colour of Magic", Author: "Terry Pratchett", Rating: 5, Shelf: models.ShelfRead,
+			Series: "Discworld", SeriesIndex: 1, Tags: models.Tags{"fantasy", "humor"},
+			Source: importer.SourceCalibre, SourceID: "uuid-colour"},
+	}
+	records := []importer.Record{
+		{Line: 1, Book: models.Book{Title: "Good Omens", Author: "Terry Pratchett, Neil Gaiman", ISBN: "9780060853983",
+			Publisher: "Gollancz", Rating: 4, Notes: "Apocalypse", Tags: models.Tags{"humor"},
+			Source: importer.SourceCalibre, SourceID: "uuid-omens"}},
+		{Line: 2, Book: models.Book{Title: "The Colour of Magic", Author: "Terry Pratchett", Rating: 5,
+			Series: "Discworld", SeriesIndex: 1, Tags: models.Tags{"humor"}, Source: importer.SourceCalibre, SourceID: "uuid-colour"}},
+		{Line: 3, Book: models.Book{Title: "Mort", Author: "Terry Pratchett", ISBN: "9780552131063",
+			Source: importer.SourceCalibre, SourceID: "uuid-mort"}},
+		{Line: 4, Book: models.Book{Title: "Mort (copy)", Author: "Terry Pratchett", ISBN: "0552131067",
+			Tags: models.Tags{"death"}, Source: importer.SourceCalibre, SourceID: "uuid-mort-2"}},
+	}
+
+	var created []models.Book
+	updated := map[int]models.Book{}
+	mockRepo := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) { return existing, nil },
+		bulkFunc: func(ctx context.Context, books []*models.Book) error {
+			for _, b := range books {
+				b.ID = 10 + len(created)
+				created = append(created, *b)
+			}
+			return nil
+		},
+		updateFunc: func(ctx context.Context, book *models.Book) error {
+			updated[book.ID] = *book
+			return nil
+		},
+	}
+	store := &mockStore{repos: repository.Repos{Books: mockRepo}}
+	report, err := importer.Import(context.Background(), store, records, importer.Options{Merge: true})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	actions := []string{importer.ActionMerge, importer.ActionSkip, importer.ActionCreate, importer.ActionMerge}
+	for i, res := range report.Results {
+		if res.Action != actions[i] {
+			t.Errorf("Record %d: expected action %s, got %s", i, actions[i], res.Action)
+		}
+	}
+	if report.Created != 1 || report.Merged != 2 || report.Skipped != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	omens := updated[1]
+	if omens.Progress != 40 || omens.Notes != "Halfway\n\nApocalypse" || omens.Publisher != "Gollancz" || omens.Source != importer.SourceCalibre {
+		t.Errorf("Unexpected merged book: %+v", omens)
+	}
+	if len(updated) != 1 {
+		t.Errorf("Expected only book 1 to be updated, got %v", updated)
+	}
+	if len(created) != 1 || !reflect.DeepEqual(created[0].Tags, models.Tags{"death"}) || report.Results[3].ID != created[0].ID {
+		t.Errorf("Expected the duplicate to merge into the created book, got %+v", created)
+	}
+}
@@ -0,0 +1,220 @@
+package unit
+
+import (
+	"book-tracker/internal/export"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseAuthors(t *testing.T) {
+	tests := []struct {
+		author   string
+		expected []export.Name
+	}{
+		{author: "Frank Herbert", expected: []export.Name{{Given: "Frank", Family: "Herbert"}}},
+		{author: "Tolkien, J.R.R.", expected: []export.Name{{Given: "J.R.R.", Family: "Tolkien"}}},
+		{author: "Le Guin, Ursula K.", expected: []export.Name{{Given: "Ursula K.", Family: "Le Guin"}}},
+		{author: "Ludwig van Beethoven", expected: []export.Name{{Given: "Ludwig", Family: "van Beethoven"}}},
+		{author: "Martin Luther King Jr.", expected: []export.Name{{Given: "Martin Luther", Family: "King", Suffix: "Jr."}}},
+		{author: "Homer", expected: []export.Name{{Family: "Homer"}}},
+		{author: "Terry Pratchett & Neil Gaiman", expected: []export.Name{
+			{Given: "Terry", Family: "Pratchett"}, {Given: "Neil", Family: "Gaiman"},
+		}},
+		{author: "Frank Herbert, Brian Herbert", expected: []export.Name{
+			{Given: "Frank", Family: "Herbert"}, {Given: "Brian", Family: "Herbert"},
+		}},
+		{author: "Kernighan, Brian and Dennis Ritchie", expected: []export.Name{
+			{Given: "Brian", Family: "Kernighan"}, {Given: "Dennis", Family: "Ritchie"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.author, func(t *testing.T) {
+			if got := export.ParseAuthors(tt.author); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCite(t *testing.T) {
+	dune := models.Book{Title: "Dune", Author: "Frank Herbert", Publisher: "Chilton Books", Year: 1965}
+	goodOmens := models.Book{Title: "Good Omens", Author: "Terry Pratchett & Neil Gaiman", Publisher: "Gollancz", Year: 1990}
+	three := models.Book{Title: "The Pragmatic Programmer?", Author: "Andrew Hunt; David Thomas; Jane Doe"}
+
+	tests := []struct {
+		name     string
+		book     models.Book
+		style    string
+		expected string
+	}{
+		{name: "APA", book: dune, style: export.StyleAPA, expected: "Herbert, F. (1965). Dune. Chilton Books."},
+		{name: "APA two authors", book: goodOmens, style: export.StyleAPA, expected: "Pratchett, T., & Gaiman, N. (1990). Good Omens. Gollancz."},
+		{name: "APA without year", book: three, style: export.StyleAPA, expected: "Hunt, A., Thomas, D., & Doe, J. (n.d.). The Pragmatic Programmer?"},
+		{name: "MLA", book: dune, style: export.StyleMLA, expected: "Herbert, Frank. Dune. Chilton Books, 1965."},
+		{name: "MLA two authors", book: goodOmens, style: export.StyleMLA, expected: "Pratchett, Terry, and Neil Gaiman. Good Omens. Gollancz, 1990."},
+		{name: "MLA three authors", book: three, style: export.StyleMLA, expected: "Hunt, Andrew, et al. The Pragmatic Programmer?"},
+		{name: "Chicago", book: dune, style: export.StyleChicago, expected: "Herbert, Frank. Dune. Chilton Books, 1965."},
+		{name: "Chicago three authors", book: three, style: export.StyleChicago, expected: "Hunt, Andrew, David Thomas, and Jane Doe. The Pragmatic Programmer?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := export.Cite(tt.book, tt.style)
+			if err != nil {
+				t.Fatalf("Cite: %v", err)
+			}
+			if got.Text != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got.Text)
+			}
+		})
+	}
+
+	got, _ := export.Cite(models.Book{Title: "Q&A", Author: "A B"}, export.StyleMLA)
+	if got.HTML != "B, A. <i>Q&amp;A</i>." {
+		t.Errorf("Expected an escaped, italic title, got %q", got.HTML)
+	}
+	if _, err := export.Cite(dune, "harvard"); err != export.ErrUnknownStyle {
+		t.Errorf("Expected ErrUnknownStyle, got %v", err)
+	}
+}
+
+func TestBibTeXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	bw := export.NewBibTeXWriter(&buf)
+	books := []models.Book{
+		{ID: 1, Title: "Dune", Author: "Frank Herbert", Publisher: "Chilton & Co", Year: 1965, ISBN: "0801950775",
+			Series: "Dune", SeriesIndex: 1, Tags: models.Tags{"sf", "classic"}},
+		{ID: 2, Title: "The Dune Messiah", Author: "Frank Herbert", Year: 1965},
+		{ID: 3, Title: "Dune", Author: "Herbert, Frank", Year: 1965},
+	}
+	for _, b := range books {
+		if err := bw.Write(b); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	expected := `@book{herbert1965dune,
+  author = {Herbert, Frank},
+  title = {Dune},
+  publisher = {Chilton \& Co},
+  year = {1965},
+  isbn = {0801950775},
+  series = {Dune},
+  number = {1},
+  keywords = {sf, classic},
+}
+
+@book{herbert1965dunea,
+  author = {Herbert, Frank},
+  title = {The Dune Messiah},
+  year = {1965},
+}
+
+@book{herbert1965duneb,
+  author = {Herbert, Frank},
+  title = {Dune},
+  year = {1965},
+}
+
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected BibTeX:\n%s", buf.String())
+	}
+}
+
+func TestWriteRIS(t *testing.T) {
+	var buf bytes.Buffer
+	book := models.Book{Title: "Good Omens", Author: "Terry Pratchett & Neil Gaiman", Publisher: "Gollancz", Year: 1990, Tags: models.Tags{"humour"}}
+	if err := export.WriteRIS(&buf, book); err != nil {
+		t.Fatalf("WriteRIS: %v", err)
+	}
+	expected := "TY  - BOOK\r\nAU  - Pratchett, Terry\r\nAU  - Gaiman, Neil\r\nTI  - Good Omens\r\nPB  - Gollancz\r\n" +
+		"PY  - 1990\r\nKW  - humour\r\nER  - \r\n\r\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestGetCitation(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedText   string
+	}{
+		{name: "Default style", url: "/books/1/citation", expectedStatus: http.StatusOK, expectedText: "Herbert, F. (1965). Dune. Chilton Books."},
+		{name: "MLA", url: "/books/1/citation?style=mla", expectedStatus: http.StatusOK, expectedText: "Herbert, Frank. Dune. Chilton Books, 1965."},
+		{name: "Unknown style", url: "/books/1/citation?style=harvard", expectedStatus: http.StatusBadRequest},
+		{name: "Missing book", url: "/books/2/citation", expectedStatus: http.StatusNotFound},
+		{name: "Invalid ID", url: "/books/x/citation", expectedStatus: http.StatusBadRequest},
+	}
+	mockRepo := &mockBookRepository{
+		getIDsFunc: func(ctx context.Context, ids []int) ([]models.Book, error) {
+			if ids[0] != 1 {
+				return nil, repository.ErrNotFound
+			}
+			return []models.Book{{ID: 1, Title: "Dune", Author: "Frank Herbert", Publisher: "Chilton Books", Year: 1965}}, nil
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/citation", handlers.GetCitation(mockRepo)).Methods("GET")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var citation export.Citation
+			if err := json.NewDecoder(rr.Body).Decode(&citation); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if citation.Text != tt.expectedText {
+				t.Errorf("Expected %q, got %q", tt.expectedText, citation.Text)
+			}
+		})
+	}
+}
+
+func TestExportBibTeX(t *testing.T) {
+	var selected []int
+	mockRepo := &mockBookRepository{
+		getIDsFunc: func(ctx context.Context, ids []int) ([]models.Book, error) {
+			selected = ids
+			return []models.Book{{ID: 2, Title: "Emma", Author: "Jane Austen", Year: 1815}}, nil
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/export/bibtex", handlers.ExportBibTeX(mockRepo)).Methods("GET")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/bibtex?ids=2,5", nil))
+	if rr.Code != http.StatusOK || !reflect.DeepEqual(selected, []int{2, 5}) {
+		t.Fatalf("Expected books 2 and 5 to be exported, got status %d and ids %v", rr.Code, selected)
+	}
+	if !bytes.HasPrefix(rr.Body.Bytes(), []byte("@book{austen1815emma,")) {
+		t.Errorf("Unexpected BibTeX: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/export/bibtex?ids=2,x", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid ids, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
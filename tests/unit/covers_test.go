@@ -0,0 +1,470 @@
+package unit
+
+import (
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memCovers is an in-memory CoverRepository for a fixed set of live books.
+type memCovers struct {
+	books  map[int]bool
+	covers map[int]*models.Cover
+	setErr error
+}
+
+func newMemCovers(bookIDs ...int) *memCovers {
+	m := &memCovers{books: make(map[int]bool), covers: make(map[int]*models.Cover)}
+	for _, id := range bookIDs {
+		m.books[id] = true
+	}
+	return m
+}
+
+func (m *memCovers) SetCover(ctx context.Context, cover *models.Cover) error {
+	if !m.books[cover.BookID] {
+		return repository.ErrNotFound
+	}
+	if m.setErr != nil {
+		return m.setErr
+	}
+	cover.UpdatedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := *cover
+	stored.Data = nil
+	m.covers[cover.BookID] = &stored
+	return nil
+}
+
+func (m *memCovers) GetCover(ctx context.Context, bookID int) (*models.Cover, error) {
+	c, ok := m.covers[bookID]
+	if !ok {
+		return nil, repository.ErrCoverNotFound
+	}
+	cover := *c
+	return &cover, nil
+}
+
+func (m *memCovers) DeleteCover(ctx context.Context, bookID int) error {
+	if _, ok := m.covers[bookID]; !ok {
+		return repository.ErrCoverNotFound
+	}
+	delete(m.covers, bookID)
+	return nil
+}
+
+func (m *memCovers) ListHeldCovers(ctx context.Context) ([]int, error) {
+	ids := []int{}
+	for id, c := range m.covers {
+		if m.books[id] && c.Data != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+var _ repository.CoverRepositoryInterface = &memCovers{}
+
+func newTestBlobs(t *testing.T) *blob.FileStore {
+	t.Helper()
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+// testImage encodes a width x height image as "jpeg", "png" or "gif".
+func testImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testWebP is a 1x1 lossless WebP image.
+var testWebP, _ = base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	blobs := newTestBlobs(t)
+	if err := blobs.Put(ctx, "covers/1/abc", []byte("one"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := blobs.Put(ctx, "covers/1/abc", []byte("two"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if data, err := blobs.Get(ctx, "covers/1/abc"); err != nil || string(data) != "two" {
+		t.Errorf("Expected the replaced object, got %q (%v)", data, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := blobs.Delete(ctx, "covers/1/abc"); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+	}
+	if _, err := blobs.Get(ctx, "covers/1/abc"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	for _, key := range []string{"", "/etc/passwd", "covers/../../x", "covers//x", "covers/"} {
+		if err := blobs.Put(ctx, key, nil, ""); err == nil {
+			t.Errorf("Expected key %q to be rejected", key)
+		}
+	}
+}
+
+// fakeS3 stands in for an S3-compatible store, checking that requests are
+// signed and that the payload matches its declared hash.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-key/") || !strings.Contains(auth, "/eu-test/s3/aws4_request") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") || r.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		http.Error(w, "payload hash mismatch", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.EscapedPath()] = body
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.EscapedPath()]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.EscapedPath())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	ctx := context.Background()
+
+	if _, err := blob.NewS3Store(blob.S3Config{Endpoint: server.URL}); err == nil {
+		t.Errorf("Expected a store without bucket or credentials to be rejected")
+	}
+	s3, err := blob.NewS3Store(blob.S3Config{
+		Endpoint: server.URL, Region: "eu-test", Bucket: "books",
+		AccessKeyID: "test-key", SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	if err := s3.Put(ctx, "covers/1/a b", []byte("image"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := fake.objects["/books/covers/1/a%20b"]; !ok {
+		t.Errorf("Expected a path-style object with an escaped key, got %v", fake.objects)
+	}
+	if data, err := s3.Get(ctx, "covers/1/a b"); err != nil || string(data) != "image" {
+		t.Errorf("Expected the stored object, got %q (%v)", data, err)
+	}
+	if err := s3.Delete(ctx, "covers/1/a b"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := s3.Get(ctx, "covers/1/a b"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSetCover(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		expectErr   error
+		expectType  string
+	}{
+		{name: "JPEG", data: testImage(t, "jpeg", 400, 600), contentType: "image/jpeg", expectType: "image/jpeg"},
+		{name: "PNG with a generic type", data: testImage(t, "png", 600, 400), contentType: "application/octet-stream", expectType: "image/png"},
+		{name: "WebP", data: testWebP, contentType: "image/webp", expectType: "image/webp"},
+		{name: "GIF", data: testImage(t, "gif", 10, 10), expectErr: covers.ErrUnsupportedType},
+		{name: "Type mismatch", data: testImage(t, "png", 10, 10), contentType: "image/jpeg", expectErr: covers.ErrInvalidImage},
+		{name: "Truncated", data: testImage(t, "png", 100, 100)[:200], expectErr: covers.ErrInvalidImage},
+		{name: "Too large", data: append(testImage(t, "png", 10, 10), make([]byte, covers.MaxBytes)...), expectErr: covers.ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := covers.NewService(newMemCovers(1), newTestBlobs(t))
+			cover, err := svc.Set(ctx, 1, tt.data, tt.contentType)
+			if tt.expectErr != nil {
+				if !errors.Is(err, tt.expectErr) {
+					t.Errorf("Expected %v, got %v", tt.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if cover.ContentType != tt.expectType || cover.Size != len(tt.data) || len(cover.SHA256) != 64 {
+				t.Errorf("Unexpected cover: %+v", cover)
+			}
+			for size, longest := range covers.Sizes {
+				thumb, err := svc.Get(ctx, 1, size)
+				if err != nil {
+					t.Fatalf("Get %s: %v", size, err)
+				}
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+				if err != nil || format != "jpeg" || thumb.ContentType != covers.ThumbnailType {
+					t.Fatalf("Expected a JPEG %s thumbnail, got %s (%v)", size, format, err)
+				}
+				// Thumbnails fit the size and are never enlarged
+				want := min(longest, max(cover.Width, cover.Height))
+				if max(cfg.Width, cfg.Height) != want {
+					t.Errorf("Expected %s thumbnail with longest side %d, got %dx%d", size, want, cfg.Width, cfg.Height)
+				}
+			}
+		})
+	}
+
+	svc := covers.NewService(newMemCovers(1), newTestBlobs(t))
+	if _, err := svc.Set(ctx, 2, testImage(t, "png", 10, 10), ""); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing book, got %v", err)
+	}
+}
+
+func TestSetCoverFailureDeletesImages(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemCovers(1)
+	blobs := newTestBlobs(t)
+	svc := covers.NewService(repo, blobs)
+	data := testImage(t, "png", 20, 20)
+	current, err := svc.Set(ctx, 1, data, "")
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// A failed replacement leaves no images behind
+	repo.setErr = errors.New("connection reset")
+	replacement := testImage(t, "png", 40, 40)
+	if _, err := svc.Set(ctx, 1, replacement, ""); !errors.Is(err, repo.setErr) {
+		t.Fatalf("Expected the repository error, got %v", err)
+	}
+	sum := sha256.Sum256(replacement)
+	orphan := &models.Cover{BookID: 1, SHA256: hex.EncodeToString(sum[:])}
+	if _, err := blobs.Get(ctx, covers.OriginalKey(orphan)); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the unused image to be deleted, got %v", err)
+	}
+
+	// Storing the current image again must not delete it
+	if _, err := svc.Set(ctx, 1, data, ""); !errors.Is(err, repo.setErr) {
+		t.Fatalf("Expected the repository error, got %v", err)
+	}
+	if _, err := blobs.Get(ctx, covers.OriginalKey(current)); err != nil {
+		t.Errorf("Expected the current image to be kept, got %v", err)
+	}
+}
+
+func TestReplaceCoverDeletesOldImages(t *testing.T) {
+	ctx := context.Background()
+	blobs := newTestBlobs(t)
+	svc := covers.NewService(newMemCovers(1), blobs)
+	first, err := svc.Set(ctx, 1, testImage(t, "png", 50, 80), "image/png")
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := svc.Set(ctx, 1, testImage(t, "jpeg", 80, 50), "image/jpeg"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := blobs.Get(ctx, covers.OriginalKey(first)); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the replaced image to be deleted, got %v", err)
+	}
+	cover, err := svc.Get(ctx, 1, "")
+	if err != nil || cover.ContentType != "image/jpeg" || cover.Width != 80 {
+		t.Errorf("Expected the new cover, got %+v (%v)", cover, err)
+	}
+
+	if err := svc.Delete(ctx, 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := svc.Get(ctx, 1, "small"); !errors.Is(err, repository.ErrCoverNotFound) {
+		t.Errorf("Expected ErrCoverNotFound, got %v", err)
+	}
+	if _, err := blobs.Get(ctx, covers.OriginalKey(cover)); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the deleted cover's image to be gone, got %v", err)
+	}
+}
+
+func TestMoveRestoredCovers(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemCovers(1, 2)
+	blobs := newTestBlobs(t)
+	original := testImage(t, "png", 30, 30)
+	repo.covers[1] = &models.Cover{BookID: 1, ContentType: "image/png", Data: original}
+	repo.covers[2] = &models.Cover{BookID: 2, ContentType: "image/gif", Data: testImage(t, "gif", 5, 5)}
+	svc := covers.NewService(repo, blobs)
+
+	// Reading a restored cover serves it without writing anything
+	cover, err := svc.Get(ctx, 1, "medium")
+	if err != nil || cover.ContentType != covers.ThumbnailType {
+		t.Fatalf("Expected a thumbnail of the restored cover, got %+v (%v)", cover, err)
+	}
+	if repo.covers[1].Data == nil {
+		t.Errorf("Expected the image to stay in the database when read, got %+v", repo.covers[1])
+	}
+	if cover, err = svc.Get(ctx, 1, ""); err != nil || !bytes.Equal(cover.Data, original) || cover.SHA256 == "" {
+		t.Errorf("Expected the restored original, got %+v (%v)", cover, err)
+	}
+
+	// Images that were never validated are served as they are
+	cover, err = svc.Get(ctx, 2, "small")
+	if err != nil || cover.ContentType != "image/gif" || cover.SHA256 == "" {
+		t.Errorf("Expected the stored GIF, got %+v (%v)", cover, err)
+	}
+	if _, err := svc.Get(ctx, 1, "huge"); !errors.Is(err, covers.ErrUnknownSize) {
+		t.Errorf("Expected ErrUnknownSize, got %v", err)
+	}
+
+	moved, err := svc.MoveRestored(ctx)
+	if err != nil || moved != 1 {
+		t.Fatalf("Expected 1 cover moved, got %d (%v)", moved, err)
+	}
+	if repo.covers[1].Data != nil || repo.covers[1].Width != 30 {
+		t.Errorf("Expected the image moved to blob storage, got %+v", repo.covers[1])
+	}
+	if data, err := blobs.Get(ctx, covers.OriginalKey(repo.covers[1])); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("Expected the original in blob storage, got %v", err)
+	}
+	if repo.covers[2].Data == nil {
+		t.Errorf("Expected the GIF to stay in the database")
+	}
+	if cover, err = svc.Get(ctx, 1, "small"); err != nil || cover.ContentType != covers.ThumbnailType {
+		t.Errorf("Expected a thumbnail from blob storage, got %+v (%v)", cover, err)
+	}
+	if moved, err := svc.MoveRestored(ctx); err != nil || moved != 0 {
+		t.Errorf("Expected nothing left to move, got %d (%v)", moved, err)
+	}
+}
+
+func TestCoverHandlers(t *testing.T) {
+	svc := covers.NewService(newMemCovers(1), newTestBlobs(t))
+	router := mux.NewRouter()
+	router.HandleFunc("/books/{id}/cover", handlers.PutCover(svc)).Methods("PUT")
+	router.HandleFunc("/books/{id}/cover", handlers.GetCover(svc)).Methods("GET")
+	router.HandleFunc("/books/{id}/cover", handlers.DeleteCover(svc)).Methods("DELETE")
+	router.HandleFunc("/books/{id}/cover/{size}", handlers.GetCover(svc)).Methods("GET")
+	serve := func(method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	puts := []struct {
+		name           string
+		path           string
+		body           []byte
+		contentType    string
+		expectedStatus int
+	}{
+		{name: "Unsupported type", path: "/books/1/cover", body: testImage(t, "gif", 4, 4), contentType: "image/gif", expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "Invalid image", path: "/books/1/cover", body: []byte("\x89PNG\r\n\x1a\nnot really"), contentType: "image/png", expectedStatus: http.StatusBadRequest},
+		{name: "Too large", path: "/books/1/cover", body: make([]byte, covers.MaxBytes+1), contentType: "image/png", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "Missing book", path: "/books/2/cover", body: testImage(t, "png", 4, 4), contentType: "image/png", expectedStatus: http.StatusNotFound},
+		{name: "Uploaded", path: "/books/1/cover", body: testImage(t, "png", 200, 300), contentType: "image/png", expectedStatus: http.StatusOK},
+	}
+	for _, tt := range puts {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(http.MethodPut, tt.path, tt.body, http.Header{"Content-Type": {tt.contentType}})
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	w := serve(http.MethodGet, "/books/1/cover/small", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("Expected a JPEG thumbnail with caching headers, got %d %v", w.Code, w.Header())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("Expected covers to be cacheable only by the client, got %q", cc)
+	}
+	etag := w.Header().Get("ETag")
+	if w := serve(http.MethodGet, "/books/1/cover/small", nil, http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	w = serve(http.MethodGet, "/books/1/cover", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("ETag") == etag {
+		t.Errorf("Expected the original PNG under its own ETag, got %d %v", w.Code, w.Header())
+	}
+	if w := serve(http.MethodGet, "/books/1/cover/huge", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown size, got %d", w.Code)
+	}
+
+	if w := serve(http.MethodDelete, "/books/1/cover", nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/books/1/cover", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", w.Code)
+	}
+	var body map[string]any
+	json.NewDecoder(serve(http.MethodPut, "/books/1/cover", testImage(t, "jpeg", 10, 20), nil).Body).Decode(&body)
+	if body["content_type"] != "image/jpeg" || body["width"] != 10.0 || body["height"] != 20.0 {
+		t.Errorf("Expected the new cover's description, got %v", body)
+	}
+}
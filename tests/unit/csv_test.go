@@ -0,0 +1,235 @@
+package unit
+
+import (
+	"book-tracker/internal/export"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := export.NewCSVWriter(&buf)
+	if err := cw.Write(models.Book{ID: 1, Title: "Dune, Part One", Author: "Frank Herbert", Progress: 40, Notes: "line1\nline2"}); err != nil {
+		t.Fatalf("Failed to write book: %v", err)
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	header := "id,title,author,isbn,progress,notes,finished,rating,shelf,tags,publisher,year,pages,language,series,series_index,finished_at,source,source_id\n"
+	expected := header + "1,\"Dune, Part One\",Frank Herbert,,40,\"line1\nline2\",false,0,,,,0,0,,,0,,,\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := export.NewCSVWriter(&buf).Flush(); err != nil || buf.String() != header {
+		t.Errorf("Expected header only for an empty export, got %q (%v)", buf.String(), err)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	finished := time.Date(2023, 3, 14, 9, 30, 0, 0, time.UTC)
+	books := []models.Book{
+		{ID: 1, Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Progress: 100, Notes: "Spice, \"must\" flow",
+			Finished: true, Rating: 5, Shelf: models.ShelfRead, Tags: models.Tags{"favorites", "sci-fi"}, Publisher: "Ace Books",
+			Year: 1965, Pages: 658, Language: "en", Series: "Dune", SeriesIndex: 1.5, FinishedAt: &finished,
+			Source: importer.SourceGoodreads, SourceID: "234225"},
+		{ID: 2, Title: "Emma", Author: "Jane Austen", Tags: models.Tags{}},
+	}
+	var buf bytes.Buffer
+	cw := export.NewCSVWriter(&buf)
+	for _, b := range books {
+		if err := cw.Write(b); err != nil {
+			t.Fatalf("Failed to write book: %v", err)
+		}
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	records, err := importer.ParseCSV(&buf, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if len(records) != len(books) {
+		t.Fatalf("Expected %d records, got %d", len(books), len(records))
+	}
+	for i, rec := range records {
+		if len(rec.Errors) != 0 {
+			t.Errorf("Line %d: unexpected errors %v", rec.Line, rec.Errors)
+		}
+		if !reflect.DeepEqual(rec.Book, books[i]) {
+			t.Errorf("Book changed by the round trip:\n got %+v\nwant %+v", rec.Book, books[i])
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	input := "Book Title,Writer,Progress (%),Read,Stars,Shelf\n" +
+		"The Hobbit,J.R.R. Tolkien,50%,yes,5,fantasy\n" +
+		"\n" +
+		"Dune,Frank Herbert,lots,no,4,sf\n"
+	records, err := importer.ParseCSV(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	hobbit := records[0].Book
+	if hobbit.Title != "The Hobbit" || hobbit.Author != "J.R.R. Tolkien" || hobbit.Progress != 50 || !hobbit.Finished || hobbit.Rating != 5 {
+		t.Errorf("Unexpected first record: %+v", hobbit)
+	}
+	if records[1].Line != 4 || len(records[1].Errors) != 1 {
+		t.Errorf("Expected a progress error on line 4, got %+v", records[1])
+	}
+}
+
+func TestParseCSVHeaderMapping(t *testing.T) {
+	input := "Name,Creator,Thoughts\nDune,Frank Herbert,great\n"
+	if _, err := importer.ParseCSV(strings.NewReader(input), nil); err == nil {
+		t.Error("Expected an error for a missing author column")
+	}
+	records, err := importer.ParseCSV(strings.NewReader(input), map[string]string{"author": "creator", "notes": "Thoughts"})
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if b := records[0].Book; b.Author != "Frank Herbert" || b.Notes != "great" {
+		t.Errorf("Unexpected mapped record: %+v", b)
+	}
+	if _, err := importer.ParseCSV(strings.NewReader(input), map[string]string{"author": "Missing"}); err == nil {
+		t.Error("Expected an error for a mapping to an unknown column")
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	existing := []models.Book{{ID: 7, Title: "Dune", Author: "Frank Herbert", ISBN: "0441013597"}}
+	records := []importer.Record{
+		{Line: 2, Book: models.Book{Title: "New Book", Author: "Someone", ISBN: "9780261102217"}},
+		{Line: 3, Book: models.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-01359-3"}},
+		{Line: 4, Book: models.Book{ID: 99, Title: "Gone", Author: "Nobody"}},
+		{Line: 5, Book: models.Book{Title: "", Author: "Nobody"}},
+		{Line: 6, Book: models.Book{Title: "New Book", Author: "Someone", ISBN: "0261102214"}},
+	}
+	results := importer.Plan(records, existing)
+	expected := []struct {
+		action string
+		id     int
+	}{
+		{importer.ActionCreate, 0},
+		{importer.ActionUpdate, 7},
+		{importer.ActionError, 0},
+		{importer.ActionError, 0},
+		{importer.ActionUpdate, 0},
+	}
+	for i, e := range expected {
+		if results[i].Action != e.action || results[i].ID != e.id {
+			t.Errorf("Line %d: expected %s of %d, got %+v", records[i].Line, e.action, e.id, results[i])
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	body := "title,author,progress\nThe Hobbit,Tolkien,10\nDune,,20\nEmma,Jane Austen,x\n"
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBulk   int
+	}{
+		{name: "Dry run", query: "?dry_run=true", expectedStatus: http.StatusOK, expectedBulk: 0},
+		{name: "Import", query: "", expectedStatus: http.StatusOK, expectedBulk: 1},
+		{name: "Invalid mapping", query: "?map=title", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bulk := 0
+			mockRepo := &mockBookRepository{
+				getFunc: func(ctx context.Context) ([]models.Book, error) { return nil, nil },
+				bulkFunc: func(ctx context.Context, books []*models.Book) error {
+					for i, b := range books {
+						bulk++
+						b.ID = i + 1
+					}
+					return nil
+				},
+			}
+			store := &mockStore{repos: repository.Repos{Books: mockRepo}}
+			req := httptest.NewRequest(http.MethodPost, "/books/import"+tt.query, strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/books/import", handlers.ImportCSV(store)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var report importer.Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if report.Created != 1 || report.Failed != 2 || len(report.Results) != 3 {
+				t.Errorf("Unexpected report: %+v", report)
+			}
+			if bulk != tt.expectedBulk {
+				t.Errorf("Expected %d books written, got %d", tt.expectedBulk, bulk)
+			}
+		})
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	mockRepo := &mockBookRepository{
+		streamFunc: func(ctx context.Context, fn func(book models.Book) error) error {
+			for _, b := range []models.Book{{ID: 1, Title: "A", Author: "X"}, {ID: 2, Title: "B", Author: "Y"}} {
+				if err := fn(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/books/export.csv", nil)
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/books/export.csv", handlers.ExportCSV(mockRepo)).Methods("GET")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Expected CSV content type, got %q", ct)
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 {
+		t.Errorf("Expected header and 2 rows, got %d lines", lines)
+	}
+
+	// A failing stream still returns what was written
+	mockRepo.streamFunc = func(ctx context.Context, fn func(book models.Book) error) error {
+		return errors.New("database error")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books/export.csv", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
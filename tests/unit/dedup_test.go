@@ -0,0 +1,76 @@
+package unit
+
+import (
+	"book-tracker/internal/dedup"
+	"book-tracker/internal/models"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"978-0-261-10221-7", "9780261102217"},
+		{"0-261-10221-4", "9780261102217"},
+		{"080442957X", "9780804429573"},
+		{"not an isbn", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := dedup.NormalizeISBN(tt.input); got != tt.expected {
+			t.Errorf("NormalizeISBN(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNormalizeTitleAndAuthor(t *testing.T) {
+	if got := dedup.NormalizeTitle("The Fellowship of the Ring: Being the First Part"); got != "fellowship of the ring" {
+		t.Errorf("Unexpected normalized title %q", got)
+	}
+	if got := dedup.NormalizeAuthor("Tolkien, J.R.R."); got != "j r r tolkien" {
+		t.Errorf("Unexpected normalized author %q", got)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	books := []models.Book{
+		{ID: 1, Title: "The Hobbit", Author: "J.R.R. Tolkien"},
+		{ID: 2, Title: "Hobbit, The", Author: "Tolkien, J. R. R.", ISBN: "0-261-10221-4"},
+		{ID: 3, Title: "There and Back Again", Author: "Someone Else", ISBN: "9780261102217"},
+		{ID: 4, Title: "Dune", Author: "Frank Herbert"},
+		{ID: 5, Title: "Dune", Author: "Brian Herbert"},
+		{ID: 6, Title: "Dune Messiah", Author: "Frank Herbert"},
+	}
+	groups := dedup.FindDuplicates(books)
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d: %+v", len(groups), groups)
+	}
+	if groups[0].Reason != dedup.ReasonISBN {
+		t.Errorf("Expected reason %s, got %s", dedup.ReasonISBN, groups[0].Reason)
+	}
+	if len(groups[0].Books) != 3 {
+		t.Errorf("Expected 3 books in group, got %d", len(groups[0].Books))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	target := models.Book{ID: 1, Title: "The Hobbit", Author: "Tolkien", Progress: 10, Notes: "first", Tags: models.Tags{"fantasy"}}
+	sources := []models.Book{
+		{ID: 2, Progress: 80, Notes: "second", Rating: 4, ISBN: "9780261102217", Tags: models.Tags{"classics"}},
+		{ID: 3, Progress: 30, Notes: "first", Finished: true, Tags: models.Tags{"fantasy"}},
+	}
+	merged := dedup.Merge(target, sources)
+	if merged.ID != 1 || merged.Title != "The Hobbit" {
+		t.Errorf("Expected target identity to be kept, got %+v", merged)
+	}
+	if merged.Progress != 80 || merged.Rating != 4 || !merged.Finished || merged.ISBN != "9780261102217" {
+		t.Errorf("Unexpected merged fields: %+v", merged)
+	}
+	if merged.Notes != "first\n\nsecond" {
+		t.Errorf("Unexpected merged notes %q", merged.Notes)
+	}
+	if len(merged.Tags) != 2 || merged.Tags[0] != "classics" || merged.Tags[1] != "fantasy" {
+		t.Errorf("Unexpected merged tags %v", merged.Tags)
+	}
+}
@@ -0,0 +1,321 @@
+package unit
+
+import (
+	"book-tracker/internal/enrich"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/jobs"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeProvider returns canned metadata by title.
+type fakeProvider struct {
+	name    string
+	byTitle map[string]*enrich.Metadata
+	err     error
+	lookups int
+}
+
+func (f *fakeProvider) Name() string {
+	return f.name
+}
+
+func (f *fakeProvider) Lookup(ctx context.Context, book models.Book) (*enrich.Metadata, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if m, ok := f.byTitle[book.Title]; ok {
+		return m, nil
+	}
+	return nil, enrich.ErrNoMatch
+}
+
+type enrichmentAttempt struct {
+	bookID   int
+	provider string
+	fields   []string
+	errMsg   string
+}
+
+type mockEnrichmentRepository struct {
+	listFunc func(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error)
+	attempts []enrichmentAttempt
+}
+
+func (m *mockEnrichmentRepository) ListBooksToEnrich(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error) {
+	return m.listFunc(ctx, attemptedBefore, limit)
+}
+
+func (m *mockEnrichmentRepository) RecordAttempt(ctx context.Context, bookID int, provider string, fields []string, errMsg string) error {
+	m.attempts = append(m.attempts, enrichmentAttempt{bookID, provider, fields, errMsg})
+	return nil
+}
+
+// newEnrichTest returns an enricher over an in-memory library.
+func newEnrichTest(provider enrich.MetadataProvider, library map[int]models.Book) (*enrich.Enricher, *mockBookRepository, *mockEnrichmentRepository) {
+	books := &mockBookRepository{
+		getIDsFunc: func(ctx context.Context, ids []int) ([]models.Book, error) {
+			book, ok := library[ids[0]]
+			if !ok {
+				return nil, repository.ErrNotFound
+			}
+			return []models.Book{book}, nil
+		},
+		updateFunc: func(ctx context.Context, book *models.Book) error {
+			library[book.ID] = *book
+			return nil
+		},
+	}
+	attempts := &mockEnrichmentRepository{}
+	store := &mockStore{repos: repository.Repos{Books: books, Enrichment: attempts}}
+	return enrich.NewEnricher(provider, store), books, attempts
+}
+
+func TestFill(t *testing.T) {
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", Publisher: "Ace"}
+	filled := enrich.Fill(&book, &enrich.Metadata{ISBN: "9780441013593", Publisher: "Chilton", Year: 1965, Pages: 412})
+	if want := []string{"isbn", "year", "pages"}; !reflect.DeepEqual(filled, want) {
+		t.Errorf("Expected fields %v, got %v", want, filled)
+	}
+	if book.ISBN != "9780441013593" || book.Publisher != "Ace" || book.Year != 1965 || book.Pages != 412 {
+		t.Errorf("Unexpected book after fill: %+v", book)
+	}
+	if enrich.NeedsEnrichment(book) {
+		t.Error("Expected a filled book not to need enrichment")
+	}
+	if undated := (models.Book{ISBN: "9780441013593", Publisher: "Ace", Pages: 412}); !enrich.NeedsEnrichment(undated) {
+		t.Error("Expected a book without a year to need enrichment")
+	}
+	if filled := enrich.Fill(&book, &enrich.Metadata{Pages: 500}); len(filled) != 0 || book.Pages != 412 {
+		t.Errorf("Expected set fields to be kept, got %v and %d pages", filled, book.Pages)
+	}
+}
+
+func TestChain(t *testing.T) {
+	first := &fakeProvider{name: "first", byTitle: map[string]*enrich.Metadata{"A": {Pages: 1}}}
+	second := &fakeProvider{name: "second", byTitle: map[string]*enrich.Metadata{"B": {Pages: 2}}}
+	chain := enrich.Chain{first, second}
+
+	if m, err := chain.Lookup(context.Background(), models.Book{Title: "B"}); err != nil || m.Pages != 2 {
+		t.Errorf("Expected the second provider's match, got %+v, %v", m, err)
+	}
+	if _, err := chain.Lookup(context.Background(), models.Book{Title: "C"}); !errors.Is(err, enrich.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch, got %v", err)
+	}
+	second.err = errors.New("unavailable")
+	if _, err := chain.Lookup(context.Background(), models.Book{Title: "C"}); err == nil || errors.Is(err, enrich.ErrNoMatch) {
+		t.Errorf("Expected the provider error, got %v", err)
+	}
+	if name := chain.Name(); name != "first, second" {
+		t.Errorf("Unexpected chain name %q", name)
+	}
+}
+
+func TestEnricher(t *testing.T) {
+	provider := &fakeProvider{name: "fake", byTitle: map[string]*enrich.Metadata{
+		"Dune": {ISBN: "9780441013593", Publisher: "Ace", Pages: 412},
+	}}
+	library := map[int]models.Book{
+		1: {ID: 1, Title: "Dune", Author: "Frank Herbert", Pages: 600},
+		2: {ID: 2, Title: "Unknown", Author: "Nobody"},
+	}
+	enricher, books, attempts := newEnrichTest(provider, library)
+	ctx := context.Background()
+
+	// The lookup used a stale copy; the stored page count is kept
+	result, err := enricher.Enrich(ctx, models.Book{ID: 1, Title: "Dune", Author: "Frank Herbert"})
+	if err != nil {
+		t.Fatalf("Enrich failed: %v", err)
+	}
+	if !result.Matched || !reflect.DeepEqual(result.Fields, []string{"isbn", "publisher"}) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if got := library[1]; got.ISBN != "9780441013593" || got.Publisher != "Ace" || got.Pages != 600 {
+		t.Errorf("Unexpected stored book %+v", got)
+	}
+
+	updated := false
+	books.updateFunc = func(ctx context.Context, book *models.Book) error {
+		updated = true
+		return nil
+	}
+	result, err = enricher.Enrich(ctx, library[2])
+	if err != nil || result.Matched || len(result.Fields) != 0 || updated {
+		t.Errorf("Expected an unmatched book to be left alone, got %+v, %v", result, err)
+	}
+
+	provider.err = errors.New("connection refused")
+	if _, err := enricher.Enrich(ctx, library[2]); !errors.Is(err, enrich.ErrLookupFailed) {
+		t.Errorf("Expected ErrLookupFailed, got %v", err)
+	}
+	if _, err := enricher.Enrich(ctx, models.Book{ID: 3}); !errors.Is(err, enrich.ErrLookupFailed) {
+		t.Errorf("Expected ErrLookupFailed, got %v", err)
+	}
+
+	want := []enrichmentAttempt{
+		{1, "fake", []string{"isbn", "publisher"}, ""},
+		{2, "fake", []string{}, ""},
+		{2, "fake", nil, "connection refused"},
+		{3, "fake", nil, "connection refused"},
+	}
+	if !reflect.DeepEqual(attempts.attempts, want) {
+		t.Errorf("Expected attempts %+v, got %+v", want, attempts.attempts)
+	}
+}
+
+func TestEnrichBookHandler(t *testing.T) {
+	provider := &fakeProvider{name: "fake", byTitle: map[string]*enrich.Metadata{
+		"Dune": {Publisher: "Ace", Year: 1965},
+	}}
+	library := map[int]models.Book{1: {ID: 1, Title: "Dune", Author: "Frank Herbert"}}
+	enricher, books, _ := newEnrichTest(provider, library)
+	serve := func(enricher *enrich.Enricher, path string) *httptest.ResponseRecorder {
+		router := mux.NewRouter()
+		router.HandleFunc("/books/{id}/enrich", handlers.EnrichBook(books, enricher)).Methods("POST")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+
+	w := serve(enricher, "/books/1/enrich")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var result enrich.Result
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if result.Book == nil || result.Book.Publisher != "Ace" || result.Book.Year != 1965 || result.Provider != "fake" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	if w := serve(enricher, "/books/9/enrich"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing book, got %d", w.Code)
+	}
+	if w := serve(nil, "/books/1/enrich"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a provider, got %d", w.Code)
+	}
+	provider.err = errors.New("timeout")
+	if w := serve(enricher, "/books/1/enrich"); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502 when the provider fails, got %d", w.Code)
+	}
+}
+
+func TestEnrichPending(t *testing.T) {
+	provider := &fakeProvider{name: "fake", byTitle: map[string]*enrich.Metadata{
+		"A": {Pages: 100},
+		"C": {Pages: 300},
+	}}
+	library := map[int]models.Book{}
+	var pending []models.Book
+	for i := 1; i <= 150; i++ {
+		title := "B"
+		switch i {
+		case 1:
+			title = "A"
+		case 150:
+			title = "C"
+		}
+		library[i] = models.Book{ID: i, Title: title, Author: "X"}
+		pending = append(pending, library[i])
+	}
+	enricher, _, attempts := newEnrichTest(provider, library)
+	cutoff := time.Now().Add(-time.Hour)
+	attempts.listFunc = func(ctx context.Context, attemptedBefore time.Time, limit int) ([]models.Book, error) {
+		if !attemptedBefore.Equal(cutoff) {
+			t.Errorf("Expected cutoff %s, got %s", cutoff, attemptedBefore)
+		}
+		// Books attempted since the cutoff are no longer listed
+		n := min(limit, len(pending))
+		batch := pending[:n]
+		pending = pending[n:]
+		return batch, nil
+	}
+
+	n, err := jobs.EnrichPending(context.Background(), attempts, enricher, cutoff)
+	if err != nil {
+		t.Fatalf("EnrichPending failed: %v", err)
+	}
+	if n != 2 || provider.lookups != 150 || len(attempts.attempts) != 150 {
+		t.Errorf("Expected 2 of 150 books enriched, got %d after %d lookups", n, provider.lookups)
+	}
+	if library[150].Pages != 300 {
+		t.Errorf("Expected the second batch to be enriched, got %+v", library[150])
+	}
+
+	provider.err = errors.New("unavailable")
+	pending = []models.Book{library[2], library[3]}
+	if _, err := jobs.EnrichPending(context.Background(), attempts, enricher, cutoff); err == nil {
+		t.Error("Expected the round to stop at the provider error")
+	}
+	if provider.lookups != 151 {
+		t.Errorf("Expected 1 more lookup, got %d", provider.lookups-150)
+	}
+}
+
+const openLibraryDump = "/type/author\t/authors/OL1A\t1\t2020-01-01T00:00:00\t{\"key\": \"/authors/OL1A\", \"name\": \"Frank Herbert\"}\n" +
+	"/type/work\t/works/OL1W\t1\t2020-01-01T00:00:00\t{\"key\": \"/works/OL1W\", \"title\": \"Dune\", \"authors\": [{\"author\": {\"key\": \"/authors/OL1A\"}}]}\n" +
+	"/type/edition\t/books/OL1M\t1\t2020-01-01T00:00:00\t{\"title\": \"Dune\", \"works\": [{\"key\": \"/works/OL1W\"}]}\n" +
+	"/type/edition\t/books/OL2M\t3\t2020-01-01T00:00:00\t{\"title\": \"Dune\", \"isbn_10\": [\"0-441-01359-7\"], \"isbn_13\": [\"978-0-441-01359-3\"], \"publishers\": [\"Ace Books\"], \"number_of_pages\": 528, \"publish_date\": \"August 2005\", \"works\": [{\"key\": \"/works/OL1W\"}]}\n" +
+	"/type/edition\t/books/OL3M\t1\t2020-01-01T00:00:00\t{\"title\": \"Dune Messiah\", \"isbn_13\": [\"9780441172696\"], \"publishers\": [\"Ace\"], \"publish_date\": \"1987\", \"authors\": [{\"key\": \"/authors/OL1A\"}]}\n"
+
+func TestOpenLibraryDump(t *testing.T) {
+	dump, err := enrich.ReadOpenLibraryDump(strings.NewReader(openLibraryDump))
+	if err != nil {
+		t.Fatalf("Failed to read dump: %v", err)
+	}
+	ctx := context.Background()
+
+	m, err := dump.Lookup(ctx, models.Book{Title: "Unrelated", ISBN: "0441013597"})
+	if err != nil {
+		t.Fatalf("Lookup by ISBN failed: %v", err)
+	}
+	want := &enrich.Metadata{ISBN: "9780441013593", Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Ace Books", Year: 2005, Pages: 528}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Expected %+v, got %+v", want, m)
+	}
+
+	// The most complete edition wins a title match
+	m, err = dump.Lookup(ctx, models.Book{Title: "dune", Author: "Herbert, Frank"})
+	if err != nil || m.Pages != 528 {
+		t.Errorf("Expected the complete edition by title and author, got %+v, %v", m, err)
+	}
+	m, err = dump.Lookup(ctx, models.Book{Title: "Dune Messiah", Author: "Frank Herbert"})
+	if err != nil || m.Year != 1987 || m.Pages != 0 {
+		t.Errorf("Expected Dune Messiah, got %+v, %v", m, err)
+	}
+	if _, err := dump.Lookup(ctx, models.Book{Title: "Dune", Author: "Someone Else"}); !errors.Is(err, enrich.ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch for another author, got %v", err)
+	}
+
+	if dump.Skipped() != 0 {
+		t.Errorf("Expected no skipped lines, got %d", dump.Skipped())
+	}
+
+	// Malformed lines are skipped without losing the rest of the dump
+	malformed := "/type/edition\tk\t1\tt\t{not json\n" +
+		"/type/edition\t/books/OL4M\t1\t2020-01-01T00:00:00\t{\"title\": \"Children of Dune\", \"number_of_pages\": \"444\"}\n" +
+		"truncated line\n" + openLibraryDump
+	dump, err = enrich.ReadOpenLibraryDump(strings.NewReader(malformed))
+	if err != nil {
+		t.Fatalf("Expected malformed lines to be skipped, got %v", err)
+	}
+	if dump.Skipped() != 3 {
+		t.Errorf("Expected 3 skipped lines, got %d", dump.Skipped())
+	}
+	if m, err := dump.Lookup(ctx, models.Book{ISBN: "0441013597"}); err != nil || m.Pages != 528 {
+		t.Errorf("Expected the well-formed editions to be indexed, got %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,245 @@
+package unit
+
+import (
+	"archive/zip"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/epub"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const epubContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const epub2OPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" xmlns:opf="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>
+      The Left Hand
+      of Darkness
+    </dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Le Guin, Ursula K.">Ursula K. Le Guin</dc:creator>
+    <dc:creator opf:role="ill">Someone Else</dc:creator>
+    <dc:identifier id="uid">urn:uuid:0b5c5a36-7f1e-4b1a-9c3e-1f0d2c3b4a59</dc:identifier>
+    <dc:identifier opf:scheme="ISBN">0-441-47812-3</dc:identifier>
+    <dc:language>en</dc:language>
+    <dc:publisher>Ace Books</dc:publisher>
+    <dc:date>1969-03-01</dc:date>
+    <dc:subject>Science Fiction</dc:subject>
+    <meta name="cover" content="cover-img"/>
+    <meta name="calibre:series" content="Hainish Cycle"/>
+    <meta name="calibre:series_index" content="4"/>
+  </metadata>
+  <manifest>
+    <item id="cover-img" href="images/cover%20art.jpg" media-type="image/jpeg"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+</package>`
+
+const epub3OPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title id="sub">A Novel</dc:title>
+    <meta refines="#sub" property="title-type">subtitle</meta>
+    <dc:title id="main">Piranesi</dc:title>
+    <meta refines="#main" property="title-type">main</meta>
+    <dc:creator id="c1">Susanna Clarke</dc:creator>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="c2">A Narrator</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">nrt</meta>
+    <dc:identifier id="uid">urn:isbn:978-1-63557-563-7</dc:identifier>
+    <dc:language>en-GB</dc:language>
+    <meta property="belongs-to-collection" id="col">Standalones</meta>
+    <meta refines="#col" property="collection-type">set</meta>
+  </metadata>
+  <manifest>
+    <item id="c" href="../cover.png" media-type="image/png" properties="cover-image"/>
+  </manifest>
+</package>`
+
+func buildEPUB(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// The mimetype comes first, as in real EPUBs
+	for _, name := range append([]string{"mimetype"}, sortedKeys(files)...) {
+		content := "application/epub+zip"
+		if name != "mimetype" {
+			content = files[name]
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func parseEPUB(t *testing.T, data []byte) (*epub.Metadata, error) {
+	t.Helper()
+	return epub.Parse(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestParseEPUB2(t *testing.T) {
+	m, err := parseEPUB(t, buildEPUB(t, map[string]string{
+		"META-INF/container.xml":     epubContainer,
+		"OEBPS/content.opf":          epub2OPF,
+		"OEBPS/images/cover art.jpg": "jpeg bytes",
+	}))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.Title != "The Left Hand of Darkness" {
+		t.Errorf("Expected whitespace collapsed in title, got %q", m.Title)
+	}
+	if !reflect.DeepEqual(m.Authors, []string{"Ursula K. Le Guin"}) {
+		t.Errorf("Expected only the author creator, got %v", m.Authors)
+	}
+	if m.ISBN != "0441478123" || len(m.Identifiers) != 2 {
+		t.Errorf("Expected ISBN 0441478123 among 2 identifiers, got %q in %v", m.ISBN, m.Identifiers)
+	}
+	if m.Language != "en" || m.Publisher != "Ace Books" || m.Year != 1969 {
+		t.Errorf("Unexpected language, publisher or year: %+v", m)
+	}
+	if m.Series != "Hainish Cycle" || m.SeriesIndex != 4 {
+		t.Errorf("Expected calibre series Hainish Cycle #4, got %q #%v", m.Series, m.SeriesIndex)
+	}
+	if string(m.Cover) != "jpeg bytes" || m.CoverType != "image/jpeg" {
+		t.Errorf("Expected the escaped cover href to resolve, got %q (%s)", m.Cover, m.CoverType)
+	}
+
+	b := m.Book()
+	if b.Author != "Ursula K. Le Guin" || b.Language != "en" || !reflect.DeepEqual(b.Tags, models.Tags{"science fiction"}) {
+		t.Errorf("Unexpected book: %+v", b)
+	}
+}
+
+func TestParseEPUB3(t *testing.T) {
+	m, err := parseEPUB(t, buildEPUB(t, map[string]string{
+		"META-INF/container.xml": epubContainer,
+		"OEBPS/content.opf":      epub3OPF,
+		"cover.png":              "png bytes",
+	}))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.Title != "Piranesi" {
+		t.Errorf("Expected the main title, got %q", m.Title)
+	}
+	if !reflect.DeepEqual(m.Authors, []string{"Susanna Clarke"}) {
+		t.Errorf("Expected refined roles to drop the narrator, got %v", m.Authors)
+	}
+	if m.ISBN != "9781635575637" || m.Language != "en-GB" {
+		t.Errorf("Expected ISBN from the URN and language en-GB, got %q and %q", m.ISBN, m.Language)
+	}
+	if m.Series != "" {
+		t.Errorf("Expected collections that are not series to be ignored, got %q", m.Series)
+	}
+	if string(m.Cover) != "png bytes" || m.CoverType != "image/png" {
+		t.Errorf("Expected the cover-image item, got %q (%s)", m.Cover, m.CoverType)
+	}
+}
+
+func TestParseEPUBInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Not a zip", data: []byte("plain text")},
+		{name: "No container", data: buildEPUB(t, map[string]string{"OEBPS/content.opf": epub2OPF})},
+		{name: "Missing package document", data: buildEPUB(t, map[string]string{"META-INF/container.xml": epubContainer})},
+		{name: "No title", data: buildEPUB(t, map[string]string{
+			"META-INF/container.xml": epubContainer,
+			"OEBPS/content.opf":      `<package><metadata><creator>Someone</creator></metadata></package>`,
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseEPUB(t, tt.data); !errors.Is(err, epub.ErrInvalidEPUB) {
+				t.Errorf("Expected ErrInvalidEPUB, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateBookFromEPUB(t *testing.T) {
+	withCover := buildEPUB(t, map[string]string{
+		"META-INF/container.xml":     epubContainer,
+		"OEBPS/content.opf":          epub2OPF,
+		"OEBPS/images/cover art.jpg": string(testImage(t, "jpeg", 300, 450)),
+	})
+	brokenCover := buildEPUB(t, map[string]string{
+		"META-INF/container.xml":     epubContainer,
+		"OEBPS/content.opf":          epub2OPF,
+		"OEBPS/images/cover art.jpg": "jpeg bytes",
+	})
+	tests := []struct {
+		name           string
+		body           []byte
+		createErr      error
+		expectedStatus int
+		expectCover    bool
+	}{
+		{name: "Created with cover", body: withCover, expectedStatus: http.StatusCreated, expectCover: true},
+		{name: "Unusable cover skipped", body: brokenCover, expectedStatus: http.StatusCreated},
+		{name: "Invalid EPUB", body: []byte("not an epub"), expectedStatus: http.StatusBadRequest},
+		{name: "Repository error", body: withCover, createErr: errors.New("database error"), expectedStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockBookRepository{createFunc: func(ctx context.Context, b *models.Book) error {
+				b.ID = 7
+				return tt.createErr
+			}}
+			coverRepo := newMemCovers(7)
+			req := httptest.NewRequest(http.MethodPost, "/books/from-epub", bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handlers.CreateBookFromEPUB(repo, covers.NewService(coverRepo, newTestBlobs(t)))(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			var book models.Book
+			json.NewDecoder(w.Body).Decode(&book)
+			if book.ID != 7 || book.Title != "The Left Hand of Darkness" || book.ISBN != "0441478123" {
+				t.Errorf("Unexpected book: %+v", book)
+			}
+			stored := coverRepo.covers[7]
+			if tt.expectCover && (stored == nil || stored.ContentType != "image/jpeg" || stored.Width != 300) {
+				t.Errorf("Expected the JPEG cover stored for book 7, got %+v", stored)
+			}
+			if !tt.expectCover && stored != nil {
+				t.Errorf("Expected no cover, got %+v", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"book-tracker/internal/feed"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFeedContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		book     models.Book
+		expected string
+	}{
+		{name: "Rating and notes", book: models.Book{Rating: 4, Notes: "Loved it.\nReally <did>.\n\nSecond thought"},
+			expected: "<p>Rating: ★★★★☆ (4/5)</p><p>Loved it.<br>Really &lt;did&gt;.</p><p>Second thought</p>"},
+		{name: "Unrated", book: models.Book{Notes: "  Short  "}, expected: "<p>Short</p>"},
+		{name: "Empty", book: models.Book{}, expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := feed.Content(tt.book); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFinishedFeed(t *testing.T) {
+	finished := time.Date(2024, 6, 2, 20, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)
+	var got models.CatalogFilter
+	mockRepo := &mockCatalogRepository{
+		listFunc: func(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error) {
+			got = filter
+			return []models.Book{{
+				ID: 4, Title: "Dune", Author: "Frank Herbert", Finished: true, Rating: 5, Notes: "Spice!",
+				Tags: models.Tags{"sf"}, FinishedAt: &finished, UpdatedAt: updated,
+			}}, 1, nil
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/feeds/finished.atom", handlers.FinishedFeed(mockRepo, handlers.FeedAtom)).Methods("GET")
+	router.HandleFunc("/feeds/finished.rss", handlers.FinishedFeed(mockRepo, handlers.FeedRSS)).Methods("GET")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/feeds/finished.atom", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("Expected an Atom feed, got status %d and %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !got.Finished || got.Order != models.CatalogOrderFinished || got.Limit == 0 {
+		t.Errorf("Expected the latest finished books, got filter %+v", got)
+	}
+	var atom struct {
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Title     string `xml:"title"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+			Content   string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if atom.Updated != "2024-06-03T09:30:00Z" || len(atom.Entries) != 1 {
+		t.Fatalf("Unexpected feed: %+v", atom)
+	}
+	e := atom.Entries[0]
+	if e.ID != "urn:book-tracker:book:4" || e.Title != "Dune by Frank Herbert" || e.Published != "2024-06-02T20:00:00Z" ||
+		e.Content != "<p>Rating: ★★★★★ (5/5)</p><p>Spice!</p>" {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+
+	// Conditional requests get 304 until the feed changes
+	etag, modified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if etag == "" || modified != "" {
+		t.Fatalf("Expected only an ETag, got %q and Last-Modified %q", etag, modified)
+	}
+	conditional := []struct {
+		header, value string
+		expected      int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"stale"`, http.StatusOK},
+		{"If-Modified-Since", "Mon, 03 Jun 2024 09:30:00 GMT", http.StatusOK},
+	}
+	for _, c := range conditional {
+		req := httptest.NewRequest("GET", "/feeds/finished.atom", nil)
+		req.Header.Set(c.header, c.value)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != c.expected {
+			t.Errorf("%s: %s: expected status %d, got %d", c.header, c.value, c.expected, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/feeds/finished.rss", nil))
+	var rss struct {
+		Channel struct {
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				GUID        string `xml:"guid"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Failed to parse RSS: %v", err)
+	}
+	if len(rss.Channel.Items) != 1 || rss.Channel.Items[0].PubDate != "Sun, 02 Jun 2024 20:00:00 +0000" ||
+		rss.Channel.Items[0].GUID != "urn:book-tracker:book:4" || rss.Channel.LastBuildDate != "Mon, 03 Jun 2024 09:30:00 +0000" {
+		t.Errorf("Unexpected RSS: %+v", rss)
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("Expected the RSS and Atom feeds to have different ETags")
+	}
+}
+
+func TestFinishedFeedBookLeaves(t *testing.T) {
+	// Dropping an older book leaves the newest timestamp unchanged, but the
+	// feed has still changed
+	updated := time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)
+	books := []models.Book{
+		{ID: 4, Title: "Dune", Finished: true, UpdatedAt: updated},
+		{ID: 2, Title: "Emma", Finished: true, UpdatedAt: updated.Add(-time.Hour)},
+	}
+	mockRepo := &mockCatalogRepository{
+		listFunc: func(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error) {
+			return books, len(books), nil
+		},
+	}
+	handler := handlers.FinishedFeed(mockRepo, handlers.FeedAtom)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/feeds/finished.atom", nil))
+	etag := rr.Header().Get("ETag")
+
+	books = books[:1]
+	req := httptest.NewRequest("GET", "/feeds/finished.atom", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the changed feed to be sent, got status %d", rr.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package unit
+
+import (
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const goodreadsExport = `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies
+234225,"Dune (Dune, #1)",Frank Herbert,"Herbert, Frank",,"=""0441013597""","=""9780441013593""",5,4.27,Ace Books,Paperback,658,2005,1965,2023/03/14,2022/12/01,"sci-fi, favorites","sci-fi (#3), favorites (#1)",read,"Spice must flow.<br/><br/>A &amp; B",,Reread soon,2,0
+5907,The Hobbit,J.R.R. Tolkien,"Tolkien, J.R.R.",,"=""""","=""""",0,4.28,Houghton Mifflin,Paperback,366,2002,1937,,2024/01/05,to-read,to-read (#12),to-read,,,,0,0
+`
+
+const storyGraphExport = `Title,Authors,Contributors,ISBN/UID,Format,Read Status,Date Added,Last Date Read,Dates Read,Read Count,Moods,Pace,Character- or Plot-Driven?,Strong Character Development?,Loveable Characters?,Diverse Characters?,Flawed Characters?,Star Rating,Review,Content Warnings,Content Warning Description,Tags,Owned?
+Piranesi,Susanna Clarke,,9781635575637,hardcover,read,2023/05/01,2023/05/20,"2023/05/10-2023/05/20, 2022/08/09",2,mysterious,medium,Plot,Yes,Yes,No,Yes,4.75,<p>Beautiful.</p>,,,"fantasy, Comfort",Yes
+Untitled Draft,Some Author,,,digital,currently-reading,2024/02/02,,,0,,,,,,,,,,,,,No
+`
+
+func TestParseGoodreads(t *testing.T) {
+	records, err := importer.ParseGoodreads(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	dune := records[0]
+	if len(dune.Errors) != 0 {
+		t.Fatalf("Unexpected errors: %v", dune.Errors)
+	}
+	read := time.Date(2023, 3, 14, 0, 0, 0, 0, time.UTC)
+	expected := models.Book{
+		Title:      "Dune (Dune, #1)",
+		Author:     "Frank Herbert",
+		ISBN:       "9780441013593",
+		Publisher:  "Ace Books",
+		Year:       1965,
+		Progress:   100,
+		Notes:      "Spice must flow.\n\nA & B\n\nReread soon",
+		Finished:   true,
+		Rating:     5,
+		Shelf:      models.ShelfRead,
+		Tags:       models.Tags{"favorites", "sci-fi"},
+		Source:     importer.SourceGoodreads,
+		SourceID:   "234225",
+		FinishedAt: &read,
+		CreatedAt:  time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(dune.Book, expected) {
+		t.Errorf("Unexpected book:\n got %+v\nwant %+v", dune.Book, expected)
+	}
+
+	// Read twice, but only the latest read is dated
+	if !reflect.DeepEqual(dune.Reads, []models.Read{{}, {FinishedAt: &read}}) {
+		t.Errorf("Unexpected reads: %+v", dune.Reads)
+	}
+
+	hobbit := records[1].Book
+	if hobbit.ISBN != "" || hobbit.Shelf != models.ShelfToRead || hobbit.Finished || len(hobbit.Tags) != 0 || hobbit.FinishedAt != nil {
+		t.Errorf("Unexpected to-read book: %+v", hobbit)
+	}
+	if len(records[1].Reads) != 0 {
+		t.Errorf("Expected an unread book to have no reads, got %+v", records[1].Reads)
+	}
+}
+
+func TestParseStoryGraph(t *testing.T) {
+	records, err := importer.ParseStoryGraph(strings.NewReader(storyGraphExport))
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	piranesi := records[0].Book
+	if piranesi.ISBN != "9781635575637" || piranesi.SourceID != "9781635575637" || piranesi.Rating != 5 {
+		t.Errorf("Unexpected identifiers or rating: %+v", piranesi)
+	}
+	if !piranesi.Finished || piranesi.Notes != "Beautiful." || !reflect.DeepEqual(piranesi.Tags, models.Tags{"comfort", "fantasy"}) {
+		t.Errorf("Unexpected status, notes or tags: %+v", piranesi)
+	}
+
+	first, second := time.Date(2022, 8, 9, 0, 0, 0, 0, time.UTC), time.Date(2023, 5, 20, 0, 0, 0, 0, time.UTC)
+	if !reflect.DeepEqual(records[0].Reads, []models.Read{{FinishedAt: &first}, {FinishedAt: &second}}) {
+		t.Errorf("Unexpected reads: %+v", records[0].Reads)
+	}
+
+	draft := records[1].Book
+	if draft.Shelf != models.ShelfCurrentlyReading || !strings.HasPrefix(draft.SourceID, "title:") {
+		t.Errorf("Unexpected book without ISBN: %+v", draft)
+	}
+	again, _ := importer.ParseStoryGraph(strings.NewReader(storyGraphExport))
+	if again[1].Book.SourceID != draft.SourceID {
+		t.Error("Expected a stable source id across imports")
+	}
+}
+
+func TestParseGoodreadsRejectsOtherCSV(t *testing.T) {
+	if _, err := importer.ParseGoodreads(strings.NewReader("title,author\nDune,Frank Herbert\n")); err == nil {
+		t.Error("Expected an error for a CSV that is not a Goodreads export")
+	}
+}
+
+func TestPlanReimportBySource(t *testing.T) {
+	existing := []models.Book{{ID: 3, Title: "Dune", Author: "Frank Herbert", Source: importer.SourceGoodreads, SourceID: "234225"}}
+	records, err := importer.ParseGoodreads(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	results := importer.Plan(records, existing)
+	if results[0].Action != importer.ActionUpdate || results[0].ID != 3 {
+		t.Errorf("Expected re-imported book to update book 3, got %+v", results[0])
+	}
+	if results[1].Action != importer.ActionCreate {
+		t.Errorf("Expected new book to be created, got %+v", results[1])
+	}
+}
+
+func TestNewTags(t *testing.T) {
+	if got := models.NewTags(" Sci-Fi", "favorites", "sci-fi", ""); !reflect.DeepEqual(got, models.Tags{"favorites", "sci-fi"}) {
+		t.Errorf("Unexpected tags %v", got)
+	}
+	if v, err := models.Tags(nil).Value(); err != nil || v != "{}" {
+		t.Errorf("Expected nil tags to store as {}, got %v (%v)", v, err)
+	}
+}
+
+func TestImportGoodreadsMerges(t *testing.T) {
+	var updated []models.Book
+	recorded := make(map[int][]models.Read)
+	mockRepo := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) {
+			return []models.Book{{ID: 5, Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593",
+				Progress: 40, Notes: "Mine", Tags: models.Tags{"owned"}}}, nil
+		},
+		bulkFunc: func(ctx context.Context, books []*models.Book) error { return nil },
+		updateFunc: func(ctx context.Context, book *models.Book) error {
+			updated = append(updated, *book)
+			return nil
+		},
+		readsFunc: func(ctx context.Context, id int, reads []models.Read) error {
+			recorded[id] = reads
+			return nil
+		},
+	}
+	store := &mockStore{repos: repository.Repos{Books: mockRepo}}
+	router := mux.NewRouter()
+	router.HandleFunc("/books/import", handlers.ImportCSV(store)).Methods("POST")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/books/import?format=goodreads", strings.NewReader(goodreadsExport)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if len(updated) != 1 {
+		t.Fatalf("Expected the matching book to be updated, got %+v", updated)
+	}
+	dune := updated[0]
+	if dune.ID != 5 || dune.Title != "Dune" || dune.Progress != 100 || !strings.HasPrefix(dune.Notes, "Mine\n\n") ||
+		!reflect.DeepEqual(dune.Tags, models.Tags{"favorites", "owned", "sci-fi"}) {
+		t.Errorf("Expected the export merged into the stored book, got %+v", dune)
+	}
+	if len(recorded) != 1 || len(recorded[5]) != 2 {
+		t.Errorf("Expected both reads of the merged book to be recorded, got %+v", recorded)
+	}
+}
@@ -1,6 +1,8 @@
 package unit
 
 import (
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
 	"book-tracker/internal/handlers"
 	"book-tracker/internal/models"
 	"book-tracker/internal/repository"
@@ -10,6 +12,8 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"time"
 
 	"testing"
 
@@ -17,20 +21,40 @@ import (
 )
 
 type mockBookRepository struct {
-	createFunc func(ctx context.Context, book *models.Book) error
-	getFunc    func(ctx context.Context) ([]models.Book, error)
-	updateFunc func(ctx context.Context, book *models.Book) error
-	deleteFunc func(ctx context.Context, id int) error
+	createFunc  func(ctx context.Context, book *models.Book) error
+	bulkFunc    func(ctx context.Context, books []*models.Book) error
+	getFunc     func(ctx context.Context) ([]models.Book, error)
+	getIDsFunc  func(ctx context.Context, ids []int) ([]models.Book, error)
+	streamFunc  func(ctx context.Context, fn func(book models.Book) error) error
+	updateFunc  func(ctx context.Context, book *models.Book) error
+	deleteFunc  func(ctx context.Context, id int) error
+	mergeFunc   func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error)
+	trashFunc   func(ctx context.Context) ([]models.Book, error)
+	restoreFunc func(ctx context.Context, id int) (*models.Book, error)
+	revertFunc  func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error)
+	readsFunc   func(ctx context.Context, id int, reads []models.Read) error
 }
 
 func (m *mockBookRepository) CreateBook(ctx context.Context, book *models.Book) error {
 	return m.createFunc(ctx, book)
 }
 
+func (m *mockBookRepository) CreateBooks(ctx context.Context, books []*models.Book) error {
+	return m.bulkFunc(ctx, books)
+}
+
 func (m *mockBookRepository) GetBooks(ctx context.Context) ([]models.Book, error) {
 	return m.getFunc(ctx)
 }
 
+func (m *mockBookRepository) GetBooksByIDs(ctx context.Context, ids []int) ([]models.Book, error) {
+	return m.getIDsFunc(ctx, ids)
+}
+
+func (m *mockBookRepository) StreamBooks(ctx context.Context, fn func(book models.Book) error) error {
+	return m.streamFunc(ctx, fn)
+}
+
 func (m *mockBookRepository) UpdateBook(ctx context.Context, book *models.Book) error {
 	return m.updateFunc(ctx, book)
 }
@@ -39,6 +63,26 @@ func (m *mockBookRepository) DeleteBook(ctx context.Context, id int) error {
 	return m.deleteFunc(ctx, id)
 }
 
+func (m *mockBookRepository) MergeBooks(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+	return m.mergeFunc(ctx, targetID, sourceIDs)
+}
+
+func (m *mockBookRepository) GetTrash(ctx context.Context) ([]models.Book, error) {
+	return m.trashFunc(ctx)
+}
+
+func (m *mockBookRepository) RestoreBook(ctx context.Context, id int) (*models.Book, error) {
+	return m.restoreFunc(ctx, id)
+}
+
+func (m *mockBookRepository) RevertBook(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+	return m.revertFunc(ctx, id, to)
+}
+
+func (m *mockBookRepository) RecordReads(ctx context.Context, id int, reads []models.Read) error {
+	return m.readsFunc(ctx, id, reads)
+}
+
 var _ repository.BookRepositoryInterface = &mockBookRepository{}
 
 func TestCreateBook(t *testing.T) {
@@ -187,6 +231,14 @@ func TestUpdateBook(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
 		},
+		{
+			name:           "Book not found",
+			id:             "2",
+			inputBook:      models.Book{Title: "Updated Book", Author: "Updated Author"},
+			updateFunc:     func(ctx context.Context, book *models.Book) error { return repository.ErrNotFound },
+			expectedStatus: http.StatusNotFound,
+			expectError:    true,
+		},
 		{
 			name:           "Repository error",
 			id:             "1",
@@ -247,6 +299,12 @@ func TestDeleteBook(t *testing.T) {
 			deleteFunc:     func(ctx context.Context, id int) error { return nil },
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:           "Book not found",
+			id:             "2",
+			deleteFunc:     func(ctx context.Context, id int) error { return repository.ErrNotFound },
+			expectedStatus: http.StatusNotFound,
+		},
 		{
 			name:           "Repository error",
 			id:             "1",
@@ -273,3 +331,378 @@ func TestDeleteBook(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDuplicates(t *testing.T) {
+	mockRepo := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) {
+			return []models.Book{
+				{ID: 1, Title: "The Hobbit", Author: "J.R.R. Tolkien"},
+				{ID: 2, Title: "Hobbit", Author: "Tolkien, J. R. R."},
+				{ID: 3, Title: "Dune", Author: "Frank Herbert"},
+			}, nil
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/books/duplicates", nil)
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/books/duplicates", handlers.GetDuplicates(mockRepo)).Methods("GET")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var groups []struct {
+		Reason string        `json:"reason"`
+		Books  []models.Book `json:"books"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&groups); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Books) != 2 {
+		t.Errorf("Expected one group of two books, got %+v", groups)
+	}
+}
+
+func TestMergeBooks(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mergeFunc      func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error)
+		expectedStatus int
+	}{
+		{
+			name: "Successful merge",
+			body: `{"target_id":1,"source_ids":[2,3]}`,
+			mergeFunc: func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+				return &models.Book{ID: targetID, Title: "Merged"}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Target listed as source",
+			body:           `{"target_id":1,"source_ids":[1]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing sources",
+			body:           `{"target_id":1}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown book",
+			body: `{"target_id":1,"source_ids":[99]}`,
+			mergeFunc: func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+				return nil, repository.ErrNotFound
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Repository error",
+			body: `{"target_id":1,"source_ids":[2]}`,
+			mergeFunc: func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+				return nil, errors.New("database error")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/books/merge", bytes.NewReader([]byte(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockBookRepository{
+				mergeFunc: tt.mergeFunc,
+			}
+			router := mux.NewRouter()
+			coverService := covers.NewService(newMemCovers(1, 2, 3), newTestBlobs(t))
+			router.HandleFunc("/books/merge", handlers.MergeBooks(mockRepo, coverService)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestMergeBooksCovers(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetCover bool
+		mergeErr    error
+		expectedSHA func(source, target *models.Cover) string
+	}{
+		{name: "Target without a cover takes the source's", expectedSHA: func(source, target *models.Cover) string { return source.SHA256 }},
+		{name: "Target keeps its own cover", targetCover: true, expectedSHA: func(source, target *models.Cover) string { return target.SHA256 }},
+		{name: "Failed merge", mergeErr: errors.New("database down")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, blobs := newMemCovers(1, 2, 3), newTestBlobs(t)
+			coverService := covers.NewService(repo, blobs)
+			ctx := context.Background()
+			source, err := coverService.Set(ctx, 3, testImage(t, "png", 40, 60), "image/png")
+			if err != nil {
+				t.Fatalf("Failed to set cover: %v", err)
+			}
+			var target *models.Cover
+			if tt.targetCover {
+				if target, err = coverService.Set(ctx, 1, testImage(t, "jpeg", 30, 30), "image/jpeg"); err != nil {
+					t.Fatalf("Failed to set cover: %v", err)
+				}
+			}
+			mockRepo := &mockBookRepository{
+				// The merge moves the first source's cover row in its
+				// transaction
+				mergeFunc: func(ctx context.Context, targetID int, sourceIDs []int) (*models.Book, error) {
+					if tt.mergeErr != nil {
+						return nil, tt.mergeErr
+					}
+					if _, ok := repo.covers[targetID]; !ok {
+						for _, id := range sourceIDs {
+							if c, ok := repo.covers[id]; ok {
+								c.BookID = targetID
+								repo.covers[targetID] = c
+								delete(repo.covers, id)
+								break
+							}
+						}
+					}
+					return &models.Book{ID: targetID, Title: "Merged"}, nil
+				},
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/merge", handlers.MergeBooks(mockRepo, coverService)).Methods("POST")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/books/merge", strings.NewReader(`{"target_id":1,"source_ids":[2,3]}`)))
+
+			moved := *source
+			moved.BookID = 1
+			if tt.mergeErr != nil {
+				if w.Code != http.StatusInternalServerError {
+					t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+				}
+				if _, err := blobs.Get(ctx, covers.OriginalKey(&moved)); err != blob.ErrNotFound {
+					t.Errorf("Expected the copied image to be deleted, got %v", err)
+				}
+				if _, err := blobs.Get(ctx, covers.OriginalKey(source)); err != nil {
+					t.Errorf("Expected the source's image to be kept, got %v", err)
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			merged, err := coverService.Get(ctx, 1, covers.SizeOriginal)
+			if err != nil {
+				t.Fatalf("Expected the merged book to have a cover, got %v", err)
+			}
+			if want := tt.expectedSHA(source, target); merged.SHA256 != want {
+				t.Errorf("Expected cover %s, got %s", want, merged.SHA256)
+			}
+			if thumb, err := coverService.Get(ctx, 1, "small"); err != nil || thumb.ContentType != covers.ThumbnailType {
+				t.Errorf("Expected a thumbnail of the merged book's cover, got %v", err)
+			}
+			if !tt.targetCover {
+				if _, err := blobs.Get(ctx, covers.OriginalKey(source)); err != blob.ErrNotFound {
+					t.Errorf("Expected the source's image to be deleted once moved, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTrash(t *testing.T) {
+	tests := []struct {
+		name           string
+		trashFunc      func(ctx context.Context) ([]models.Book, error)
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name: "Successful get",
+			trashFunc: func(ctx context.Context) ([]models.Book, error) {
+				return []models.Book{{ID: 1, Title: "Deleted Book", Author: "Test Author"}}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:           "Empty trash",
+			trashFunc:      func(ctx context.Context) ([]models.Book, error) { return nil, nil },
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+		},
+		{
+			name:           "Repository error",
+			trashFunc:      func(ctx context.Context) ([]models.Book, error) { return nil, errors.New("database error") },
+			expectedStatus: http.StatusInternalServerError,
+			expectedCount:  -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/trash", nil)
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockBookRepository{
+				trashFunc: tt.trashFunc,
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/trash", handlers.GetTrash(mockRepo)).Methods("GET")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedCount >= 0 {
+				var books []models.Book
+				if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				if len(books) != tt.expectedCount {
+					t.Errorf("Expected %d books, got %d", tt.expectedCount, len(books))
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreBook(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		restoreFunc    func(ctx context.Context, id int) (*models.Book, error)
+		expectedStatus int
+	}{
+		{
+			name: "Successful restore",
+			id:   "1",
+			restoreFunc: func(ctx context.Context, id int) (*models.Book, error) {
+				return &models.Book{ID: id, Title: "Restored Book"}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid ID",
+			id:             "invalid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not in trash",
+			id:             "2",
+			restoreFunc:    func(ctx context.Context, id int) (*models.Book, error) { return nil, repository.ErrNotFound },
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Repository error",
+			id:             "1",
+			restoreFunc:    func(ctx context.Context, id int) (*models.Book, error) { return nil, errors.New("database error") },
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/books/"+tt.id+"/restore", nil)
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockBookRepository{
+				restoreFunc: tt.restoreFunc,
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/restore", handlers.RestoreBook(mockRepo)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRevertBook(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		revertFunc     func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error)
+		expectedStatus int
+		expectedPoint  models.RevertPoint
+	}{
+		{
+			name: "Revert to version",
+			path: "/books/1/revert?to=2",
+			revertFunc: func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+				return &models.Book{ID: id, Title: "Reverted"}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedPoint:  models.RevertPoint{Version: 2},
+		},
+		{
+			name: "Revert to timestamp",
+			path: "/books/1/revert?to=2024-05-01T12:00:00Z",
+			revertFunc: func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+				return &models.Book{ID: id, Title: "Reverted"}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedPoint:  models.RevertPoint{At: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:           "Missing target",
+			path:           "/books/1/revert",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid version",
+			path:           "/books/1/revert?to=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown version",
+			path: "/books/1/revert?to=9",
+			revertFunc: func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+				return nil, repository.ErrNoSuchVersion
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedPoint:  models.RevertPoint{Version: 9},
+		},
+		{
+			name: "Repository error",
+			path: "/books/1/revert?to=1",
+			revertFunc: func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+				return nil, errors.New("database error")
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedPoint:  models.RevertPoint{Version: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			var got models.RevertPoint
+			mockRepo := &mockBookRepository{
+				revertFunc: func(ctx context.Context, id int, to models.RevertPoint) (*models.Book, error) {
+					got = to
+					return tt.revertFunc(ctx, id, to)
+				},
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/revert", handlers.RevertBook(mockRepo)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.revertFunc != nil && (got.Version != tt.expectedPoint.Version || !got.At.Equal(tt.expectedPoint.At)) {
+				t.Errorf("Expected revert point %+v, got %+v", tt.expectedPoint, got)
+			}
+		})
+	}
+}
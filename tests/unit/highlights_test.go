@@ -0,0 +1,305 @@
+package unit
+
+import (
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type mockHighlightRepository struct {
+	createOneFunc func(ctx context.Context, highlight *models.Highlight) error
+	createFunc    func(ctx context.Context, highlights []*models.Highlight) error
+	getFunc       func(ctx context.Context, bookID int) ([]models.Highlight, error)
+	getAllFunc    func(ctx context.Context) ([]models.Highlight, error)
+	getOneFunc    func(ctx context.Context, bookID, id int) (*models.Highlight, error)
+	updateFunc    func(ctx context.Context, highlight *models.Highlight) error
+	deleteFunc    func(ctx context.Context, bookID, id int) error
+	searchFunc    func(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error)
+}
+
+func (m *mockHighlightRepository) CreateHighlight(ctx context.Context, highlight *models.Highlight) error {
+	return m.createOneFunc(ctx, highlight)
+}
+
+func (m *mockHighlightRepository) CreateHighlights(ctx context.Context, highlights []*models.Highlight) error {
+	return m.createFunc(ctx, highlights)
+}
+
+func (m *mockHighlightRepository) GetHighlights(ctx context.Context, bookID int) ([]models.Highlight, error) {
+	return m.getFunc(ctx, bookID)
+}
+
+func (m *mockHighlightRepository) GetAllHighlights(ctx context.Context) ([]models.Highlight, error) {
+	return m.getAllFunc(ctx)
+}
+
+func (m *mockHighlightRepository) GetHighlight(ctx context.Context, bookID, id int) (*models.Highlight, error) {
+	return m.getOneFunc(ctx, bookID, id)
+}
+
+func (m *mockHighlightRepository) UpdateHighlight(ctx context.Context, highlight *models.Highlight) error {
+	return m.updateFunc(ctx, highlight)
+}
+
+func (m *mockHighlightRepository) DeleteHighlight(ctx context.Context, bookID, id int) error {
+	return m.deleteFunc(ctx, bookID, id)
+}
+
+func (m *mockHighlightRepository) SearchHighlights(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error) {
+	return m.searchFunc(ctx, query, limit, offset)
+}
+
+func TestHighlightValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		highlight models.Highlight
+		valid     bool
+	}{
+		{name: "Defaults to highlight", highlight: models.Highlight{Text: "A passage"}, valid: true},
+		{name: "Bookmark without text", highlight: models.Highlight{Kind: models.HighlightKindBookmark, Location: "40"}, valid: true},
+		{name: "Colored note", highlight: models.Highlight{Kind: models.HighlightKindNote, Text: "Why?", Color: "blue"}, valid: true},
+		{name: "Highlight without text", highlight: models.Highlight{Kind: models.HighlightKindHighlight}, valid: false},
+		{name: "Unknown kind", highlight: models.Highlight{Kind: "underline", Text: "A passage"}, valid: false},
+		{name: "Unknown color", highlight: models.Highlight{Text: "A passage", Color: "red"}, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.highlight.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Expected valid=%v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestCreateHighlight(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		createFunc     func(ctx context.Context, highlight *models.Highlight) error
+		expectedStatus int
+	}{
+		{
+			name: "Successful creation",
+			id:   "1",
+			body: `{"text":"Fear is the mind-killer.","comment":"Litany","color":"yellow","page":"12"}`,
+			createFunc: func(ctx context.Context, highlight *models.Highlight) error {
+				if highlight.BookID != 1 || highlight.Kind != models.HighlightKindHighlight {
+					return errors.New("unexpected highlight")
+				}
+				highlight.ID = 7
+				return nil
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Invalid highlight",
+			id:             "1",
+			body:           `{"text":"","kind":"highlight"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid ID",
+			id:             "invalid",
+			body:           `{"text":"A passage"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Book not found",
+			id:             "99",
+			body:           `{"text":"A passage"}`,
+			createFunc:     func(ctx context.Context, highlight *models.Highlight) error { return repository.ErrNotFound },
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/books/"+tt.id+"/highlights", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockHighlightRepository{createOneFunc: tt.createFunc}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/highlights", handlers.CreateHighlight(mockRepo)).Methods("POST")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateHighlight(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		updateFunc     func(ctx context.Context, highlight *models.Highlight) error
+		expectedStatus int
+	}{
+		{
+			name: "Successful update",
+			path: "/books/1/highlights/7",
+			body: `{"text":"Fear is the mind-killer.","comment":"Revisit"}`,
+			updateFunc: func(ctx context.Context, highlight *models.Highlight) error {
+				if highlight.ID != 7 || highlight.BookID != 1 || highlight.Comment != "Revisit" {
+					return errors.New("unexpected highlight")
+				}
+				return nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid color",
+			path:           "/books/1/highlights/7",
+			body:           `{"text":"A passage","color":"red"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Highlight not found",
+			path:           "/books/1/highlights/99",
+			body:           `{"text":"A passage"}`,
+			updateFunc:     func(ctx context.Context, highlight *models.Highlight) error { return repository.ErrHighlightNotFound },
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockHighlightRepository{updateFunc: tt.updateFunc}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/highlights/{highlight_id}", handlers.UpdateHighlight(mockRepo)).Methods("PUT")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteHighlight(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		deleteFunc     func(ctx context.Context, bookID, id int) error
+		expectedStatus int
+	}{
+		{
+			name:           "Successful deletion",
+			path:           "/books/1/highlights/7",
+			deleteFunc:     func(ctx context.Context, bookID, id int) error { return nil },
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Invalid highlight ID",
+			path:           "/books/1/highlights/invalid",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Highlight not found",
+			path:           "/books/1/highlights/99",
+			deleteFunc:     func(ctx context.Context, bookID, id int) error { return repository.ErrHighlightNotFound },
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockHighlightRepository{deleteFunc: tt.deleteFunc}
+			router := mux.NewRouter()
+			router.HandleFunc("/books/{id}/highlights/{highlight_id}", handlers.DeleteHighlight(mockRepo)).Methods("DELETE")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		searchFunc     func(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error)
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:  "Successful search",
+			query: "?q=fear&limit=10&offset=5",
+			searchFunc: func(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error) {
+				if query != "fear" || limit != 10 || offset != 5 {
+					return nil, errors.New("unexpected parameters")
+				}
+				return []models.HighlightMatch{{Highlight: models.Highlight{ID: 7}, BookTitle: "Dune", Snippet: "**Fear** is the mind-killer."}}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			name:  "No matches",
+			query: "?q=nothing",
+			searchFunc: func(ctx context.Context, query string, limit, offset int) ([]models.HighlightMatch, error) {
+				return nil, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+		},
+		{
+			name:           "Missing query",
+			query:          "",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?q=fear&limit=-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/highlights/search"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			mockRepo := &mockHighlightRepository{searchFunc: tt.searchFunc}
+			router := mux.NewRouter()
+			router.HandleFunc("/highlights/search", handlers.SearchHighlights(mockRepo)).Methods("GET")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var matches []models.HighlightMatch
+			if err := json.NewDecoder(w.Body).Decode(&matches); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if matches == nil || len(matches) != tt.expectedCount {
+				t.Errorf("Expected %d matches as a JSON array, got %v", tt.expectedCount, matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package unit
+
+import (
+	"book-tracker/internal/blob"
+	"book-tracker/internal/covers"
+	"book-tracker/internal/jobs"
+	"book-tracker/internal/models"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePurger struct {
+	mu      sync.Mutex
+	cutoffs []time.Time
+}
+
+func (f *fakePurger) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, []models.Cover, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cutoffs = append(f.cutoffs, deletedBefore)
+	return 1, []models.Cover{{BookID: 7, SHA256: "abc"}}, nil
+}
+
+func (f *fakePurger) calls() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.cutoffs...)
+}
+
+func TestRunTrashPurge(t *testing.T) {
+	purger := &fakePurger{}
+	blobs := newTestBlobs(t)
+	cover := models.Cover{BookID: 7, SHA256: "abc"}
+	keys := []string{covers.OriginalKey(&cover)}
+	for size := range covers.Sizes {
+		keys = append(keys, covers.OriginalKey(&cover)+"-"+size)
+	}
+	for _, key := range keys {
+		if err := blobs.Put(context.Background(), key, []byte("image"), "image/png"); err != nil {
+			t.Fatalf("Failed to store blob: %v", err)
+		}
+	}
+	coverService := covers.NewService(newMemCovers(), blobs)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		jobs.RunTrashPurge(ctx, purger, coverService, 24*time.Hour, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(purger.calls()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	calls := purger.calls()
+	if len(calls) < 2 {
+		t.Fatalf("Expected at least 2 purge runs, got %d", len(calls))
+	}
+	age := time.Since(calls[0])
+	if age < 24*time.Hour || age > 25*time.Hour {
+		t.Errorf("Expected cutoff about 24h in the past, got %s", age)
+	}
+	for _, key := range keys {
+		if _, err := blobs.Get(context.Background(), key); err != blob.ErrNotFound {
+			t.Errorf("Expected blob %s of the purged cover to be deleted, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package unit
+
+import (
+	"book-tracker/internal/importer"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const clippingsFile = "\uFEFFDune (Herbert, Frank)\r\n" +
+	"- Your Highlight on page 12 | Location 180-181 | Added on Sunday, March 5, 2023 10:15:32 PM\r\n" +
+	"\r\n" +
+	"Fear is the mind-killer.\r\n" +
+	"==========\r\n" +
+	"Dune (Herbert, Frank)\r\n" +
+	"- Your Highlight on page 12 | Location 180-182 | Added on Sunday, March 5, 2023 10:16:01 PM\r\n" +
+	"\r\n" +
+	"Fear is the mind-killer. Fear is the little-death.\r\n" +
+	"==========\r\n" +
+	"Dune (Herbert, Frank)\r\n" +
+	"- Your Note on page 12 | Location 182 | Added on Sunday, March 5, 2023 10:17:00 PM\r\n" +
+	"\r\n" +
+	"Litany against fear\r\n" +
+	"==========\r\n" +
+	"Dune (Herbert, Frank)\r\n" +
+	"- Your Bookmark on page 40 | Location 610 | Added on Monday, March 6, 2023 7:02:11 AM\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"==========\r\n" +
+	"Der Process (Kafka, Franz)\r\n" +
+	"- Ihre Markierung auf Seite 7 | bei Position 95-96 | Hinzugefügt am Dienstag, 4. April 2023 21:05:09\r\n" +
+	"\r\n" +
+	"Jemand musste Josef K. verleumdet haben.\r\n" +
+	"==========\r\n" +
+	"L'Étranger (Camus, Albert)\r\n" +
+	"- Votre surlignement à l'emplacement 12-13 | Ajouté le samedi 2 décembre 2023 09:00:00\r\n" +
+	"\r\n" +
+	"Aujourd'hui, maman est morte.\r\n" +
+	"==========\r\n" +
+	"Cien años de soledad (García Márquez, Gabriel)\r\n" +
+	"- Tu nota en la página xii | posición 40 | Añadido el miércoles, 10 de enero de 2024 18:30:00\r\n" +
+	"\r\n" +
+	"Macondo\r\n" +
+	"==========\r\n" +
+	"Broken (Someone)\r\n" +
+	"- Something unexpected\r\n" +
+	"\r\n" +
+	"text\r\n" +
+	"==========\r\n"
+
+func TestParseClippings(t *testing.T) {
+	clippings, err := importer.ParseClippings(strings.NewReader(clippingsFile))
+	if err != nil {
+		t.Fatalf("Failed to parse clippings: %v", err)
+	}
+	if len(clippings) != 7 {
+		t.Fatalf("Expected 7 clippings after dropping the superseded highlight, got %d", len(clippings))
+	}
+
+	tests := []struct {
+		title, author, kind, text, page, location string
+		added                                     time.Time
+	}{
+		{"Dune", "Frank Herbert", models.HighlightKindHighlight, "Fear is the mind-killer. Fear is the little-death.", "12", "180-182",
+			time.Date(2023, 3, 5, 22, 16, 1, 0, time.UTC)},
+		{"Dune", "Frank Herbert", models.HighlightKindNote, "Litany against fear", "12", "182",
+			time.Date(2023, 3, 5, 22, 17, 0, 0, time.UTC)},
+		{"Dune", "Frank Herbert", models.HighlightKindBookmark, "", "40", "610",
+			time.Date(2023, 3, 6, 7, 2, 11, 0, time.UTC)},
+		{"Der Process", "Franz Kafka", models.HighlightKindHighlight, "Jemand musste Josef K. verleumdet haben.", "7", "95-96",
+			time.Date(2023, 4, 4, 21, 5, 9, 0, time.UTC)},
+		{"L'Étranger", "Albert Camus", models.HighlightKindHighlight, "Aujourd'hui, maman est morte.", "", "12-13",
+			time.Date(2023, 12, 2, 9, 0, 0, 0, time.UTC)},
+		{"Cien años de soledad", "Gabriel García Márquez", models.HighlightKindNote, "Macondo", "xii", "40",
+			time.Date(2024, 1, 10, 18, 30, 0, 0, time.UTC)},
+	}
+	for i, tt := range tests {
+		c := clippings[i]
+		h := c.Highlight
+		if len(c.Errors) != 0 {
+			t.Errorf("Clipping %d: unexpected errors %v", i, c.Errors)
+		}
+		if c.Title != tt.title || c.Author != tt.author || h.Kind != tt.kind || h.Text != tt.text ||
+			h.Page != tt.page || h.Location != tt.location {
+			t.Errorf("Clipping %d: unexpected %+v", i, c)
+		}
+		if h.AddedAt == nil || !h.AddedAt.Equal(tt.added) {
+			t.Errorf("Clipping %d: expected added at %v, got %v", i, tt.added, h.AddedAt)
+		}
+	}
+	if broken := clippings[6]; len(broken.Errors) == 0 || broken.Line != 36 {
+		t.Errorf("Expected an error for the unrecognized header on line 36, got %+v", broken)
+	}
+}
+
+func TestImportClippings(t *testing.T) {
+	clippings, err := importer.ParseClippings(strings.NewReader(clippingsFile))
+	if err != nil {
+		t.Fatalf("Failed to parse clippings: %v", err)
+	}
+	existing := []models.Book{
+		{ID: 1, Title: "Dune", Author: "Frank Herbert"},
+		{ID: 2, Title: "The Trial", Author: "Franz Kafka"},
+	}
+	var created []models.Book
+	var added []models.Highlight
+	books := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) { return existing, nil },
+		bulkFunc: func(ctx context.Context, books []*models.Book) error {
+			for _, b := range books {
+				b.ID = 10 + len(created)
+				created = append(created, *b)
+			}
+			return nil
+		},
+	}
+	highlights := &mockHighlightRepository{
+		getFunc: func(ctx context.Context, bookID int) ([]models.Highlight, error) {
+			if bookID != 1 {
+				t.Errorf("Expected stored highlights to be read only for book 1, got %d", bookID)
+			}
+			// the note was imported before
+			return []models.Highlight{clippings[1].Highlight}, nil
+		},
+		createFunc: func(ctx context.Context, hs []*models.Highlight) error {
+			for _, h := range hs {
+				added = append(added, *h)
+			}
+			return nil
+		},
+	}
+	store := &mockStore{repos: repository.Repos{Books: books, Highlights: highlights}}
+
+	report, err := importer.ImportClippings(context.Background(), store, clippings, false)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.BooksMatched != 1 || report.BooksCreated != 3 || report.Added != 5 || report.Duplicates != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if len(created) != 3 || created[0].Title != "Der Process" || created[0].Source != importer.SourceKindle {
+		t.Errorf("Unexpected created books: %+v", created)
+	}
+	if len(added) != 5 || added[0].BookID != 1 || added[2].BookID != created[0].ID {
+		t.Errorf("Unexpected highlights: %+v", added)
+	}
+
+	// A dry run reports the same without writing
+	created, added = nil, nil
+	report, err = importer.ImportClippings(context.Background(), store, clippings, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if !report.DryRun || report.Added != 5 || len(created) != 0 || len(added) != 0 {
+		t.Errorf("Expected a dry run without writes, got %+v", report)
+	}
+}
+
+func TestImportClippingsSharedBooks(t *testing.T) {
+	const file = "Dune (Herbert, Frank)\r\n" +
+		"- Your Highlight on page 12 | Location 180-182 | Added on Sunday, March 5, 2023 10:16:01 PM\r\n" +
+		"\r\n" +
+		"Fear is the mind-killer. Fear is the little-death.\r\n" +
+		"==========\r\n" +
+		"Dune: A Novel (Herbert, Frank)\r\n" +
+		"- Your Highlight on page 12 | Location 180-182 | Added on Sunday, March 5, 2023 10:16:01 PM\r\n" +
+		"\r\n" +
+		"Fear is the mind-killer. Fear is the little-death.\r\n" +
+		"==========\r\n" +
+		"The Hobbit (Tolkien, J.R.R.)\r\n" +
+		"- Your Highlight on page 1 | Location 10-11 | Added on Sunday, March 5, 2023 10:20:00 PM\r\n" +
+		"\r\n" +
+		"In a hole in the ground there lived a hobbit.\r\n" +
+		"==========\r\n" +
+		"The hobbit (Tolkien, J.R.R.)\r\n" +
+		"- Your Highlight on page 1 | Location 10-11 | Added on Sunday, March 5, 2023 10:20:00 PM\r\n" +
+		"\r\n" +
+		"In a hole in the ground there lived a hobbit.\r\n" +
+		"==========\r\n" +
+		"The Hobbit (J.R.R. Tolkien)\r\n" +
+		"- Your Note on page 2 | Location 20 | Added on Sunday, March 5, 2023 10:21:00 PM\r\n" +
+		"\r\n" +
+		"Bilbo\r\n" +
+		"==========\r\n"
+	clippings, err := importer.ParseClippings(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("Failed to parse clippings: %v", err)
+	}
+	var created []models.Book
+	var added []models.Highlight
+	books := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) {
+			return []models.Book{{ID: 1, Title: "Dune", Author: "Frank Herbert"}}, nil
+		},
+		bulkFunc: func(ctx context.Context, books []*models.Book) error {
+			for _, b := range books {
+				b.ID = 10 + len(created)
+				created = append(created, *b)
+			}
+			return nil
+		},
+	}
+	reads := 0
+	highlights := &mockHighlightRepository{
+		getFunc: func(ctx context.Context, bookID int) ([]models.Highlight, error) {
+			reads++
+			return nil, nil
+		},
+		createFunc: func(ctx context.Context, hs []*models.Highlight) error {
+			seen := make(map[string]bool)
+			for _, h := range hs {
+				key := strconv.Itoa(h.BookID) + "\x00" + h.Fingerprint
+				if seen[key] {
+					t.Errorf("Highlight added twice to book %d: %q", h.BookID, h.Text)
+				}
+				seen[key] = true
+				added = append(added, *h)
+			}
+			return nil
+		},
+	}
+	store := &mockStore{repos: repository.Repos{Books: books, Highlights: highlights}}
+
+	report, err := importer.ImportClippings(context.Background(), store, clippings, false)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if reads != 1 {
+		t.Errorf("Expected stored highlights to be read once for the matched book, got %d reads", reads)
+	}
+	if report.BooksMatched != 1 || report.BooksCreated != 1 || report.Added != 3 || report.Duplicates != 2 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if len(created) != 1 || created[0].Title != "The Hobbit" {
+		t.Errorf("Expected one book for every spelling of The Hobbit, got %+v", created)
+	}
+	if len(added) != 3 || added[0].BookID != 1 || added[1].BookID != 10 || added[2].BookID != 10 {
+		t.Errorf("Unexpected highlights: %+v", added)
+	}
+	for _, res := range report.Books {
+		if res.Title == "The Hobbit" && res.BookID != 10 {
+			t.Errorf("Expected every Hobbit group to report book 10, got %+v", res)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package unit
+
+import (
+	"book-tracker/internal/auth"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memLibraries is an in-memory LibraryRepository keyed by library and
+// user id.
+type memLibraries struct {
+	roles map[[2]int]models.Role
+}
+
+func (m *memLibraries) GetRole(ctx context.Context, libraryID, userID int) (models.Role, error) {
+	role, ok := m.roles[[2]int{libraryID, userID}]
+	if !ok {
+		return "", repository.ErrLibraryNotFound
+	}
+	return role, nil
+}
+
+func (m *memLibraries) ListLibraries(ctx context.Context, userID int) ([]models.Library, error) {
+	libraries := []models.Library{{ID: userID, Role: models.RoleOwner}}
+	for k, role := range m.roles {
+		if k[1] == userID {
+			libraries = append(libraries, models.Library{ID: k[0], Role: role})
+		}
+	}
+	return libraries, nil
+}
+
+func (m *memLibraries) ListMembers(ctx context.Context, libraryID int) ([]models.Membership, error) {
+	members := []models.Membership{{LibraryID: libraryID, UserID: libraryID, Role: models.RoleOwner}}
+	for k, role := range m.roles {
+		if k[0] == libraryID {
+			members = append(members, models.Membership{LibraryID: libraryID, UserID: k[1], Role: role})
+		}
+	}
+	return members, nil
+}
+
+func (m *memLibraries) SetMember(ctx context.Context, libraryID, userID int, role models.Role) error {
+	if m.roles == nil {
+		m.roles = map[[2]int]models.Role{}
+	}
+	m.roles[[2]int{libraryID, userID}] = role
+	return nil
+}
+
+func (m *memLibraries) RemoveMember(ctx context.Context, libraryID, userID int) error {
+	if _, ok := m.roles[[2]int{libraryID, userID}]; !ok {
+		return repository.ErrMemberNotFound
+	}
+	delete(m.roles, [2]int{libraryID, userID})
+	return nil
+}
+
+var _ repository.LibraryRepositoryInterface = &memLibraries{}
+
+// newLibraryService returns an auth service with alice (1), bob (2) and
+// carol (3) registered, and their sessions.
+func newLibraryService(t *testing.T) (*auth.Service, *memLibraries, map[string]string) {
+	t.Helper()
+	users, libraries := &memUsers{}, &memLibraries{}
+	svc := auth.NewService(&mockStore{repos: repository.Repos{Users: users}}, users, libraries, time.Hour)
+	sessions := map[string]string{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		creds := models.Credentials{Username: name, Password: "correct horse"}
+		if _, err := svc.Register(context.Background(), creds); err != nil {
+			t.Fatalf("Register %s: %v", name, err)
+		}
+		session, _, err := svc.Login(context.Background(), creds)
+		if err != nil {
+			t.Fatalf("Login %s: %v", name, err)
+		}
+		sessions[name] = session.Token
+	}
+	return svc, libraries, sessions
+}
+
+func TestRole(t *testing.T) {
+	tests := []struct {
+		role     models.Role
+		required models.Role
+		allowed  bool
+	}{
+		{models.RoleOwner, models.RoleOwner, true},
+		{models.RoleOwner, models.RoleViewer, true},
+		{models.RoleEditor, models.RoleEditor, true},
+		{models.RoleEditor, models.RoleOwner, false},
+		{models.RoleViewer, models.RoleEditor, false},
+		{models.RoleViewer, models.RoleViewer, true},
+		{"admin", models.RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.allowed {
+			t.Errorf("Expected %s allows %s to be %v", tt.role, tt.required, tt.allowed)
+		}
+	}
+	if err := models.Role("admin").Validate(); !errors.Is(err, models.ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+	if role := auth.Role(context.Background()); role != models.RoleOwner {
+		t.Errorf("Expected contexts without a role to act as owner, got %s", role)
+	}
+}
+
+func TestLibraryMembers(t *testing.T) {
+	svc, _, _ := newLibraryService(t)
+	ctx := context.Background()
+	alice, _ := svc.CheckPassword(ctx, "alice", "correct horse")
+	bob, _ := svc.CheckPassword(ctx, "bob", "correct horse")
+	carol, _ := svc.CheckPassword(ctx, "carol", "correct horse")
+
+	member, err := svc.SetMember(ctx, alice, alice.ID, "bob", models.RoleEditor)
+	if err != nil || *member != (models.Membership{LibraryID: alice.ID, UserID: bob.ID, Username: "bob", Role: models.RoleEditor}) {
+		t.Fatalf("Expected bob as editor, got %+v, %v", member, err)
+	}
+	if role, err := svc.LibraryRole(ctx, bob, alice.ID); err != nil || role != models.RoleEditor {
+		t.Errorf("Expected bob to edit alice's library, got %s, %v", role, err)
+	}
+	if _, err := svc.LibraryRole(ctx, carol, alice.ID); !errors.Is(err, repository.ErrLibraryNotFound) {
+		t.Errorf("Expected ErrLibraryNotFound for a non-member, got %v", err)
+	}
+	if _, err := svc.Members(ctx, carol, alice.ID); !errors.Is(err, repository.ErrLibraryNotFound) {
+		t.Errorf("Expected non-members not to list members, got %v", err)
+	}
+	if members, err := svc.Members(ctx, bob, alice.ID); err != nil || len(members) != 2 {
+		t.Errorf("Expected members to list 2 members, got %+v, %v", members, err)
+	}
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		username string
+		role     models.Role
+		err      error
+	}{
+		{name: "Editors cannot add members", user: bob, username: "carol", role: models.RoleViewer, err: auth.ErrNotLibraryOwner},
+		{name: "Unknown role", user: alice, username: "carol", role: "admin", err: models.ErrInvalidRole},
+		{name: "Unknown user", user: alice, username: "dave", role: models.RoleViewer, err: repository.ErrUserNotFound},
+		{name: "Library's own user", user: alice, username: "alice", role: models.RoleViewer, err: auth.ErrOwnLibrary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.SetMember(ctx, tt.user, alice.ID, tt.username, tt.role); !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// Owners promote members, who may then manage the library themselves
+	svc.SetMember(ctx, alice, alice.ID, "bob", models.RoleOwner)
+	if _, err := svc.SetMember(ctx, bob, alice.ID, "carol", models.RoleViewer); err != nil {
+		t.Fatalf("Expected a promoted owner to add members, got %v", err)
+	}
+	if err := svc.RemoveMember(ctx, carol, alice.ID, "bob"); !errors.Is(err, auth.ErrNotLibraryOwner) {
+		t.Errorf("Expected viewers not to remove others, got %v", err)
+	}
+	if err := svc.RemoveMember(ctx, bob, alice.ID, "alice"); !errors.Is(err, auth.ErrOwnLibrary) {
+		t.Errorf("Expected the library's own user to stay, got %v", err)
+	}
+	if err := svc.RemoveMember(ctx, carol, alice.ID, "carol"); err != nil {
+		t.Errorf("Expected members to leave, got %v", err)
+	}
+	if err := svc.RemoveMember(ctx, alice, alice.ID, "carol"); !errors.Is(err, repository.ErrMemberNotFound) {
+		t.Errorf("Expected ErrMemberNotFound, got %v", err)
+	}
+}
+
+func TestLibraryRoles(t *testing.T) {
+	svc, _, sessions := newLibraryService(t)
+	ctx := context.Background()
+	alice, _ := svc.CheckPassword(ctx, "alice", "correct horse")
+	svc.SetMember(ctx, alice, alice.ID, "bob", models.RoleEditor)
+	svc.SetMember(ctx, alice, alice.ID, "carol", models.RoleViewer)
+
+	var owner int
+	repo := &mockBookRepository{
+		getFunc: func(ctx context.Context) ([]models.Book, error) {
+			owner, _ = repository.Owner(ctx)
+			return []models.Book{}, nil
+		},
+		createFunc: func(ctx context.Context, book *models.Book) error {
+			owner, _ = repository.Owner(ctx)
+			return nil
+		},
+		deleteFunc: func(ctx context.Context, id int) error {
+			owner, _ = repository.Owner(ctx)
+			return nil
+		},
+	}
+	router := mux.NewRouter()
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/books", handlers.GetBooks(repo)).Methods("GET")
+	api.HandleFunc("/books", handlers.CreateBook(repo)).Methods("POST")
+	api.HandleFunc("/books/{id}", handlers.DeleteBook(repo)).Methods("DELETE")
+
+	tests := []struct {
+		name           string
+		user           string
+		library        string
+		method         string
+		path           string
+		expectedStatus int
+		expectedOwner  int
+	}{
+		{name: "Own library", user: "carol", method: "POST", path: "/books", expectedStatus: http.StatusCreated, expectedOwner: 3},
+		{name: "Own library by ID", user: "alice", library: "1", method: "DELETE", path: "/books/1", expectedStatus: http.StatusNoContent, expectedOwner: 1},
+		{name: "Editor adds", user: "bob", library: "1", method: "POST", path: "/books", expectedStatus: http.StatusCreated, expectedOwner: 1},
+		{name: "Editor deletes", user: "bob", library: "1", method: "DELETE", path: "/books/1", expectedStatus: http.StatusNoContent, expectedOwner: 1},
+		{name: "Viewer reads", user: "carol", library: "1", method: "GET", path: "/books", expectedStatus: http.StatusOK, expectedOwner: 1},
+		{name: "Viewer cannot add", user: "carol", library: "1", method: "POST", path: "/books", expectedStatus: http.StatusForbidden},
+		{name: "Viewer cannot delete", user: "carol", library: "1", method: "DELETE", path: "/books/1", expectedStatus: http.StatusForbidden},
+		{name: "Not a member", user: "alice", library: "2", method: "GET", path: "/books", expectedStatus: http.StatusNotFound},
+		{name: "Invalid library", user: "bob", library: "alice", method: "GET", path: "/books", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner = 0
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"title":"Dune","author":"Frank Herbert"}`))
+			req.Header.Set("Authorization", "Bearer "+sessions[tt.user])
+			if tt.library != "" {
+				req.Header.Set("X-Library", tt.library)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+			if owner != tt.expectedOwner {
+				t.Errorf("Expected the books of library %d, got %d", tt.expectedOwner, owner)
+			}
+		})
+	}
+}
+
+func TestLibraryHandlers(t *testing.T) {
+	svc, _, sessions := newLibraryService(t)
+	router := mux.NewRouter()
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/libraries", handlers.ListLibraries(svc)).Methods("GET")
+	api.HandleFunc("/libraries/{id}/members", handlers.ListMembers(svc)).Methods("GET")
+	members := api.PathPrefix("/libraries/{id}/members/{username}").Subrouter()
+	members.Use(auth.RequireSession)
+	members.HandleFunc("", handlers.SetMember(svc)).Methods("PUT")
+	members.HandleFunc("", handlers.RemoveMember(svc)).Methods("DELETE")
+	serve := func(method, path, body, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+sessions[user])
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("PUT", "/libraries/1/members/bob", `{"role":"viewer"}`, "alice")
+	var member models.Membership
+	if err := json.NewDecoder(rr.Body).Decode(&member); err != nil || member.Username != "bob" || member.Role != models.RoleViewer {
+		t.Fatalf("Expected bob as viewer, got %d: %+v, %v", rr.Code, member, err)
+	}
+	rr = serve("GET", "/libraries", "", "bob")
+	var libraries []models.Library
+	json.NewDecoder(rr.Body).Decode(&libraries)
+	if len(libraries) != 2 || libraries[1].ID != 1 || libraries[1].Role != models.RoleViewer {
+		t.Errorf("Expected bob's library and alice's, got %+v", libraries)
+	}
+
+	// A leaked API token, even one that may write books, cannot let anyone in
+	alice, err := svc.Authenticate(context.Background(), sessions["alice"])
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	token, err := svc.CreateToken(context.Background(), alice, models.APITokenRequest{Name: "script", Scopes: models.Scopes})
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	sessions["alice's token"] = token.Token
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		user           string
+		expectedStatus int
+	}{
+		{name: "Token adds member", method: "PUT", path: "/libraries/1/members/carol", body: `{"role":"owner"}`, user: "alice's token", expectedStatus: http.StatusForbidden},
+		{name: "Token removes member", method: "DELETE", path: "/libraries/1/members/bob", user: "alice's token", expectedStatus: http.StatusForbidden},
+		{name: "List members", method: "GET", path: "/libraries/1/members", user: "bob", expectedStatus: http.StatusOK},
+		{name: "List members of another library", method: "GET", path: "/libraries/1/members", user: "carol", expectedStatus: http.StatusNotFound},
+		{name: "Invalid ID", method: "GET", path: "/libraries/x/members", user: "alice", expectedStatus: http.StatusBadRequest},
+		{name: "Viewer adds member", method: "PUT", path: "/libraries/1/members/carol", body: `{"role":"viewer"}`, user: "bob", expectedStatus: http.StatusForbidden},
+		{name: "Unknown role", method: "PUT", path: "/libraries/1/members/carol", body: `{"role":"admin"}`, user: "alice", expectedStatus: http.StatusBadRequest},
+		{name: "Malformed body", method: "PUT", path: "/libraries/1/members/carol", body: `{`, user: "alice", expectedStatus: http.StatusBadRequest},
+		{name: "Unknown user", method: "PUT", path: "/libraries/1/members/dave", body: `{"role":"viewer"}`, user: "alice", expectedStatus: http.StatusNotFound},
+		{name: "Own library", method: "PUT", path: "/libraries/1/members/alice", body: `{"role":"viewer"}`, user: "alice", expectedStatus: http.StatusBadRequest},
+		{name: "Remove non-member", method: "DELETE", path: "/libraries/1/members/carol", user: "alice", expectedStatus: http.StatusNotFound},
+		{name: "Leave library", method: "DELETE", path: "/libraries/1/members/bob", user: "bob", expectedStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := serve(tt.method, tt.path, tt.body, tt.user); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+	if rr := serve("GET", "/libraries/1/members", "", "bob"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after leaving, got %d", rr.Code)
+	}
+}
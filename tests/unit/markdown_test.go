@@ -0,0 +1,148 @@
+package unit
+
+import (
+	"archive/zip"
+	"book-tracker/internal/export"
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdownFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		book     models.Book
+		expected string
+	}{
+		{name: "Plain", book: models.Book{ID: 3, Title: "Dune", Author: "Frank Herbert"}, expected: "Dune - Frank Herbert (3).md"},
+		{name: "Unsafe characters", book: models.Book{ID: 4, Title: "Who/What? #1: [Part] A", Author: "A. Author"}, expected: "WhoWhat 1 Part A - A. Author (4).md"},
+		{name: "Long title", book: models.Book{ID: 5, Title: strings.Repeat("a", 150), Author: "B"}, expected: strings.Repeat("a", 100) + " (5).md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := export.MarkdownFilename(tt.book); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	finished := time.Date(2023, 3, 14, 0, 0, 0, 0, time.UTC)
+	book := models.Book{
+		ID: 3, Title: "Dune", Author: "Frank Herbert", Year: 1965, Rating: 5, Shelf: models.ShelfRead,
+		Progress: 100, Finished: true, Notes: "Spice must flow.", Tags: models.Tags{"sci-fi", `say "hi"`},
+		FinishedAt: &finished,
+		CreatedAt:  time.Date(2022, 12, 1, 9, 0, 0, 0, time.UTC),
+		UpdatedAt:  time.Date(2023, 3, 15, 9, 0, 0, 0, time.UTC),
+	}
+	highlights := []models.Highlight{
+		{Kind: models.HighlightKindHighlight, Text: "Fear is the mind-killer.\nFear is the little-death.", Page: "12", Location: "180-182", Comment: "Litany"},
+		{Kind: models.HighlightKindBookmark, Location: "610"},
+		{Kind: models.HighlightKindNote, Text: "Reread this chapter", Location: "700"},
+	}
+
+	var b strings.Builder
+	if err := export.WriteMarkdown(&b, book, highlights); err != nil {
+		t.Fatalf("Failed to write Markdown: %v", err)
+	}
+	expected := `---
+id: 3
+title: "Dune"
+author: "Frank Herbert"
+year: 1965
+shelf: "read"
+progress: 100
+finished: true
+rating: 5
+tags: ["sci-fi", "say \"hi\""]
+created: 2022-12-01
+finished_at: 2023-03-14
+updated: 2023-03-15
+---
+
+# Dune
+
+by Frank Herbert
+
+## Notes
+
+Spice must flow.
+
+## Highlights
+
+> Fear is the mind-killer.
+> Fear is the little-death.
+
+— page 12, location 180-182
+
+Litany
+
+Reread this chapter
+
+— location 700
+`
+	if b.String() != expected {
+		t.Errorf("Unexpected Markdown:\n%s\nwant:\n%s", b.String(), expected)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	books := []models.Book{
+		{ID: 1, Title: "Dune", Author: "Frank Herbert"},
+		{ID: 2, Title: "Emma", Author: "Jane Austen"},
+	}
+	mockRepo := &mockBookRepository{
+		streamFunc: func(ctx context.Context, fn func(models.Book) error) error {
+			for _, b := range books {
+				if err := fn(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	highlightRepo := &mockHighlightRepository{
+		getAllFunc: func(ctx context.Context) ([]models.Highlight, error) {
+			return []models.Highlight{{BookID: 2, Kind: models.HighlightKindHighlight, Text: "A truth universally acknowledged"}}, nil
+		},
+	}
+
+	run := func() []byte {
+		req := httptest.NewRequest(http.MethodGet, "/export/markdown", nil)
+		w := httptest.NewRecorder()
+		handlers.ExportMarkdown(mockRepo, highlightRepo)(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		return w.Body.Bytes()
+	}
+	body := run()
+	if !bytes.Equal(body, run()) {
+		t.Error("Expected repeated exports to be byte-identical")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "Dune - Frank Herbert (1).md" || zr.File[1].Name != "Emma - Jane Austen (2).md" {
+		t.Fatalf("Unexpected files in archive: %v", zr.File)
+	}
+	f, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	content, _ := io.ReadAll(f)
+	if !strings.Contains(string(content), "> A truth universally acknowledged") {
+		t.Errorf("Expected the highlight in Emma's file, got:\n%s", content)
+	}
+}
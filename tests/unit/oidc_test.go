@@ -0,0 +1,398 @@
+package unit
+
+import (
+	"book-tracker/internal/auth"
+	"book-tracker/internal/models"
+	"book-tracker/internal/repository"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// testIssuer is a local stand-in for an OpenID Connect provider. It serves
+// a discovery document and its public keys, counts key fetches and signs
+// ID tokens with its current key.
+type testIssuer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	keys       []*rsa.PrivateKey
+	ec         *ecdsa.PrivateKey
+	keyFetches int
+	down       bool
+	hold       chan struct{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	iss := &testIssuer{}
+	iss.rotate(t)
+	router := http.NewServeMux()
+	router.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+	})
+	router.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		iss.keyFetches++
+		hold := iss.hold
+		iss.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		if iss.down {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		keys := []map[string]string{}
+		for i, k := range iss.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "use": "sig", "kid": fmt.Sprintf("rsa-%d", i),
+				"n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		if iss.ec != nil {
+			keys = append(keys, map[string]string{
+				"kty": "EC", "kid": "ec", "crv": "P-256",
+				"x": b64(iss.ec.X.FillBytes(make([]byte, 32))), "y": b64(iss.ec.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		keys = append(keys, map[string]string{"kty": "RSA", "use": "enc", "kid": "encryption", "n": "AQAB", "e": "AQAB"})
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	iss.Server = httptest.NewServer(router)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// rotate publishes a new signing key next to the old ones.
+func (iss *testIssuer) rotate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.keys = append(iss.keys, key)
+}
+
+func (iss *testIssuer) setDown(down bool) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.down = down
+}
+
+func (iss *testIssuer) fetches() int {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	return iss.keyFetches
+}
+
+// sign returns an ID token with the current key, standard claims for the
+// subject and any claims given, which override the standard ones or, when
+// nil, remove them.
+func (iss *testIssuer) sign(t *testing.T, subject string, extra jwt.MapClaims) string {
+	iss.mu.Lock()
+	kid, key := fmt.Sprintf("rsa-%d", len(iss.keys)-1), iss.keys[len(iss.keys)-1]
+	iss.mu.Unlock()
+	claims := jwt.MapClaims{
+		"iss":                iss.URL,
+		"aud":                "book-tracker",
+		"sub":                subject,
+		"preferred_username": subject,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// testRefreshInterval is the least time between key fetches in tests.
+const testRefreshInterval = 20 * time.Millisecond
+
+func newTestOIDC(iss *testIssuer, ttl time.Duration) *auth.OIDC {
+	return auth.NewOIDC(auth.OIDCConfig{Issuer: iss.URL, Audience: "book-tracker", KeyCacheTTL: ttl, KeyRefreshInterval: testRefreshInterval})
+}
+
+func TestOIDCVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	oidc := newTestOIDC(iss, time.Hour)
+	ctx := context.Background()
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": iss.URL, "aud": "book-tracker", "sub": "alice", "preferred_username": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	forged.Header["kid"] = "rsa-0"
+	forgedToken, _ := forged.SignedString(other)
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": iss.URL, "aud": "book-tracker", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}).SignedString([]byte("secret"))
+
+	identity, err := oidc.Verify(ctx, iss.sign(t, "alice", nil))
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if *identity != (auth.Identity{Issuer: iss.URL, Subject: "alice", Username: "alice"}) {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "Expired", token: iss.sign(t, "alice", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})},
+		{name: "No expiry", token: iss.sign(t, "alice", jwt.MapClaims{"exp": nil})},
+		{name: "Other audience", token: iss.sign(t, "alice", jwt.MapClaims{"aud": "another-app"})},
+		{name: "Other issuer", token: iss.sign(t, "alice", jwt.MapClaims{"iss": "https://evil.example"})},
+		{name: "Unusable username", token: iss.sign(t, "alice", jwt.MapClaims{"preferred_username": "alice smith"})},
+		{name: "Other key", token: forgedToken},
+		{name: "Symmetric", token: hmacToken},
+		{name: "Garbage", token: "a.b.c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := oidc.Verify(ctx, tt.token); !errors.Is(err, auth.ErrInvalidLogin) {
+				t.Errorf("Expected ErrInvalidLogin, got %v", err)
+			}
+		})
+	}
+	if n := iss.fetches(); n != 1 {
+		t.Errorf("Expected the keys to be fetched once, got %d", n)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	oidc := newTestOIDC(iss, time.Hour)
+	ctx := context.Background()
+	first := iss.sign(t, "alice", nil)
+	if _, err := oidc.Verify(ctx, first); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// A token signed with a new key fetches the keys again
+	iss.rotate(t)
+	time.Sleep(2 * testRefreshInterval)
+	if _, err := oidc.Verify(ctx, iss.sign(t, "alice", nil)); err != nil {
+		t.Fatalf("Expected a token signed with the rotated key to verify, got %v", err)
+	}
+	if n := iss.fetches(); n != 2 {
+		t.Errorf("Expected the keys to be fetched again once, got %d fetches", n)
+	}
+	if _, err := oidc.Verify(ctx, first); err != nil {
+		t.Errorf("Expected a token signed with the old key to still verify, got %v", err)
+	}
+
+	// Unknown key IDs fetch the keys again at most once per interval
+	time.Sleep(2 * testRefreshInterval)
+	unknown := iss.sign(t, "alice", nil)
+	parts := strings.Split(unknown, ".")
+	parts[0] = b64([]byte(`{"alg":"RS256","kid":"made-up","typ":"JWT"}`))
+	for range 3 {
+		if _, err := oidc.Verify(ctx, strings.Join(parts, ".")); !errors.Is(err, auth.ErrInvalidLogin) {
+			t.Errorf("Expected ErrInvalidLogin for an unknown key, got %v", err)
+		}
+	}
+	if n := iss.fetches(); n != 3 {
+		t.Errorf("Expected one more fetch for unknown keys, got %d", n)
+	}
+}
+
+func TestOIDCSlowKeyFetch(t *testing.T) {
+	iss := newTestIssuer(t)
+	oidc := newTestOIDC(iss, time.Hour)
+	ctx := context.Background()
+	first := iss.sign(t, "alice", nil)
+	if _, err := oidc.Verify(ctx, first); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// Tokens with a new key wait for one fetch, which the provider holds
+	iss.rotate(t)
+	rotated := iss.sign(t, "alice", nil)
+	hold := make(chan struct{})
+	iss.mu.Lock()
+	iss.hold = hold
+	iss.mu.Unlock()
+	time.Sleep(2 * testRefreshInterval)
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := oidc.Verify(ctx, rotated)
+			errs <- err
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); iss.fetches() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the keys to be fetched again")
+		}
+	}
+
+	// Tokens with a known key verify while the fetch is in progress
+	verified := make(chan error, 1)
+	go func() {
+		_, err := oidc.Verify(ctx, first)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Expected the known key to verify, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected a known key to verify without waiting for the fetch")
+	}
+
+	close(hold)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected the rotated key to verify after the fetch, got %v", err)
+		}
+	}
+	if n := iss.fetches(); n != 2 {
+		t.Errorf("Expected one fetch for concurrent tokens, got %d fetches", n)
+	}
+}
+
+func TestOIDCKeysUnavailable(t *testing.T) {
+	iss := newTestIssuer(t)
+	ctx := context.Background()
+	token := iss.sign(t, "alice", nil)
+
+	iss.setDown(true)
+	if _, err := newTestOIDC(iss, time.Hour).Verify(ctx, token); !errors.Is(err, auth.ErrKeysUnavailable) {
+		t.Errorf("Expected ErrKeysUnavailable, got %v", err)
+	}
+
+	// Cached keys outlive their TTL while the provider is down
+	iss.setDown(false)
+	oidc := newTestOIDC(iss, time.Nanosecond)
+	if _, err := oidc.Verify(ctx, token); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	iss.setDown(true)
+	time.Sleep(2 * testRefreshInterval)
+	if _, err := oidc.Verify(ctx, token); err != nil {
+		t.Errorf("Expected the cached key to be used, got %v", err)
+	}
+	if n := iss.fetches(); n != 3 {
+		t.Errorf("Expected a failed fetch of the stale keys, got %d fetches", n)
+	}
+}
+
+func TestOIDCECKeys(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.ec, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": iss.URL, "aud": []string{"other", "book-tracker"}, "sub": "carol", "email": "carol.x@example.com",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "ec"
+	signed, _ := token.SignedString(iss.ec)
+	oidc := auth.NewOIDC(auth.OIDCConfig{Issuer: iss.URL + "/", Audience: "book-tracker", UsernameClaim: "sub"})
+	identity, err := oidc.Verify(context.Background(), signed)
+	if err != nil || identity.Username != "carol" {
+		t.Errorf("Expected carol, got %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCMiddleware(t *testing.T) {
+	iss := newTestIssuer(t)
+	svc, users := newAuthService(time.Hour)
+	svc.UseOIDC(newTestOIDC(iss, time.Hour))
+	ctx := context.Background()
+	svc.Register(ctx, models.Credentials{Username: "bob", Password: "battery staple"})
+
+	router := mux.NewRouter()
+	api := router.NewRoute().Subrouter()
+	api.Use(auth.Middleware(svc))
+	api.HandleFunc("/auth/me", func(w http.ResponseWriter, r *http.Request) {
+		owner, _ := repository.Owner(r.Context())
+		if owner != auth.User(r.Context()).ID {
+			t.Errorf("Expected the user as owner, got %d", owner)
+		}
+		json.NewEncoder(w).Encode(auth.User(r.Context()))
+	})
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The first sign-in creates a user without a password
+	rr := serve(iss.sign(t, "sub-123", jwt.MapClaims{"preferred_username": "alice"}))
+	var me models.User
+	json.NewDecoder(rr.Body).Decode(&me)
+	if rr.Code != http.StatusOK || me.Username != "alice" {
+		t.Fatalf("Expected alice to be signed in, got %d: %+v", rr.Code, me)
+	}
+	if n := len(users.users); n != 2 || users.users[1].PasswordHash != "" || users.users[1].OIDCSubject != "sub-123" {
+		t.Fatalf("Expected a new linked user without a password, got %+v", users.users)
+	}
+	if _, err := svc.CheckPassword(ctx, "alice", ""); !errors.Is(err, auth.ErrInvalidLogin) {
+		t.Errorf("Expected a user without a password not to sign in with one, got %v", err)
+	}
+
+	// Later sign-ins find the user by subject, even under a new name
+	rr = serve(iss.sign(t, "sub-123", jwt.MapClaims{"preferred_username": "alice.renamed"}))
+	json.NewDecoder(rr.Body).Decode(&me)
+	if rr.Code != http.StatusOK || me.Username != "alice" || len(users.users) != 2 {
+		t.Errorf("Expected the same user, got %d: %+v", rr.Code, me)
+	}
+
+	// An identity cannot take over a local account with the same name
+	if rr := serve(iss.sign(t, "sub-456", jwt.MapClaims{"preferred_username": "bob"})); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a taken username, got %d", rr.Code)
+	}
+	if rr := serve(iss.sign(t, "sub-123", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an expired token, got %d", rr.Code)
+	}
+
+	iss.Close()
+	svc.UseOIDC(newTestOIDC(iss, time.Hour))
+	if rr := serve(iss.sign(t, "sub-789", nil)); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while the provider is down, got %d", rr.Code)
+	}
+
+	plain, _ := newAuthService(time.Hour)
+	router = mux.NewRouter()
+	router.Use(auth.Middleware(plain))
+	router.HandleFunc("/auth/me", func(w http.ResponseWriter, r *http.Request) {})
+	if rr := serve(iss.sign(t, "sub-123", nil)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without OIDC configured, got %d", rr.Code)
+	}
+}
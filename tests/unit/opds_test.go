@@ -0,0 +1,252 @@
+package unit
+
+import (
+	"book-tracker/internal/handlers"
+	"book-tracker/internal/models"
+	"book-tracker/internal/opds"
+	"book-tracker/internal/repository"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type mockCatalogRepository struct {
+	listFunc   func(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error)
+	facetsFunc func(ctx context.Context, field string) ([]models.Facet, error)
+}
+
+func (m *mockCatalogRepository) ListBooks(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error) {
+	return m.listFunc(ctx, filter)
+}
+
+func (m *mockCatalogRepository) Facets(ctx context.Context, field string) ([]models.Facet, error) {
+	return m.facetsFunc(ctx, field)
+}
+
+var _ repository.CatalogRepositoryInterface = &mockCatalogRepository{}
+
+// feedLinks maps link relations to hrefs.
+func feedLinks(f opds.Feed) map[string]string {
+	links := make(map[string]string)
+	for _, l := range f.Links {
+		links[l.Rel] = l.Href
+	}
+	return links
+}
+
+func serveOPDS(t *testing.T, router *mux.Router, url string) (*httptest.ResponseRecorder, opds.Feed) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	var feed opds.Feed
+	if rr.Code == http.StatusOK {
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("Failed to parse feed: %v\n%s", err, rr.Body.String())
+		}
+	}
+	return rr, feed
+}
+
+func TestOPDSBooks(t *testing.T) {
+	updated := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var got models.CatalogFilter
+	mockRepo := &mockCatalogRepository{
+		listFunc: func(ctx context.Context, filter models.CatalogFilter) ([]models.Book, int, error) {
+			got = filter
+			return []models.Book{{
+				ID: 7, Title: "Dune", Author: "Frank Herbert", Publisher: "Chilton", Year: 1965, ISBN: "0801950775",
+				Tags: models.Tags{"sf"}, Series: "Dune", SeriesIndex: 1, UpdatedAt: updated,
+			}}, 120, nil
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/opds/books", handlers.OPDSBooks(mockRepo)).Methods("GET")
+	router.HandleFunc("/opds/search", handlers.OPDSSearch(mockRepo)).Methods("GET")
+	router.HandleFunc("/opds/recent", handlers.OPDSRecent(mockRepo)).Methods("GET")
+
+	rr, feed := serveOPDS(t, router, "/opds/books?tag=sf&page=2")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, opds.AcquisitionType) {
+		t.Errorf("Expected an acquisition feed, got %q", ct)
+	}
+	want := models.CatalogFilter{Tag: "sf", Order: models.CatalogOrderTitle, Limit: opds.PageSize, Offset: opds.PageSize}
+	if got != want {
+		t.Errorf("Expected filter %+v, got %+v", want, got)
+	}
+	links := feedLinks(feed)
+	expected := map[string]string{
+		"self":     "http://example.com/opds/books?page=2&tag=sf",
+		"first":    "http://example.com/opds/books?tag=sf",
+		"previous": "http://example.com/opds/books?tag=sf",
+		"next":     "http://example.com/opds/books?page=3&tag=sf",
+		"last":     "http://example.com/opds/books?page=3&tag=sf",
+		"search":   "http://example.com/opds/opensearch.xml",
+		"start":    "http://example.com/opds",
+		"up":       "http://example.com/opds",
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected links %v, got %v", expected, links)
+	}
+	if feed.Title != "Tag: sf" || len(feed.Entries) != 1 {
+		t.Fatalf("Unexpected feed: %+v", feed)
+	}
+	e := feed.Entries[0]
+	if e.ID != "urn:book-tracker:book:7" || e.Authors[0].Name != "Frank Herbert" || e.Updated != "2024-05-01T10:00:00Z" ||
+		e.Categories[0].Term != "sf" || e.Content.Text != "Dune #1" {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+	entryLinks := make(map[string]opds.Link)
+	for _, l := range e.Links {
+		entryLinks[l.Rel] = l
+	}
+	expectedEntryLinks := map[string]opds.Link{
+		"alternate":       {Rel: "alternate", Href: "http://example.com/opds/books/7", Type: opds.EntryType, Title: "Full entry"},
+		opds.RelImage:     {Rel: opds.RelImage, Href: "http://example.com/books/7/cover"},
+		opds.RelThumbnail: {Rel: opds.RelThumbnail, Href: "http://example.com/books/7/cover/medium", Type: "image/jpeg"},
+	}
+	if !reflect.DeepEqual(entryLinks, expectedEntryLinks) {
+		t.Errorf("Expected entry links %v, got %v", expectedEntryLinks, entryLinks)
+	}
+	for _, part := range []string{`<opensearch:totalResults>120</opensearch:totalResults>`, `<opensearch:startIndex>51</opensearch:startIndex>`,
+		`<dc:issued>1965</dc:issued>`, `<dc:identifier>urn:isbn:0801950775</dc:identifier>`, `xmlns:dc="http://purl.org/dc/terms/"`} {
+		if !strings.Contains(rr.Body.String(), part) {
+			t.Errorf("Expected feed to contain %s", part)
+		}
+	}
+
+	_, feed = serveOPDS(t, router, "/opds/search?q=dune")
+	if got.Query != "dune" || feedLinks(feed)["next"] != "http://example.com/opds/search?page=2&q=dune" {
+		t.Errorf("Expected a paged search for dune, got filter %+v and links %v", got, feedLinks(feed))
+	}
+	_, _ = serveOPDS(t, router, "/opds/recent")
+	if got.Order != models.CatalogOrderRecent {
+		t.Errorf("Expected newest books first, got order %q", got.Order)
+	}
+
+	for _, url := range []string{"/opds/books?page=0", "/opds/books?page=x", "/opds/search"} {
+		if rr, _ := serveOPDS(t, router, url); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, url, rr.Code)
+		}
+	}
+}
+
+func TestOPDSBook(t *testing.T) {
+	mockRepo := &mockBookRepository{
+		getIDsFunc: func(ctx context.Context, ids []int) ([]models.Book, error) {
+			if ids[0] != 7 {
+				return nil, repository.ErrNotFound
+			}
+			return []models.Book{{ID: 7, Title: "Dune", Author: "Frank Herbert"}}, nil
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/opds/books/{id}", handlers.OPDSBook(mockRepo)).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/opds/books/7", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), opds.EntryType) {
+		t.Fatalf("Expected an entry document, got status %d and %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var entry opds.EntryDocument
+	if err := xml.Unmarshal(rr.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse entry: %v\n%s", err, rr.Body.String())
+	}
+	if entry.Title != "Dune" || entry.ID != "urn:book-tracker:book:7" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	hrefs := make(map[string]string)
+	for _, l := range entry.Links {
+		hrefs[l.Rel] = l.Href
+	}
+	expected := map[string]string{
+		"alternate":       "https://example.com/opds/books/7",
+		opds.RelImage:     "https://example.com/books/7/cover",
+		opds.RelThumbnail: "https://example.com/books/7/cover/medium",
+	}
+	if !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected links %v, got %v", expected, hrefs)
+	}
+
+	for url, code := range map[string]int{"/opds/books/8": http.StatusNotFound, "/opds/books/x": http.StatusBadRequest} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != code {
+			t.Errorf("Expected status %d for %s, got %d", code, url, rr.Code)
+		}
+	}
+}
+
+func TestOPDSFacets(t *testing.T) {
+	mockRepo := &mockCatalogRepository{
+		facetsFunc: func(ctx context.Context, field string) ([]models.Facet, error) {
+			if field != repository.FacetAuthor {
+				return nil, fmt.Errorf("unexpected facet %q", field)
+			}
+			return []models.Facet{{Value: "Jane Austen", Count: 6}, {Value: "Frank Herbert", Count: 1}}, nil
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/opds/authors", handlers.OPDSFacets(mockRepo, repository.FacetAuthor)).Methods("GET")
+
+	rr, feed := serveOPDS(t, router, "/opds/authors")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), opds.NavigationType) {
+		t.Fatalf("Expected a navigation feed, got status %d and %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(feed.Entries))
+	}
+	e := feed.Entries[0]
+	if e.Title != "Jane Austen" || e.Content.Text != "6 books" || e.Links[0].Href != "http://example.com/opds/books?author=Jane+Austen" ||
+		e.Links[0].Type != opds.AcquisitionType {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+	if feed.Entries[1].Content.Text != "1 book" {
+		t.Errorf("Expected a singular count, got %q", feed.Entries[1].Content.Text)
+	}
+}
+
+func TestOPDSRootAndOpenSearch(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/opds", handlers.OPDSRoot()).Methods("GET")
+	router.HandleFunc("/opds/opensearch.xml", handlers.OPDSOpenSearch()).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/opds", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var feed opds.Feed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	var hrefs []string
+	for _, e := range feed.Entries {
+		hrefs = append(hrefs, e.Links[0].Href)
+	}
+	expected := []string{"https://example.com/opds/recent", "https://example.com/opds/authors", "https://example.com/opds/shelves",
+		"https://example.com/opds/tags", "https://example.com/opds/books"}
+	if !reflect.DeepEqual(hrefs, expected) {
+		t.Errorf("Expected entries linking to %v, got %v", expected, hrefs)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/opds/opensearch.xml", nil))
+	var desc opds.OpenSearchDescription
+	if err := xml.Unmarshal(rr.Body.Bytes(), &desc); err != nil {
+		t.Fatalf("Failed to parse OpenSearch description: %v", err)
+	}
+	if desc.URL.Template != "http://example.com/opds/search?q={searchTerms}" || desc.URL.Type != opds.AcquisitionType {
+		t.Errorf("Unexpected search template: %+v", desc.URL)
+	}
+}
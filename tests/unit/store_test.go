@@ -0,0 +1,128 @@
+package unit
+
+import (
+	"book-tracker/internal/repository"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// txDriver is a database/sql driver that only supports transactions, so
+// Store can be tested without a database. It counts commits and rollbacks.
+type txDriver struct {
+	commits   atomic.Int32
+	rollbacks atomic.Int32
+	isolation atomic.Int32
+}
+
+func (d *txDriver) Connect(ctx context.Context) (driver.Conn, error) { return &txConn{d: d}, nil }
+func (d *txDriver) Driver() driver.Driver                            { return d }
+func (d *txDriver) Open(name string) (driver.Conn, error)            { return &txConn{d: d}, nil }
+
+type txConn struct {
+	d *txDriver
+}
+
+func (c *txConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("queries are not supported")
+}
+func (c *txConn) Close() error              { return nil }
+func (c *txConn) Begin() (driver.Tx, error) { return &fakeTx{d: c.d}, nil }
+func (c *txConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.d.isolation.Store(int32(opts.Isolation))
+	return &fakeTx{d: c.d}, nil
+}
+
+type fakeTx struct {
+	d *txDriver
+}
+
+func (t *fakeTx) Commit() error   { t.d.commits.Add(1); return nil }
+func (t *fakeTx) Rollback() error { t.d.rollbacks.Add(1); return nil }
+
+func newFakeStore() (*repository.Store, *txDriver) {
+	d := &txDriver{}
+	db := sqlx.NewDb(sql.OpenDB(d), "postgres")
+	return repository.NewStore(db), d
+}
+
+func TestRunInTxCommits(t *testing.T) {
+	store, d := newFakeStore()
+	err := store.RunInTx(context.Background(), func(repos repository.Repos) error {
+		if repos.Books == nil || repos.Audit == nil {
+			return errors.New("expected repositories to be set")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if d.commits.Load() != 1 {
+		t.Errorf("Expected 1 commit, got %d", d.commits.Load())
+	}
+	if sql.IsolationLevel(d.isolation.Load()) != sql.LevelSerializable {
+		t.Errorf("Expected serializable isolation, got %v", sql.IsolationLevel(d.isolation.Load()))
+	}
+}
+
+func TestRunInTxRollsBackOnError(t *testing.T) {
+	store, d := newFakeStore()
+	want := errors.New("step failed")
+	calls := 0
+	err := store.RunInTx(context.Background(), func(repos repository.Repos) error {
+		calls++
+		return want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("Expected %v, got %v", want, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected non-retryable error to run once, ran %d times", calls)
+	}
+	if d.commits.Load() != 0 || d.rollbacks.Load() != 1 {
+		t.Errorf("Expected 0 commits and 1 rollback, got %d and %d", d.commits.Load(), d.rollbacks.Load())
+	}
+}
+
+func TestRunInTxRetriesSerializationFailures(t *testing.T) {
+	store, d := newFakeStore()
+	calls := 0
+	err := store.RunInTx(context.Background(), func(repos repository.Repos) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("update book: %w", &pq.Error{Code: "40001"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	if calls != 3 || d.commits.Load() != 1 {
+		t.Errorf("Expected 3 attempts and 1 commit, got %d and %d", calls, d.commits.Load())
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("database error"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := repository.IsSerializationFailure(tt.err); got != tt.expected {
+			t.Errorf("IsSerializationFailure(%v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}